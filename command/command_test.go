@@ -440,6 +440,14 @@ func testStateOutput(t *testing.T, path string, expected string) {
 	}
 }
 
+func mustResourceInstanceAddr(s string) addrs.AbsResourceInstance {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(s)
+	if diags.HasErrors() {
+		panic(diags.Err())
+	}
+	return addr
+}
+
 func testProvider() *terraform.MockProvider {
 	p := new(terraform.MockProvider)
 	p.PlanResourceChangeResponse = providers.PlanResourceChangeResponse{
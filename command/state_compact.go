@@ -0,0 +1,333 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+	"github.com/mitchellh/cli"
+)
+
+// StateCompactCommand is a Command implementation that rewrites the state
+// without the cruft that can accumulate in it over time.
+type StateCompactCommand struct {
+	StateMeta
+}
+
+func (c *StateCompactCommand) Run(args []string) int {
+	args, err := c.Meta.process(args, true)
+	if err != nil {
+		return 1
+	}
+
+	var dryRun bool
+	var removeFlags []string
+	cmdFlags := c.Meta.flagSet("state compact")
+	cmdFlags.BoolVar(&dryRun, "dry-run", false, "dry run")
+	cmdFlags.Var((*FlagStringSlice)(&removeFlags), "remove", "address of an instance to remove")
+	cmdFlags.StringVar(&c.backupPath, "backup", "", "backup")
+	cmdFlags.StringVar(&c.statePath, "state", "", "path")
+	if err := cmdFlags.Parse(args); err != nil {
+		return cli.RunResultHelp
+	}
+	args = cmdFlags.Args()
+
+	var diags tfdiags.Diagnostics
+
+	if len(args) != 0 {
+		c.Ui.Error("The state compact command expects no arguments.")
+		return cli.RunResultHelp
+	}
+
+	// For other commands -backup=- disables the backup, but compacting
+	// rewrites the whole snapshot, so it always keeps the original.
+	if c.backupPath == "-" {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Backup can't be disabled",
+			"The state compact command always backs up the state snapshot as it was stored, so -backup=- can't be used to disable the backup. Give the path to write the backup to instead, or leave out -backup to use the default path.",
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	var toRemove []addrs.AbsResourceInstance
+	for i, rawAddr := range removeFlags {
+		addr, moreDiags := c.parseResourceInstanceAddr(rawAddr, fmt.Sprintf("<address %d>", i+1))
+		diags = diags.Append(moreDiags)
+		toRemove = append(toRemove, addr)
+	}
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	stateMgr, err := c.State()
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to load state",
+			fmt.Sprintf(errStateLoadingState, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if err := stateMgr.RefreshState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to load state",
+			fmt.Sprintf(errStateLoadingState, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	state := stateMgr.State()
+	if state == nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No state found",
+			errStateNotFound,
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	for _, addr := range toRemove {
+		if state.ResourceInstance(addr) == nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"No such resource instance in state",
+				fmt.Sprintf("There is no resource instance in the current state with the address %s.", addr),
+			))
+		}
+	}
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	before, comparable, err := storedStateSnapshot(stateMgr)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to read state snapshot",
+			fmt.Sprintf("Could not read the stored state snapshot to back it up: %s.", err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	removed, pruned := compactState(state, toRemove)
+
+	if dryRun {
+		// The size is estimated from the snapshot as it would be written
+		// now, which differs from the stored one only in its serial.
+		var buf bytes.Buffer
+		if err := statefile.Write(statemgr.StateFile(stateMgr, state), &buf); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to encode state",
+				fmt.Sprintf("Could not encode the compacted state: %s.", err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		for _, addr := range removed {
+			c.Ui.Output(fmt.Sprintf("Would remove %s", addr))
+		}
+		for _, addr := range pruned {
+			c.Ui.Output(fmt.Sprintf("Would remove %s, which has no objects", addr))
+		}
+		if comparable && before != nil {
+			c.Ui.Output(fmt.Sprintf("%s, without -dry-run.", stateCompactSizeChange("Would've compacted", len(before), buf.Len())))
+		} else {
+			c.Ui.Output("Would've rewritten the state snapshot, without -dry-run.")
+		}
+		return 0
+	}
+
+	// The content of the state may not be changing, in which case the
+	// state managers won't write a backup of their own. We save the original
+	// bytes instead in every case, so that the earlier version can be
+	// restored exactly, and stop a local state manager from overwriting
+	// them with its own re-encoded copy.
+	if lb, ok := stateMgr.(*statemgr.Filesystem); ok {
+		lb.SetBackupPath("")
+	}
+	if before != nil {
+		if err := ioutil.WriteFile(c.stateBackupPath, before, 0644); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to write backup",
+				fmt.Sprintf("Could not back up the state snapshot to %s: %s. The state has not been changed.", c.stateBackupPath, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	if err := stateMgr.WriteState(state); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state",
+			fmt.Sprintf(errStateCompactPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to persist state",
+			fmt.Sprintf(errStateCompactPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	var after []byte
+	if comparable {
+		after, _, err = storedStateSnapshot(stateMgr)
+		if err != nil {
+			comparable = false
+		}
+	}
+	if !comparable {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Can't compare state snapshots",
+			"The current backend doesn't give access to its stored state snapshots, so Terraform can't tell how much compacting the state reduced its size.",
+		))
+	}
+	c.showDiagnostics(diags)
+
+	for _, addr := range removed {
+		c.Ui.Output(fmt.Sprintf("Removed %s", addr))
+	}
+	for _, addr := range pruned {
+		c.Ui.Output(fmt.Sprintf("Removed %s, which had no objects", addr))
+	}
+	if comparable && before != nil {
+		c.Ui.Output(fmt.Sprintf("%s.", stateCompactSizeChange("Compacted", len(before), len(after))))
+	} else {
+		c.Ui.Output("Rewrote the state snapshot.")
+	}
+	if before != nil {
+		c.Ui.Output(fmt.Sprintf("The original snapshot was backed up to %s.", c.stateBackupPath))
+	}
+	return 0
+}
+
+func (c *StateCompactCommand) Help() string {
+	helpText := `
+Usage: terraform state compact [options]
+
+  Rewrite the state without the cruft that accumulates in it over time,
+  and report how much smaller it became.
+
+  Resources that no longer have any objects, such as those left behind
+  after all of their instances were removed, are dropped, and the state is
+  written again in the current format, which normalizes its whitespace. The
+  resource instances given with -remove are removed too, which is useful for
+  large objects that are no longer needed.
+
+  A backup of the state as it was originally stored is always written.
+
+Options:
+
+  -dry-run            Report what would be removed and the size the state
+                      would be reduced to, without changing it.
+
+  -remove=ADDRESS     Also remove the resource instance with the given
+                      address. This can be given multiple times.
+
+  -backup=PATH        Path where Terraform should write the backup
+                      state. If not set, Terraform will write it to the same
+                      path as the statefile with a backup extension. The
+                      backup can't be disabled, so -backup=- is an error.
+
+  -state=PATH         Path to the source state file. Defaults to the
+                      configured backend, or "terraform.tfstate".
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *StateCompactCommand) Synopsis() string {
+	return "Rewrite the state without accumulated cruft"
+}
+
+// compactState removes the given resource instances from the given state,
+// returning the addresses of those removed without duplicates, and then
+// prunes the resources left without any objects, returning their addresses
+// in order.
+//
+// A resource is pruned if none of its instances has a current or deposed
+// object. The state snapshot format would otherwise keep recording such a
+// resource with an empty list of instances, since it records resources
+// rather than instances. Deposed entries without an object and instances
+// without any objects are dropped along the way, and so are the modules
+// left empty. Normalizing whitespace happens when the state is written.
+func compactState(state *states.State, toRemove []addrs.AbsResourceInstance) (removed []addrs.AbsResourceInstance, pruned []addrs.AbsResource) {
+	ss := state.SyncWrapper()
+	seen := make(map[string]bool, len(toRemove))
+	for _, addr := range toRemove {
+		if seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		ss.ForgetResourceInstanceAll(addr)
+		removed = append(removed, addr)
+	}
+
+	for _, ms := range state.Modules {
+		for _, rs := range ms.Resources {
+			for key, is := range rs.Instances {
+				for dk, obj := range is.Deposed {
+					if obj == nil {
+						delete(is.Deposed, dk)
+					}
+				}
+				if !is.HasObjects() {
+					delete(rs.Instances, key)
+				}
+			}
+			if len(rs.Instances) == 0 {
+				pruned = append(pruned, rs.Addr.Absolute(ms.Addr))
+				ms.RemoveResource(rs.Addr)
+			}
+		}
+		if !ms.Addr.IsRoot() && len(ms.Resources) == 0 && len(ms.OutputValues) == 0 && len(ms.LocalValues) == 0 {
+			state.RemoveModule(ms.Addr)
+		}
+	}
+	sort.Slice(pruned, func(i, j int) bool {
+		if !pruned[i].Module.Equal(pruned[j].Module) {
+			return pruned[i].Module.Less(pruned[j].Module)
+		}
+		return pruned[i].Resource.String() < pruned[j].Resource.String()
+	})
+	return removed, pruned
+}
+
+// stateCompactSizeChange describes the change in the size of the stored state
+// snapshot, such as "Compacted the state from 2048 to 1024 bytes, saving 50%".
+func stateCompactSizeChange(verb string, before, after int) string {
+	saved := 0.0
+	if before > 0 {
+		saved = float64(before-after) / float64(before) * 100
+	}
+	return fmt.Sprintf("%s the state from %d to %d bytes, saving %.0f%%", verb, before, after, saved)
+}
+
+const errStateCompactPersist = `Error saving the state: %s
+
+The state was not saved. No changes were made to the persisted state.
+Please resolve the issue above and try again.`
@@ -0,0 +1,153 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+)
+
+func TestStateCompact(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	canonical, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same snapshot, but bloated with extra whitespace.
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, canonical, "", "                "); err != nil {
+		t.Fatal(err)
+	}
+	original := buf.Bytes()
+	if err := ioutil.WriteFile(statePath, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+	backupPath := statePath + ".backup"
+
+	c, ui := testStateCompactCommand()
+	args := []string{
+		"-state", statePath,
+		"-remove", "test_instance.foo",
+		"-dry-run",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Would remove test_instance.foo\n"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+	if got, want := ui.OutputWriter.String(), fmt.Sprintf("Would've compacted the state from %d to ", len(original)); !strings.Contains(got, want) {
+		t.Errorf("size not reported\ngot:  %s\nwant: %s", got, want)
+	}
+	if got, _ := ioutil.ReadFile(statePath); !bytes.Equal(got, original) {
+		t.Fatalf("dry run changed the state file\n%s", got)
+	}
+
+	c, ui = testStateCompactCommand()
+	args = []string{
+		"-state", statePath,
+		"-backup", backupPath,
+		"-remove", "test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	compacted, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compacted) >= len(original) {
+		t.Errorf("state grew from %d to %d bytes", len(original), len(compacted))
+	}
+	if got, want := ui.OutputWriter.String(), fmt.Sprintf("Compacted the state from %d to %d bytes", len(original), len(compacted)); !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+
+	// The backup is the snapshot exactly as it was stored.
+	if got, _ := ioutil.ReadFile(backupPath); !bytes.Equal(got, original) {
+		t.Errorf("wrong backup\n%s", got)
+	}
+}
+
+func TestStateCompact_noSuchInstance(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateCompactCommand()
+	args := []string{
+		"-state", statePath,
+		"-remove", "test_instance.baz",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "No such resource instance in state"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateCompact_emptyResources(t *testing.T) {
+	state := testStateRmState()
+	husk := addrs.Resource{
+		Mode: addrs.ManagedResourceMode,
+		Type: "test_instance",
+		Name: "gone",
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	child := addrs.RootModuleInstance.Child("child", addrs.NoKey)
+	state.RootModule().SetResourceMeta(husk, states.EachList, provider)
+	state.EnsureModule(child).SetResourceMeta(husk, states.EachMap, provider)
+	statePath := testStateFile(t, state)
+	if got := testStateRead(t, statePath).Resource(husk.Absolute(child)); got == nil {
+		t.Fatal("empty resource wasn't stored")
+	}
+
+	c, ui := testStateCompactCommand()
+	if code := c.Run([]string{"-state", statePath, "-dry-run"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := "Would remove test_instance.gone, which has no objects\nWould remove module.child.test_instance.gone, which has no objects\n"
+	if got := ui.OutputWriter.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant prefix:\n%s", got, want)
+	}
+
+	c, ui = testStateCompactCommand()
+	if code := c.Run([]string{"-state", statePath}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := testStateRead(t, statePath)
+	if got.Resource(husk.Absolute(addrs.RootModuleInstance)) != nil || got.Module(child) != nil {
+		t.Errorf("empty resources are still in the state")
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateCompact_backupDisabled(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateCompactCommand()
+	if code := c.Run([]string{"-state", statePath, "-backup", "-"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Backup can't be disabled"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func testStateCompactCommand() (*StateCompactCommand, *cli.MockUi) {
+	ui := cli.NewMockUi()
+	return &StateCompactCommand{
+		StateMeta{Meta: Meta{Ui: ui}},
+	}, ui
+}
@@ -1,11 +1,19 @@
 package command
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/hashicorp/terraform/addrs"
 	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
 	"github.com/mitchellh/cli"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
 )
 
 // StateListCommand is a Command implementation that lists the resources
@@ -24,11 +32,203 @@ func (c *StateListCommand) Run(args []string) int {
 	cmdFlags := c.Meta.flagSet("state list")
 	cmdFlags.StringVar(&c.Meta.statePath, "state", "", "path")
 	lookupId := cmdFlags.String("id", "", "Restrict output to paths with a resource having the specified ID.")
+	changedSince := cmdFlags.String("changed-since", "", "Restrict output to instances that differ from the given state file.")
+	sortBy := cmdFlags.String("sort", "", "Sort the output by type, name, or address.")
+	countOnly := cmdFlags.Bool("count-only", false, "Print only the number of instances.")
+	jsonOutput := cmdFlags.Bool("json", false, "Print the output as JSON.")
+	modulesOnly := cmdFlags.Bool("modules-only", false, "Print only the module instances, as a tree.")
+	resourcesOnly := cmdFlags.Bool("resources-only", false, "Print only the resources, without instance keys.")
+	cmdFlags.BoolVar(resourcesOnly, "resource-addresses-only", false, "The same as -resources-only.")
+	withCounts := cmdFlags.Bool("with-counts", false, "With -modules-only, print the number of resource instances in each module.")
+	orphans := cmdFlags.Bool("orphans", false, "Print only the instances whose resources are not in the configuration.")
+	filterStatus := cmdFlags.String("filter-status", "", "Print only the instances with an object of the given status.")
+	instanceKeyType := cmdFlags.Bool("instance-key-type", false, "Annotate each instance with the type of its instance key.")
+	providerSummary := cmdFlags.Bool("provider-summary", false, "Print the number of resources using each provider configuration.")
+	statusSummary := cmdFlags.Bool("status-summary", false, "Print the number of instances and objects of each status.")
+	duplicatedIDs := cmdFlags.Bool("duplicated-ids", false, "Print only the instances whose id is shared by another instance, grouped by id.")
+	paths := cmdFlags.Bool("paths", false, "Print the instances as a tree of modules.")
+	showDeposedKeys := cmdFlags.Bool("show-deposed-keys", false, "Follow each instance with the keys of its deposed objects.")
+	deposedOnly := cmdFlags.Bool("deposed-only", false, "With -show-deposed-keys, print only the instances with deposed objects.")
+	sinceApply := cmdFlags.Duration("since-apply", 0, "Print only the instances applied within the given duration.")
+	before := cmdFlags.Bool("before", false, "With -since-apply, print only the instances applied longer ago than the duration.")
+	depth := cmdFlags.Int("depth", 0, "With -paths, the number of levels of modules to expand.")
+	outputValues := cmdFlags.Bool("output-values", false, "Print the output values instead of the resource instances.")
+	showSensitive := cmdFlags.Bool("show-sensitive", false, "With -output-values, also print the value of each output.")
+	scopeModule := cmdFlags.String("module", "", "Print only the instances within the given module instance.")
+	noRecurse := cmdFlags.Bool("no-recurse", false, "With -module, leave out the instances in its child modules.")
+	graph := cmdFlags.Bool("graph", false, "Print the dependencies between the instances as a Graphviz DOT graph.")
+	focus := cmdFlags.String("focus", "", "With -graph, draw only the neighborhood of the given resource or instance.")
+	var attrPaths []string
+	cmdFlags.Var((*FlagStringSlice)(&attrPaths), "attr", "Print the value of the given attribute after each address.")
+	withID := cmdFlags.Bool("with-id", false, "Print the id of each instance after its address.")
+	csvOutput := cmdFlags.Bool("csv", false, "Print the output as CSV.")
+	newerSerialThan := cmdFlags.String("newer-serial-than", "", "Report whether the state is ahead of, behind, or diverged from the given state file.")
+	format := cmdFlags.String("format", "", "Print the output as text, json, json-lines, or csv.")
+	includeSensitive := cmdFlags.Bool("include-sensitive-marks", false, "Report the sensitive attributes of each instance.")
+	emptyModules := cmdFlags.Bool("empty-modules", false, "Print only the module instances that have no resource instances.")
+	diffConfig := cmdFlags.Bool("diff-config", false, "Compare the instances with the configuration in the current directory.")
 	if err := cmdFlags.Parse(args); err != nil {
 		return cli.RunResultHelp
 	}
 	args = cmdFlags.Args()
 
+	// The -format option is an alternative to -json and -csv, and json-lines
+	// is otherwise treated as -json, since it has the same content.
+	var jsonLines bool
+	if *format != "" && (*jsonOutput || *csvOutput) {
+		c.Ui.Error("The -format option can't be used with -json or -csv.")
+		return 1
+	}
+	switch *format {
+	case "", "text":
+	case "json":
+		*jsonOutput = true
+	case "json-lines":
+		*jsonOutput, jsonLines = true, true
+	case "csv":
+		*csvOutput = true
+	default:
+		c.Ui.Error(fmt.Sprintf("The -format option must be \"text\", \"json\", \"json-lines\", or \"csv\", not %q.", *format))
+		return 1
+	}
+	if jsonLines && (*countOnly || *outputValues) {
+		c.Ui.Error("The -format=json-lines option prints a line for each resource instance, so it can't be used with -count-only or -output-values.")
+		return 1
+	}
+
+	switch *sortBy {
+	case "", "type", "name", "address":
+	default:
+		c.Ui.Error(fmt.Sprintf("The -sort option must be \"type\", \"name\", or \"address\", not %q.", *sortBy))
+		return 1
+	}
+	switch *filterStatus {
+	case "", "ready", "tainted", "deposed":
+	default:
+		c.Ui.Error(fmt.Sprintf("The -filter-status option must be \"ready\", \"tainted\", or \"deposed\", not %q.", *filterStatus))
+		return 1
+	}
+	if *modulesOnly && *resourcesOnly {
+		c.Ui.Error("The -modules-only and -resources-only options can't be used together.")
+		return 1
+	}
+	if *jsonOutput && !*countOnly && (*modulesOnly || *resourcesOnly) {
+		c.Ui.Error("The -json option can only be used with -modules-only or -resources-only together with -count-only.")
+		return 1
+	}
+	if *instanceKeyType && (*jsonOutput || *countOnly || *modulesOnly || *resourcesOnly) {
+		c.Ui.Error("The -instance-key-type option annotates the address of each instance, so it can't be used with -json, -count-only, -modules-only, or -resources-only.")
+		return 1
+	}
+	if *providerSummary && (*jsonOutput || *countOnly || *modulesOnly || *resourcesOnly || *instanceKeyType) {
+		c.Ui.Error("The -provider-summary option prints a table instead of the resource instances, so it can't be used with -json, -count-only, -modules-only, -resources-only, or -instance-key-type.")
+		return 1
+	}
+	if *statusSummary && (*countOnly || *csvOutput || jsonLines || *modulesOnly || *resourcesOnly || *instanceKeyType || *providerSummary) {
+		c.Ui.Error("The -status-summary option prints the number of instances of each status instead of the resource instances, so it can't be used with -count-only, -csv, -modules-only, -resources-only, -instance-key-type, or -provider-summary.")
+		return 1
+	}
+	if *duplicatedIDs && (*countOnly || *csvOutput || jsonLines || *modulesOnly || *resourcesOnly || *instanceKeyType || *providerSummary || *statusSummary || *paths || *showDeposedKeys || *outputValues || *graph || len(attrPaths) > 0 || *withID || *includeSensitive) {
+		c.Ui.Error("The -duplicated-ids option prints the listed instances grouped by id, so it can't be used with -count-only, -csv, -modules-only, -resources-only, -instance-key-type, -provider-summary, -status-summary, -paths, -show-deposed-keys, -output-values, -graph, -attr, -with-id, or -include-sensitive-marks.")
+		return 1
+	}
+	if *paths && (*jsonOutput || *countOnly || *modulesOnly || *resourcesOnly || *providerSummary) {
+		c.Ui.Error("The -paths option prints the resource instances as a tree, so it can't be used with -json, -count-only, -modules-only, -resources-only, or -provider-summary.")
+		return 1
+	}
+	if *showDeposedKeys && (*jsonOutput || *countOnly || *modulesOnly || *resourcesOnly || *providerSummary || *paths) {
+		c.Ui.Error("The -show-deposed-keys option annotates the address of each instance, so it can't be used with -json, -count-only, -modules-only, -resources-only, -provider-summary, or -paths. The -json output already includes the deposed keys.")
+		return 1
+	}
+	if *deposedOnly && !*showDeposedKeys {
+		c.Ui.Error("The -deposed-only option can only be used with -show-deposed-keys.")
+		return 1
+	}
+	if *depth < 0 || (*depth > 0 && !*paths) {
+		c.Ui.Error("The -depth option must be a positive number of levels, and can only be used with -paths.")
+		return 1
+	}
+	if *before && *sinceApply == 0 {
+		c.Ui.Error("The -before option can only be used with -since-apply.")
+		return 1
+	}
+	if *sinceApply < 0 {
+		c.Ui.Error("The -since-apply option must be a positive duration, such as 1h.")
+		return 1
+	}
+	if *sinceApply > 0 {
+		// Listing every instance, or none, would look like an answer, so
+		// we refuse instead until the timestamps are recorded.
+		c.Ui.Error(fmt.Sprintf(errStateListNoApplyTimes, *sinceApply))
+		return 1
+	}
+	if *withCounts && !*modulesOnly {
+		c.Ui.Error("The -with-counts option can only be used with -modules-only.")
+		return 1
+	}
+	if *outputValues && (len(args) > 0 || *lookupId != "" || *changedSince != "" || *sortBy != "" || *countOnly || *modulesOnly || *resourcesOnly || *orphans || *filterStatus != "" || *instanceKeyType || *providerSummary || *paths || *showDeposedKeys) {
+		c.Ui.Error("The -output-values option lists the output values instead of the resource instances, so it can't be used with a pattern or with the other options that select or format resource instances, except for -json.")
+		return 1
+	}
+	if *showSensitive && !*outputValues {
+		c.Ui.Error("The -show-sensitive option can only be used with -output-values.")
+		return 1
+	}
+	var moduleAddr addrs.ModuleInstance
+	if *scopeModule != "" {
+		if *outputValues {
+			c.Ui.Error("The -module option scopes the listing of resource instances, so it can't be used with -output-values.")
+			return 1
+		}
+		addr, diags := addrs.ParseModuleInstanceStr(*scopeModule)
+		if diags.HasErrors() {
+			c.Ui.Error(fmt.Sprintf("The -module option must be the address of a module instance, such as module.foo or module.foo[\"a\"], not %q.", *scopeModule))
+			return 1
+		}
+		moduleAddr = addr
+	}
+	if *noRecurse && *scopeModule == "" {
+		c.Ui.Error("The -no-recurse option can only be used with -module.")
+		return 1
+	}
+	if *graph && (*jsonOutput || *countOnly || *modulesOnly || *resourcesOnly || *instanceKeyType || *providerSummary || *paths || *showDeposedKeys || *outputValues) {
+		c.Ui.Error("The -graph option prints the resource instances as a DOT graph, so it can't be used with -json, -count-only, -modules-only, -resources-only, -instance-key-type, -provider-summary, -paths, -show-deposed-keys, or -output-values.")
+		return 1
+	}
+	if *focus != "" && !*graph {
+		c.Ui.Error("The -focus option can only be used with -graph.")
+		return 1
+	}
+	if *csvOutput && *jsonOutput {
+		c.Ui.Error("The -csv and -json options can't be used together.")
+		return 1
+	}
+	if (len(attrPaths) > 0 || *withID || *csvOutput) && (*countOnly || *modulesOnly || *resourcesOnly || *instanceKeyType || *providerSummary || *paths || *showDeposedKeys || *outputValues || *graph) {
+		c.Ui.Error("The -attr, -with-id, and -csv options print a row for each resource instance, so they can't be used with -count-only, -modules-only, -resources-only, -instance-key-type, -provider-summary, -paths, -show-deposed-keys, -output-values, or -graph.")
+		return 1
+	}
+	if *newerSerialThan != "" && (len(args) > 0 || *lookupId != "" || *changedSince != "" || *sortBy != "" || *countOnly || *jsonOutput || *csvOutput || *modulesOnly || *resourcesOnly || *orphans || *filterStatus != "" || *instanceKeyType || *providerSummary || *paths || *showDeposedKeys || *outputValues || *scopeModule != "" || *graph || len(attrPaths) > 0 || *withID || *includeSensitive) {
+		c.Ui.Error("The -newer-serial-than option compares the whole state instead of listing its resource instances, so it can't be used with a pattern or with the other options.")
+		return 1
+	}
+	if *includeSensitive && (*countOnly || *csvOutput || *modulesOnly || *resourcesOnly || *providerSummary || *paths || *outputValues || *graph) {
+		c.Ui.Error("The -include-sensitive-marks option annotates each resource instance, so it can't be used with -count-only, -csv, -modules-only, -resources-only, -provider-summary, -paths, -output-values, or -graph.")
+		return 1
+	}
+	if *emptyModules && (len(args) > 0 || *lookupId != "" || *changedSince != "" || *sortBy != "" || *csvOutput || jsonLines || *modulesOnly || *resourcesOnly || *orphans || *filterStatus != "" || *instanceKeyType || *providerSummary || *paths || *showDeposedKeys || *outputValues || *noRecurse || *graph || len(attrPaths) > 0 || *withID || *newerSerialThan != "" || *includeSensitive) {
+		c.Ui.Error("The -empty-modules option lists module instances instead of resource instances, so it can only be used with -json, -count-only, and -module.")
+		return 1
+	}
+	if *diffConfig && (len(args) > 0 || *lookupId != "" || *changedSince != "" || *countOnly || *csvOutput || jsonLines || *modulesOnly || *resourcesOnly || *orphans || *filterStatus != "" || *instanceKeyType || *providerSummary || *paths || *showDeposedKeys || *outputValues || *graph || len(attrPaths) > 0 || *withID || *newerSerialThan != "" || *includeSensitive || *emptyModules) {
+		c.Ui.Error("The -diff-config option compares every resource instance with the configuration, so it can only be used with -json, -sort, -module, and -no-recurse.")
+		return 1
+	}
+	attrs, err := parseStateListAttrs(attrPaths)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
 	// Load the backend
 	b, backendDiags := c.Backend(nil)
 	if backendDiags.HasErrors() {
@@ -55,6 +255,22 @@ func (c *StateListCommand) Run(args []string) int {
 		return 1
 	}
 
+	if *newerSerialThan != "" {
+		return c.compareSerial(stateMgr, state, *newerSerialThan)
+	}
+
+	if *outputValues {
+		return c.outputValues(state, *jsonOutput, *showSensitive)
+	}
+
+	if *emptyModules {
+		modules := stateListEmptyModules(state, moduleAddr)
+		if *countOnly {
+			return c.outputCount(len(modules), *jsonOutput)
+		}
+		return c.outputEmptyModules(modules, *jsonOutput)
+	}
+
 	filter := &states.Filter{State: state}
 	results, err := filter.Filter(args...)
 	if err != nil {
@@ -62,17 +278,921 @@ func (c *StateListCommand) Run(args []string) int {
 		return cli.RunResultHelp
 	}
 
-	for _, result := range results {
-		if is, ok := result.Value.(*states.ResourceInstance); ok {
-			if *lookupId == "" || *lookupId == states.LegacyInstanceObjectID(is.Current) {
-				c.Ui.Output(result.Address)
+	// The instances to list are looked up in each of these states in turn,
+	// so that -json can describe instances that were removed since the
+	// baseline.
+	search := []*states.State{state}
+
+	var listed []string
+	if *changedSince != "" {
+		baseline, err := readStateFile(*changedSince)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to load baseline state %s: %s", *changedSince, err))
+			return 1
+		}
+
+		filter := &states.Filter{State: baseline.State}
+		baseResults, err := filter.Filter(args...)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf(errStateFilter, err))
+			return cli.RunResultHelp
+		}
+
+		listed = changedInstances(results, baseResults)
+		search = append(search, baseline.State)
+	} else {
+		for _, result := range results {
+			if is, ok := result.Value.(*states.ResourceInstance); ok {
+				if *lookupId == "" || *lookupId == states.LegacyInstanceObjectID(is.Current) {
+					listed = append(listed, result.Address)
+				}
+			}
+		}
+	}
+
+	if moduleAddr != nil {
+		listed = stateListInModule(listed, moduleAddr, !*noRecurse)
+	}
+
+	if *diffConfig {
+		config, configDiags := c.Meta.loadConfig(".")
+		if configDiags.HasErrors() {
+			c.showDiagnostics(configDiags)
+			return 1
+		}
+		c.showDiagnostics(configDiags)
+
+		listed, err = sortStateListAddrs(listed, *sortBy)
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		diff := stateListDiffConfig(config, state, listed, moduleAddr, !*noRecurse)
+		return c.outputConfigDiff(diff, *jsonOutput)
+	}
+
+	if *orphans {
+		config, configDiags := c.Meta.loadConfig(".")
+		if configDiags.HasErrors() {
+			c.showDiagnostics(configDiags)
+			return 1
+		}
+		c.showDiagnostics(configDiags)
+
+		orphaned := make(map[string]bool)
+		for _, addr := range configOrphanedInstances(config, state) {
+			orphaned[addr.String()] = true
+		}
+		var kept []string
+		for _, addr := range listed {
+			if orphaned[addr] {
+				kept = append(kept, addr)
+			}
+		}
+		listed = kept
+	}
+
+	if *filterStatus != "" {
+		listed = stateListFilterStatus(state, listed, *filterStatus)
+	}
+	if *deposedOnly {
+		listed = stateListFilterStatus(state, listed, "deposed")
+	}
+
+	if *graph {
+		return c.outputGraph(state, listed, *focus)
+	}
+
+	if *providerSummary {
+		summary, err := stateListProviderSummary(listed, search...)
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		c.Ui.Output(summary)
+		return 0
+	}
+
+	if *statusSummary {
+		return c.outputStatusSummary(stateListStatusCounts(state, listed), *jsonOutput)
+	}
+
+	if *duplicatedIDs {
+		return c.outputDuplicateIDs(stateListDuplicateIDs(listed, search...), *jsonOutput)
+	}
+
+	if *modulesOnly {
+		modules, err := stateListModules(listed)
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		if *countOnly {
+			return c.outputCount(len(modules), *jsonOutput)
+		}
+		for _, mod := range modules {
+			line := strings.Repeat("  ", len(mod.Addr)-1) + mod.Addr.String()
+			if *withCounts {
+				line = fmt.Sprintf("%s: %d", line, mod.Count)
+			}
+			c.Ui.Output(line)
+		}
+		return 0
+	}
+
+	if *countOnly && !*resourcesOnly {
+		return c.outputCount(len(listed), *jsonOutput)
+	}
+
+	listed, err = sortStateListAddrs(listed, *sortBy)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+	if *resourcesOnly {
+		listed = stateListResources(listed)
+		if *countOnly {
+			return c.outputCount(len(listed), *jsonOutput)
+		}
+	}
+	var sensitive map[string]stateListSensitive
+	if *includeSensitive {
+		var diags tfdiags.Diagnostics
+		sensitive, diags = c.sensitiveAttrs(listed, search...)
+		c.showDiagnostics(diags)
+	}
+	if *jsonOutput {
+		return c.outputJSON(listed, *withID, attrs, sensitive, jsonLines, search...)
+	}
+	if *csvOutput {
+		src, err := marshalStateListCSV(listed, *withID, attrs, search...)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to write CSV: %s", err))
+			return 1
+		}
+		c.Ui.Output(strings.TrimSuffix(string(src), "\n"))
+		return 0
+	}
+	if *paths {
+		tree, err := stateListTree(listed)
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		var buf bytes.Buffer
+		writeStateListTree(&buf, tree, "", 1, *depth, *instanceKeyType)
+		c.Ui.Output(c.Colorize().Color(strings.TrimSuffix(buf.String(), "\n")))
+		return 0
+	}
+	for _, addr := range listed {
+		line := addr
+		if *withID {
+			id := stateListInstanceID(addr, search...)
+			if id == "" {
+				id = "-"
+			}
+			line = line + "\t" + id
+		}
+		if len(attrs) > 0 {
+			fields := []string{line}
+			for _, val := range stateListAttrValues(attrs, addr, search...) {
+				fields = append(fields, stateListAttrString(val))
+			}
+			line = strings.Join(fields, "\t")
+		}
+		if *instanceKeyType {
+			line = fmt.Sprintf("%s (%s)", line, stateListKeyType(addr))
+		}
+		if *showDeposedKeys {
+			if keys := stateListDeposedKeys(addr, search...); len(keys) > 0 {
+				line = fmt.Sprintf("%s (deposed: %s)", line, strings.Join(keys, ", "))
+			}
+		}
+		if sens, ok := sensitive[addr]; ok {
+			line = fmt.Sprintf("%s (%s)", line, sens)
+		}
+		c.Ui.Output(line)
+	}
+
+	return 0
+}
+
+// outputGraph prints the dependencies recorded in the given state between the
+// resource instances with the given addresses as a DOT graph, for the -graph
+// option, returning the exit status for the command. With a focus address,
+// only the neighborhood of the instances it selects is drawn.
+func (c *StateListCommand) outputGraph(state *states.State, listed []string, focus string) int {
+	nodes, edges := stateListDependencyEdges(state, listed)
+
+	var focused map[string]bool
+	if focus != "" {
+		focused = make(map[string]bool)
+		for _, addr := range stateRmSelectorInstances(state, focus) {
+			focused[addr.String()] = true
+		}
+		if len(focused) == 0 {
+			c.Ui.Error(fmt.Sprintf("The -focus option must select a resource or resource instance in the state, but there is nothing matching %q.", focus))
+			return 1
+		}
+		nodes, edges = stateListFocus(nodes, edges, focused)
+	}
+
+	var buf bytes.Buffer
+	writeStateListGraph(&buf, nodes, edges, focused)
+	c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+	return 0
+}
+
+// stateListKeyType returns the kind of instance key in the given resource
+// instance address, for the -instance-key-type option: "int" for an instance
+// of a resource using count, "string" for one using for_each, or "no-key"
+// for the single instance of a resource that uses neither.
+func stateListKeyType(rawAddr string) string {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+	if diags.HasErrors() {
+		return "no-key"
+	}
+	switch addr.Resource.Key.(type) {
+	case addrs.IntKey:
+		return "int"
+	case addrs.StringKey:
+		return "string"
+	default:
+		return "no-key"
+	}
+}
+
+// stateListDeposedKeys returns the keys of the deposed objects of the
+// resource instance with the given address, in lexical order, for the
+// -show-deposed-keys option. The instance is looked up in each of the given
+// states in turn.
+func stateListDeposedKeys(rawAddr string, search ...*states.State) []string {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+	if diags.HasErrors() {
+		return nil
+	}
+	for _, state := range search {
+		if is := state.ResourceInstance(addr); is != nil {
+			keys := make([]string, 0, len(is.Deposed))
+			for k := range is.Deposed {
+				keys = append(keys, string(k))
+			}
+			sort.Strings(keys)
+			return keys
+		}
+	}
+	return nil
+}
+
+// stateListJSONInstance is the JSON representation of a single resource
+// instance, as produced by "terraform state list -json".
+type stateListJSONInstance struct {
+	Address  string      `json:"address"`
+	Mode     string      `json:"mode"`
+	Type     string      `json:"type"`
+	Name     string      `json:"name"`
+	Module   string      `json:"module"`
+	Provider string      `json:"provider"`
+	IndexKey interface{} `json:"index_key"`
+
+	// Status is the status of the current object, or null if the instance
+	// has only deposed objects.
+	Status  *string  `json:"status"`
+	Deposed []string `json:"deposed"`
+
+	// ID is the id attribute of the current object, or an empty string if
+	// it has none. It is omitted without -with-id.
+	ID *string `json:"id,omitempty"`
+
+	// Attributes has the value of each -attr path, by path, or null for an
+	// attribute the current object doesn't have. It is omitted without -attr.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+
+	// Sensitive describes the sensitive attributes of the current object,
+	// and is omitted without -include-sensitive-marks.
+	Sensitive *stateListSensitiveJSON `json:"sensitive,omitempty"`
+}
+
+// outputJSON prints a JSON array describing each of the resource instances
+// with the given addresses, which are looked up in each of the given states
+// in turn. Each element is marshalled and printed as soon as it is ready, so
+// that the whole array is never held in memory alongside the state.
+//
+// With lines, each element is instead printed as a JSON object on a line of
+// its own, without the array around them, for -format=json-lines.
+func (c *StateListCommand) outputJSON(rawAddrs []string, withID bool, attrs []stateListAttr, sensitive map[string]stateListSensitive, lines bool, search ...*states.State) int {
+	if !lines {
+		c.Ui.Output("[")
+	}
+	for i, rawAddr := range rawAddrs {
+		src, err := stateListInstanceJSON(rawAddr, withID, attrs, sensitive, search...)
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+		if !lines && i < len(rawAddrs)-1 {
+			src = append(src, ',')
+		}
+		c.Ui.Output(string(src))
+	}
+	if !lines {
+		c.Ui.Output("]")
+	}
+	return 0
+}
+
+// stateListInstanceJSON returns the JSON representation of the resource
+// instance with the given address, which is looked up in each of the given
+// states in turn, on a single line. With withID, the id of the instance is
+// included.
+func stateListInstanceJSON(rawAddr string, withID bool, attrs []stateListAttr, sensitive map[string]stateListSensitive, search ...*states.State) ([]byte, error) {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("Invalid resource instance address %q in state: %s", rawAddr, diags.Err())
+	}
+
+	var rs *states.Resource
+	var is *states.ResourceInstance
+	for _, state := range search {
+		if rs = state.Resource(addr.ContainingResource()); rs != nil {
+			if is = rs.Instance(addr.Resource.Key); is != nil {
+				break
+			}
+		}
+	}
+	if is == nil {
+		return nil, fmt.Errorf("Resource instance %s is missing from the state.", rawAddr)
+	}
+
+	inst := stateListJSON(addr, rs, is)
+	if withID {
+		id := stateListInstanceID(rawAddr, search...)
+		inst.ID = &id
+	}
+	if len(attrs) > 0 {
+		inst.Attributes = make(map[string]interface{}, len(attrs))
+		for i, val := range stateListAttrValues(attrs, rawAddr, search...) {
+			inst.Attributes[attrs[i].Path] = val
+		}
+	}
+	if sens, ok := sensitive[rawAddr]; ok {
+		inst.Sensitive = &stateListSensitiveJSON{UnknownReason: sens.Unknown}
+		if sens.Unknown == "" {
+			inst.Sensitive.Attributes = append([]string{}, sens.Paths...)
+		}
+	}
+	src, err := json.Marshal(inst)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal %s to JSON: %s", rawAddr, err)
+	}
+	return src, nil
+}
+
+func stateListJSON(addr addrs.AbsResourceInstance, rs *states.Resource, is *states.ResourceInstance) *stateListJSONInstance {
+	res := addr.Resource.Resource
+	ret := &stateListJSONInstance{
+		Address:  addr.String(),
+		Type:     res.Type,
+		Name:     res.Name,
+		Module:   addr.Module.String(),
+		Provider: rs.ProviderConfig.String(),
+		Deposed:  []string{},
+	}
+
+	switch res.Mode {
+	case addrs.ManagedResourceMode:
+		ret.Mode = "managed"
+	case addrs.DataResourceMode:
+		ret.Mode = "data"
+	}
+
+	switch key := addr.Resource.Key.(type) {
+	case addrs.IntKey:
+		ret.IndexKey = int(key)
+	case addrs.StringKey:
+		ret.IndexKey = string(key)
+	}
+
+	if is.Current != nil {
+		var status string
+		switch is.Current.Status {
+		case states.ObjectTainted:
+			status = "tainted"
+		default:
+			status = "ready"
+		}
+		ret.Status = &status
+	}
+	for k := range is.Deposed {
+		ret.Deposed = append(ret.Deposed, string(k))
+	}
+	sort.Strings(ret.Deposed)
+
+	return ret
+}
+
+// stateListOutputJSON is the JSON representation of an output value listed by
+// "terraform state list -output-values -json".
+type stateListOutputJSON struct {
+	Address   string `json:"address"`
+	Module    string `json:"module"`
+	Name      string `json:"name"`
+	Sensitive bool   `json:"sensitive"`
+
+	// Value is included only with -show-sensitive, so that listing the
+	// output values never reveals a value by default.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// outputValues prints the output values of each module in the given state, in
+// order, for the -output-values option. Their values are printed only if
+// showValues is set, including those of sensitive output values. Otherwise
+// each sensitive output value is marked as such.
+func (c *StateListCommand) outputValues(state *states.State, jsonOutput, showValues bool) int {
+	var listed []addrs.AbsOutputValue
+	for _, ms := range state.Modules {
+		for name := range ms.OutputValues {
+			listed = append(listed, ms.Addr.OutputValue(name))
+		}
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		if !listed[i].Module.Equal(listed[j].Module) {
+			return listed[i].Module.Less(listed[j].Module)
+		}
+		return listed[i].OutputValue.Name < listed[j].OutputValue.Name
+	})
+
+	var out []stateListOutputJSON
+	var lines []string
+	for _, addr := range listed {
+		ov := state.Module(addr.Module).OutputValues[addr.OutputValue.Name]
+		ret := stateListOutputJSON{
+			Address:   addr.String(),
+			Module:    addr.Module.String(),
+			Name:      addr.OutputValue.Name,
+			Sensitive: ov.Sensitive,
+		}
+		if showValues {
+			src, err := ctyjson.Marshal(ov.Value, ov.Value.Type())
+			if err != nil {
+				c.Ui.Error(fmt.Sprintf("Failed to marshal the value of %s to JSON: %s", addr, err))
+				return 1
 			}
+			ret.Value = json.RawMessage(src)
+		}
+		out = append(out, ret)
+
+		line := ret.Address
+		switch {
+		case showValues:
+			line = fmt.Sprintf("%s = %s", line, ret.Value)
+		case ov.Sensitive:
+			line += " (sensitive)"
+		}
+		lines = append(lines, line)
+	}
+
+	if jsonOutput {
+		if out == nil {
+			out = []stateListOutputJSON{}
+		}
+		src, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to marshal the output values to JSON: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(src))
+		return 0
+	}
+	for _, line := range lines {
+		c.Ui.Output(line)
+	}
+	return 0
+}
+
+// stateListInModule returns those of the given resource instance addresses
+// that are within the given module instance, for the -module option. With
+// recurse, this includes those in all of its descendent modules, and
+// otherwise only those declared directly in the module itself.
+//
+// Unlike a module address given as a pattern, a module step without an
+// instance key only matches a module without count or for_each.
+func stateListInModule(rawAddrs []string, module addrs.ModuleInstance, recurse bool) []string {
+	var ret []string
+	for _, rawAddr := range rawAddrs {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if diags.HasErrors() {
+			continue
+		}
+		if !stateMvModuleContains(module, addr.Module) {
+			continue
+		}
+		if recurse || len(addr.Module) == len(module) {
+			ret = append(ret, rawAddr)
+		}
+	}
+	return ret
+}
+
+// stateListFilterStatus returns those of the given instance addresses whose
+// instances in the given state have an object with the given status, for the
+// -filter-status option: "ready" or "tainted" for the status of the current
+// object, or "deposed" for an instance with any deposed objects. Instances
+// that aren't in the state at all, such as those listed by -changed-since
+// because they were removed, have no objects and so are never kept.
+func stateListFilterStatus(state *states.State, rawAddrs []string, status string) []string {
+	var ret []string
+	for _, rawAddr := range rawAddrs {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if diags.HasErrors() {
+			continue
+		}
+		is := state.ResourceInstance(addr)
+		if is == nil {
+			continue
+		}
+
+		var keep bool
+		switch status {
+		case "ready":
+			keep = is.Current != nil && is.Current.Status == states.ObjectReady
+		case "tainted":
+			keep = is.Current != nil && is.Current.Status == states.ObjectTainted
+		case "deposed":
+			keep = len(is.Deposed) > 0
+		}
+		if keep {
+			ret = append(ret, rawAddr)
 		}
 	}
+	return ret
+}
+
+// stateListStatusSummary is the number of resource instances and objects of
+// each status printed by the -status-summary option.
+type stateListStatusSummary struct {
+	// Ready and Tainted are the numbers of instances whose current object
+	// has each status, and NoCurrent the number with only deposed objects.
+	Ready, Tainted, NoCurrent int
+
+	// Deposed is the number of deposed objects, across all instances.
+	Deposed int
+}
+
+// stateListStatusCounts returns the number of the resource instances in the
+// given state with the given addresses that have each status, for the
+// -status-summary option. Addresses that aren't in the state are skipped.
+func stateListStatusCounts(state *states.State, rawAddrs []string) stateListStatusSummary {
+	var ret stateListStatusSummary
+	for _, rawAddr := range rawAddrs {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if diags.HasErrors() {
+			continue
+		}
+		is := state.ResourceInstance(addr)
+		if is == nil {
+			continue
+		}
 
+		switch {
+		case is.Current == nil:
+			ret.NoCurrent++
+		case is.Current.Status == states.ObjectTainted:
+			ret.Tainted++
+		default:
+			ret.Ready++
+		}
+		ret.Deposed += len(is.Deposed)
+	}
+	return ret
+}
+
+// outputStatusSummary prints the given numbers for the -status-summary
+// option, one category per line, returning the exit status for the command.
+func (c *StateListCommand) outputStatusSummary(summary stateListStatusSummary, jsonOutput bool) int {
+	if jsonOutput {
+		src, err := json.Marshal(struct {
+			Ready     int `json:"ready"`
+			Tainted   int `json:"tainted"`
+			Deposed   int `json:"deposed_objects"`
+			NoCurrent int `json:"no_current_object"`
+		}{summary.Ready, summary.Tainted, summary.Deposed, summary.NoCurrent})
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to marshal summary to JSON: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(src))
+		return 0
+	}
+
+	c.Ui.Output(fmt.Sprintf(
+		"Ready:             %d\nTainted:           %d\nDeposed objects:   %d\nNo current object: %d",
+		summary.Ready, summary.Tainted, summary.Deposed, summary.NoCurrent,
+	))
 	return 0
 }
 
+// stateListProviderSummary returns a table of the provider configurations of
+// the resources containing the resource instances with the given addresses,
+// each with the number of those resources that it manages, for the
+// -provider-summary option. The resources are looked up in each of the given
+// states in turn, and the table is ordered by provider configuration.
+func stateListProviderSummary(rawAddrs []string, search ...*states.State) (string, error) {
+	counts := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, rawAddr := range rawAddrs {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if diags.HasErrors() {
+			return "", fmt.Errorf("Invalid resource instance address %q in state: %s", rawAddr, diags.Err())
+		}
+		resAddr := addr.ContainingResource()
+		if seen[resAddr.String()] {
+			continue
+		}
+		seen[resAddr.String()] = true
+
+		for _, state := range search {
+			if rs := state.Resource(resAddr); rs != nil {
+				counts[rs.ProviderConfig.String()]++
+				break
+			}
+		}
+	}
+
+	providers := make([]string, 0, len(counts))
+	width := len("PROVIDER")
+	for provider := range counts {
+		providers = append(providers, provider)
+		if len(provider) > width {
+			width = len(provider)
+		}
+	}
+	sort.Strings(providers)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%-*s  RESOURCES\n", width, "PROVIDER")
+	for _, provider := range providers {
+		fmt.Fprintf(&buf, "%-*s  %d\n", width, provider, counts[provider])
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// stateListModule is a module instance listed by -modules-only.
+type stateListModule struct {
+	Addr addrs.ModuleInstance
+
+	// Count is the number of the listed resource instances that are in the
+	// module or in any of its descendents.
+	Count int
+}
+
+// stateListModules returns the module instances containing the resource
+// instances with the given addresses, along with all of their ancestors
+// other than the root module. The result is in tree order, so that each
+// module is followed by its descendents.
+func stateListModules(rawAddrs []string) ([]*stateListModule, error) {
+	byAddr := make(map[string]*stateListModule)
+	var ret []*stateListModule
+	for _, rawAddr := range rawAddrs {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("Invalid resource instance address %q in state: %s", rawAddr, diags.Err())
+		}
+		for _, modAddr := range addr.Module.Ancestors()[1:] {
+			mod, ok := byAddr[modAddr.String()]
+			if !ok {
+				mod = &stateListModule{Addr: modAddr}
+				byAddr[modAddr.String()] = mod
+				ret = append(ret, mod)
+			}
+			mod.Count++
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i].Addr, ret[j].Addr
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k].Name != b[k].Name {
+				return a[k].Name < b[k].Name
+			}
+			if a[k].InstanceKey != b[k].InstanceKey {
+				return addrs.InstanceKeyLess(a[k].InstanceKey, b[k].InstanceKey)
+			}
+		}
+		return len(a) < len(b)
+	})
+	return ret, nil
+}
+
+// stateListResources returns the addresses of the resources containing the
+// resource instances with the given already-validated addresses, in the order
+// that each resource first appears.
+func stateListResources(rawAddrs []string) []string {
+	var ret []string
+	seen := make(map[string]bool)
+	for _, rawAddr := range rawAddrs {
+		addr, _ := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		resAddr := addr.ContainingResource().String()
+		if seen[resAddr] {
+			continue
+		}
+		seen[resAddr] = true
+		ret = append(ret, resAddr)
+	}
+	return ret
+}
+
+// stateListTreeNode is a node in the tree printed by -paths: either a module
+// instance, whose children are the resource instances and module instances
+// within it, or a resource instance, which has no children.
+type stateListTreeNode struct {
+	// Label is the address of the node relative to its parent.
+	Label string
+
+	Module   bool
+	Children []*stateListTreeNode
+
+	// Count is the number of resource instances in a module node and its
+	// descendents.
+	Count int
+
+	modules map[string]*stateListTreeNode
+}
+
+// stateListTree returns the root module node of a tree of the resource
+// instances with the given addresses. The resource instances and the child
+// modules of each module are each in the order that they first appear in the
+// given addresses.
+func stateListTree(rawAddrs []string) (*stateListTreeNode, error) {
+	root := &stateListTreeNode{Module: true}
+	for _, rawAddr := range rawAddrs {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("Invalid resource instance address %q in state: %s", rawAddr, diags.Err())
+		}
+
+		node := root
+		node.Count++
+		for _, step := range addr.Module {
+			label := addrs.ModuleInstance{step}.String()
+			child, ok := node.modules[label]
+			if !ok {
+				child = &stateListTreeNode{Label: label, Module: true}
+				if node.modules == nil {
+					node.modules = make(map[string]*stateListTreeNode)
+				}
+				node.modules[label] = child
+				node.Children = append(node.Children, child)
+			}
+			node = child
+			node.Count++
+		}
+		node.Children = append(node.Children, &stateListTreeNode{Label: addr.Resource.String()})
+	}
+	return root, nil
+}
+
+// writeStateListTree writes the children of the given module node, which is
+// at the given level of nesting, to the given buffer with box-drawing
+// characters, each line starting with the given prefix. Modules nested more
+// than maxDepth levels deep, if maxDepth isn't zero, are collapsed into a
+// single line giving the number of resource instances in them.
+func writeStateListTree(buf *bytes.Buffer, node *stateListTreeNode, prefix string, level, maxDepth int, keyType bool) {
+	// The resource instances of a module come before its child modules, as
+	// in the flat list.
+	children := make([]*stateListTreeNode, 0, len(node.Children))
+	for _, child := range node.Children {
+		if !child.Module {
+			children = append(children, child)
+		}
+	}
+	for _, child := range node.Children {
+		if child.Module {
+			children = append(children, child)
+		}
+	}
+
+	for i, child := range children {
+		branch, indent := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		switch {
+		case !child.Module:
+			label := child.Label
+			if keyType {
+				label = fmt.Sprintf("%s (%s)", label, stateListKeyType(label))
+			}
+			fmt.Fprintf(buf, "%s%s%s\n", prefix, branch, label)
+		case maxDepth > 0 && level > maxDepth:
+			fmt.Fprintf(buf, "%s%s[bold]%s[reset] (%d resource instances)\n", prefix, branch, child.Label, child.Count)
+		default:
+			fmt.Fprintf(buf, "%s%s[bold]%s[reset]\n", prefix, branch, child.Label)
+			writeStateListTree(buf, child, prefix+indent, level+1, maxDepth, keyType)
+		}
+	}
+}
+
+// outputCount prints the given number of resource instances for the
+// -count-only option, either alone or as a JSON object.
+func (c *StateListCommand) outputCount(count int, jsonOutput bool) int {
+	if !jsonOutput {
+		c.Ui.Output(strconv.Itoa(count))
+		return 0
+	}
+
+	src, err := json.Marshal(struct {
+		Count int `json:"count"`
+	}{count})
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to marshal count to JSON: %s", err))
+		return 1
+	}
+	c.Ui.Output(string(src))
+	return 0
+}
+
+// sortStateListAddrs sorts the given resource instance addresses for the
+// -sort option of "terraform state list". The addresses are always sorted
+// by module first, so that the instances in each module are together, and
+// then by resource type, resource name, or the whole resource address. An
+// empty sort order leaves the addresses as they are.
+func sortStateListAddrs(rawAddrs []string, by string) ([]string, error) {
+	if by == "" {
+		return rawAddrs, nil
+	}
+
+	parsed := make([]addrs.AbsResourceInstance, len(rawAddrs))
+	for i, rawAddr := range rawAddrs {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("Invalid resource instance address %q in state: %s", rawAddr, diags.Err())
+		}
+		parsed[i] = addr
+	}
+
+	less := func(a, b addrs.AbsResourceInstance) bool {
+		ra, rb := a.Resource.Resource, b.Resource.Resource
+		switch {
+		case a.Module.String() != b.Module.String():
+			return a.Module.Less(b.Module)
+		case by == "type" && ra.Type != rb.Type:
+			return ra.Type < rb.Type
+		case by == "name" && ra.Name != rb.Name:
+			return ra.Name < rb.Name
+		default:
+			return a.Less(b)
+		}
+	}
+
+	idx := make([]int, len(parsed))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return less(parsed[idx[i]], parsed[idx[j]])
+	})
+
+	ret := make([]string, len(idx))
+	for i, j := range idx {
+		ret[i] = rawAddrs[j]
+	}
+	return ret, nil
+}
+
+// changedInstances compares the resource instances in the two given sets of
+// filter results and returns the sorted addresses of all instances that were
+// added, removed, or changed in the current results relative to the
+// baseline results.
+func changedInstances(current, baseline []*states.FilterResult) []string {
+	instances := func(results []*states.FilterResult) map[string]*states.ResourceInstance {
+		ret := make(map[string]*states.ResourceInstance)
+		for _, result := range results {
+			if is, ok := result.Value.(*states.ResourceInstance); ok {
+				ret[result.Address] = is
+			}
+		}
+		return ret
+	}
+	cur := instances(current)
+	base := instances(baseline)
+
+	var changed []string
+	for addr, is := range cur {
+		if !reflect.DeepEqual(is, base[addr]) {
+			changed = append(changed, addr)
+		}
+	}
+	for addr := range base {
+		if _, exists := cur[addr]; !exists {
+			changed = append(changed, addr)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
 func (c *StateListCommand) Help() string {
 	helpText := `
 Usage: terraform state list [options] [pattern...]
@@ -99,6 +1219,171 @@ Options:
 
   -id=ID              Restricts the output to objects whose id is ID.
 
+  -changed-since=PATH Restricts the output to instances that were added,
+                      removed, or changed relative to the baseline state
+                      file at PATH.
+
+  -count-only         Print only the number of matching resource instances,
+                      rather than their addresses.
+
+  -json               Print a JSON array with an object for each resource
+                      instance, giving its address, mode, type, name,
+                      module, provider, index key, the status of its
+                      current object, and the keys of its deposed objects.
+                      With -count-only, print the number as a JSON object
+                      with a "count" property instead.
+
+  -format=FORMAT      The format of the output: "text", the default, "json"
+                      or "csv", the same as -json or -csv, or "json-lines",
+                      to print the same objects as -json one per line
+                      without the array around them, as each is found. This
+                      suits line-oriented log shippers, and state too large
+                      to parse as a single JSON array.
+
+  -include-sensitive-marks
+                      Follow the address of each resource instance with the
+                      paths of its attributes that are sensitive, without
+                      their values, or "none". The state doesn't record which
+                      values are sensitive, so this uses the schemas of the
+                      installed providers, and says when it can't tell, such
+                      as when a provider isn't installed. With -json, this is
+                      a "sensitive" object for each instance.
+
+  -sort=ORDER         Sort the output by resource "type", by resource
+                      "name", or by the whole "address". Instances are
+                      always grouped by module first.
+
+  -orphans            Print only the resource instances whose resources are
+                      no longer declared in the configuration in the current
+                      directory, as candidates for "terraform state rm".
+
+  -diff-config        Instead of a plain list, sort the resource instances
+                      into those whose resources are declared in the
+                      configuration in the current directory ("in-config")
+                      and those that aren't ("orphan"), and also list the
+                      resources in the configuration that aren't in the
+                      state yet ("missing"). Can be used with -json, -sort,
+                      -module, and -no-recurse.
+
+  -filter-status=STATUS  Print only the resource instances whose current
+                      object has the status "ready" or "tainted", or that
+                      have "deposed" objects.
+
+  -instance-key-type  Follow the address of each resource instance with the
+                      type of its instance key: "(int)" for count,
+                      "(string)" for for_each, or "(no-key)" for neither.
+                      This helps to spot resources that changed between
+                      count and for_each.
+
+  -show-deposed-keys  Follow the address of each resource instance that has
+                      deposed objects with their keys, as in
+                      "(deposed: 00000001, 00000002)".
+
+  -deposed-only       With -show-deposed-keys, print only the resource
+                      instances that have deposed objects.
+
+  -provider-summary   Instead of listing the resource instances, print a
+                      table of each provider configuration used by the
+                      matching resources and the number of resources it
+                      manages.
+
+  -status-summary     Instead of listing the resource instances, print the
+                      number of them whose current object is ready or
+                      tainted, the number of deposed objects they have, and
+                      the number with no current object. This can be used
+                      with -json.
+
+  -duplicated-ids     Print only the managed resource instances whose current
+                      object has the same id as another of the same
+                      resource type, grouped by id, to find objects that
+                      were imported or copied more than once. This can be
+                      used with -json.
+
+  -paths              Print the resource instances as a tree, with each
+                      module instance as a branch containing its resource
+                      instances and child modules.
+
+  -depth=N            With -paths, only expand modules nested up to N
+                      levels deep, printing the number of resource
+                      instances in each deeper module instead.
+
+  -since-apply=DURATION  Print only the resource instances applied within
+                      the given duration, such as 1h, or with -before, those
+                      applied longer ago. The state doesn't currently record
+                      when each object was applied, so this fails with an
+                      explanation rather than give a misleading list.
+
+  -output-values      Instead of the resource instances, print the address of
+                      each output value in the state, marking those that
+                      are sensitive. With -json, print a JSON array with an
+                      object for each output value.
+
+  -show-sensitive     With -output-values, also print the value of each
+                      output value, including sensitive ones, as JSON.
+
+  -modules-only       Print only the addresses of the module instances that
+                      contain matching resource instances, along with their
+                      ancestors, as a tree indented by nesting depth.
+
+  -with-counts        With -modules-only, also print the number of matching
+                      resource instances in each module instance, including
+                      those in its descendents.
+
+  -empty-modules      Print only the module instances that have no resource
+                      instances, either in themselves or in any of their
+                      descendents, followed by any resources without
+                      instances that they still have. These are the modules
+                      left behind once their resources have been removed.
+                      Can be used with -json, -count-only, and -module.
+
+  -resources-only     Print only the addresses of the resources that have
+                      matching instances, without instance keys.
+
+  -resource-addresses-only  The same as -resources-only.
+
+  -module=ADDRESS     Print only the resource instances within the module
+                      instance ADDRESS, including those in its child
+                      modules. Unlike a pattern, this always selects a
+                      single module instance.
+
+  -no-recurse         With -module, print only the resource instances
+                      declared directly in the module, and not those in its
+                      child modules.
+
+  -graph              Print the dependencies recorded in the state between
+                      the matching resource instances as a Graphviz DOT
+                      graph, with an edge from each instance to each
+                      instance it depends on.
+
+  -focus=ADDRESS      With -graph, only draw the resource or resource
+                      instance ADDRESS, the instances it directly depends
+                      on, and the instances that directly depend on it.
+
+  -newer-serial-than=PATH  Instead of listing the resource instances, compare
+                      the lineage and serial of the state with those of the
+                      state file at PATH, such as one saved by "terraform
+                      state pull", and report whether the state is ahead of
+                      it, behind it, or has diverged from it. Exits with
+                      status 2 if the state is behind or has diverged, as
+                      a check before running commands that change it.
+
+  -attr=PATH          Follow the address of each resource instance with the
+                      value of the attribute at PATH in its current object,
+                      separated by a tab, or nothing if it has no such
+                      attribute. PATH is written as for "terraform state
+                      show -attr". This can be given multiple times to print
+                      several attributes in order. With -json, the values
+                      are given in an "attributes" object instead.
+
+  -with-id            Follow the address of each resource instance with the
+                      id attribute of its current object, separated by a
+                      tab, or "-" if it has none. With -json and -csv, the
+                      id is given in an "id" field instead.
+
+  -csv                Print a CSV table with a row for each resource
+                      instance, giving its address, its id with -with-id,
+                      and the value of each -attr attribute.
+
 `
 	return strings.TrimSpace(helpText)
 }
@@ -107,6 +1392,14 @@ func (c *StateListCommand) Synopsis() string {
 	return "List resources in the state"
 }
 
+const errStateListNoApplyTimes = `The -since-apply option can't be used with this state.
+
+Filtering by -since-apply=%s needs the time that each object was last
+applied, but the state doesn't record when its objects were created or
+updated, so there is nothing to compare against the duration. To see what
+recent applies changed, compare with an older state or a backup using
+-changed-since instead.`
+
 const errStateFilter = `Error filtering state: %[1]s
 
 Please ensure that all your addresses are formatted properly.`
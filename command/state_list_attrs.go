@@ -0,0 +1,142 @@
+package command
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateListAttr is an attribute path given with the -attr option of
+// "terraform state list", to print alongside the address of each instance.
+type stateListAttr struct {
+	// Path is the path as it was given, which is also its name in the JSON
+	// and CSV output.
+	Path  string
+	Steps []string
+}
+
+// parseStateListAttrs parses the given -attr paths, which are written as for
+// the -attr option of "terraform state show".
+func parseStateListAttrs(paths []string) ([]stateListAttr, error) {
+	ret := make([]stateListAttr, 0, len(paths))
+	for _, path := range paths {
+		steps, err := parseStateShowAttrPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -attr path %q: %s", path, err)
+		}
+		ret = append(ret, stateListAttr{Path: path, Steps: steps})
+	}
+	return ret, nil
+}
+
+// stateListAttrValues returns the value of each of the given attributes in
+// the current object of the resource instance with the given address, which
+// is looked up in each of the given states in turn.
+//
+// The value of an attribute that the object doesn't have is nil, as are all
+// of the values for an instance with no current object or whose attributes
+// can't be decoded, since an inventory is more useful with a gap in it than
+// not at all.
+func stateListAttrValues(attrs []stateListAttr, rawAddr string, search ...*states.State) []interface{} {
+	ret := make([]interface{}, len(attrs))
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+	if diags.HasErrors() {
+		return ret
+	}
+	var obj *states.ResourceInstanceObjectSrc
+	for _, state := range search {
+		if is := state.ResourceInstance(addr); is != nil {
+			obj = is.Current
+			break
+		}
+	}
+	if obj == nil {
+		return ret
+	}
+	for i, attr := range attrs {
+		if val, err := stateShowAttrLookup(obj, attr.Steps); err == nil {
+			ret[i] = val
+		}
+	}
+	return ret
+}
+
+// stateListInstanceID returns the id attribute of the current object of the
+// resource instance with the given address, which is looked up in each of the
+// given states in turn, for the -with-id option. The result is empty if the
+// instance has no current object or its object has no id.
+func stateListInstanceID(rawAddr string, search ...*states.State) string {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+	if diags.HasErrors() {
+		return ""
+	}
+	for _, state := range search {
+		if is := state.ResourceInstance(addr); is != nil {
+			if is.Current == nil {
+				return ""
+			}
+			// This is the same id that the -id option matches, except that
+			// LegacyInstanceObjectID describes a missing one as "<none>".
+			if id := states.LegacyInstanceObjectID(is.Current); id != "<none>" {
+				return id
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// stateListAttrString returns the given attribute value as text for a line
+// or CSV field: a string as it is, a missing value as an empty string, and
+// anything else as JSON on a single line.
+func stateListAttrString(val interface{}) string {
+	switch tv := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return tv
+	default:
+		src, err := json.Marshal(tv)
+		if err != nil {
+			return ""
+		}
+		return string(src)
+	}
+}
+
+// marshalStateListCSV returns the CSV representation of the given resource
+// instance addresses, for the -csv option, with a column for the address,
+// then one for the id with withID, and then one for each of the given
+// attributes.
+func marshalStateListCSV(rawAddrs []string, withID bool, attrs []stateListAttr, search ...*states.State) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := []string{"address"}
+	if withID {
+		header = append(header, "id")
+	}
+	for _, attr := range attrs {
+		header = append(header, attr.Path)
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	for _, rawAddr := range rawAddrs {
+		row := []string{rawAddr}
+		if withID {
+			row = append(row, stateListInstanceID(rawAddr, search...))
+		}
+		for _, val := range stateListAttrValues(attrs, rawAddr, search...) {
+			row = append(row, stateListAttrString(val))
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
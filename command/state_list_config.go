@@ -0,0 +1,145 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/configs"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateListConfigDiff is the comparison of the state with the configuration
+// made by the -diff-config option of "terraform state list".
+type stateListConfigDiff struct {
+	// InConfig and Orphan are the addresses of the listed resource
+	// instances whose resources are, and aren't, declared in the
+	// configuration, in the order they were listed.
+	InConfig, Orphan []string
+
+	// Missing are the addresses of the resources declared in the
+	// configuration that have no objects in the state, in lexical order.
+	// These are resources rather than instances because the configuration
+	// isn't evaluated, so their count and for_each aren't known.
+	Missing []string
+}
+
+// stateListDiffConfig compares the given state with the given configuration
+// for the -diff-config option, sorting the resource instances with the given
+// addresses into those that are in the configuration and those that are
+// orphans, and finding the resources in the configuration that are missing
+// from the state. With a scope, only the resources in the configuration
+// within that module instance, or with recurse unset only in it, are checked
+// for being missing.
+//
+// As with -orphans, an instance is in the configuration if its resource is
+// declared, whether or not its instance key is one that the resource's
+// count or for_each would still produce.
+func stateListDiffConfig(config *configs.Config, state *states.State, listed []string, scope addrs.ModuleInstance, recurse bool) *stateListConfigDiff {
+	ret := &stateListConfigDiff{}
+
+	orphaned := make(map[string]bool)
+	for _, addr := range configOrphanedInstances(config, state) {
+		orphaned[addr.String()] = true
+	}
+	for _, addr := range listed {
+		if orphaned[addr] {
+			ret.Orphan = append(ret.Orphan, addr)
+		} else {
+			ret.InConfig = append(ret.InConfig, addr)
+		}
+	}
+
+	config.DeepEach(func(c *configs.Config) {
+		// Modules can't have count or for_each yet, so each module in the
+		// configuration has exactly one instance.
+		path := c.Path.UnkeyedInstanceShim()
+		if scope != nil && !(path.Equal(scope) || (recurse && scope.IsAncestor(path))) {
+			return
+		}
+		var resources []*configs.Resource
+		for _, r := range c.Module.ManagedResources {
+			resources = append(resources, r)
+		}
+		for _, r := range c.Module.DataResources {
+			resources = append(resources, r)
+		}
+		for _, r := range resources {
+			addr := r.Addr().Absolute(path)
+			if !stateListResourceHasObjects(state.Resource(addr)) {
+				ret.Missing = append(ret.Missing, addr.String())
+			}
+		}
+	})
+	sort.Strings(ret.Missing)
+	return ret
+}
+
+// stateListResourceHasObjects returns true if the given resource, which may
+// be nil, has an instance with a current or deposed object.
+func stateListResourceHasObjects(rs *states.Resource) bool {
+	if rs == nil {
+		return false
+	}
+	for _, is := range rs.Instances {
+		if is.HasObjects() {
+			return true
+		}
+	}
+	return false
+}
+
+// stateListConfigDiffJSON is the JSON representation of stateListConfigDiff.
+type stateListConfigDiffJSON struct {
+	InConfig []string `json:"in_config"`
+	Orphan   []string `json:"orphan"`
+	Missing  []string `json:"missing"`
+}
+
+// outputConfigDiff prints the given comparison with the configuration for
+// the -diff-config option, returning the exit status for the command. In
+// text, each of the three groups is printed under a heading, with "(none)"
+// if it is empty, so that the output always has the same shape.
+func (c *StateListCommand) outputConfigDiff(diff *stateListConfigDiff, jsonOutput bool) int {
+	if jsonOutput {
+		out := stateListConfigDiffJSON{
+			InConfig: diff.InConfig,
+			Orphan:   diff.Orphan,
+			Missing:  diff.Missing,
+		}
+		for _, group := range []*[]string{&out.InConfig, &out.Orphan, &out.Missing} {
+			if *group == nil {
+				*group = []string{}
+			}
+		}
+		src, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to marshal comparison to JSON: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(src))
+		return 0
+	}
+
+	var lines []string
+	for _, group := range []struct {
+		Name  string
+		Addrs []string
+	}{
+		{"in-config", diff.InConfig},
+		{"orphan", diff.Orphan},
+		{"missing", diff.Missing},
+	} {
+		lines = append(lines, fmt.Sprintf("%s (%d):", group.Name, len(group.Addrs)))
+		if len(group.Addrs) == 0 {
+			lines = append(lines, "  (none)")
+		}
+		for _, addr := range group.Addrs {
+			lines = append(lines, "  "+addr)
+		}
+	}
+	c.Ui.Output(strings.Join(lines, "\n"))
+	return 0
+}
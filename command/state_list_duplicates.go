@@ -0,0 +1,103 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateListDuplicateID is a set of the listed managed resource instances
+// whose current objects are of the same resource type and have the same id,
+// for the -duplicated-ids option. Such instances most likely all track the
+// same real object, such as after an object was imported twice or a module
+// block was copied.
+type stateListDuplicateID struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+
+	// Addresses are the addresses of the instances, in the order they were
+	// listed.
+	Addresses []string `json:"addresses"`
+}
+
+// stateListDuplicateIDs returns each set of the resource instances with the
+// given addresses, which are looked up in each of the given states in turn,
+// that share an id, ordered by id and then resource type. Only instances of
+// the same resource type are compared, as for the -merge and
+// -dedupe-across-modules options of "terraform state rm", which can remove
+// the duplicates found. Data resources and objects without an id are
+// ignored.
+func stateListDuplicateIDs(rawAddrs []string, search ...*states.State) []*stateListDuplicateID {
+	byID := make(map[string]*stateListDuplicateID)
+	for _, rawAddr := range rawAddrs {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if diags.HasErrors() || addr.Resource.Resource.Mode != addrs.ManagedResourceMode {
+			continue
+		}
+		id := stateListInstanceID(rawAddr, search...)
+		if id == "" {
+			continue
+		}
+		ty := addr.Resource.Resource.Type
+		k := ty + "\x00" + id
+		dups, ok := byID[k]
+		if !ok {
+			dups = &stateListDuplicateID{ID: id, Type: ty}
+			byID[k] = dups
+		}
+		dups.Addresses = append(dups.Addresses, rawAddr)
+	}
+
+	var ret []*stateListDuplicateID
+	for _, dups := range byID {
+		if len(dups.Addresses) > 1 {
+			ret = append(ret, dups)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ID != ret[j].ID {
+			return ret[i].ID < ret[j].ID
+		}
+		return ret[i].Type < ret[j].Type
+	})
+	return ret
+}
+
+// outputDuplicateIDs prints the given sets of instances for the
+// -duplicated-ids option, returning the exit status for the command. Each
+// set is printed as its id and resource type followed by the addresses of
+// its instances, indented.
+func (c *StateListCommand) outputDuplicateIDs(dups []*stateListDuplicateID, jsonOutput bool) int {
+	if jsonOutput {
+		if dups == nil {
+			dups = []*stateListDuplicateID{}
+		}
+		src, err := json.MarshalIndent(dups, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to marshal duplicated ids to JSON: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(src))
+		return 0
+	}
+
+	var buf bytes.Buffer
+	for i, d := range dups {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "%s (%s):\n", d.ID, d.Type)
+		for _, addr := range d.Addresses {
+			fmt.Fprintf(&buf, "  %s\n", addr)
+		}
+	}
+	if buf.Len() > 0 {
+		c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+	}
+	return 0
+}
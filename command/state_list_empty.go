@@ -0,0 +1,115 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateListEmptyModule is a module instance listed by -empty-modules.
+type stateListEmptyModule struct {
+	Addr addrs.ModuleInstance
+
+	// Resources are the addresses, relative to the module, of the resources
+	// still recorded in it without any instances, in lexical order. Such
+	// resources are what is left of a module once all of its instances have
+	// been removed.
+	Resources []string
+}
+
+// stateListEmptyModules returns the module instances in the given state that
+// have no resource instance objects, either in themselves or in any of their
+// descendents, ordered by address. The root module is never included. With
+// a scope, only the module instances within it are returned.
+func stateListEmptyModules(state *states.State, scope addrs.ModuleInstance) []stateListEmptyModule {
+	// A module is non-empty if any module at or below it has an object, so
+	// we mark every ancestor of each module that has one.
+	nonEmpty := make(map[string]bool)
+	for _, ms := range state.Modules {
+		if !stateListModuleHasObjects(ms) {
+			continue
+		}
+		for addr := ms.Addr; !addr.IsRoot(); addr = addr.Parent() {
+			nonEmpty[addr.String()] = true
+		}
+	}
+
+	var ret []stateListEmptyModule
+	for _, ms := range state.Modules {
+		if ms.Addr.IsRoot() || nonEmpty[ms.Addr.String()] {
+			continue
+		}
+		if scope != nil && !moduleWithinScope(ms.Addr, scope) {
+			continue
+		}
+		mod := stateListEmptyModule{Addr: ms.Addr}
+		for _, rs := range ms.Resources {
+			mod.Resources = append(mod.Resources, rs.Addr.String())
+		}
+		sort.Strings(mod.Resources)
+		ret = append(ret, mod)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Addr.String() < ret[j].Addr.String()
+	})
+	return ret
+}
+
+// stateListModuleHasObjects returns true if any resource in the given module
+// has an instance with a current or deposed object.
+func stateListModuleHasObjects(ms *states.Module) bool {
+	for _, rs := range ms.Resources {
+		for _, is := range rs.Instances {
+			if is.HasObjects() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stateListEmptyModuleJSON is the JSON representation of a module instance
+// listed by -empty-modules.
+type stateListEmptyModuleJSON struct {
+	Address   string   `json:"address"`
+	Resources []string `json:"resources"`
+}
+
+// outputEmptyModules prints the given empty module instances for the
+// -empty-modules option, returning the exit status for the command. In text,
+// each module instance is followed by the resources without instances that
+// it still has, if any.
+func (c *StateListCommand) outputEmptyModules(modules []stateListEmptyModule, jsonOutput bool) int {
+	if jsonOutput {
+		out := make([]stateListEmptyModuleJSON, len(modules))
+		for i, mod := range modules {
+			out[i] = stateListEmptyModuleJSON{
+				Address:   mod.Addr.String(),
+				Resources: mod.Resources,
+			}
+			if out[i].Resources == nil {
+				out[i].Resources = []string{}
+			}
+		}
+		src, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to marshal empty modules to JSON: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(src))
+		return 0
+	}
+
+	for _, mod := range modules {
+		line := mod.Addr.String()
+		if len(mod.Resources) > 0 {
+			line = fmt.Sprintf("%s (resources: %s)", line, strings.Join(mod.Resources, ", "))
+		}
+		c.Ui.Output(line)
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateListEdge is a dependency between two resource instances in the state,
+// from the instance whose current object records the dependency to the
+// instance it depends on.
+type stateListEdge struct {
+	From, To addrs.AbsResourceInstance
+}
+
+// stateListDependencyEdges returns the dependencies recorded in the given
+// state between the resource instances with the given addresses, in order.
+// Dependencies on instances that aren't given are left out.
+func stateListDependencyEdges(state *states.State, rawAddrs []string) ([]addrs.AbsResourceInstance, []stateListEdge) {
+	var nodes []addrs.AbsResourceInstance
+	listed := make(map[string]bool, len(rawAddrs))
+	for _, rawAddr := range rawAddrs {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if diags.HasErrors() {
+			continue
+		}
+		nodes = append(nodes, addr)
+		listed[rawAddr] = true
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Less(nodes[j])
+	})
+
+	var edges []stateListEdge
+	for _, addr := range nodes {
+		is := state.ResourceInstance(addr)
+		if is == nil || is.Current == nil {
+			continue
+		}
+		for _, dep := range stateInstanceDependencies(state, addr.Module, is.Current) {
+			if listed[dep.String()] && !dep.Equal(addr) {
+				edges = append(edges, stateListEdge{From: addr, To: dep})
+			}
+		}
+	}
+	return nodes, edges
+}
+
+// stateListFocus narrows the given nodes and edges down to the neighborhood
+// of the given focus instances, for the -focus option: the focus instances
+// themselves, the instances they directly depend on, and the instances that
+// directly depend on them, along with the edges to and from the focus
+// instances.
+func stateListFocus(nodes []addrs.AbsResourceInstance, edges []stateListEdge, focus map[string]bool) ([]addrs.AbsResourceInstance, []stateListEdge) {
+	near := make(map[string]bool, len(focus))
+	for addr := range focus {
+		near[addr] = true
+	}
+	var keptEdges []stateListEdge
+	for _, edge := range edges {
+		if focus[edge.From.String()] || focus[edge.To.String()] {
+			keptEdges = append(keptEdges, edge)
+			near[edge.From.String()] = true
+			near[edge.To.String()] = true
+		}
+	}
+	var keptNodes []addrs.AbsResourceInstance
+	for _, addr := range nodes {
+		if near[addr.String()] {
+			keptNodes = append(keptNodes, addr)
+		}
+	}
+	return keptNodes, keptEdges
+}
+
+// writeStateListGraph writes the given nodes and edges to the given buffer as
+// a Graphviz DOT digraph, for the -graph option. Each edge points from an
+// instance to an instance it depends on, as in "terraform graph", and the
+// given focus instances, if any, are drawn in bold.
+func writeStateListGraph(buf *bytes.Buffer, nodes []addrs.AbsResourceInstance, edges []stateListEdge, focus map[string]bool) {
+	buf.WriteString("digraph {\n")
+	for _, addr := range nodes {
+		if focus[addr.String()] {
+			fmt.Fprintf(buf, "\t%q [shape = \"box\", style = \"bold\"]\n", addr.String())
+			continue
+		}
+		fmt.Fprintf(buf, "\t%q [shape = \"box\"]\n", addr.String())
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(buf, "\t%q -> %q\n", edge.From.String(), edge.To.String())
+	}
+	buf.WriteString("}\n")
+}
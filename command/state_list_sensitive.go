@@ -0,0 +1,197 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/configs/configschema"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// stateListSensitive describes which attributes of a resource instance are
+// sensitive, for the -include-sensitive-marks option of "terraform state
+// list".
+//
+// The state doesn't record which values are sensitive, so this comes from
+// the schema of the resource type in the provider, applied to the attributes
+// that the current object actually has.
+type stateListSensitive struct {
+	// Paths are the paths of the sensitive attributes with values, in the
+	// format accepted by the -attr option of "terraform state show".
+	Paths []string
+
+	// Unknown, if set, is why the sensitive attributes couldn't be found, in
+	// which case Paths is empty.
+	Unknown string
+}
+
+func (s stateListSensitive) String() string {
+	switch {
+	case s.Unknown != "":
+		return "sensitive: unknown, " + s.Unknown
+	case len(s.Paths) == 0:
+		return "sensitive: none"
+	default:
+		return "sensitive: " + strings.Join(s.Paths, ", ")
+	}
+}
+
+// stateListSensitiveJSON is the JSON representation of stateListSensitive.
+type stateListSensitiveJSON struct {
+	// Attributes is null when the sensitive attributes are unknown, as
+	// opposed to an empty array when there are none.
+	Attributes    []string `json:"attributes"`
+	UnknownReason string   `json:"unknown_reason,omitempty"`
+}
+
+// sensitiveAttrs returns which attributes of the current object of each of the
+// resource instances with the given addresses are sensitive, by address, for
+// the -include-sensitive-marks option. Each instance is looked up in each of
+// the given states in turn.
+func (c *StateListCommand) sensitiveAttrs(rawAddrs []string, search ...*states.State) (map[string]stateListSensitive, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	type listed struct {
+		Addr     addrs.AbsResourceInstance
+		Provider string
+		Object   *states.ResourceInstanceObjectSrc
+	}
+	instances := make(map[string]listed, len(rawAddrs))
+	seen := make(map[string]bool)
+	var types []string
+	for _, rawAddr := range rawAddrs {
+		addr, addrDiags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+		if addrDiags.HasErrors() {
+			continue
+		}
+		for _, state := range search {
+			rs := state.Resource(addr.ContainingResource())
+			if rs == nil {
+				continue
+			}
+			is := rs.Instance(addr.Resource.Key)
+			if is == nil {
+				continue
+			}
+			name := rs.ProviderConfig.ProviderConfig.Type
+			instances[rawAddr] = listed{Addr: addr, Provider: name, Object: is.Current}
+			if !seen[name] {
+				seen[name] = true
+				types = append(types, name)
+			}
+			break
+		}
+	}
+
+	schemas, missing, moreDiags := c.stateProviderSchemas(types)
+	diags = diags.Append(moreDiags)
+	if len(missing) > 0 {
+		lines := make([]string, len(missing))
+		for i, name := range missing {
+			lines[i] = "  provider." + name
+		}
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Providers not installed",
+			fmt.Sprintf("The state doesn't record which attributes are sensitive, so they are found from the provider schemas, but the following providers aren't installed:\n\n%s\n\nThe sensitive attributes of their resource instances are shown as unknown. Run \"terraform init\" to install them.", strings.Join(lines, "\n")),
+		))
+	}
+
+	ret := make(map[string]stateListSensitive, len(instances))
+	for rawAddr, inst := range instances {
+		resp, ok := schemas[inst.Provider]
+		if !ok {
+			ret[rawAddr] = stateListSensitive{Unknown: fmt.Sprintf("provider.%s isn't available", inst.Provider)}
+			continue
+		}
+		if inst.Object == nil {
+			// Only deposed objects, which are listed but not inspected.
+			ret[rawAddr] = stateListSensitive{Unknown: "no current object"}
+			continue
+		}
+		res := inst.Addr.Resource.Resource
+		schema, ok := stateResourceTypeSchema(resp, res)
+		if !ok || schema.Block == nil {
+			ret[rawAddr] = stateListSensitive{Unknown: fmt.Sprintf("provider.%s has no schema for %s", inst.Provider, res.Type)}
+			continue
+		}
+		if inst.Object.SchemaVersion != schema.Version {
+			ret[rawAddr] = stateListSensitive{Unknown: fmt.Sprintf("the object was saved with schema version %d, but the provider is at version %d", inst.Object.SchemaVersion, schema.Version)}
+			continue
+		}
+		obj, err := inst.Object.Decode(schema.Block.ImpliedType())
+		if err != nil {
+			ret[rawAddr] = stateListSensitive{Unknown: fmt.Sprintf("its attributes don't match the schema: %s", err)}
+			continue
+		}
+		ret[rawAddr] = stateListSensitive{Paths: stateListSensitivePaths(schema.Block, obj.Value, "")}
+	}
+	return ret, diags
+}
+
+// stateListSensitivePaths returns the paths of the attributes of the given
+// value, which conforms to the given schema, that are sensitive and not null,
+// with the given prefix. Attributes are ordered by name, with those of nested
+// blocks after those of the block itself. The elements of a nested set block
+// have no key to identify them by, so they are all given as "[*]".
+func stateListSensitivePaths(schema *configschema.Block, val cty.Value, prefix string) []string {
+	if val.IsNull() || !val.IsKnown() {
+		return nil
+	}
+
+	var ret []string
+	names := make([]string, 0, len(schema.Attributes))
+	for name := range schema.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if schema.Attributes[name].Sensitive && !val.GetAttr(name).IsNull() {
+			ret = append(ret, prefix+name)
+		}
+	}
+
+	names = names[:0]
+	for name := range schema.BlockTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		blockS := schema.BlockTypes[name]
+		bv := val.GetAttr(name)
+		if bv.IsNull() || !bv.IsKnown() {
+			continue
+		}
+		switch blockS.Nesting {
+		case configschema.NestingSingle:
+			ret = append(ret, stateListSensitivePaths(&blockS.Block, bv, prefix+name+".")...)
+		case configschema.NestingList:
+			for it := bv.ElementIterator(); it.Next(); {
+				k, ev := it.Element()
+				idx, _ := k.AsBigFloat().Int64()
+				ret = append(ret, stateListSensitivePaths(&blockS.Block, ev, fmt.Sprintf("%s%s[%d].", prefix, name, idx))...)
+			}
+		case configschema.NestingSet:
+			seen := make(map[string]bool)
+			for it := bv.ElementIterator(); it.Next(); {
+				_, ev := it.Element()
+				for _, path := range stateListSensitivePaths(&blockS.Block, ev, prefix+name+"[*].") {
+					if !seen[path] {
+						seen[path] = true
+						ret = append(ret, path)
+					}
+				}
+			}
+		case configschema.NestingMap:
+			for it := bv.ElementIterator(); it.Next(); {
+				k, ev := it.Element()
+				ret = append(ret, stateListSensitivePaths(&blockS.Block, ev, fmt.Sprintf("%s%s[%q].", prefix, name, k.AsString()))...)
+			}
+		}
+	}
+	return ret
+}
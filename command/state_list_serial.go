@@ -0,0 +1,57 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+	"github.com/hashicorp/terraform/states/statemgr"
+)
+
+// stateListBehindExitStatus is the exit status of "terraform state list
+// -newer-serial-than" when the state is behind or has diverged from the
+// other state file, so that a script can tell that apart from an error.
+const stateListBehindExitStatus = 2
+
+// compareSerial reports whether the state read from the given state manager
+// is ahead of, behind, or has diverged from the state file at the given path,
+// for the -newer-serial-than option, and returns the exit status for the
+// command.
+//
+// This is judged by lineage and serial alone, the same as the backends do
+// when refusing to overwrite a newer state. A higher serial doesn't prove
+// that the other snapshot is an ancestor, since both could have been changed
+// from a common ancestor, but with the same lineage and serial we can at
+// least tell whether the contents match.
+func (c *StateListCommand) compareSerial(stateMgr statemgr.Full, state *states.State, path string) int {
+	metaMgr, ok := stateMgr.(statemgr.PersistentMeta)
+	if !ok {
+		c.Ui.Error("The current backend doesn't report the lineage and serial of the state, so -newer-serial-than can't compare it.")
+		return 1
+	}
+	meta := metaMgr.StateSnapshotMeta()
+
+	other, err := readStateFile(path)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to load state %s: %s", path, err))
+		return 1
+	}
+
+	switch {
+	case meta.Lineage != other.Lineage:
+		c.Ui.Error(fmt.Sprintf("The state has diverged from %s: their lineages are %q and %q, so they are not snapshots of the same state.", path, meta.Lineage, other.Lineage))
+		return stateListBehindExitStatus
+	case meta.Serial > other.Serial:
+		c.Ui.Output(fmt.Sprintf("The state is ahead of %s: its serial is %d, and the serial of %s is %d.", path, meta.Serial, path, other.Serial))
+		return 0
+	case meta.Serial < other.Serial:
+		c.Ui.Error(fmt.Sprintf("The state is behind %s: its serial is %d, and the serial of %s is %d. The state has been changed since this copy of it was taken, so get the latest state before running any command that changes it.", path, meta.Serial, path, other.Serial))
+		return stateListBehindExitStatus
+	case !statefile.StatesMarshalEqual(state, other.State):
+		c.Ui.Error(fmt.Sprintf("The state has diverged from %s: both have serial %d, but their contents differ, so they were changed separately.", path, meta.Serial))
+		return stateListBehindExitStatus
+	default:
+		c.Ui.Output(fmt.Sprintf("The state is up to date with %s: both have serial %d.", path, meta.Serial))
+		return 0
+	}
+}
@@ -1,12 +1,21 @@
 package command
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/configs/configschema"
 	"github.com/hashicorp/terraform/helper/copy"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+	"github.com/hashicorp/terraform/terraform"
 	"github.com/mitchellh/cli"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func TestStateList(t *testing.T) {
@@ -203,6 +212,1366 @@ func TestStateList_noState(t *testing.T) {
 	}
 }
 
+func TestStateList_changedSince(t *testing.T) {
+	obj := func(id string) *states.ResourceInstanceObjectSrc {
+		return &states.ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(fmt.Sprintf(`{"id":%q}`, id)),
+			Status:    states.ObjectReady,
+		}
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	instance := func(name string) addrs.AbsResourceInstance {
+		return addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "test_instance",
+			Name: name,
+		}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance)
+	}
+
+	baseline := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(instance("same"), obj("same"), provider)
+		s.SetResourceInstanceCurrent(instance("changed"), obj("before"), provider)
+		s.SetResourceInstanceCurrent(instance("removed"), obj("removed"), provider)
+	})
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(instance("same"), obj("same"), provider)
+		s.SetResourceInstanceCurrent(instance("changed"), obj("after"), provider)
+		s.SetResourceInstanceCurrent(instance("added"), obj("added"), provider)
+	})
+	baselinePath := testStateFile(t, baseline)
+	statePath := testStateFile(t, state)
+
+	p := testProvider()
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(p),
+			Ui:               ui,
+		},
+	}
+
+	args := []string{
+		"-state", statePath,
+		"-changed-since", baselinePath,
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	expected := "test_instance.added\ntest_instance.changed\ntest_instance.removed\n"
+	actual := ui.OutputWriter.String()
+	if actual != expected {
+		t.Fatalf("Expected:\n%q\n\nTo equal: %q", actual, expected)
+	}
+}
+
 const testStateListOutput = `
 test_instance.foo
 `
+
+func TestStateList_sort(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"module.child.b_thing.a",
+			"b_thing.a",
+			"a_thing.b",
+			"a_thing.a",
+			"b_thing.c",
+			"data.b_thing.d",
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]string{
+		"type":    "a_thing.a\na_thing.b\ndata.b_thing.d\nb_thing.a\nb_thing.c\nmodule.child.b_thing.a\n",
+		"name":    "a_thing.a\nb_thing.a\na_thing.b\nb_thing.c\ndata.b_thing.d\nmodule.child.b_thing.a\n",
+		"address": "data.b_thing.d\na_thing.a\na_thing.b\nb_thing.a\nb_thing.c\nmodule.child.b_thing.a\n",
+	}
+	for by, want := range cases {
+		t.Run(by, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := []string{
+				"-state", statePath,
+				"-sort", by,
+			}
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestStateList_countOnly(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"test_instance.foo[0]",
+			"test_instance.foo[1]",
+			"test_instance.bar",
+			"module.child.test_instance.foo",
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"all": {[]string{"-count-only"}, "4\n"},
+		// As for the list itself, the pattern matches the resource in
+		// any module, and each instance is counted.
+		"scoped": {[]string{"-count-only", "test_instance.foo"}, "3\n"},
+		"json":   {[]string{"-count-only", "-json", "module.child"}, `{"count":1}` + "\n"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := append([]string{"-state", statePath}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:  %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStateList_modulesOnly(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"test_instance.foo[0]",
+			"test_instance.foo[1]",
+			"module.b.test_instance.foo",
+			"module.a.module.c.test_instance.foo",
+			"module.a.module.c.test_instance.bar",
+			"module.a.test_instance.foo",
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"modules":        {[]string{"-modules-only"}, "module.a\n  module.a.module.c\nmodule.b\n"},
+		"with counts":    {[]string{"-modules-only", "-with-counts"}, "module.a: 3\n  module.a.module.c: 2\nmodule.b: 1\n"},
+		"scoped":         {[]string{"-modules-only", "module.a.module.c"}, "module.a\n  module.a.module.c\n"},
+		"count":          {[]string{"-modules-only", "-count-only"}, "3\n"},
+		"resources":      {[]string{"-resources-only", "-sort", "address"}, "test_instance.foo\nmodule.a.test_instance.foo\nmodule.b.test_instance.foo\nmodule.a.module.c.test_instance.bar\nmodule.a.module.c.test_instance.foo\n"},
+		"resource count": {[]string{"-resources-only", "-count-only"}, "5\n"},
+		"resource addrs": {[]string{"-resource-addresses-only", "module.a.module.c"}, "module.a.module.c.test_instance.bar\nmodule.a.module.c.test_instance.foo\n"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := append([]string{"-state", statePath}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStateList_emptyModules(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"test_instance.foo",
+			"module.a.test_instance.foo",
+			"module.c.module.d.test_instance.foo",
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+		// Resources without any instances, as left behind once all of
+		// their instances have been removed.
+		for _, addr := range []string{
+			"module.a.module.b.test_instance.old",
+			"module.c.test_instance.old",
+			"module.e.test_instance.old",
+			"module.e.module.f.test_instance.old",
+		} {
+			s.SetResourceMeta(mustResourceInstanceAddr(addr).ContainingResource(), states.NoEach, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"text":   {[]string{"-empty-modules"}, "module.a.module.b (resources: test_instance.old)\nmodule.e (resources: test_instance.old)\nmodule.e.module.f (resources: test_instance.old)\n"},
+		"scoped": {[]string{"-empty-modules", "-module", "module.a"}, "module.a.module.b (resources: test_instance.old)\n"},
+		"count":  {[]string{"-empty-modules", "-count-only"}, "3\n"},
+		"json": {[]string{"-empty-modules", "-json", "-module", "module.e.module.f"}, `[
+  {
+    "address": "module.e.module.f",
+    "resources": [
+      "test_instance.old"
+    ]
+  }
+]
+`},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := append([]string{"-state", statePath}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStateList_module(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"test_instance.foo",
+			"module.a.test_instance.foo",
+			"module.a.module.c.test_instance.bar",
+			"module.a.module.c.test_instance.foo",
+			"module.b[0].test_instance.foo",
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"recursive":    {[]string{"-module", "module.a"}, "module.a.module.c.test_instance.bar\nmodule.a.module.c.test_instance.foo\nmodule.a.test_instance.foo\n"},
+		"no recurse":   {[]string{"-module", "module.a", "-no-recurse"}, "module.a.test_instance.foo\n"},
+		"with pattern": {[]string{"-module", "module.a", "test_instance.foo"}, "module.a.module.c.test_instance.foo\nmodule.a.test_instance.foo\n"},
+		"instance key": {[]string{"-module", "module.b[0]"}, "module.b[0].test_instance.foo\n"},
+		"no key":       {[]string{"-module", "module.b"}, ""},
+		"count":        {[]string{"-module", "module.a", "-count-only"}, "3\n"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := append([]string{"-state", statePath}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-no-recurse"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+}
+
+func TestStateList_graph(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for addr, deps := range map[string][]addrs.Referenceable{
+			"test_instance.vpc":    nil,
+			"test_instance.subnet": {addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_instance", Name: "vpc"}},
+			"test_instance.web[0]": {addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_instance", Name: "subnet"}},
+			"test_instance.web[1]": {addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_instance", Name: "subnet"}},
+			"test_instance.other":  nil,
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON:    []byte(`{"id":"foo"}`),
+					Status:       states.ObjectReady,
+					Dependencies: deps,
+				},
+				provider,
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"all": {
+			[]string{"-graph"},
+			`digraph {
+	"test_instance.other" [shape = "box"]
+	"test_instance.subnet" [shape = "box"]
+	"test_instance.vpc" [shape = "box"]
+	"test_instance.web[0]" [shape = "box"]
+	"test_instance.web[1]" [shape = "box"]
+	"test_instance.subnet" -> "test_instance.vpc"
+	"test_instance.web[0]" -> "test_instance.subnet"
+	"test_instance.web[1]" -> "test_instance.subnet"
+}
+`,
+		},
+		"focus": {
+			[]string{"-graph", "-focus", "test_instance.web[0]"},
+			`digraph {
+	"test_instance.subnet" [shape = "box"]
+	"test_instance.web[0]" [shape = "box", style = "bold"]
+	"test_instance.web[0]" -> "test_instance.subnet"
+}
+`,
+		},
+		"focus resource": {
+			[]string{"-graph", "-focus", "test_instance.vpc"},
+			`digraph {
+	"test_instance.subnet" [shape = "box"]
+	"test_instance.vpc" [shape = "box", style = "bold"]
+	"test_instance.subnet" -> "test_instance.vpc"
+}
+`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := append([]string{"-state", statePath}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStateList_orphans(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-gc-orphan-data"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"data.test_data_source.kept",
+			"data.test_data_source.stale",
+			"test_instance.gone",
+			"module.child.test_instance.foo",
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args := []string{
+		"-state", statePath,
+		"-orphans",
+		"-sort", "address",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := "data.test_data_source.stale\ntest_instance.gone\nmodule.child.test_instance.foo\n"
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestStateList_diffConfig(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-list-diff-config"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"data.test_data_source.kept",
+			"test_instance.foo[0]",
+			"test_instance.foo[1]",
+			"test_instance.gone",
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-diff-config", "-sort", "address"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `in-config (3):
+  data.test_data_source.kept
+  test_instance.foo[0]
+  test_instance.foo[1]
+orphan (1):
+  test_instance.gone
+missing (1):
+  test_instance.new
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	ui = cli.NewMockUi()
+	c = &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-diff-config", "-json", "-module", "module.child"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var got map[string][]string
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if wantJSON := map[string][]string{"in_config": {}, "orphan": {}, "missing": {}}; !reflect.DeepEqual(got, wantJSON) {
+		t.Errorf("wrong JSON output %#v; want %#v", got, wantJSON)
+	}
+
+	ui = cli.NewMockUi()
+	c = &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-diff-config", "-orphans"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+}
+
+func TestStateList_filterStatus(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	ready := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	tainted := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectTainted,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.ok"), ready, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.bad"), tainted, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.bad"), tainted, provider)
+		s.SetResourceInstanceDeposed(mustResourceInstanceAddr("test_instance.old"), states.DeposedKey("00000001"), ready, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.replaced"), ready, provider)
+		s.SetResourceInstanceDeposed(mustResourceInstanceAddr("test_instance.replaced"), states.DeposedKey("00000002"), ready, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"ready":        {[]string{"-filter-status", "ready"}, "test_instance.ok\ntest_instance.replaced\n"},
+		"tainted":      {[]string{"-filter-status", "tainted"}, "test_instance.bad\nmodule.child.test_instance.bad\n"},
+		"deposed":      {[]string{"-filter-status", "deposed"}, "test_instance.old\ntest_instance.replaced\n"},
+		"scoped":       {[]string{"-filter-status", "tainted", "module.child"}, "module.child.test_instance.bad\n"},
+		"summary":      {[]string{"-status-summary"}, "Ready:             2\nTainted:           2\nDeposed objects:   2\nNo current object: 1\n"},
+		"summary json": {[]string{"-status-summary", "-json", "module.child"}, `{"ready":0,"tainted":1,"deposed_objects":0,"no_current_object":0}` + "\n"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := append([]string{"-state", statePath, "-sort", "address"}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStateList_instanceKeyType(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.single"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.counted[0]"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr(`module.child.test_instance.each["a"]`), obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args := []string{"-state", statePath, "-sort", "address", "-instance-key-type"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `test_instance.counted[0] (int)
+test_instance.single (no-key)
+module.child.test_instance.each["a"] (string)
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestStateList_keyWildcard(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.single"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.counted[0]"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.counted[1]"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr(`module.child["a"].test_instance.counted[0]`), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr(`module.child["b"].test_instance.single`), obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]string{
+		"test_instance.counted[*]": "test_instance.counted[0]\ntest_instance.counted[1]\n",
+		`module.child["*"]`:        "module.child[\"a\"].test_instance.counted[0]\nmodule.child[\"b\"].test_instance.single\n",
+	}
+	for arg, want := range cases {
+		ui := cli.NewMockUi()
+		c := &StateListCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(testProvider()),
+				Ui:               ui,
+			},
+		}
+		if code := c.Run([]string{"-state", statePath, arg}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		if got := ui.OutputWriter.String(); got != want {
+			t.Errorf("wrong output for %s\ngot:\n%s\nwant:\n%s", arg, got, want)
+		}
+	}
+}
+
+func TestStateList_providerSummary(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		test := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+		west := addrs.ProviderConfig{Type: "test", Alias: "west"}.Absolute(addrs.RootModuleInstance)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo[0]"), obj, test)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo[1]"), obj, test)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.bar"), obj, test)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.baz"), obj, west)
+	})
+	statePath := testStateFile(t, state)
+
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-provider-summary"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `PROVIDER            RESOURCES
+provider.test       2
+provider.test.west  1
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestStateList_paths(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo[0]"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo[1]"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.bar"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr(`module.child.module.grand["a"].test_instance.baz`), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.other.test_instance.qux"), obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	tests := map[string]struct {
+		args []string
+		want string
+	}{
+		"full tree": {
+			[]string{"-paths"},
+			`├── test_instance.foo[0]
+├── test_instance.foo[1]
+├── module.child
+│   ├── test_instance.bar
+│   └── module.grand["a"]
+│       └── test_instance.baz
+└── module.other
+    └── test_instance.qux
+`,
+		},
+		"depth": {
+			[]string{"-paths", "-depth=1"},
+			`├── test_instance.foo[0]
+├── test_instance.foo[1]
+├── module.child
+│   ├── test_instance.bar
+│   └── module.grand["a"] (1 resource instances)
+└── module.other
+    └── test_instance.qux
+`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+			args := append([]string{"-no-color", "-state", statePath}, test.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != test.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStateList_showDeposedKeys(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+		foo := mustResourceInstanceAddr("test_instance.foo")
+		s.SetResourceInstanceCurrent(foo, obj, provider)
+		s.SetResourceInstanceDeposed(foo, states.DeposedKey("00000002"), obj, provider)
+		s.SetResourceInstanceDeposed(foo, states.DeposedKey("00000001"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.bar"), obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	tests := map[string]struct {
+		args []string
+		want string
+	}{
+		"all": {
+			[]string{"-show-deposed-keys"},
+			"test_instance.bar\ntest_instance.foo (deposed: 00000001, 00000002)\n",
+		},
+		"deposed only": {
+			[]string{"-show-deposed-keys", "-deposed-only"},
+			"test_instance.foo (deposed: 00000001, 00000002)\n",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+			if code := c.Run(append([]string{"-state", statePath}, test.args...)); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != test.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStateList_sinceApply(t *testing.T) {
+	statePath := testStateFile(t, testState())
+
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-since-apply", "1h"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "the state doesn't record when its objects"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	if got := ui.OutputWriter.String(); got != "" {
+		t.Errorf("unexpected output\n%s", got)
+	}
+}
+
+func TestStateList_outputValues(t *testing.T) {
+	state := testState()
+	state.SyncWrapper().SetOutputValue(addrs.OutputValue{Name: "password"}.Absolute(addrs.RootModuleInstance), cty.StringVal("hunter2"), true)
+	state.SyncWrapper().SetOutputValue(addrs.OutputValue{Name: "ips"}.Absolute(addrs.RootModuleInstance), cty.ListVal([]cty.Value{cty.StringVal("10.0.0.1")}), false)
+	statePath := testStateFile(t, state)
+
+	run := func(args ...string) *cli.MockUi {
+		t.Helper()
+		ui := cli.NewMockUi()
+		c := &StateListCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(testProvider()),
+				Ui:               ui,
+			},
+		}
+		if code := c.Run(append([]string{"-state", statePath, "-output-values"}, args...)); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		return ui
+	}
+
+	ui := run()
+	if got, want := ui.OutputWriter.String(), "output.ips\noutput.password (sensitive)\n"; got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	ui = run("-show-sensitive")
+	if got, want := ui.OutputWriter.String(), "output.ips = [\"10.0.0.1\"]\noutput.password = \"hunter2\"\n"; got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	ui = run("-json")
+	var got []map[string]interface{}
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	want := []map[string]interface{}{
+		{"address": "output.ips", "module": "", "name": "ips", "sensitive": false},
+		{"address": "output.password", "module": "", "name": "password", "sensitive": true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong JSON\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestStateList_json(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.foo[0]"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"foo"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr(`module.child.test_instance.bar["a"]`),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"bar"}`),
+				Status:    states.ObjectTainted,
+			},
+			provider,
+		)
+		s.SetResourceInstanceDeposed(
+			mustResourceInstanceAddr(`module.child.test_instance.bar["a"]`),
+			states.DeposedKey("00000001"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"old"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-json", "-sort=address"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	var got []map[string]interface{}
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	want := []map[string]interface{}{
+		{
+			"address":   "test_instance.foo[0]",
+			"mode":      "managed",
+			"type":      "test_instance",
+			"name":      "foo",
+			"module":    "",
+			"provider":  "provider.test",
+			"index_key": float64(0),
+			"status":    "ready",
+			"deposed":   []interface{}{},
+		},
+		{
+			"address":   `module.child.test_instance.bar["a"]`,
+			"mode":      "managed",
+			"type":      "test_instance",
+			"name":      "bar",
+			"module":    "module.child",
+			"provider":  "provider.test",
+			"index_key": "a",
+			"status":    "tainted",
+			"deposed":   []interface{}{"00000001"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong output\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestStateList_jsonLines(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{"test_instance.a", `module.child.test_instance.b["x"]`} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(`{"id":"foo"}`),
+					Status:    states.ObjectReady,
+				},
+				provider,
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	run := func(args ...string) *cli.MockUi {
+		t.Helper()
+		ui := cli.NewMockUi()
+		c := &StateListCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(testProvider()),
+				Ui:               ui,
+			},
+		}
+		if code := c.Run(append([]string{"-state", statePath, "-sort=address"}, args...)); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		return ui
+	}
+
+	// Each line is one of the elements of the -json array.
+	var want []map[string]interface{}
+	ui := run("-json", "-attr", "id")
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &want); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+
+	ui = run("-format=json-lines", "-attr", "id")
+	lines := strings.Split(strings.TrimSuffix(ui.OutputWriter.String(), "\n"), "\n")
+	var got []map[string]interface{}
+	for _, line := range lines {
+		var inst map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &inst); err != nil {
+			t.Fatalf("line is not valid JSON: %s\n%s", err, line)
+		}
+		got = append(got, inst)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong output\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestStateList_includeSensitiveMarks(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.db"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"db","password":"hunter2","credentials":[{"secret":"a"},{"secret":null}]}`),
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.web"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"web","password":null,"credentials":[]}`),
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("other_thing.x"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"x"}`),
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "other"}.Absolute(addrs.RootModuleInstance),
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	p := testProvider()
+	p.GetSchemaReturn = &terraform.ProviderSchema{
+		ResourceTypes: map[string]*configschema.Block{
+			"test_instance": {
+				Attributes: map[string]*configschema.Attribute{
+					"id":       {Type: cty.String, Computed: true},
+					"password": {Type: cty.String, Optional: true, Sensitive: true},
+				},
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"credentials": {
+						Nesting: configschema.NestingList,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"secret": {Type: cty.String, Optional: true, Sensitive: true},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	run := func(args ...string) *cli.MockUi {
+		t.Helper()
+		ui := cli.NewMockUi()
+		c := &StateListCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(p),
+				Ui:               ui,
+			},
+		}
+		if code := c.Run(append([]string{"-state", statePath, "-sort=address", "-include-sensitive-marks"}, args...)); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		return ui
+	}
+
+	ui := run()
+	want := `other_thing.x (sensitive: unknown, provider.other isn't available)
+test_instance.db (sensitive: password, credentials[0].secret)
+test_instance.web (sensitive: none)
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := ui.ErrorWriter.String(), "provider.other"; !strings.Contains(got, want) {
+		t.Errorf("missing warning about %s\n\n%s", want, got)
+	}
+
+	ui = run("-json")
+	var got []stateListJSONInstance
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if len(got) != 3 {
+		t.Fatalf("wrong number of instances\n%s", ui.OutputWriter.String())
+	}
+	if s := got[0].Sensitive; s == nil || s.Attributes != nil || s.UnknownReason == "" {
+		t.Errorf("wrong sensitivity for %s: %#v", got[0].Address, s)
+	}
+	if s := got[2].Sensitive; s == nil || s.Attributes == nil || len(s.Attributes) != 0 {
+		t.Errorf("wrong sensitivity for %s: %#v", got[2].Address, s)
+	}
+}
+
+func TestStateList_attr(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.a"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"i-a","region":"us-east-1","tags":{"Name":"web"},"port":8080}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.b"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"i-b"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"text": {
+			[]string{"-attr", "id", "-attr", "region", "-attr", `tags["Name"]`, "-attr", "port"},
+			"test_instance.a\ti-a\tus-east-1\tweb\t8080\ntest_instance.b\ti-b\t\t\t\n",
+		},
+		"csv": {
+			[]string{"-csv", "-attr", "id", "-attr", "region"},
+			"address,id,region\ntest_instance.a,i-a,us-east-1\ntest_instance.b,i-b,\n",
+		},
+		"json": {
+			[]string{"-json", "-attr", "region", "test_instance.b"},
+			`[
+{"address":"test_instance.b","mode":"managed","type":"test_instance","name":"b","module":"","provider":"provider.test","index_key":null,"status":"ready","deposed":[],"attributes":{"region":null}}
+]
+`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := append([]string{"-state", statePath}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-attr", "id", "-count-only"}); code != 1 {
+		t.Fatalf("expected -attr with -count-only to fail, got %d", code)
+	}
+}
+
+func TestStateList_withID(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.a"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"i-a","region":"us-east-1"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.b"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"region":"eu-west-1"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"text": {
+			[]string{"-with-id"},
+			"test_instance.a\ti-a\ntest_instance.b\t-\n",
+		},
+		"text with attr": {
+			[]string{"-with-id", "-attr", "region"},
+			"test_instance.a\ti-a\tus-east-1\ntest_instance.b\t-\teu-west-1\n",
+		},
+		"csv": {
+			[]string{"-csv", "-with-id", "-attr", "region"},
+			"address,id,region\ntest_instance.a,i-a,us-east-1\ntest_instance.b,,eu-west-1\n",
+		},
+		"json": {
+			[]string{"-json", "-with-id"},
+			`[
+{"address":"test_instance.a","mode":"managed","type":"test_instance","name":"a","module":"","provider":"provider.test","index_key":null,"status":"ready","deposed":[],"id":"i-a"},
+{"address":"test_instance.b","mode":"managed","type":"test_instance","name":"b","module":"","provider":"provider.test","index_key":null,"status":"ready","deposed":[],"id":""}
+]
+`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := append([]string{"-state", statePath}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+
+	ui := cli.NewMockUi()
+	c := &StateListCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-with-id", "-resources-only"}); code != 1 {
+		t.Fatalf("expected -with-id with -resources-only to fail, got %d", code)
+	}
+}
+
+func TestStateList_duplicatedIDs(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for addr, id := range map[string]string{
+			"test_instance.a":              "i-1",
+			"module.copy.test_instance.a":  "i-1",
+			"test_instance.b":              "i-2",
+			"test_instance.c":              "i-3",
+			"test_instance.d":              "i-3",
+			"other_instance.x":             "i-2",
+			"module.copy.test_instance.zz": "",
+			"test_instance.e":              "",
+		} {
+			attrs := `{"id":"` + id + `"}`
+			if id == "" {
+				attrs = `{}`
+			}
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(attrs),
+					Status:    states.ObjectReady,
+				},
+				provider,
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	// i-2 is shared by instances of different resource types, so it isn't
+	// reported.
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"text": {
+			nil,
+			"i-1 (test_instance):\n  module.copy.test_instance.a\n  test_instance.a\n\ni-3 (test_instance):\n  test_instance.c\n  test_instance.d\n",
+		},
+		"json": {
+			[]string{"-json", "test_instance.b", "test_instance.c", "test_instance.d"},
+			`[
+  {
+    "id": "i-3",
+    "type": "test_instance",
+    "addresses": [
+      "test_instance.c",
+      "test_instance.d"
+    ]
+  }
+]
+`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+			args := append([]string{"-state", statePath, "-duplicated-ids"}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStateList_newerSerialThan(t *testing.T) {
+	writeState := func(lineage string, serial uint64, state *states.State) string {
+		path := testTempFile(t)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := statefile.Write(&statefile.File{Lineage: lineage, Serial: serial, State: state}, f); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	statePath := writeState("abc", 5, testState())
+
+	changed := testState()
+	changed.RootModule().SetOutputValue("extra", cty.StringVal("changed"), false)
+
+	cases := map[string]struct {
+		other string
+		code  int
+		want  string
+	}{
+		"up to date":       {writeState("abc", 5, testState()), 0, "The state is up to date with"},
+		"ahead":            {writeState("abc", 4, testState()), 0, "The state is ahead of"},
+		"behind":           {writeState("abc", 6, testState()), 2, "The state is behind"},
+		"other lineage":    {writeState("xyz", 5, testState()), 2, "The state has diverged from"},
+		"different object": {writeState("abc", 5, changed), 2, "but their contents differ"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateListCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+			code := c.Run([]string{"-state", statePath, "-newer-serial-than", tc.other})
+			if code != tc.code {
+				t.Fatalf("wrong exit status %d; want %d\n\n%s%s", code, tc.code, ui.OutputWriter.String(), ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String() + ui.ErrorWriter.String(); !strings.Contains(got, tc.want) {
+				t.Errorf("missing %q in output\n%s", tc.want, got)
+			}
+		})
+	}
+}
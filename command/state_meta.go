@@ -3,18 +3,44 @@ package command
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
 	"time"
 
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcl/hclsyntax"
+	"github.com/mitchellh/cli"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/backend"
 	backendlocal "github.com/hashicorp/terraform/backend/local"
+	"github.com/hashicorp/terraform/configs"
+	"github.com/hashicorp/terraform/plans"
+	"github.com/hashicorp/terraform/plugin/discovery"
+	"github.com/hashicorp/terraform/providers"
 	"github.com/hashicorp/terraform/state"
+	"github.com/hashicorp/terraform/state/remote"
 	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
 	"github.com/hashicorp/terraform/states/statemgr"
 	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform/tfdiags"
 )
 
 // StateMeta is the meta struct that should be embedded in state subcommands.
 type StateMeta struct {
 	Meta
+
+	// stateBackupPath is the path of the local backup file that State chose
+	// for the state manager it returned, whether or not that manager writes
+	// local backups itself.
+	stateBackupPath string
+
+	// stateOutPath is the path of the local file where State's state
+	// manager writes the state, or caches it for a remote backend. Default
+	// backup paths are derived from it.
+	stateOutPath string
 }
 
 // State returns the state for this meta. This gets the appropriate state from
@@ -63,12 +89,10 @@ func (c *StateMeta) State() (state.State, error) {
 	if backupPath == "-" || backupPath == "" {
 		// Determine the backup path. stateOutPath is set to the resulting
 		// file where state is written (cached in the case of remote state)
-		backupPath = fmt.Sprintf(
-			"%s.%d%s",
-			stateOutPath,
-			time.Now().UTC().Unix(),
-			DefaultBackupExtension)
+		backupPath = defaultStateBackupPath(stateOutPath)
 	}
+	c.stateBackupPath = backupPath
+	c.stateOutPath = stateOutPath
 
 	// If the backend is local (which it should always be, given our asserting
 	// of it above) we can now enable backups for it.
@@ -79,6 +103,436 @@ func (c *StateMeta) State() (state.State, error) {
 	return realState, nil
 }
 
+// defaultStateBackupPath returns the timestamped path where state commands
+// write a backup of the state stored at the given path, if no backup path
+// is given explicitly.
+func defaultStateBackupPath(stateOutPath string) string {
+	return fmt.Sprintf(
+		"%s.%d%s",
+		stateOutPath,
+		time.Now().UTC().Unix(),
+		DefaultBackupExtension)
+}
+
+// backupToBackend saves the given state, which should be the state as it was
+// before a state command changed it, as a new workspace alongside the current
+// one in the configured backend, so that the backup is kept with the state
+// rather than on the local machine. The new workspace is named by
+// backupWorkspaceName, and is locked while the backup is written to it. It
+// is an ordinary workspace, so it is listed by "terraform workspace list"
+// and is left for the user to delete with "terraform workspace delete".
+//
+// If the backend doesn't support creating workspaces then the state is
+// written to a local backup file instead and a warning is returned. Nothing
+// is done for state stored in a local file, which already has a local backup.
+//
+// The returned string is the name of the new workspace, or an empty string
+// if the state was not backed up to the backend.
+func (c *StateMeta) backupToBackend(state *states.State, kind string) (_ string, diags tfdiags.Diagnostics) {
+
+	if c.statePath != "" {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"State is not in a backend",
+			"The state is being read from a local file given by -state, so -backup-to-backend has no effect. A local backup file is written as usual.",
+		))
+		return "", diags
+	}
+
+	b, backendDiags := c.Backend(nil)
+	diags = diags.Append(backendDiags)
+	if backendDiags.HasErrors() {
+		return "", diags
+	}
+
+	workspace := c.Workspace()
+	current, err := b.StateMgr(workspace)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to load state",
+			fmt.Sprintf(errStateLoadingState, err),
+		))
+		return "", diags
+	}
+	if _, ok := current.(*statemgr.Filesystem); ok {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"State is not in a remote backend",
+			"The state is stored locally, so -backup-to-backend has no effect. A local backup file is written as usual.",
+		))
+		return "", diags
+	}
+
+	existing, err := b.Workspaces()
+	if err != nil && err != backend.ErrWorkspacesNotSupported {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state backup",
+			fmt.Sprintf("Could not list the existing workspaces to name the backup workspace: %s.", err),
+		))
+		return "", diags
+	}
+	name := backupWorkspaceName(existing, workspace, kind, time.Now())
+	stateMgr, err := b.StateMgr(name)
+	if err == backend.ErrWorkspacesNotSupported {
+		// We'll fall back on the local backup file that we'd have used if
+		// the backend were local.
+		backupPath := c.backupPath
+		if backupPath == "-" || backupPath == "" {
+			localRaw, localDiags := c.Backend(&BackendOpts{ForceLocal: true})
+			diags = diags.Append(localDiags)
+			if localDiags.HasErrors() {
+				return "", diags
+			}
+			_, stateOutPath, _ := localRaw.(*backendlocal.Local).StatePaths(workspace)
+			backupPath = defaultStateBackupPath(stateOutPath)
+		}
+
+		f := statemgr.NewStateFile()
+		f.State = state
+		if err := writeStateFile(backupPath, f); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to write state backup",
+				fmt.Sprintf("The backend doesn't support workspaces, so the backup was to be written to the local file %s instead, but that failed: %s.", backupPath, err),
+			))
+			return "", diags
+		}
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Backend can't store backup",
+			fmt.Sprintf("The backend doesn't support workspaces, so the backup has been written to the local file %s instead.", backupPath),
+		))
+		return "", diags
+	}
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state backup",
+			fmt.Sprintf("Could not create the backup workspace %q: %s.", name, err),
+		))
+		return "", diags
+	}
+
+	// The new workspace is locked while we write to it, as with any other
+	// workspace, in case something else has noticed it already.
+	if locker, ok := stateMgr.(statemgr.Locker); ok {
+		info := statemgr.NewLockInfo()
+		info.Operation = "backup"
+		id, err := locker.Lock(info)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to write state backup",
+				fmt.Sprintf("Could not lock the new backup workspace %q: %s.", name, err),
+			))
+			return "", diags
+		}
+		defer func() {
+			if err := locker.Unlock(id); err != nil {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Warning,
+					"Failed to unlock state backup",
+					fmt.Sprintf("The backup was written to the workspace %q, but the workspace could not be unlocked: %s. Use \"terraform force-unlock %s\" in that workspace to unlock it.", name, err, id),
+				))
+			}
+		}()
+	}
+
+	// Backends typically create an empty snapshot for a new workspace, which
+	// some state managers will only replace if they've read it first.
+	if err := stateMgr.RefreshState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state backup",
+			fmt.Sprintf("Could not read the new backup workspace %q: %s.", name, err),
+		))
+		return "", diags
+	}
+	if err := statemgr.WriteAndPersist(stateMgr, state); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state backup",
+			fmt.Sprintf("Could not write the backup to the workspace %q: %s.", name, err),
+		))
+		return "", diags
+	}
+
+	return name, diags
+}
+
+// backupWorkspaceName returns the name for a backup of the given workspace
+// made by backupToBackend at the given time, which takes the form
+// "<workspace>.<kind>-backup-<unix time>", such as
+// "default.staterm-backup-1540000000". If a workspace of that name already
+// exists, because of another backup in the same second or because a user
+// happened to choose it, a numeric suffix is added to make the name unique.
+func backupWorkspaceName(existing []string, workspace, kind string, now time.Time) string {
+	taken := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		taken[name] = true
+	}
+	base := fmt.Sprintf("%s.%s-backup-%d", workspace, kind, now.UTC().Unix())
+	name := base
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	return name
+}
+
+// parseResourceInstanceAddr parses the given raw command line argument as
+// a resource instance address.
+//
+// The given filename is used as a synthetic source filename for the address,
+// so that any returned diagnostics can include a source snippet showing
+// exactly which part of which address was invalid.
+func (c *StateMeta) parseResourceInstanceAddr(rawAddr, filename string) (addrs.AbsResourceInstance, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	src := []byte(rawAddr)
+	traversal, travDiags := hclsyntax.ParseTraversalAbs(src, filename, hcl.Pos{Line: 1, Column: 1})
+	diags = diags.Append(travDiags)
+	if travDiags.HasErrors() {
+		c.registerSynthConfigSource(filename, src) // so we can include a source snippet
+		return addrs.AbsResourceInstance{}, diags
+	}
+
+	addr, addrDiags := addrs.ParseAbsResourceInstance(traversal)
+	diags = diags.Append(addrDiags)
+	if addrDiags.HasErrors() {
+		c.registerSynthConfigSource(filename, src) // so we can include a source snippet
+	}
+	return addr, diags
+}
+
+// planAgainstState creates a plan for the given configuration against the
+// given state, which need not have been persisted yet. This allows state
+// subcommands to understand how the configuration relates to the objects in
+// the state before or after they make any changes. If any targets are given,
+// the plan is limited to them as with the -target option of "terraform plan".
+func (c *Meta) planAgainstState(config *configs.Config, state *states.State, targets ...addrs.Targetable) (*plans.Plan, tfdiags.Diagnostics) {
+	tfCtx, diags := c.contextForState(config, state, targets...)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	plan, planDiags := tfCtx.Plan()
+	diags = diags.Append(planDiags)
+	return plan, diags
+}
+
+// refreshAgainstState refreshes the given state using the providers required
+// by the given configuration, returning the refreshed state. The given state
+// isn't modified, so this allows state subcommands to compare the objects in
+// the state with the real objects they represent.
+func (c *Meta) refreshAgainstState(config *configs.Config, state *states.State) (*states.State, tfdiags.Diagnostics) {
+	tfCtx, diags := c.contextForState(config, state)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	refreshed, refreshDiags := tfCtx.Refresh()
+	diags = diags.Append(refreshDiags)
+	return refreshed, diags
+}
+
+// contextForState creates a context for the given configuration and state,
+// with the variable values given on the command line and the given targets,
+// for planAgainstState and refreshAgainstState.
+func (c *Meta) contextForState(config *configs.Config, state *states.State, targets ...addrs.Targetable) (*terraform.Context, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	rawVariables, varDiags := c.collectVariableValues()
+	diags = diags.Append(varDiags)
+	if varDiags.HasErrors() {
+		return nil, diags
+	}
+	variables, varDiags := backend.ParseVariableValues(rawVariables, config.Module.Variables)
+	diags = diags.Append(varDiags)
+	if varDiags.HasErrors() {
+		return nil, diags
+	}
+
+	opts := c.contextOpts()
+	opts.Config = config
+	opts.State = state
+	opts.Variables = variables
+	if len(targets) > 0 {
+		opts.Targets = targets
+	}
+	tfCtx, ctxDiags := terraform.NewContext(opts)
+	diags = diags.Append(ctxDiags)
+	return tfCtx, diags
+}
+
+// stateProviderSchemas returns the schemas of the installed providers of the
+// given types, for state subcommands that interpret the objects in the state
+// without loading the configuration. The types of the providers that aren't
+// installed are also returned, sorted, so that the caller can say what that
+// means for its output. A provider that can't be started or doesn't return
+// its schema is only a warning, and is left out of both.
+func (m *Meta) stateProviderSchemas(types []string) (map[string]providers.GetSchemaResponse, []string, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	reqd := make(discovery.PluginRequirements, len(types))
+	for _, name := range types {
+		reqd[name] = &discovery.PluginConstraints{Versions: discovery.AllVersions}
+	}
+	var resolver providers.Resolver
+	if m.testingOverrides != nil {
+		resolver = m.testingOverrides.ProviderResolver
+	} else {
+		resolver = m.providerResolver()
+	}
+	factories, _ := resolver.ResolveProviders(reqd)
+
+	schemas := make(map[string]providers.GetSchemaResponse, len(reqd))
+	var missing []string
+	for name := range reqd {
+		factory, ok := factories[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		provider, err := factory()
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Failed to start provider",
+				fmt.Sprintf("Could not start provider.%s to read its schema: %s.", name, err),
+			))
+			continue
+		}
+		resp := provider.GetSchema()
+		provider.Close()
+		if resp.Diagnostics.HasErrors() {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Failed to load provider schema",
+				fmt.Sprintf("Could not load the schema of provider.%s: %s.", name, resp.Diagnostics.Err()),
+			))
+			continue
+		}
+		schemas[name] = resp
+	}
+	sort.Strings(missing)
+	return schemas, missing, diags
+}
+
+// configOrphanedInstances returns the addresses of all of the resource
+// instances in the given state whose resources are not declared in the
+// given configuration, either because the resource block was removed or
+// because the module it belongs to was. The result is sorted by address.
+func configOrphanedInstances(config *configs.Config, state *states.State) []addrs.AbsResourceInstance {
+	var ret []addrs.AbsResourceInstance
+	for _, ms := range state.Modules {
+		modCfg := config.DescendentForInstance(ms.Addr)
+		for _, rs := range ms.Resources {
+			if modCfg != nil && modCfg.Module.ResourceByAddr(rs.Addr) != nil {
+				continue
+			}
+			for key := range rs.Instances {
+				ret = append(ret, rs.Addr.Instance(key).Absolute(ms.Addr))
+			}
+		}
+	}
+
+	// The state is made of maps, so we'll sort the result to produce
+	// consistent output.
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].String() < ret[j].String()
+	})
+	return ret
+}
+
+// readStateFile reads the state snapshot stored in the file at the given
+// path. This is used by commands that compare the current state against some
+// other snapshot, such as a baseline or a backup file.
+func readStateFile(path string) (*statefile.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return statefile.Read(f)
+}
+
+// writeStateFile writes the given state file to the given path, replacing
+// any file that is already there.
+func writeStateFile(path string, f *statefile.File) error {
+	fh, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return statefile.Write(f, fh)
+}
+
+// stateTrace reports how long each phase of a state command takes, for
+// the -trace option. A nil *stateTrace is valid and reports nothing.
+type stateTrace struct {
+	ui    cli.Ui
+	phase string
+	start time.Time
+}
+
+// newStateTrace returns a stateTrace that writes to the error stream of the
+// given UI, or nil if tracing isn't enabled.
+func newStateTrace(ui cli.Ui, enabled bool) *stateTrace {
+	if !enabled {
+		return nil
+	}
+	return &stateTrace{ui: ui}
+}
+
+// Phase ends the current phase, if any, reporting its duration, and then
+// starts a new phase with the given name.
+func (t *stateTrace) Phase(name string) {
+	if t == nil {
+		return
+	}
+	t.Done()
+	t.phase = name
+	t.start = time.Now()
+}
+
+// Done ends the current phase, if any, reporting its duration. It's safe to
+// call Done more than once, so callers can defer it to report the phase that
+// was in progress when the command returned early.
+func (t *stateTrace) Done() {
+	if t == nil || t.phase == "" {
+		return
+	}
+	t.ui.Error(fmt.Sprintf("[trace] %s: %s", t.phase, time.Since(t.start)))
+	t.phase = ""
+}
+
+// storedStateSnapshot returns the raw bytes of the state snapshot currently
+// stored by the given state manager, exactly as it was written, or nil if
+// there is no snapshot yet. The second return value is false if the manager
+// doesn't give access to its stored snapshots.
+func storedStateSnapshot(mgr statemgr.Full) ([]byte, bool, error) {
+	switch mgr := mgr.(type) {
+	case *statemgr.Filesystem:
+		src, err := ioutil.ReadFile(mgr.ReadPath())
+		if os.IsNotExist(err) {
+			return nil, true, nil
+		}
+		return src, true, err
+	case *remote.State:
+		payload, err := mgr.Client.Get()
+		if err != nil || payload == nil {
+			return nil, true, err
+		}
+		return payload.Data, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
 // filterInstance filters a single instance out of filter results.
 func (c *StateMeta) filterInstance(rs []*states.FilterResult) (*states.FilterResult, error) {
 	var result *states.FilterResult
@@ -1,10 +1,13 @@
 package command
 
 import (
+	"bytes"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
 	"github.com/mitchellh/cli"
 )
 
@@ -21,19 +24,73 @@ func (c *StateMvCommand) Run(args []string) int {
 
 	// We create two metas to track the two states
 	var backupPathOut, statePathOut string
+	var dryRun, regex bool
+	var fromFile string
 
 	cmdFlags := c.Meta.flagSet("state mv")
 	cmdFlags.StringVar(&c.backupPath, "backup", "-", "backup")
 	cmdFlags.StringVar(&c.statePath, "state", "", "path")
 	cmdFlags.StringVar(&backupPathOut, "backup-out", "-", "backup")
 	cmdFlags.StringVar(&statePathOut, "state-out", "", "path")
+	cmdFlags.BoolVar(&dryRun, "dry-run", false, "dry run")
+	cmdFlags.StringVar(&fromFile, "from-file", "", "path")
+	cmdFlags.BoolVar(&regex, "regex", false, "move the instances matching a pattern")
 	if err := cmdFlags.Parse(args); err != nil {
 		return cli.RunResultHelp
 	}
 	args = cmdFlags.Args()
-	if len(args) != 2 {
-		c.Ui.Error("Exactly two arguments expected.\n")
-		return cli.RunResultHelp
+
+	// The moves of a batch are only checked and listed for now, since a
+	// single move can't be made with the current state types either.
+	if (fromFile != "" || regex) && !dryRun {
+		c.showDiagnostics(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Batch moves can only be previewed",
+			"The -from-file and -regex options only check and list the moves they choose, without making them, so they must be used with -dry-run.",
+		))
+		return 1
+	}
+
+	var pairs []stateMvPair
+	var re *regexp.Regexp
+	if regex {
+		if fromFile != "" {
+			c.Ui.Error("The -regex and -from-file options both choose the moves to make, so they can't be used together.\n")
+			return cli.RunResultHelp
+		}
+		if len(args) != 2 {
+			c.Ui.Error("The -regex option expects exactly two arguments: a pattern and a replacement.\n")
+			return cli.RunResultHelp
+		}
+		re, err = compileStateMvRegex(args[0])
+		if err != nil {
+			c.showDiagnostics(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -regex pattern",
+				fmt.Sprintf("The pattern %q is not a valid regular expression: %s.", args[0], err),
+			))
+			return 1
+		}
+	} else if fromFile != "" {
+		if len(args) != 0 {
+			c.Ui.Error("The -from-file option lists the moves to make, so no addresses can be given as arguments.\n")
+			return cli.RunResultHelp
+		}
+		pairs, err = readMovePairsFile(fromFile)
+		if err != nil {
+			c.showDiagnostics(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to read moves",
+				fmt.Sprintf("Could not read the moves from %s: %s.", fromFile, err),
+			))
+			return 1
+		}
+	} else {
+		if len(args) != 2 {
+			c.Ui.Error("Exactly two arguments expected.\n")
+			return cli.RunResultHelp
+		}
+		pairs = []stateMvPair{{From: args[0], To: args[1]}}
 	}
 
 	// Read the from state
@@ -78,6 +135,19 @@ func (c *StateMvCommand) Run(args []string) int {
 		}
 	}
 
+	if regex {
+		var diags tfdiags.Diagnostics
+		pairs, diags = stateMvRegexPairs(stateFromReal, re, args[1])
+		if diags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	if dryRun {
+		return c.dryRun(stateFromReal, stateToReal, stateTo == stateFrom, pairs)
+	}
+
 	c.Ui.Error("state mv command not yet updated for new state types")
 	return 1
 	/*
@@ -137,6 +207,55 @@ func (c *StateMvCommand) Run(args []string) int {
 	return 0
 }
 
+// dryRun prints each of the resource instance moves that moving the given
+// pairs of source and destination addresses would make, without changing
+// either state, and fails if any of the destinations already exist.
+func (c *StateMvCommand) dryRun(from, to *states.State, sameState bool, pairs []stateMvPair) int {
+	moves, diags := c.checkMoves(from, to, sameState, pairs)
+	if moves == nil && diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	var buf bytes.Buffer
+	for _, move := range moves {
+		fmt.Fprintf(&buf, "Would move %s -> %s\n", move.From, move.To)
+	}
+	fmt.Fprintf(&buf, "\nWould move %d resource instances.", len(moves))
+	c.Ui.Output(buf.String())
+
+	c.showDiagnostics(diags)
+	if diags.HasErrors() {
+		return 1
+	}
+	return 0
+}
+
+// checkMoves returns the resource instance moves that moving the given pairs
+// of source and destination addresses would make, along with an error if any
+// of the destinations already exist in the destination state. The moves are
+// returned with that error, so that they can still be listed by -dry-run,
+// but with no moves for any other error.
+func (c *StateMvCommand) checkMoves(from, to *states.State, sameState bool, pairs []stateMvPair) ([]stateMvMove, tfdiags.Diagnostics) {
+	moves, diags := stateMvBatchMoves(from, pairs)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	if conflicts := stateMvConflicts(to, moves, sameState); len(conflicts) > 0 {
+		var lines []string
+		for _, move := range conflicts {
+			lines = append(lines, fmt.Sprintf("  %s -> %s", move.From, move.To))
+		}
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Destination already exists",
+			fmt.Sprintf("The following moves would overwrite resource instances that already exist in the destination state:\n\n%s\n\nMove or remove the existing instances first, or choose a different destination.", strings.Join(lines, "\n")),
+		))
+	}
+	return moves, diags
+}
+
 // addableResult takes the result from a filter operation and returns what to
 // call State.Add with. The reason we do this is because in the module case
 // we must add the list of all modules returned versus just the root module.
@@ -163,6 +282,8 @@ func (c *StateMvCommand) addableResult(results []*states.FilterResult) interface
 func (c *StateMvCommand) Help() string {
 	helpText := `
 Usage: terraform state mv [options] SOURCE DESTINATION
+       terraform state mv [options] -dry-run -from-file=PATH
+       terraform state mv [options] -dry-run -regex PATTERN REPLACEMENT
 
  This command will move an item matched by the address given to the
  destination address. This command can also move to a destination address
@@ -194,6 +315,30 @@ Options:
                       to be specified if -state-out is set to a different path
                       than -state.
 
+  -dry-run            Print each resource instance that would be moved, with
+                      its source and destination addresses, without changing
+                      either state. Moving a module lists every resource
+                      instance in it and its descendent modules. Fails if
+                      any of the destinations already exist.
+
+  -from-file=PATH     Check and list each of the moves in the file at PATH,
+                      instead of a single move given as arguments, without
+                      making them. This must be used with -dry-run, since
+                      it only previews the moves. Each line is a SOURCE and a
+                      DESTINATION address separated by a space, and blank
+                      lines and lines starting with "#" are ignored. The
+                      moves are checked together, including that no two
+                      select the same instance or the same destination.
+
+  -regex              Check and list the move of each resource instance
+                      whose address matches the regular expression PATTERN,
+                      which must match the whole address, to the address
+                      made by expanding REPLACEMENT, where $1 or ${name} is
+                      replaced by a submatch, without making the moves. As
+                      with -from-file, this must be used with -dry-run, and
+                      the moves are checked together. Quote both arguments
+                      to keep the shell from expanding them.
+
   -state=PATH         Path to the source state file. Defaults to the configured
                       backend, or "terraform.tfstate"
 
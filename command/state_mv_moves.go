@@ -0,0 +1,308 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateMvMove is the move of a single resource instance by "terraform state
+// mv", from its address in the source state to its address in the
+// destination state.
+type stateMvMove struct {
+	From, To addrs.AbsResourceInstance
+}
+
+// stateMvMoves returns the moves of the resource instances in the given state
+// that are selected by the given source address, to the given destination
+// address, sorted by source address. Moving a module moves all of the
+// resource instances in it and in its descendent modules.
+//
+// The addresses can each be a module instance, a resource, or a resource
+// instance, and a resource or resource instance can be moved into a module
+// by giving the module as the destination, keeping its own name.
+func stateMvMoves(state *states.State, rawFrom, rawTo string) ([]stateMvMove, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	from, fromDiags := addrs.ParseTargetStr(rawFrom)
+	diags = diags.Append(fromDiags)
+	to, toDiags := addrs.ParseTargetStr(rawTo)
+	diags = diags.Append(toDiags)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var moves []stateMvMove
+	switch fromAddr := from.Subject.(type) {
+	case addrs.ModuleInstance:
+		toAddr, ok := to.Subject.(addrs.ModuleInstance)
+		if !ok {
+			diags = diags.Append(stateMvInvalidDestination(rawFrom, rawTo, "a module can only be moved to another module address"))
+			return nil, diags
+		}
+		if fromAddr.IsRoot() {
+			diags = diags.Append(stateMvInvalidDestination(rawFrom, rawTo, "the root module can't be moved"))
+			return nil, diags
+		}
+		if stateMvModuleContains(fromAddr, toAddr) {
+			diags = diags.Append(stateMvInvalidDestination(rawFrom, rawTo, "a module can't be moved into itself"))
+			return nil, diags
+		}
+		for _, ms := range state.Modules {
+			if !stateMvModuleContains(fromAddr, ms.Addr) {
+				continue
+			}
+			modAddr := append(toAddr[:len(toAddr):len(toAddr)], ms.Addr[len(fromAddr):]...)
+			for _, rs := range ms.Resources {
+				for key := range rs.Instances {
+					moves = append(moves, stateMvMove{
+						From: rs.Addr.Instance(key).Absolute(ms.Addr),
+						To:   rs.Addr.Instance(key).Absolute(modAddr),
+					})
+				}
+			}
+		}
+
+	case addrs.AbsResource:
+		rs := state.Resource(fromAddr)
+		if rs == nil {
+			break
+		}
+		var toAddr addrs.AbsResource
+		switch addr := to.Subject.(type) {
+		case addrs.AbsResource:
+			toAddr = addr
+		case addrs.ModuleInstance:
+			toAddr = fromAddr.Resource.Absolute(addr)
+		default:
+			diags = diags.Append(stateMvInvalidDestination(rawFrom, rawTo, "a whole resource can only be moved to a resource or module address"))
+			return nil, diags
+		}
+		if !stateMvSameType(fromAddr.Resource, toAddr.Resource) {
+			diags = diags.Append(stateMvInvalidDestination(rawFrom, rawTo, "a resource can only be moved to an address of the same resource type"))
+			return nil, diags
+		}
+		for key := range rs.Instances {
+			moves = append(moves, stateMvMove{
+				From: fromAddr.Instance(key),
+				To:   toAddr.Instance(key),
+			})
+		}
+
+	case addrs.AbsResourceInstance:
+		if state.ResourceInstance(fromAddr) == nil {
+			break
+		}
+		var toAddr addrs.AbsResourceInstance
+		switch addr := to.Subject.(type) {
+		case addrs.AbsResourceInstance:
+			toAddr = addr
+		case addrs.AbsResource:
+			toAddr = addr.Instance(addrs.NoKey)
+		case addrs.ModuleInstance:
+			toAddr = fromAddr.Resource.Absolute(addr)
+		}
+		if !stateMvSameType(fromAddr.Resource.Resource, toAddr.Resource.Resource) {
+			diags = diags.Append(stateMvInvalidDestination(rawFrom, rawTo, "a resource instance can only be moved to an address of the same resource type"))
+			return nil, diags
+		}
+		moves = append(moves, stateMvMove{From: fromAddr, To: toAddr})
+	}
+
+	if len(moves) == 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Nothing to move",
+			fmt.Sprintf("There are no resource instances in the state matching %s.", rawFrom),
+		))
+		return nil, diags
+	}
+
+	sort.Slice(moves, func(i, j int) bool {
+		return moves[i].From.Less(moves[j].From)
+	})
+	return moves, diags
+}
+
+// stateMvPair is a single SOURCE DESTINATION pair to move, as given on the
+// command line or on a line of a -from-file file.
+type stateMvPair struct {
+	From, To string
+}
+
+// readMovePairsFile reads the pairs of addresses listed in the file at the
+// given path, for the -from-file option. Each line of the file is a source
+// address followed by a destination address, separated by whitespace, and
+// blank lines and lines starting with "#" are ignored.
+func readMovePairsFile(path string) ([]stateMvPair, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []stateMvPair
+	for i, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d must be a source address and a destination address separated by a space, not:\n  %s", i+1, line)
+		}
+		ret = append(ret, stateMvPair{From: fields[0], To: fields[1]})
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("the file doesn't list any moves")
+	}
+	return ret, nil
+}
+
+// compileStateMvRegex compiles the given pattern for the -regex option. The
+// pattern must match the whole of an address, so that a pattern such as
+// `aws_instance\.web_(.*)` doesn't also match within a module path.
+func compileStateMvRegex(pattern string) (*regexp.Regexp, error) {
+	// The pattern is compiled alone first, so that an error refers to the
+	// pattern as it was given.
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// stateMvRegexPairs returns a pair for each resource instance in the given
+// state whose address matches the given pattern, in order of address, for
+// the -regex option. The destination of each is the given replacement, with
+// $1 or ${name} expanded to the submatches of the pattern as for
+// regexp.Regexp.Expand.
+//
+// Each destination must be a valid address, but whether the moves can be made
+// together is left to stateMvBatchMoves and the conflict check, as for
+// -from-file.
+func stateMvRegexPairs(state *states.State, re *regexp.Regexp, replacement string) ([]stateMvPair, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	var ret []stateMvPair
+	for _, addr := range stateAllResourceInstances(state) {
+		from := addr.String()
+		match := re.FindStringSubmatchIndex(from)
+		if match == nil {
+			continue
+		}
+		to := string(re.ExpandString(nil, replacement, from, match))
+		if _, moreDiags := addrs.ParseTargetStr(to); moreDiags.HasErrors() {
+			diags = diags.Append(stateMvInvalidDestination(from, to, "the replacement doesn't make a valid address"))
+			continue
+		}
+		ret = append(ret, stateMvPair{From: from, To: to})
+	}
+	if len(ret) == 0 && !diags.HasErrors() {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Nothing to move",
+			fmt.Sprintf("There are no resource instances in the state with addresses matching %s.", re.String()),
+		))
+	}
+	return ret, diags
+}
+
+// stateMvBatchMoves returns the moves made by all of the given pairs
+// together, in the order of the pairs. Each pair selects its instances from
+// the given state as it is before any of the moves, so that the whole batch
+// can be checked before anything is changed.
+//
+// As well as the errors for each pair, it's an error for two pairs to move
+// the same instance, or to move two instances to the same destination.
+func stateMvBatchMoves(state *states.State, pairs []stateMvPair) ([]stateMvMove, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	var moves []stateMvMove
+	for _, pair := range pairs {
+		pairMoves, pairDiags := stateMvMoves(state, pair.From, pair.To)
+		diags = diags.Append(pairDiags)
+		moves = append(moves, pairMoves...)
+	}
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var sources, destinations []string
+	from := make(map[string]bool, len(moves))
+	to := make(map[string]bool, len(moves))
+	for _, move := range moves {
+		if from[move.From.String()] {
+			sources = append(sources, "  "+move.From.String())
+		}
+		from[move.From.String()] = true
+		if to[move.To.String()] {
+			destinations = append(destinations, "  "+move.To.String())
+		}
+		to[move.To.String()] = true
+	}
+	if len(sources) > 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Resource instances moved more than once",
+			fmt.Sprintf("More than one of the moves selects each of the following resource instances:\n\n%s\n\nEach instance can only be moved once.", strings.Join(sources, "\n")),
+		))
+	}
+	if len(destinations) > 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Conflicting destinations",
+			fmt.Sprintf("More than one resource instance would be moved to each of the following addresses:\n\n%s\n\nChoose a different destination for each instance.", strings.Join(destinations, "\n")),
+		))
+	}
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	return moves, diags
+}
+
+// stateMvConflicts returns those of the given moves whose destinations already
+// exist in the given destination state. If the destination state is also the
+// source state, a destination that is itself being moved away doesn't
+// conflict.
+func stateMvConflicts(to *states.State, moves []stateMvMove, sameState bool) []stateMvMove {
+	movedAway := make(map[string]bool, len(moves))
+	if sameState {
+		for _, move := range moves {
+			movedAway[move.From.String()] = true
+		}
+	}
+
+	var ret []stateMvMove
+	for _, move := range moves {
+		if to.ResourceInstance(move.To) == nil || movedAway[move.To.String()] {
+			continue
+		}
+		ret = append(ret, move)
+	}
+	return ret
+}
+
+// stateMvModuleContains returns true if the module instance addr is the given
+// module instance or one of its descendents. Unlike
+// ModuleInstance.IsAncestor, a step without an instance key only matches
+// a module without count or for_each.
+func stateMvModuleContains(module, addr addrs.ModuleInstance) bool {
+	return len(addr) >= len(module) && addr[:len(module)].Equal(module)
+}
+
+func stateMvSameType(from, to addrs.Resource) bool {
+	return from.Mode == to.Mode && from.Type == to.Type
+}
+
+func stateMvInvalidDestination(from, to, reason string) tfdiags.Diagnostic {
+	return tfdiags.Sourceless(
+		tfdiags.Error,
+		"Invalid destination address",
+		fmt.Sprintf("Can't move %s to %s: %s.", from, to, reason),
+	)
+}
@@ -1,9 +1,12 @@
 package command
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/mitchellh/cli"
@@ -335,6 +338,311 @@ func TestStateMv_stateOutExisting(t *testing.T) {
 	testStateOutput(t, backups[0], testStateMvExisting_stateDstOriginal)
 }
 
+func TestStateMv_dryRun(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"test_instance.foo",
+			"test_instance.baz",
+			"module.child.test_instance.web[0]",
+			"module.child.module.grandchild.test_instance.db",
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(`{"id":"bar"}`),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+	original, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (int, *cli.MockUi) {
+		ui := new(cli.MockUi)
+		c := &StateMvCommand{
+			StateMeta{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			},
+		}
+		return c.Run(append([]string{"-state", statePath, "-dry-run"}, args...)), ui
+	}
+
+	code, ui := run("module.child", "module.parent.module.child")
+	if code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `Would move module.child.test_instance.web[0] -> module.parent.module.child.test_instance.web[0]
+Would move module.child.module.grandchild.test_instance.db -> module.parent.module.child.module.grandchild.test_instance.db
+
+Would move 2 resource instances.
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	code, ui = run("test_instance.foo", "test_instance.baz")
+	if code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	got := ui.ErrorWriter.String()
+	for _, want := range []string{
+		"Destination already exists",
+		"test_instance.foo -> test_instance.baz",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in error\n%s", want, got)
+		}
+	}
+
+	code, ui = run("test_instance.foo", "test_other.foo")
+	if code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got := ui.ErrorWriter.String(); !strings.Contains(got, "Invalid destination address") {
+		t.Errorf("wrong error\n%s", got)
+	}
+
+	after, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, original) {
+		t.Fatalf("state was changed by a dry run\n%s", after)
+	}
+}
+
+func TestStateMv_fromFile(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"test_instance.foo",
+			"test_instance.bar",
+			"test_instance.web[0]",
+			"test_instance.web[1]",
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(`{"id":"bar"}`),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+	original, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movesPath := filepath.Join(filepath.Dir(statePath), "moves.txt")
+	run := func(moves string, args ...string) (int, *cli.MockUi) {
+		if err := ioutil.WriteFile(movesPath, []byte(moves), 0644); err != nil {
+			t.Fatal(err)
+		}
+		ui := new(cli.MockUi)
+		c := &StateMvCommand{
+			StateMeta{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			},
+		}
+		return c.Run(append([]string{"-state", statePath, "-from-file", movesPath}, args...)), ui
+	}
+
+	// Swapping two instances within one state doesn't conflict, because
+	// each destination is moved away by the other line.
+	code, ui := run(`# Swap foo and bar, and move web into a module.
+test_instance.foo test_instance.bar
+
+test_instance.bar  test_instance.foo
+test_instance.web module.child
+`, "-dry-run")
+	if code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `Would move test_instance.foo -> test_instance.bar
+Would move test_instance.bar -> test_instance.foo
+Would move test_instance.web[0] -> module.child.test_instance.web[0]
+Would move test_instance.web[1] -> module.child.test_instance.web[1]
+
+Would move 4 resource instances.
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	cases := map[string]struct {
+		moves string
+		want  string
+	}{
+		"malformed line": {
+			"test_instance.foo test_instance.baz\ntest_instance.bar\n",
+			"Failed to read moves",
+		},
+		"moved twice": {
+			"test_instance.foo test_instance.baz\ntest_instance.foo test_instance.qux\n",
+			"Resource instances moved more than once",
+		},
+		"same destination": {
+			"test_instance.foo test_instance.baz\ntest_instance.web[0] test_instance.baz\n",
+			"Conflicting destinations",
+		},
+		"destination exists": {
+			"test_instance.foo test_instance.bar\n",
+			"Destination already exists",
+		},
+		"nothing to move": {
+			"test_instance.foo test_instance.baz\ntest_instance.nope test_instance.qux\n",
+			"Nothing to move",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			code, ui := run(tc.moves, "-dry-run")
+			if code != 1 {
+				t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+			}
+			if got := ui.ErrorWriter.String(); !strings.Contains(got, tc.want) {
+				t.Errorf("missing %q in error\n%s", tc.want, got)
+			}
+		})
+	}
+
+	// The moves are only previewed, so they can't be made without -dry-run.
+	code, ui = run("test_instance.foo test_instance.baz\n")
+	if code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Batch moves can only be previewed"; !strings.Contains(got, want) {
+		t.Errorf("missing %q in error\n%s", want, got)
+	}
+
+	after, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, original) {
+		t.Fatalf("state was changed\n%s", after)
+	}
+}
+
+func TestStateMv_regex(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"test_instance.other",
+			"test_instance.web_a",
+			"test_instance.web_b",
+			"module.old.test_instance.web_c",
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(`{"id":"bar"}`),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+	original, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (int, *cli.MockUi) {
+		ui := new(cli.MockUi)
+		c := &StateMvCommand{
+			StateMeta{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			},
+		}
+		return c.Run(append([]string{"-state", statePath, "-regex"}, args...)), ui
+	}
+
+	// The pattern must match the whole address, so the instance in
+	// module.old isn't moved.
+	code, ui := run("-dry-run", `test_instance\.web_(\w+)`, "module.web.test_instance.$1")
+	if code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `Would move test_instance.web_a -> module.web.test_instance.a
+Would move test_instance.web_b -> module.web.test_instance.b
+
+Would move 2 resource instances.
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"invalid pattern": {
+			[]string{"test_instance.(", "test_instance.x"},
+			"Invalid -regex pattern",
+		},
+		"invalid destination": {
+			[]string{`test_instance\.web_a`, "not an address"},
+			"Invalid destination address",
+		},
+		"same destination": {
+			[]string{`test_instance\.web_\w+`, "test_instance.web"},
+			"Conflicting destinations",
+		},
+		"destination exists": {
+			[]string{`test_instance\.web_a`, "test_instance.other"},
+			"Destination already exists",
+		},
+		"nothing to move": {
+			[]string{`test_instance\.nope`, "test_instance.x"},
+			"Nothing to move",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			code, ui := run(append([]string{"-dry-run"}, tc.args...)...)
+			if code != 1 {
+				t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+			}
+			if got := ui.ErrorWriter.String(); !strings.Contains(got, tc.want) {
+				t.Errorf("missing %q in error\n%s", tc.want, got)
+			}
+		})
+	}
+
+	code, ui = run(`test_instance\.web_(\w+)`, "module.web.test_instance.$1")
+	if code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Batch moves can only be previewed"; !strings.Contains(got, want) {
+		t.Errorf("missing %q in error\n%s", want, got)
+	}
+
+	after, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, original) {
+		t.Fatalf("state was changed\n%s", after)
+	}
+}
+
 func TestStateMv_noState(t *testing.T) {
 	tmp, cwd := testCwd(t)
 	defer testFixCwd(t, tmp, cwd)
@@ -2,12 +2,33 @@ package command
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcl/hclsyntax"
 	"github.com/mitchellh/cli"
 
 	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/command/clistate"
+	"github.com/hashicorp/terraform/configs"
+	"github.com/hashicorp/terraform/plans"
+	"github.com/hashicorp/terraform/plugin/discovery"
+	"github.com/hashicorp/terraform/providers"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
 	"github.com/hashicorp/terraform/tfdiags"
 )
 
@@ -16,126 +37,3876 @@ type StateRmCommand struct {
 	StateMeta
 }
 
-func (c *StateRmCommand) Run(args []string) int {
+func (c *StateRmCommand) Run(args []string) (code int) {
+	// The arguments as given are recorded by -pre-plan-snapshot.
+	givenArgs := append([]string(nil), args...)
 	args, err := c.Meta.process(args, true)
 	if err != nil {
 		return 1
 	}
 
+	var opts stateRmOpts
+	var preserveOutputs bool
+	var assertCount, postRemovePlanGate int
+	var addressFlags, mergeRaw []string
+	var addressesFrom string
 	cmdFlags := c.Meta.flagSet("state show")
-	var dryRun bool
-	cmdFlags.BoolVar(&dryRun, "dry-run", false, "dry run")
+	cmdFlags.BoolVar(&opts.DryRun, "dry-run", false, "dry run")
+	cmdFlags.BoolVar(&opts.DryRunExitCode, "dry-run-exit-code", false, "exit with status 2 if anything would be removed")
+	cmdFlags.BoolVar(&opts.FailIfEmpty, "fail-if-empty", false, "fail if nothing is selected for removal")
+	cmdFlags.IntVar(&assertCount, "assert-count", -1, "fail unless exactly this many instances are selected for removal")
+	cmdFlags.BoolVar(&opts.ApprovalToken, "approval-token", false, "print approval token")
+	cmdFlags.BoolVar(&opts.SimulatePlan, "simulate-plan", false, "plan against the state after the removal")
+	cmdFlags.StringVar(&opts.PlanFileOut, "plan-file-out", "", "path")
+	cmdFlags.BoolVar(&opts.PreviewDestroyCount, "preview-apply-destroy-count", false, "count the selected instances that apply would destroy")
+	cmdFlags.BoolVar(&opts.RequireCleanPlan, "require-clean-plan", false, "refuse if a plan would change the selected instances")
+	cmdFlags.BoolVar(&opts.RespectPreventDestroy, "respect-prevent-destroy", false, "refuse to remove instances whose configuration sets prevent_destroy")
+	cmdFlags.BoolVar(&opts.ConflictCheck, "conflict-check", false, "refuse if another operation holds the lock on the state")
+	cmdFlags.BoolVar(&opts.RefuseOnDrift, "refuse-on-drift", false, "refuse if a refresh would change the selected instances")
+	cmdFlags.StringVar(&opts.ConfirmFile, "confirm-file", "", "approval token file")
+	cmdFlags.BoolVar(&opts.StdinConfirmToken, "stdin-confirm-token", false, "read approval token from stdin")
+	cmdFlags.Var((*FlagStringSlice)(&addressFlags), "address", "address of an instance to remove")
+	cmdFlags.Var((*FlagStringSlice)(&mergeRaw), "merge", "remove SOURCE as a duplicate of DEST, given as SOURCE=DEST")
+	cmdFlags.StringVar(&opts.FromFile, "from-file", "", "path of a file to read addresses from, or - for stdin")
+	cmdFlags.StringVar(&addressesFrom, "addresses-from", "", "alias of -from-file")
+	cmdFlags.StringVar(&opts.AddressFileFormat, "address-file-format", "", "format of the -from-file file: json or text")
+	cmdFlags.StringVar(&opts.ByResourceFile, "by-resource-file", "", "path")
+	cmdFlags.BoolVar(&opts.ApplyRemovedBlocks, "apply-removed-blocks", false, "remove the items of the removed blocks in the configuration")
+	cmdFlags.StringVar(&opts.ProviderRename, "provider-rename", "", "change the provider of resources from OLD to NEW, given as OLD=NEW")
+	cmdFlags.StringVar(&opts.ProviderMigrationReport, "provider-migration-report", "", "path to a file mapping providers to report on moving resources to")
+	cmdFlags.BoolVar(&opts.ScrubPrivate, "scrub-private", false, "clear the private data of the selected instances instead of removing them")
+	cmdFlags.Var((*FlagStringSlice)(&opts.OrphanKeys), "orphan-keys", "resource whose orphaned instances should be removed")
+	cmdFlags.BoolVar(&opts.GCOrphanData, "gc-orphan-data", false, "remove data resources not in configuration")
+	cmdFlags.Var((*FlagStringSlice)(&opts.ResourceTypes), "resource-type", "resource type whose instances should be removed")
+	cmdFlags.IntVar(&opts.KeepLatest, "keep-latest", 0, "number of the newest instances of each -resource-type to keep")
+	cmdFlags.StringVar(&opts.KeepLatestBy, "keep-latest-by", "", "path of the timestamp attribute for -keep-latest")
+	cmdFlags.StringVar(&opts.Mode, "mode", "all", "resource mode")
+	cmdFlags.StringVar(&opts.DecodeErrors, "decode-errors", "skip", "skip or fail on objects whose attributes can't be decoded")
+	cmdFlags.Var((*FlagStringSlice)(&opts.Where), "where", "only remove instances with the attribute value PATH=VALUE")
+	cmdFlags.Var((*FlagStringSlice)(&opts.WhereNot), "where-not", "don't remove instances with the attribute value PATH=VALUE")
+	cmdFlags.Var((*FlagStringSlice)(&opts.Exclude), "exclude", "address of an instance, resource or module to keep")
+	cmdFlags.StringVar(&opts.ScopeModule, "scope-module", "", "only remove instances within this module")
+	cmdFlags.StringVar(&opts.FromPlanJSON, "from-plan-json", "", "path")
+	cmdFlags.StringVar(&opts.RemovedBetween, "removed-between", "", "git revisions OLD..NEW")
+	cmdFlags.StringVar(&opts.ForeignLineage, "foreign-lineage", "", "expected lineage")
+	cmdFlags.StringVar(&opts.AssertLineage, "assert-lineage", "", "lineage that the state must have")
+	cmdFlags.BoolVar(&opts.LineageReport, "lineage-report", false, "print the lineage and other metadata of the state")
+	cmdFlags.BoolVar(&opts.IfNewerThanConfig, "if-newer-than-config", false, "refuse to remove instances created since the configuration changed")
+	cmdFlags.BoolVar(&opts.Force, "force", false, "skip safety checks")
+	cmdFlags.BoolVar(&opts.ContinueOnError, "continue-on-error", false, "skip instances that can't be removed")
+	cmdFlags.Var((*FlagStringSlice)(&opts.FailOn), "fail-on", "resource type that must not be removed")
+	cmdFlags.BoolVar(&opts.KeepIfReferenced, "keep-if-referenced", false, "don't remove instances that other instances depend on")
+	cmdFlags.BoolVar(&opts.DiffProviders, "diff-providers", false, "list the providers of the instances to remove")
+	cmdFlags.IntVar(&opts.MaxProviders, "max-providers", 0, "maximum number of distinct providers to remove instances of")
+	cmdFlags.IntVar(&opts.MaxRemovalPct, "max-removal-pct", 0, "maximum percentage of the instances in the state to remove")
+	cmdFlags.BoolVar(&opts.ValidateProviders, "validate-providers", false, "warn if the instances to remove use providers that aren't installed")
+	cmdFlags.BoolVar(&preserveOutputs, "preserve-outputs", true, "keep output values of removed modules")
+	cmdFlags.BoolVar(&opts.ModuleOutputCleanup, "module-output-cleanup", false, "remove output values that refer to removed instances")
+	cmdFlags.StringVar(&opts.SaveRemoved, "save-removed", "", "path")
+	cmdFlags.StringVar(&opts.ObjectHashManifest, "object-hash-manifest", "", "path")
+	cmdFlags.StringVar(&opts.EmitRemovedIDs, "emit-removed-ids", "", "path")
+	cmdFlags.StringVar(&opts.ExportBefore, "export-before", "", "command or URL to send the removed instances to")
+	cmdFlags.StringVar(&opts.PrePlanSnapshot, "pre-plan-snapshot", "", "path to save the state and a record of the removal to before removing")
+	cmdFlags.StringVar(&opts.AddressTransform, "address-transform", "", "sed-style substitution suggesting a new address for each removed instance")
+	cmdFlags.StringVar(&opts.Label, "label", "", "reason for the removal to record in its reports")
+	cmdFlags.StringVar(&opts.SnapshotDiffURL, "snapshot-diff-url", "", "webhook URL to notify of the removal")
+	cmdFlags.StringVar(&opts.Checkpoint, "checkpoint", "", "path")
+	cmdFlags.StringVar(&opts.EmitRemovedBlock, "emit-removed-block", "", "path")
+	cmdFlags.BoolVar(&opts.RetainSchemaVersion, "retain-schema-version", false, "keep schema versions in -save-removed")
+	cmdFlags.BoolVar(&opts.GroupByModule, "group-by-module", false, "group output by module")
+	cmdFlags.BoolVar(&opts.JSON, "json", false, "json")
+	cmdFlags.BoolVar(&opts.CSV, "csv", false, "csv")
+	cmdFlags.BoolVar(&opts.SummaryOnly, "summary-only", false, "print only the summary")
+	cmdFlags.BoolVar(&opts.BatchSizeReport, "batch-size-report", false, "print the projected size of the state after the removal")
+	cmdFlags.StringVar(&opts.MaxStateSize, "max-state-size", "", "fail if the state would be larger than this size after the removal")
+	cmdFlags.StringVar(&opts.Output, "output", "", "path")
+	cmdFlags.StringVar(&opts.EmitMetrics, "emit-metrics", "", "path")
+	cmdFlags.StringVar(&opts.Template, "template", "", "template for each removed instance")
+	cmdFlags.StringVar(&opts.TemplateFile, "template-file", "", "path")
 	cmdFlags.StringVar(&c.backupPath, "backup", "-", "backup")
+	cmdFlags.BoolVar(&opts.PrintBackupPath, "print-backup-path", false, "print the path of the backup")
+	cmdFlags.BoolVar(&opts.BackupToBackend, "backup-to-backend", false, "write the backup to the backend")
+	cmdFlags.IntVar(&opts.BackupRetention, "backup-retention", 0, "number of timestamped backups to keep")
+	cmdFlags.IntVar(&opts.Retry, "retry", 0, "number of times to retry persisting the state")
+	cmdFlags.IntVar(&opts.ChunkPersist, "chunk-persist", 0, "number of instances to remove before each persist")
+	cmdFlags.BoolVar(&opts.RespectOrder, "respect-depends-on-order", false, "remove dependents first, saving the state after each step")
+	cmdFlags.DurationVar(&opts.PersistInterval, "persist-interval", 0, "time between each persist of the state during the removal")
+	cmdFlags.BoolVar(&opts.VerifyAfter, "verify-after", false, "check that the removed instances are gone from the saved state")
+	cmdFlags.BoolVar(&opts.RollbackOnVerifyFailure, "rollback-on-verify-failure", false, "restore the state if -verify-after fails")
+	cmdFlags.BoolVar(&opts.ReinsertOnAbort, "reinsert-on-abort", false, "put the removed objects back if -verify-after fails")
+	cmdFlags.IntVar(&postRemovePlanGate, "post-remove-plan-gate", -1, "fail if a plan after the removal would destroy or replace more than this many instances")
+	cmdFlags.BoolVar(&opts.PostRemovePlanGateRollback, "post-remove-plan-gate-rollback", false, "restore the state if -post-remove-plan-gate fails")
+	cmdFlags.DurationVar(&opts.RetryInterval, "retry-interval", time.Second, "time to wait before the first retry")
 	cmdFlags.StringVar(&c.statePath, "state", "", "path")
+	cmdFlags.BoolVar(&opts.Trace, "trace", false, "report the duration of each phase")
+	cmdFlags.BoolVar(&opts.NormalizeOnly, "normalize-only", false, "rewrite the state without removing anything")
+	cmdFlags.BoolVar(&opts.AllowMissingState, "allow-missing-state", false, "succeed if there is no state")
+	cmdFlags.BoolVar(&opts.ExpandForEach, "expand-for-each", false, "remove all instances of a resource given without an instance key")
+	cmdFlags.BoolVar(&opts.MatchCount, "match-count", false, "print the number of instances each selector matches")
+	cmdFlags.BoolVar(&opts.PartitionByWorkspace, "partition-by-workspace", false, "report which workspaces each address is in")
+	cmdFlags.StringVar(&opts.WorkspacePattern, "workspace-pattern", "", "remove from each workspace whose name matches this glob")
+	cmdFlags.BoolVar(&opts.CoalesceInstances, "coalesce-instances", false, "find duplicate deposed objects")
+	cmdFlags.BoolVar(&opts.Fix, "fix", false, "remove the duplicates found by -coalesce-instances or -dedupe-across-modules")
+	cmdFlags.BoolVar(&opts.DedupeAcrossModules, "dedupe-across-modules", false, "find resource instances in different modules with the same id")
+	cmdFlags.StringVar(&opts.KeepModule, "keep-module", "", "module whose instance to keep with -dedupe-across-modules")
+	cmdFlags.BoolVar(&opts.DedupeDeposed, "dedupe-deposed", false, "remove byte-identical duplicate deposed objects")
+	cmdFlags.BoolVar(&opts.UndoLast, "undo-last", false, "restore the most recent backup")
+	cmdFlags.BoolVar(&opts.AutoApprove, "auto-approve", false, "skip confirmation")
+	cmdFlags.BoolVar(&opts.ConfirmShowDiff, "confirm-show-diff", false, "show the instances to remove and ask for confirmation")
+	cmdFlags.BoolVar(&opts.SchemaUpgradeFirst, "schema-upgrade-first", false, "upgrade the selected instances to their current schemas before checking them")
 	if err := cmdFlags.Parse(args); err != nil {
 		return cli.RunResultHelp
 	}
-	args = cmdFlags.Args()
+	opts.StatePath = c.statePath
+	opts.BackupPath = c.backupPath
+	opts.RemoveOutputs = !preserveOutputs
+	if assertCount != -1 {
+		opts.AssertCount = &assertCount
+	}
+	if postRemovePlanGate != -1 {
+		opts.PostRemovePlanGate = &postRemovePlanGate
+	}
+	if addressesFrom != "" {
+		if opts.FromFile != "" {
+			c.Ui.Error("The -addresses-from option is another name for -from-file, so only one of them can be given.\n")
+			return cli.RunResultHelp
+		}
+		opts.FromFile = addressesFrom
+	}
+
+	tracer := newStateTrace(c.Ui, opts.Trace)
+	defer tracer.Done()
+
+	// The metrics are written however the command ends, so that failures
+	// are recorded too.
+	var metrics *stateRmMetrics
+	if opts.EmitMetrics != "" {
+		metrics = &stateRmMetrics{
+			Start:  time.Now(),
+			DryRun: opts.DryRun || opts.EmitRemovedBlock != "",
+			Label:  opts.Label,
+		}
+		defer func() {
+			c.writeStateRmMetrics(opts.EmitMetrics, metrics, code)
+		}()
+	}
+
+	var diags tfdiags.Diagnostics
+
+	if opts.FromFile == "-" && opts.StdinConfirmToken {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -from-file=- option reads the addresses from stdin, so it cannot be used with -stdin-confirm-token, which reads the approval token from stdin too. Write the addresses to a file instead.",
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	// Addresses can be given as arguments, with -address, and in the file
+	// given by -from-file, and all of them are used together.
+	opts.Selectors = append(cmdFlags.Args(), addressFlags...)
+	if opts.FromFile != "" {
+		fileAddrs, err := readAddressFile(opts.FromFile, opts.AddressFileFormat)
+		if err != nil {
+			from := opts.FromFile
+			if from == "-" {
+				from = "stdin"
+			}
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to read addresses",
+				fmt.Sprintf("Could not read resource addresses from %s: %s.", from, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		opts.Selectors = append(opts.Selectors, fileAddrs...)
+	}
+	if opts.ByResourceFile != "" {
+		froms, moreDiags := c.readRemovedBlocksFile(opts.ByResourceFile)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		for _, from := range froms {
+			opts.RemovedFroms = append(opts.RemovedFroms, stateRmRemovedFrom{Path: opts.ByResourceFile, From: from})
+		}
+		opts.Selectors = append(opts.Selectors, froms...)
+	}
+
+	// The source of each merge is removed like any other address, once we
+	// have checked that it duplicates the destination.
+	merges, moreDiags := c.parseStateRmMerges(mergeRaw)
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	opts.Merges = merges
+	for _, merge := range merges {
+		opts.Selectors = append(opts.Selectors, merge.Source.String())
+	}
+
+	// The removed blocks in the configuration are only added to the
+	// selectors once we have the state, since those already applied are
+	// skipped.
+	if opts.ApplyRemovedBlocks {
+		var moreDiags tfdiags.Diagnostics
+		opts.ConfigFroms, moreDiags = c.readConfigRemovedBlocks(".")
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	// The extension of the -output file chooses its format.
+	switch {
+	case strings.EqualFold(filepath.Ext(opts.Output), ".json"):
+		opts.JSON = true
+	case strings.EqualFold(filepath.Ext(opts.Output), ".csv"):
+		opts.CSV = true
+	}
+
+	moreDiags = opts.validate()
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	if opts.PartitionByWorkspace {
+		return c.partitionByWorkspace(opts.Selectors)
+	}
+	if opts.WorkspacePattern != "" {
+		return c.removeFromWorkspaces(opts.WorkspacePattern, opts.Selectors, opts.DryRun, opts.AutoApprove)
+	}
+
+	var renameFrom, renameTo addrs.AbsProviderConfig
+	if opts.ProviderRename != "" {
+		// The option was already validated by validate.
+		renameFrom, renameTo, _ = parseProviderRename(opts.ProviderRename)
+	}
+	var keepModule addrs.ModuleInstance
+	if opts.KeepModule != "" {
+		keepModule, _ = parseModuleInstanceArg(opts.KeepModule)
+	}
+
+	var migrationMappings []*stateRmProviderMapping
+	if opts.ProviderMigrationReport != "" {
+		var err error
+		migrationMappings, err = readProviderMappingFile(opts.ProviderMigrationReport)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -provider-migration-report option",
+				fmt.Sprintf("Could not read the provider mappings from %s: %s.", opts.ProviderMigrationReport, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	if opts.TemplateFile != "" {
+		src, err := ioutil.ReadFile(opts.TemplateFile)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to read output template",
+				fmt.Sprintf("Could not read the template file %s: %s.", opts.TemplateFile, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		opts.Template = string(src)
+	}
+	outputTemplate := opts.Template != "" || opts.TemplateFile != ""
+
+	// The output file is opened before the state is read, so that a dry
+	// run that can't be saved fails first.
+	var outputFile *os.File
+	if opts.Output != "" {
+		f, err := os.Create(opts.Output)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to create output file",
+				fmt.Sprintf("Could not create %s for the -output option: %s.", opts.Output, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		defer f.Close()
+		outputFile = f
+	}
+
+	tracer.Phase("load")
+	stateMgr, err := c.State()
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to load state",
+			fmt.Sprintf(errStateLoadingState, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	// Removing in chunks means persisting several times, so we hold the
+	// lock throughout to make sure that nothing else writes the state in
+	// between, and lock before reading so that we remove from the latest
+	// snapshot.
+	chunked := opts.chunked()
+	if chunked && !opts.DryRun && opts.EmitRemovedBlock == "" {
+		stateLocker := clistate.NewLocker(context.Background(), 0, c.Ui, c.Colorize())
+		if err := stateLocker.Lock(stateMgr, "state rm"); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to lock state",
+				fmt.Sprintf("Removing in chunks requires a lock on the state for the whole removal: %s.", err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		defer stateLocker.Unlock(nil)
+	}
+
+	tracer.Phase("refresh")
+	if err := stateMgr.RefreshState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to load state",
+			fmt.Sprintf(errStateLoadingState, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	state := stateMgr.State()
+	if state == nil && opts.AllowMissingState {
+		// A state that doesn't exist yet has nothing in it to remove, so
+		// this isn't an error for scripts that run unconditionally.
+		c.Ui.Output("No state found, so there is nothing to remove.")
+		return 0
+	}
+	if state == nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No state found",
+			errStateNotFound,
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	// This comes before anything else that could change the state, so that
+	// a script pinned to one state can't modify some other one.
+	if opts.AssertLineage != "" {
+		moreDiags := stateRmAssertLineage(stateMgr, opts.AssertLineage)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	if opts.LineageReport {
+		return c.lineageReport(stateMgr, state, opts.AssertLineage)
+	}
+
+	if opts.UndoLast {
+		return c.undoLast(stateMgr, state, opts.DryRun, opts.AutoApprove)
+	}
+
+	if opts.ForeignLineage != "" {
+		lineageDiags := stateRmCheckLineage(stateMgr, opts.ForeignLineage)
+		if lineageDiags.HasErrors() {
+			diags = diags.Append(lineageDiags)
+			c.showDiagnostics(diags)
+			return 1
+		}
+		c.showDiagnostics(lineageDiags)
+
+		// With nothing else selected, there's nothing more to do since
+		// the lineage check can't select any objects itself.
+		if !opts.selected() && !opts.NormalizeOnly && !opts.CoalesceInstances && !opts.DedupeDeposed && !opts.DedupeAcrossModules && opts.ProviderRename == "" {
+			return 0
+		}
+	}
+
+	if opts.CoalesceInstances {
+		tracer.Phase("mutate")
+		return c.coalesceInstances(stateMgr, state, opts.Fix && !opts.DryRun, opts.AutoApprove)
+	}
+
+	if opts.DedupeDeposed {
+		tracer.Phase("mutate")
+		return c.dedupeDeposed(stateMgr, state, opts.DryRun, opts.AutoApprove)
+	}
+
+	if opts.DedupeAcrossModules {
+		tracer.Phase("mutate")
+		return c.dedupeAcrossModules(stateMgr, state, keepModule, opts.Fix && !opts.DryRun, opts.AutoApprove)
+	}
+
+	if opts.NormalizeOnly {
+		tracer.Phase("write")
+		return c.normalizeState(stateMgr, state, opts.DryRun, opts.AutoApprove, opts.BackupToBackend)
+	}
+
+	// Every state manager returns a copy of its state from State, so the
+	// removal can't reach the manager's own snapshot until the state is
+	// written, and nothing is saved partly changed if anything fails first.
+	// The state as it was before the removal is kept for as long as it
+	// might need to be restored, since a remote backend may not have
+	// written a local backup to restore it from, and for the backups made
+	// once the removal is confirmed.
+	var before *states.State
+	if (opts.RollbackOnVerifyFailure || opts.ReinsertOnAbort || opts.PostRemovePlanGateRollback || opts.BackupToBackend || opts.PrePlanSnapshot != "") && !opts.DryRun && opts.EmitRemovedBlock == "" {
+		before = state.DeepCopy()
+	}
+
+	opts.Env = &stateRmCommandEnv{StateRmCommand: c, stateMgr: stateMgr}
+	opts.Tracer = tracer
+	tracer.Phase("filter")
+	result, moreDiags := runStateRm(state, &opts)
+	diags = diags.Append(moreDiags)
+	if metrics != nil {
+		metrics.Result = result
+	}
+	if result.NothingSelected {
+		if opts.JSON && !opts.SummaryOnly {
+			// A script parsing the output still gets an array to iterate.
+			c.Ui.Output("[]")
+		}
+		c.showDiagnostics(diags)
+		return stateRmEmptyExitStatus
+	}
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	c.showDiagnostics(diags)
+	diags = nil
+	if result.Cancelled {
+		c.Ui.Output("Removal cancelled.")
+		return 0
+	}
+	sel := result.Selection
+
+	switch {
+	case opts.ProviderMigrationReport != "":
+		tracer.Done()
+		return c.providerMigrationReport(state, sel.Addrs, migrationMappings, opts.JSON)
+	case opts.ProviderRename != "":
+		tracer.Phase("mutate")
+		return c.renameProvider(stateMgr, state, sel.Addrs, renameFrom, renameTo, opts.DryRun, opts.AutoApprove)
+	case opts.ScrubPrivate:
+		tracer.Phase("mutate")
+		return c.scrubPrivate(stateMgr, state, sel.Addrs, opts.DryRun, opts.AutoApprove)
+	case opts.MatchCount:
+		tracer.Done()
+		c.Ui.Output(result.Report)
+		return 0
+	}
+	tracer.Done()
+
+	if opts.BackupToBackend && !opts.DryRun && opts.EmitRemovedBlock == "" {
+		tracer.Phase("backup")
+		name, backupDiags := c.backupToBackend(before, "staterm")
+		tracer.Done()
+		if backupDiags.HasErrors() {
+			c.showDiagnostics(backupDiags)
+			return 1
+		}
+		c.showDiagnostics(backupDiags)
+		if name != "" && !opts.JSON && !opts.CSV && !outputTemplate {
+			c.Ui.Output(fmt.Sprintf("Backed up the state to the workspace %q.", name))
+		}
+	}
+
+	// The snapshot must be of the state exactly as it was read.
+	if opts.PrePlanSnapshot != "" && !opts.DryRun && opts.EmitRemovedBlock == "" {
+		tracer.Phase("snapshot")
+		err := writeStateRmPrePlanSnapshot(opts.PrePlanSnapshot, stateMgr, before, givenArgs, sel.Addrs, opts.Label)
+		tracer.Done()
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to write pre-plan snapshot",
+				fmt.Sprintf("Could not write the state and the record of the removal to %s and %s: %s. The state has not been changed.", opts.PrePlanSnapshot, stateRmSnapshotMetaPath(opts.PrePlanSnapshot), err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	// Any instances skipped by -continue-on-error are reported once
+	// everything else is done, and make the command fail so that a script
+	// notices that the removal was incomplete.
+	var skippedDiags tfdiags.Diagnostics
+	if len(result.Skipped) > 0 {
+		verb := "were"
+		if opts.DryRun {
+			verb = "would be"
+		}
+		skippedDiags = skippedDiags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Some resource instances were skipped",
+			fmt.Sprintf("The following %d resource instances could not be removed, and so %s skipped by -continue-on-error:\n\n%s\n\nThe other selected resource instances %s removed.", len(result.Skipped), verb, strings.Join(stateRmSkippedLines(result.Skipped), "\n"), verb),
+		))
+	}
+
+	// With -emit-removed-block the removal is written out as configuration
+	// instead of being made to the state, so that it can be checked in and
+	// reviewed like any other change.
+	if opts.EmitRemovedBlock != "" {
+		if err := ioutil.WriteFile(opts.EmitRemovedBlock, stateRmRemovedBlocks(result.Items), 0644); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to write removed blocks",
+				fmt.Sprintf("Could not write removed blocks to %s: %s.", opts.EmitRemovedBlock, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !opts.DryRun {
+			switch {
+			case opts.JSON:
+				if code := c.outputStateRmJSON(result, true, opts.SummaryOnly, opts.GroupByModule, opts.Mode, sel.Modules, result.Shape); code != 0 {
+					return code
+				}
+			case opts.CSV:
+				if code := c.outputStateRmCSV(result, true, opts.skipDecodeErrors()); code != 0 {
+					return code
+				}
+			default:
+				c.Ui.Output(fmt.Sprintf("Wrote %d removed blocks to %s. The state has not been changed.", len(result.Items), opts.EmitRemovedBlock))
+			}
+			if skippedDiags.HasErrors() {
+				c.showDiagnostics(skippedDiags)
+				return 1
+			}
+			return 0
+		}
+	}
+
+	// The ids are written before the state is saved, and in dry-run mode
+	// too, so that the file always lists the objects that the removal
+	// leaves unmanaged.
+	if opts.EmitRemovedIDs != "" {
+		ids, idDiags := stateRmRemovedIDs(result, opts.skipDecodeErrors())
+		if idDiags.HasErrors() {
+			diags = diags.Append(idDiags)
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if err := writeStateRmRemovedIDs(opts.EmitRemovedIDs, ids); err != nil {
+			diags = diags.Append(idDiags)
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to write removed ids",
+				fmt.Sprintf("Could not write the ids of the removed objects to %s: %s. The state has not been changed.", opts.EmitRemovedIDs, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		c.showDiagnostics(idDiags)
+	}
+
+	if opts.DryRun {
+		// With -output, everything that would be printed from here on is
+		// written to the file instead. Diagnostics still go to the UI.
+		if outputFile != nil {
+			realUi := c.Ui
+			c.Ui = &stateRmOutputFileUi{Ui: realUi, w: outputFile}
+			defer func() { c.Ui = realUi }()
+		}
+
+		// With -dry-run-exit-code, a dry run that finds something to remove
+		// exits with status 2 so that automation can detect that a removal
+		// is pending.
+		dryRunStatus := 0
+		if opts.DryRunExitCode && len(result.Items) > 0 {
+			dryRunStatus = 2
+		}
+		if skippedDiags.HasErrors() {
+			c.showDiagnostics(skippedDiags)
+			dryRunStatus = 1
+		}
+
+		if opts.ApprovalToken {
+			// The token alone is printed so that it can be redirected
+			// straight into the file given to -confirm-file.
+			c.Ui.Output(stateRmApprovalToken(result.Addrs()))
+			return dryRunStatus
+		}
+
+		if opts.JSON {
+			if code := c.outputStateRmJSON(result, opts.DryRun, opts.SummaryOnly, opts.GroupByModule, opts.Mode, sel.Modules, result.Shape); code != 0 {
+				return code
+			}
+			return dryRunStatus
+		}
+
+		if opts.CSV {
+			if code := c.outputStateRmCSV(result, opts.DryRun, opts.skipDecodeErrors()); code != 0 {
+				return code
+			}
+			return dryRunStatus
+		}
+
+		if outputTemplate {
+			c.Ui.Output(result.TemplateOutput)
+			return dryRunStatus
+		}
+
+		c.Ui.Output(result.Report)
+
+		if opts.PreviewDestroyCount {
+			tracer.Phase("plan")
+			destroyed, planDiags := c.countApplyDestroys(state, result)
+			tracer.Done()
+			if planDiags.HasErrors() {
+				c.showDiagnostics(planDiags)
+				return 1
+			}
+			c.showDiagnostics(planDiags)
+			c.Ui.Output(fmt.Sprintf("\nIf they were kept in the state, terraform apply would destroy %d of the %d selected resource instances.", destroyed, len(result.Items)))
+		}
+
+		if opts.SimulatePlan {
+			tracer.Phase("plan")
+			plan, planDiags := c.simulatePlan(state, result)
+			tracer.Done()
+			if planDiags.HasErrors() {
+				c.showDiagnostics(planDiags)
+				return 1
+			}
+			c.showDiagnostics(planDiags)
+
+			var planBuf bytes.Buffer
+			writeStateRmPlanSummary(&planBuf, plan, result)
+			c.Ui.Output(planBuf.String())
+		}
+		if opts.PlanFileOut != "" {
+			tracer.Phase("plan")
+			planDiags := c.writePlanFile(stateMgr, stateRmStateAfter(state, result), opts.PlanFileOut)
+			tracer.Done()
+			if planDiags.HasErrors() {
+				c.showDiagnostics(planDiags)
+				return 1
+			}
+			c.showDiagnostics(planDiags)
+			c.Ui.Output(fmt.Sprintf("\nSaved a plan against the state as the removal would leave it to %s. Applying it would also make the removal.", opts.PlanFileOut))
+		}
+		if opts.BatchSizeReport || opts.MaxStateSize != "" {
+			c.Ui.Output("\n" + result.Sizes.String())
+		}
+		if opts.StdinConfirmToken {
+			c.Ui.Output(fmt.Sprintf("\nTo approve exactly this removal, pass this token on stdin with -stdin-confirm-token:\n%s", stateRmApprovalToken(result.Addrs())))
+		}
+		if result.TooLarge.HasErrors() {
+			c.showDiagnostics(result.TooLarge)
+			return 1
+		}
+		return dryRunStatus // This is as far as we go in dry-run mode
+	}
+
+	// The removed objects are saved before we write the new state, so that
+	// if we can't save them then nothing has been lost.
+	if opts.SaveRemoved != "" {
+		removed := statemgr.NewStateFile()
+		removed.State = stateRmRemovedState(result, opts.RetainSchemaVersion)
+		if err := writeStateFile(opts.SaveRemoved, removed); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to save removed instances",
+				fmt.Sprintf("Could not write the removed resource instances to %s: %s. The state has not been changed.", opts.SaveRemoved, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	// Likewise, the hashes are written before the state so that there's a
+	// record of every object that is removed.
+	if opts.ObjectHashManifest != "" {
+		hashes, err := stateRmObjectHashes(result)
+		if err == nil {
+			err = writeStateRmObjectHashes(opts.ObjectHashManifest, opts.Label, hashes)
+		}
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to write object hash manifest",
+				fmt.Sprintf("Could not write the hashes of the removed objects to %s: %s. The state has not been changed.", opts.ObjectHashManifest, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	// The export comes after -save-removed so that, if it fails, the
+	// removed objects are still saved locally even though the state hasn't
+	// been changed.
+	if opts.ExportBefore != "" && len(result.Items) > 0 {
+		tracer.Phase("export")
+		exportDiags := c.exportBefore(opts.ExportBefore, result)
+		tracer.Done()
+		diags = diags.Append(exportDiags)
+		if exportDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	if !opts.JSON && !opts.CSV && !outputTemplate {
+		if sel.Resumed > 0 {
+			c.Ui.Output(fmt.Sprintf("Resuming from %s, where %d of the selected resource instances were already removed.", opts.Checkpoint, sel.Resumed))
+		}
+		if opts.GroupByModule {
+			var buf bytes.Buffer
+			writeStateRmGroups(&buf, result, "Removed")
+			c.Ui.Output(buf.String())
+		}
+	}
+
+	if opts.BatchSizeReport {
+		c.Ui.Output(result.Sizes.String())
+	}
+
+	// The count of the kinds of things removed is printed once everything
+	// else is done.
+	var kindSummary bytes.Buffer
+	writeStateRmKindSummary(&kindSummary, result.Shape, sel.Modules, result)
+
+	retryOpts := &stateRmRetryOpts{
+		Retries:  opts.Retry,
+		Interval: opts.RetryInterval,
+	}
+	if chunked {
+		if opts.Checkpoint != "" && sel.Checkpoint == nil {
+			sel.Checkpoint = make(map[string]bool)
+		}
+		quiet := opts.JSON || opts.CSV || outputTemplate || opts.SummaryOnly
+		if opts.RespectOrder && !quiet {
+			var buf bytes.Buffer
+			writeStateRmOrder(&buf, result.OrderLayers)
+			c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+		}
+		tracer.Phase("persist")
+		moreDiags := c.persistStateRmChunks(stateMgr, state, result, &stateRmChunkOpts{
+			Size:           opts.ChunkPersist,
+			Chunks:         result.OrderLayers,
+			Interval:       opts.PersistInterval,
+			Retry:          retryOpts,
+			Checkpoint:     sel.Checkpoint,
+			CheckpointPath: opts.Checkpoint,
+			Quiet:          quiet,
+		})
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		c.showDiagnostics(moreDiags)
+	} else {
+		tracer.Phase("write")
+		if err := stateMgr.WriteState(state); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to write state",
+				fmt.Sprintf(errStateRmPersist, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+
+		tracer.Phase("persist")
+		attempts, err := persistStateWithRetry(stateMgr, retryOpts)
+		if err != nil {
+			if attempts > 1 {
+				err = fmt.Errorf("%s (after %d attempts)", err, attempts)
+			}
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to persist state",
+				fmt.Sprintf(errStateRmPersist, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if attempts > 1 && !opts.JSON && !opts.CSV && !outputTemplate {
+			c.Ui.Output(fmt.Sprintf("Persisted the state after %d attempts.", attempts))
+		}
+	}
+	tracer.Done()
+	if metrics != nil {
+		metrics.BackupPath = c.stateBackupPath
+	}
+
+	if opts.VerifyAfter {
+		tracer.Phase("verify")
+		moreDiags := c.verifyRemoved(result)
+		tracer.Done()
+		if moreDiags.HasErrors() {
+			diags = diags.Append(moreDiags)
+			if opts.RollbackOnVerifyFailure {
+				tracer.Phase("rollback")
+				diags = diags.Append(c.rollbackRemoval(stateMgr, before, "-rollback-on-verify-failure"))
+				tracer.Done()
+			} else if opts.ReinsertOnAbort {
+				tracer.Phase("reinsert")
+				diags = diags.Append(c.reinsertRemoved(stateMgr, state, before, result))
+				tracer.Done()
+			}
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !opts.JSON && !opts.CSV && !outputTemplate {
+			c.Ui.Output("Verified that the removed items are no longer in the saved state.")
+		}
+	}
+
+	// Without -post-remove-plan-gate-rollback, a failed gate leaves the
+	// removal in place, so that it can be inspected or finished by hand.
+	if opts.PostRemovePlanGate != nil {
+		tracer.Phase("plan")
+		moreDiags := c.checkPostRemovePlan(state, *opts.PostRemovePlanGate)
+		tracer.Done()
+		if moreDiags.HasErrors() {
+			diags = diags.Append(moreDiags)
+			if opts.PostRemovePlanGateRollback {
+				tracer.Phase("rollback")
+				diags = diags.Append(c.rollbackRemoval(stateMgr, before, "-post-remove-plan-gate-rollback"))
+				tracer.Done()
+			} else {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Warning,
+					"Removal not rolled back",
+					"The removal has already been saved, and is kept. Use -post-remove-plan-gate-rollback to restore the state when the gate fails.",
+				))
+			}
+			c.showDiagnostics(diags)
+			return 1
+		}
+		c.showDiagnostics(moreDiags)
+	}
+
+	// The plan is made once the state has been saved, so that the plan file
+	// records the new serial and can be applied until the state changes
+	// again. The removal itself has already succeeded by now.
+	if opts.PlanFileOut != "" {
+		tracer.Phase("plan")
+		planDiags := c.writePlanFile(stateMgr, stateRmStateAfter(state, result), opts.PlanFileOut)
+		tracer.Done()
+		diags = diags.Append(planDiags)
+		if planDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		c.showDiagnostics(planDiags)
+		if !opts.JSON && !opts.CSV && !outputTemplate {
+			c.Ui.Output(fmt.Sprintf("Saved a plan against the updated state to %s. Review it with \"terraform show %s\".", opts.PlanFileOut, opts.PlanFileOut))
+		}
+	}
+
+	// The checkpoint records what has been persisted, and is only needed
+	// until the whole removal has completed. When removing in chunks it
+	// was already updated after each chunk.
+	if opts.Checkpoint != "" && !chunked {
+		if sel.Checkpoint == nil {
+			sel.Checkpoint = make(map[string]bool)
+		}
+		for _, item := range result.Items {
+			sel.Checkpoint[item.Addr.String()] = true
+		}
+		if err := writeStateRmCheckpoint(opts.Checkpoint, sel.Checkpoint); err != nil {
+			c.showDiagnostics(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Failed to write checkpoint",
+				fmt.Sprintf("Could not record the removed resource instances in the checkpoint file %s: %s. The state was saved.", opts.Checkpoint, err),
+			))
+		}
+	}
+
+	if opts.SnapshotDiffURL != "" && (len(result.Items) > 0 || len(result.Outputs) > 0) {
+		tracer.Phase("notify")
+		c.notifySnapshotDiff(opts.SnapshotDiffURL, stateMgr, result)
+		tracer.Done()
+	}
+
+	if opts.BackupRetention > 0 {
+		c.pruneBackups(stateMgr, opts.BackupRetention, opts.JSON || opts.CSV || outputTemplate)
+	}
+
+	// The backup path is only looked up once the state has been saved,
+	// since that's when the local state manager writes the backup.
+	var backupPath string
+	if opts.PrintBackupPath {
+		backupPath = c.writtenBackupPath()
+		if backupPath == "" {
+			c.showDiagnostics(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"No backup path to print",
+				"The state was saved, but no local backup was written for -print-backup-path to print, as is the case for remote backends.",
+			))
+		}
+	}
+
+	switch {
+	case opts.JSON:
+		if code := c.outputStateRmJSON(result, opts.DryRun, opts.SummaryOnly, opts.GroupByModule, opts.Mode, sel.Modules, result.Shape); code != 0 {
+			return code
+		}
+	case opts.CSV:
+		if code := c.outputStateRmCSV(result, opts.DryRun, opts.skipDecodeErrors()); code != 0 {
+			return code
+		}
+	case outputTemplate:
+		c.Ui.Output(result.TemplateOutput)
+	case opts.SummaryOnly:
+		var buf bytes.Buffer
+		if len(result.Items) > 0 {
+			writeStateRmTypeSummary(&buf, result)
+			writeStateRmModuleSummary(&buf, result)
+		}
+		buf.WriteString(kindSummary.String())
+		if path := c.writtenBackupPath(); path != "" {
+			fmt.Fprintf(&buf, "The original state was backed up to %s.\n", path)
+		}
+		buf.WriteString("Updated state written successfully.")
+		c.Ui.Output(buf.String())
+	default:
+		c.Ui.Output(kindSummary.String() + "Updated state written successfully.")
+	}
+
+	if skippedDiags.HasErrors() {
+		// The checkpoint is kept, so that a re-run after fixing the
+		// skipped instances resumes with only those.
+		c.showDiagnostics(skippedDiags)
+		return 1
+	}
+	if opts.Checkpoint != "" {
+		if err := stateRmRemoveCheckpoint(opts.Checkpoint); err != nil && !os.IsNotExist(err) {
+			c.showDiagnostics(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Failed to remove checkpoint",
+				fmt.Sprintf("The removal completed, but the checkpoint file %s could not be removed: %s.", opts.Checkpoint, err),
+			))
+		}
+	}
+
+	// The path alone is printed as the last line, so that a script can
+	// take it from the end of the output.
+	if backupPath != "" && !opts.JSON {
+		c.Ui.Output(backupPath)
+	}
+	return 0
+}
+
+// verifyRemoved reads the state back from where it was saved, using a new
+// state manager so that nothing cached by the one that saved it is used,
+// and checks that none of the resource instances and output values in the
+// given result are in it, for the -verify-after option.
+func (c *StateRmCommand) verifyRemoved(result *stateRmResult) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	var mgr statemgr.Full
+	if c.statePath != "" {
+		mgr = statemgr.NewFilesystem(c.statePath)
+	} else {
+		b, backendDiags := c.Backend(nil)
+		diags = diags.Append(backendDiags)
+		if backendDiags.HasErrors() {
+			return diags
+		}
+		var err error
+		mgr, err = b.StateMgr(c.Workspace())
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to verify the removal",
+				fmt.Sprintf("The state was saved, but could not be read back to check it: %s.", err),
+			))
+			return diags
+		}
+	}
+	if err := mgr.RefreshState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to verify the removal",
+			fmt.Sprintf("The state was saved, but could not be read back to check it: %s.", err),
+		))
+		return diags
+	}
+
+	saved := mgr.State()
+	var remaining []string
+	for _, item := range result.Items {
+		if saved != nil && saved.ResourceInstance(item.Addr) != nil {
+			remaining = append(remaining, "  "+item.Addr.String())
+		}
+	}
+	for _, addr := range result.Outputs {
+		if saved != nil && saved.OutputValue(addr) != nil {
+			remaining = append(remaining, "  "+addr.String())
+		}
+	}
+	if len(remaining) > 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Removed items still in state",
+			fmt.Sprintf("The state was saved, but when it was read back the following %d removed items were still in it:\n\n%s\n\nThe backend may not have saved the change, or may be returning an older snapshot. Check the state before running this command again.", len(remaining), strings.Join(remaining, "\n")),
+		))
+	}
+	return diags
+}
+
+// pruneBackups deletes the older timestamped backups of the state written by
+// the given state manager, keeping only the most recent keep of them, for the
+// -backup-retention option. The state has already been saved by this point,
+// so any problem is only reported as a warning.
+func (c *StateRmCommand) pruneBackups(stateMgr statemgr.Full, keep int, quiet bool) {
+	metaMgr, ok := stateMgr.(statemgr.PersistentMeta)
+	if !ok {
+		c.showDiagnostics(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Backups not pruned",
+			"The current backend doesn't report the lineage of its state snapshots, so -backup-retention can't tell which backups belong to this state. No backups have been deleted.",
+		))
+		return
+	}
+
+	deleted, err := pruneStateBackups(c.stateOutPath, metaMgr.StateSnapshotMeta().Lineage, keep)
+	if err != nil {
+		c.showDiagnostics(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Failed to prune backups",
+			fmt.Sprintf("Could not delete old backups of the state: %s. Deleted %d backups before the error.", err, len(deleted)),
+		))
+		return
+	}
+	if len(deleted) > 0 && !quiet {
+		c.Ui.Output(fmt.Sprintf("Deleted %d old backups, keeping the most recent %d.", len(deleted), keep))
+	}
+}
+
+// outputStateRmJSON prints the JSON representation of the given result, or
+// only its summary with summaryOnly, returning the exit status for the
+// command. The given modules are those removed as a whole, and the shape is
+// that of the state before the removal. With groupByModule, the result is
+// grouped by module instead.
+func (c *StateRmCommand) outputStateRmJSON(result *stateRmResult, dryRun, summaryOnly, groupByModule bool, mode string, modules []addrs.ModuleInstance, shape *stateRmShape) int {
+	var src []byte
+	var err error
+	if summaryOnly {
+		src, err = marshalStateRmSummaryJSON(result, dryRun, mode, c.writtenBackupPath())
+	} else {
+		src, err = marshalStateRmJSON(result, modules, shape, groupByModule)
+	}
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to marshal result to JSON: %s", err))
+		return 1
+	}
+	c.Ui.Output(string(src))
+	return 0
+}
+
+// outputStateRmCSV prints the CSV representation of the given result,
+// returning the exit status for the command. With skipDecodeErrors, an
+// instance whose id can't be decoded has an empty id instead of failing.
+func (c *StateRmCommand) outputStateRmCSV(result *stateRmResult, dryRun, skipDecodeErrors bool) int {
+	src, err := marshalStateRmCSV(result, dryRun, skipDecodeErrors)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to write result as CSV: %s", err))
+		return 1
+	}
+	c.Ui.Output(strings.TrimSuffix(string(src), "\n"))
+	return 0
+}
+
+// writtenBackupPath returns the path of the local backup of the state, if
+// one was written, or an empty string otherwise, such as for a remote backend
+// that doesn't write local backups.
+func (c *StateRmCommand) writtenBackupPath() string {
+	if c.stateBackupPath == "" {
+		return ""
+	}
+	if _, err := os.Stat(c.stateBackupPath); err != nil {
+		return ""
+	}
+	return c.stateBackupPath
+}
+
+// simulatePlan creates a plan for the configuration in the current working
+// directory against a copy of the given state with the objects described by
+// the given dry-run result removed, for the -simulate-plan option. The given
+// state is not modified.
+func (c *StateRmCommand) simulatePlan(state *states.State, result *stateRmResult) (*plans.Plan, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		return nil, diags
+	}
+
+	plan, planDiags := c.planAgainstState(config, stateRmStateAfter(state, result))
+	diags = diags.Append(planDiags)
+	return plan, diags
+}
+
+// stateRmStateAfter returns a copy of the given state with the objects
+// described by the given result removed, which for a dry-run result is the
+// state as the removal would leave it. The given state is not modified.
+func stateRmStateAfter(state *states.State, result *stateRmResult) *states.State {
+	after := state.DeepCopy()
+	ss := after.SyncWrapper()
+	for _, item := range result.Items {
+		ss.ForgetResourceInstanceAll(item.Addr)
+	}
+	for _, addr := range result.Outputs {
+		ss.RemoveOutputValue(addr)
+	}
+	return after
+}
+
+// countApplyDestroys creates a plan for the configuration in the current
+// working directory against the given state, for the
+// -preview-apply-destroy-count option, and returns how many of the resource
+// instances in the given dry-run result it would destroy, including those it
+// would replace. Removing such an instance from the state instead leaves a
+// real object behind that the configuration no longer wants.
+func (c *StateRmCommand) countApplyDestroys(state *states.State, result *stateRmResult) (int, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		return 0, diags
+	}
+	plan, planDiags := c.planAgainstState(config, state)
+	diags = diags.Append(planDiags)
+	if planDiags.HasErrors() {
+		return 0, diags
+	}
+
+	selected := make(map[string]bool, len(result.Items))
+	for _, item := range result.Items {
+		selected[item.Addr.String()] = true
+	}
+	destroyed := make(map[string]bool)
+	for _, rc := range plan.Changes.Resources {
+		switch rc.Action {
+		case plans.Delete, plans.DeleteThenCreate, plans.CreateThenDelete:
+			if addr := rc.Addr.String(); selected[addr] {
+				destroyed[addr] = true
+			}
+		}
+	}
+	return len(destroyed), diags
+}
+
+// checkPreventDestroy loads the configuration in the current working
+// directory and returns an error listing each of the given instances whose
+// resource sets prevent_destroy, for the -respect-prevent-destroy option.
+// Removing such an instance from the state doesn't destroy it, but a later
+// apply would no longer know about it, which sidesteps the protection all
+// the same.
+func (c *StateRmCommand) checkPreventDestroy(instances []addrs.AbsResourceInstance) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		return diags
+	}
+
+	protected := stateRmPreventDestroyInstances(config, instances)
+	if len(protected) == 0 {
+		return diags
+	}
+	lines := make([]string, len(protected))
+	for i, addr := range protected {
+		lines[i] = "  " + addr.String()
+	}
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Resource instances protected by prevent_destroy",
+		fmt.Sprintf("The following resource instances were selected for removal, but their resources set prevent_destroy = true in the configuration:\n\n%s\n\nNothing has been removed. To remove them anyway, first remove prevent_destroy from their lifecycle blocks, or run without -respect-prevent-destroy.", strings.Join(lines, "\n")),
+	))
+	return diags
+}
+
+// stateRmPreventDestroyInstances returns the addresses of the given instances
+// whose resources set prevent_destroy in the given configuration, in order.
+// An instance whose resource isn't in the configuration at all has nothing
+// protecting it.
+func stateRmPreventDestroyInstances(config *configs.Config, instances []addrs.AbsResourceInstance) []addrs.AbsResourceInstance {
+	var ret []addrs.AbsResourceInstance
+	seen := make(map[string]bool)
+	for _, addr := range instances {
+		if seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		modCfg := config.DescendentForInstance(addr.Module)
+		if modCfg == nil {
+			continue
+		}
+		rc := modCfg.Module.ResourceByAddr(addr.Resource.Resource)
+		if rc == nil || rc.Managed == nil || !rc.Managed.PreventDestroy {
+			continue
+		}
+		ret = append(ret, addr)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Less(ret[j])
+	})
+	return ret
+}
+
+// checkCleanPlan creates a plan for the configuration in the current working
+// directory against the given state, for the -require-clean-plan option, and
+// returns an error listing each of the given instances that the plan would
+// change. Removing such an instance would take it out from under a change
+// that the configuration is still waiting to make.
+func (c *StateRmCommand) checkCleanPlan(state *states.State, instances []addrs.AbsResourceInstance) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		return diags
+	}
+	plan, planDiags := c.planAgainstState(config, state)
+	diags = diags.Append(planDiags)
+	if planDiags.HasErrors() {
+		return diags
+	}
+
+	selected := make(map[string]bool, len(instances))
+	for _, addr := range instances {
+		selected[addr.String()] = true
+	}
+
+	var pending []addrs.AbsResourceInstance
+	actions := make(map[string][]string)
+	for _, rc := range plan.Changes.Resources {
+		addr := rc.Addr.String()
+		if !selected[addr] || rc.Action == plans.NoOp {
+			continue
+		}
+		if _, ok := actions[addr]; !ok {
+			pending = append(pending, rc.Addr)
+		}
+		action := stateRmActionVerb(rc.Action)
+		if rc.DeposedKey != states.NotDeposed {
+			action = fmt.Sprintf("%s deposed object %s", action, rc.DeposedKey)
+		}
+		actions[addr] = append(actions[addr], action)
+	}
+	if len(pending) == 0 {
+		return diags
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Less(pending[j])
+	})
+	var lines []string
+	for _, addr := range pending {
+		lines = append(lines, fmt.Sprintf("  %s: %s", addr, strings.Join(actions[addr.String()], ", ")))
+	}
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Pending changes to selected instances",
+		fmt.Sprintf("A plan for the current configuration would change the following resource instances that were selected for removal:\n\n%s\n\nNothing has been removed. Apply or discard these changes first, or run without -require-clean-plan to remove the instances anyway.", strings.Join(lines, "\n")),
+	))
+	return diags
+}
+
+// checkDrift refreshes the given state using the configuration in the current
+// directory, for the -refuse-on-drift option, and returns an error listing
+// each of the given instances whose real object no longer matches what the
+// state records. Removing such an instance would forget about changes made
+// outside of Terraform that nobody has reviewed yet.
+//
+// An object that the refresh finds is gone hasn't drifted, since forgetting
+// about it is exactly what removing it from the state is for.
+func (c *StateRmCommand) checkDrift(state *states.State, instances []addrs.AbsResourceInstance, skipDecodeErrors bool) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		return diags
+	}
+	refreshed, refreshDiags := c.refreshAgainstState(config, state)
+	diags = diags.Append(refreshDiags)
+	if refreshDiags.HasErrors() {
+		return diags
+	}
+
+	var drifted []string
+	for _, addr := range instances {
+		before := state.ResourceInstance(addr)
+		after := refreshed.ResourceInstance(addr)
+		if before == nil || before.Current == nil || after == nil || after.Current == nil {
+			continue
+		}
+		diff, err := stateRmDriftDiff(before.Current, after.Current)
+		if err != nil {
+			diags = diags.Append(stateRmDecodeError(addr, "check for drift", err, skipDecodeErrors))
+			continue
+		}
+		if diff != "" {
+			drifted = append(drifted, fmt.Sprintf("  %s:\n    %s", addr, strings.Replace(diff, "\n", "\n    ", -1)))
+		}
+	}
+	if diags.HasErrors() || len(drifted) == 0 {
+		return diags
+	}
+
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Selected instances have drifted",
+		fmt.Sprintf("Refreshing the state found that the following resource instances selected for removal no longer match their real objects:\n\n%s\n\nNothing has been removed. Run \"terraform refresh\" or \"terraform apply\" to review and accept the changes first, or run without -refuse-on-drift to remove the instances anyway.", strings.Join(drifted, "\n")),
+	))
+	return diags
+}
+
+// stateRmDriftDiff returns the differences between the attributes of the given
+// objects from before and after a refresh, formatted as by
+// stateShowFormatDiff, or an empty string if there are none.
+func stateRmDriftDiff(before, after *states.ResourceInstanceObjectSrc) (string, error) {
+	beforeAttrs, err := stateShowFlatAttrs(before)
+	if err != nil {
+		return "", err
+	}
+	afterAttrs, err := stateShowFlatAttrs(after)
+	if err != nil {
+		return "", err
+	}
+	return stateShowFormatDiff(beforeAttrs, afterAttrs), nil
+}
+
+// normalizeState rewrites the given state, as read from the given state
+// manager, in the current state snapshot format without removing anything,
+// for the -normalize-only option. Unless autoApprove is set, the user must
+// confirm the rewrite. The original snapshot is backed up first, and the
+// result reports whether the stored bytes of the snapshot changed.
+func (c *StateRmCommand) normalizeState(stateMgr statemgr.Full, state *states.State, dryRun, autoApprove, backupToBackend bool) int {
+	var diags tfdiags.Diagnostics
+
+	if dryRun {
+		c.Ui.Output("Would've rewritten the state snapshot without removing anything, without -dry-run.")
+		return 0
+	}
+	if !autoApprove {
+		c.Ui.Output("Terraform will rewrite the state snapshot in the current format, without removing anything.")
+	}
+	if !autoApprove {
+		ok, moreDiags := c.confirmStateChange("Do you want to rewrite the state snapshot?")
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !ok {
+			c.Ui.Output("Change cancelled.")
+			return 0
+		}
+	}
+
+	before, comparable, err := storedStateSnapshot(stateMgr)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to read state snapshot",
+			fmt.Sprintf("Could not read the stored state snapshot to back it up: %s.", err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	// The content of the state isn't changing, so the state managers won't
+	// write a backup of their own. We save the original bytes instead, so
+	// that the earlier version can be restored exactly.
+	if before != nil {
+		if err := ioutil.WriteFile(c.stateBackupPath, before, 0644); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to write backup",
+				fmt.Sprintf("Could not back up the state snapshot to %s: %s. The state has not been changed.", c.stateBackupPath, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+	if backupToBackend {
+		name, backupDiags := c.backupToBackend(state.DeepCopy(), "statenormalize")
+		diags = diags.Append(backupDiags)
+		if backupDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if name != "" {
+			c.Ui.Output(fmt.Sprintf("Backed up the state to the workspace %q.", name))
+		}
+	}
+
+	if err := stateMgr.WriteState(state); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to persist state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	var after []byte
+	if comparable {
+		after, _, err = storedStateSnapshot(stateMgr)
+		if err != nil {
+			comparable = false
+		}
+	}
+	if !comparable {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Can't compare state snapshots",
+			"The current backend doesn't give access to its stored state snapshots, so Terraform can't tell whether normalizing the state changed it.",
+		))
+	}
+	c.showDiagnostics(diags)
+
+	switch {
+	case !comparable:
+		c.Ui.Output("Rewrote the state snapshot.")
+	case bytes.Equal(before, after):
+		c.Ui.Output("The state snapshot was already normalized, so its stored bytes did not change.")
+	default:
+		c.Ui.Output("Normalized the state snapshot, changing its stored bytes.")
+	}
+	if before != nil {
+		c.Ui.Output(fmt.Sprintf("The original snapshot was backed up to %s.", c.stateBackupPath))
+	}
+	return 0
+}
+
+// renameProvider changes the provider configuration recorded for each of the
+// resources containing the given instances from the given old configuration
+// to the given new one, for the -provider-rename option. Resources that use
+// any other provider configuration are left unchanged, and nothing is
+// removed from the state. Unless dryRun or autoApprove is set, the user must
+// confirm the change.
+func (c *StateRmCommand) renameProvider(stateMgr statemgr.Full, state *states.State, instances []addrs.AbsResourceInstance, from, to addrs.AbsProviderConfig, dryRun, autoApprove bool) int {
+	var diags tfdiags.Diagnostics
+
+	verb := "Changed"
+	if dryRun || !autoApprove {
+		verb = "Would change"
+	}
+
+	seen := make(map[string]bool)
+	ss := state.SyncWrapper()
+	count := 0
+	for _, addr := range instances {
+		resAddr := addr.ContainingResource()
+		if seen[resAddr.String()] {
+			continue
+		}
+		seen[resAddr.String()] = true
+
+		rs := state.Resource(resAddr)
+		if rs == nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"No such resource in state",
+				fmt.Sprintf("There is no resource in the current state with the address %s.", resAddr),
+			))
+			continue
+		}
+		if rs.ProviderConfig.String() != from.String() {
+			continue
+		}
+
+		c.Ui.Output(fmt.Sprintf("%s the provider of %s from %s to %s", verb, resAddr, from, to))
+		count++
+		if !dryRun {
+			ss.SetResourceMeta(resAddr, rs.EachMode, to)
+		}
+	}
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	if dryRun {
+		c.Ui.Output(fmt.Sprintf("\nWould've changed the provider of %d resources, without -dry-run.", count))
+		return 0
+	}
+	if count == 0 {
+		c.Ui.Output(fmt.Sprintf("No resources use the provider %s, so the state has not been changed.", from))
+		return 0
+	}
+	if !autoApprove {
+		ok, moreDiags := c.confirmStateChange("Do you want to change the provider of these resources?")
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !ok {
+			c.Ui.Output("Change cancelled.")
+			return 0
+		}
+	}
+
+	if err := stateMgr.WriteState(state); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to persist state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("\nChanged the provider of %d resources. Updated state written successfully.", count))
+	return 0
+}
+
+// parseProviderRename parses the value of the -provider-rename option, which
+// is a pair of absolute provider configuration addresses separated by "=".
+func parseProviderRename(raw string) (from, to addrs.AbsProviderConfig, diags tfdiags.Diagnostics) {
+	parts := strings.SplitN(raw, "=", 2)
+	if len(parts) != 2 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -provider-rename option",
+			fmt.Sprintf("The -provider-rename option must be given as OLD=NEW, where OLD and NEW are provider configuration addresses such as provider.aws.west, not %q.", raw),
+		))
+		return from, to, diags
+	}
+
+	from, fromDiags := addrs.ParseAbsProviderConfigStr(strings.TrimSpace(parts[0]))
+	diags = diags.Append(fromDiags)
+	to, toDiags := addrs.ParseAbsProviderConfigStr(strings.TrimSpace(parts[1]))
+	diags = diags.Append(toDiags)
+	return from, to, diags
+}
+
+// stateAllResourceInstances returns the addresses of all of the resource
+// instances in the given state, in order.
+func stateAllResourceInstances(state *states.State) []addrs.AbsResourceInstance {
+	var ret []addrs.AbsResourceInstance
+	for _, ms := range state.Modules {
+		for _, rs := range ms.Resources {
+			for key := range rs.Instances {
+				ret = append(ret, rs.Addr.Instance(key).Absolute(ms.Addr))
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Less(ret[j])
+	})
+	return ret
+}
+
+// stateRmMatch records the resource instances in the state that were matched
+// by a single selector, which is either an address argument or one of the
+// options that select instances to remove.
+type stateRmMatch struct {
+	Selector string
+	Addrs    []addrs.AbsResourceInstance
+}
+
+// stateRmEmptyExitStatus is the exit status of "terraform state rm" when
+// nothing was selected for removal, distinct from the 1 of other errors and
+// the 2 of -dry-run-exit-code.
+const stateRmEmptyExitStatus = 3
+
+// stateRmFailIfEmpty returns true if selecting nothing for removal should
+// fail with stateRmEmptyExitStatus. That is always so when addresses are
+// given, since an address that matches nothing is most likely mistyped or
+// stale. The other selection options, such as -gc-orphan-data, can
+// reasonably find nothing to do, so they fail only with -fail-if-empty.
+func stateRmFailIfEmpty(args []string, failIfEmpty bool) bool {
+	return failIfEmpty || len(args) > 0
+}
+
+// stateRmOpts are the options for runStateRm.
+//
+// Only the first few are needed to remove a known set of resource instances,
+// as the other parts of the command do. Most of the rest are the options of
+// "terraform state rm" of the same names, including those that only Run acts
+// on, so that validate can check all of them together.
+type stateRmOpts struct {
+	// Addrs are the addresses of the resource instances to remove. Each
+	// instance is removed along with all of its deposed objects. Duplicate
+	// addresses are ignored.
+	Addrs []addrs.AbsResourceInstance
+
+	// Modules are the addresses of any module instances being removed as a
+	// whole. Their resource instances must also be included in Addrs.
+	Modules []addrs.ModuleInstance
+
+	// Outputs are the addresses of any other output values to remove, such
+	// as those that refer to the removed resource instances. Duplicate
+	// addresses and those not in the state are ignored.
+	Outputs []addrs.AbsOutputValue
+
+	// RemoveOutputs, if set, causes the output values of each of the
+	// modules in Modules, and of their descendents, to be removed too.
+	RemoveOutputs bool
+
+	// DryRun, if set, causes runStateRm to only report what it would remove,
+	// leaving the state unchanged.
+	DryRun bool
+
+	// ContinueOnError, if set, causes any instances that can't be removed
+	// to be skipped and recorded in the result, rather than preventing
+	// the others from being removed.
+	ContinueOnError bool
+
+	// Env gives runStateRm what it needs beyond the state itself, such as
+	// the configuration and a way to ask for confirmation. It may be nil
+	// only for a removal of the above alone, which is then made without
+	// asking.
+	Env stateRmEnv
+
+	// Tracer, if set, records the phases of the removal for -trace.
+	Tracer *stateTrace
+
+	// Selectors are the addresses of the resource instances, resources and
+	// module instances to remove, from the arguments, -address, the files
+	// given by -from-file and -by-resource-file, and the
+	// sources of Merges. They are added to Addrs and Modules.
+	Selectors []string
+
+	// RemovedFroms are the removed blocks of the -by-resource-file file,
+	// whose addresses are also in Selectors. ConfigFroms are those in the
+	// configuration, for -apply-removed-blocks, which are only selected if
+	// they still match something in the state.
+	RemovedFroms, ConfigFroms []stateRmRemovedFrom
+
+	// Merges are the parsed -merge options.
+	Merges []*stateRmMerge
+
+	// The options that select what to remove, along with Selectors.
+	ExpandForEach      bool
+	OrphanKeys         []string
+	ResourceTypes      []string
+	GCOrphanData       bool
+	FromPlanJSON       string
+	RemovedBetween     string
+	ApplyRemovedBlocks bool
+	FromFile           string
+	AddressFileFormat  string
+	ByResourceFile     string
+
+	// The options that narrow down what was selected.
+	Mode               string
+	ScopeModule        string
+	Exclude            []string
+	Where              []string
+	WhereNot           []string
+	DecodeErrors       string
+	SchemaUpgradeFirst bool
+	KeepLatest         int
+	KeepLatestBy       string
+	Checkpoint         string
+
+	// The options that check what was selected before anything is removed.
+	// AssertCount is nil unless -assert-count is given.
+	FailIfEmpty           bool
+	AssertCount           *int
+	MaxRemovalPct         int
+	FailOn                []string
+	RespectPreventDestroy bool
+	MaxProviders          int
+	ValidateProviders     bool
+	RequireCleanPlan      bool
+	ConflictCheck         bool
+	RefuseOnDrift         bool
+	IfNewerThanConfig     bool
+	Force                 bool
+	KeepIfReferenced      bool
+	MaxStateSize          string
+
+	// The options that approve the removal.
+	AutoApprove       bool
+	ConfirmShowDiff   bool
+	ConfirmFile       string
+	StdinConfirmToken bool
+
+	// The options that change what is removed and how.
+	ModuleOutputCleanup bool
+	EmitRemovedBlock    string
+	RespectOrder        bool
+	ChunkPersist        int
+	PersistInterval     time.Duration
+
+	// The options that choose what is reported. Template is the content of
+	// the -template-file file when that is given instead.
+	JSON                bool
+	CSV                 bool
+	Template            string
+	TemplateFile        string
+	Output              string
+	GroupByModule       bool
+	SummaryOnly         bool
+	ApprovalToken       bool
+	DryRunExitCode      bool
+	SimulatePlan        bool
+	PreviewDestroyCount bool
+	DiffProviders       bool
+	BatchSizeReport     bool
+	AddressTransform    string
+	Label               string
+	MatchCount          bool
+	PlanFileOut         string
+
+	// The options that only Run acts on, once the state has been changed.
+	// PostRemovePlanGate is nil unless -post-remove-plan-gate is given.
+	StatePath                  string
+	BackupPath                 string
+	BackupToBackend            bool
+	BackupRetention            int
+	PrintBackupPath            bool
+	Retry                      int
+	RetryInterval              time.Duration
+	VerifyAfter                bool
+	RollbackOnVerifyFailure    bool
+	ReinsertOnAbort            bool
+	PostRemovePlanGate         *int
+	PostRemovePlanGateRollback bool
+	SaveRemoved                string
+	RetainSchemaVersion        bool
+	ObjectHashManifest         string
+	EmitRemovedIDs             string
+	ExportBefore               string
+	PrePlanSnapshot            string
+	SnapshotDiffURL            string
+	EmitMetrics                string
+	Trace                      bool
+	AllowMissingState          bool
+
+	// The options that change the state in other ways than removing the
+	// selected instances, which only use the selection if at all.
+	ProviderRename          string
+	ProviderMigrationReport string
+	ScrubPrivate            bool
+	NormalizeOnly           bool
+	CoalesceInstances       bool
+	Fix                     bool
+	DedupeDeposed           bool
+	DedupeAcrossModules     bool
+	KeepModule              string
+	UndoLast                bool
+	ForeignLineage          string
+	AssertLineage           string
+	LineageReport           bool
+	PartitionByWorkspace    bool
+	WorkspacePattern        string
+}
+
+// selected returns true if any of the options that select resource
+// instances to remove are set.
+func (o *stateRmOpts) selected() bool {
+	return len(o.Selectors) > 0 || len(o.OrphanKeys) > 0 || len(o.ResourceTypes) > 0 || o.GCOrphanData || o.FromPlanJSON != "" || o.RemovedBetween != "" || o.ApplyRemovedBlocks
+}
+
+// backupGiven returns true if a backup path was given with -backup, rather
+// than the timestamped backups being written by default.
+func (o *stateRmOpts) backupGiven() bool {
+	return o.BackupPath != "" && o.BackupPath != "-"
+}
+
+// chunked returns true if the removal is persisted in several steps rather
+// than all at once.
+func (o *stateRmOpts) chunked() bool {
+	return o.ChunkPersist > 0 || o.RespectOrder || o.PersistInterval > 0
+}
+
+// skipDecodeErrors returns true if objects whose attributes can't be decoded
+// are skipped rather than failing, as -decode-errors=skip asks, which is
+// the default.
+func (o *stateRmOpts) skipDecodeErrors() bool {
+	return o.DecodeErrors != "fail"
+}
+
+// stateRmResult describes the outcome of runStateRm.
+type stateRmResult struct {
+	// Items describes each of the resource instances that were removed, or
+	// that would have been removed in dry-run mode, in the order they were
+	// requested.
+	Items []*stateRmItem
+
+	// Outputs are the addresses of the output values that were (or would
+	// have been) removed, in lexical order.
+	Outputs []addrs.AbsOutputValue
+
+	// CurrentCount and DeposedCount are the total number of current and
+	// deposed objects that were (or would have been) removed.
+	CurrentCount, DeposedCount int
+
+	// Skipped describes each of the resource instances that could not be
+	// removed, in the order they were requested. It is only populated when
+	// stateRmOpts.ContinueOnError is set.
+	Skipped []*stateRmSkipped
+
+	// Label is the reason given for the removal with -label, if any, which
+	// is recorded in each of the reports made from the result.
+	Label string
+
+	// Selection describes what the options selected for removal, and what
+	// they left out. It is nil if runStateRm failed before selecting.
+	Selection *stateRmSelection
+
+	// NothingSelected is set if nothing was selected for removal when that
+	// is an error, as with -fail-if-empty, and Cancelled if the removal
+	// wasn't confirmed. Nothing is removed in either case.
+	NothingSelected, Cancelled bool
+
+	// Shape describes the state as it was before anything was removed.
+	Shape *stateRmShape
+
+	// ProviderCounts are the number of selected resource instances
+	// belonging to each provider configuration.
+	ProviderCounts map[string]int
+
+	// StaleOutputs are the output values found by -module-output-cleanup,
+	// which are also in Outputs.
+	StaleOutputs []stateRmStaleOutput
+
+	// OrderLayers are the steps of -respect-depends-on-order, and Items
+	// are in the same order.
+	OrderLayers [][]*stateRmItem
+
+	// Sizes are the projected sizes of the state for -batch-size-report and
+	// -max-state-size. A dry run that would leave the state larger than
+	// -max-state-size allows still succeeds, with the error in TooLarge.
+	Sizes    stateRmSizes
+	TooLarge tfdiags.Diagnostics
+
+	// TemplateOutput is what -template or -template-file rendered, and
+	// Report the human-readable report of a dry run or of -match-count.
+	TemplateOutput, Report string
+}
+
+// stateRmSkipped describes a resource instance that runStateRm skipped
+// because it could not be removed.
+type stateRmSkipped struct {
+	Addr addrs.AbsResourceInstance
+
+	// Diag is the error that prevented the instance from being removed.
+	Diag tfdiags.Diagnostic
+}
+
+// stateRmSkippedLines returns a line for each of the given skipped instances,
+// for use in diagnostics.
+func stateRmSkippedLines(skipped []*stateRmSkipped) []string {
+	lines := make([]string, len(skipped))
+	for i, item := range skipped {
+		lines[i] = fmt.Sprintf("  %s: %s", item.Addr, item.Diag.Description().Summary)
+	}
+	return lines
+}
+
+// Addrs returns the addresses of all of the items in the result.
+func (r *stateRmResult) Addrs() []addrs.AbsResourceInstance {
+	ret := make([]addrs.AbsResourceInstance, len(r.Items))
+	for i, item := range r.Items {
+		ret[i] = item.Addr
+	}
+	return ret
+}
+
+// stateRmItem describes a single resource instance removed by runStateRm.
+type stateRmItem struct {
+	Addr addrs.AbsResourceInstance
+
+	// Instance is the resource instance as it was before it was removed.
+	Instance *states.ResourceInstance
+
+	// ProviderConfig is the provider configuration of the resource the
+	// instance belonged to.
+	ProviderConfig addrs.AbsProviderConfig
+
+	// Current is true if the instance had a current object.
+	Current bool
+
+	// Deposed are the keys of the instance's deposed objects, in sorted
+	// order.
+	Deposed []states.DeposedKey
+}
+
+// stateRmSelection describes what the options given to runStateRm selected
+// for removal, and what they left out, for the reports made from it.
+type stateRmSelection struct {
+	// Addrs and Modules are the selected resource instances and the module
+	// instances selected to be removed as a whole, in the order they were
+	// selected.
+	Addrs   []addrs.AbsResourceInstance
+	Modules []addrs.ModuleInstance
+
+	// Selectors are the addresses that were given to select them, along
+	// with those of the removed blocks in the configuration that were still
+	// in the state.
+	Selectors []string
+
+	// Matches records the resource instances in the state that each of the
+	// selectors matched, for -match-count, and Expansions the resource
+	// addresses that were expanded to all of their instances, for
+	// -expand-for-each.
+	Matches, Expansions []stateRmMatch
+
+	// RemovedFroms are the removed blocks whose addresses were selected, and
+	// AppliedFroms those in the configuration that were skipped because
+	// they no longer match anything in the state.
+	RemovedFroms, AppliedFroms []stateRmRemovedFrom
+
+	// RemovedConfig records the resources and module calls that were
+	// removed from the configuration between the -removed-between revisions.
+	RemovedConfig []string
+
+	// OutOfScope is the number of selected instances outside of
+	// -scope-module, and Excluded and Unmatched are those left out by
+	// -exclude and by -where or -where-not. Kept are the newest instances
+	// kept by -keep-latest.
+	OutOfScope          int
+	Excluded, Unmatched []addrs.AbsResourceInstance
+	Kept                []stateRmKept
+
+	// Checkpoint is what the -checkpoint file records as already removed,
+	// if there is one, and Resumed is the number of selected instances that
+	// were left out because of it.
+	Checkpoint map[string]bool
+	Resumed    int
+
+	// Upgrades are the objects upgraded by -schema-upgrade-first, which are
+	// restored before anything is removed.
+	Upgrades *stateRmUpgrades
+}
+
+// runStateRm removes the resource instances selected by the given options
+// from the given state, returning a description of what was removed.
+//
+// This is the core of the "terraform state rm" command, separated from the
+// command line handling so that it can be used and tested without any UI
+// or state manager. It selects what to remove, narrows that down with the
+// filtering options, checks it against the guards and renders the reports
+// made from the result, using opts.Env for anything it needs beyond the
+// state. The caller is responsible for persisting the modified state and for
+// printing the reports.
+//
+// Either all of the selected instances are removed or, if any of them cannot
+// be, the state is left unchanged and error diagnostics are returned. With
+// opts.ContinueOnError, the instances that cannot be removed are instead
+// recorded in the result's Skipped and the others are removed. The state is
+// also left unchanged with -emit-removed-block and when removing in chunks,
+// which the caller does itself from the result.
+//
+// With -provider-rename, -provider-migration-report, -scrub-private or
+// -match-count, nothing is removed and only the result's Selection is set,
+// along with the Report of -match-count.
+func runStateRm(state *states.State, opts *stateRmOpts) (*stateRmResult, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	result := &stateRmResult{Label: opts.Label}
+
+	// The template is parsed before anything else is done, so that a
+	// mistake in it can't leave a removal without its report.
+	var outputTemplate *template.Template
+	if opts.Template != "" || opts.TemplateFile != "" {
+		var err error
+		outputTemplate, err = parseStateRmTemplate(opts.Template)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid output template",
+				fmt.Sprintf("The output template is not valid: %s.", err),
+			))
+			return result, diags
+		}
+	}
+
+	sel, moreDiags := selectStateRm(state, opts)
+	result.Selection = sel
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		return result, diags
+	}
+
+	moreDiags = filterStateRm(state, opts, sel)
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		return result, diags
+	}
+
+	switch {
+	case opts.ProviderRename != "" || opts.ProviderMigrationReport != "":
+		// Without any selectors, all resources using the old provider
+		// configuration are changed, or reported on.
+		if !opts.selected() {
+			sel.Addrs = stateAllResourceInstances(state)
+			if mode, _ := parseStateRmMode(opts.Mode); mode != addrs.InvalidResourceMode {
+				sel.Addrs = filterResourceInstancesByMode(sel.Addrs, mode)
+			}
+			if scope, ok := parseModuleInstanceArg(opts.ScopeModule); ok {
+				sel.Addrs, _ = filterResourceInstancesByModule(sel.Addrs, scope)
+			}
+		}
+		// The migration report is of the upgraded objects, since it only
+		// reports on them without changing the state.
+		if opts.ProviderRename != "" {
+			sel.Upgrades.Restore(state)
+		}
+		return result, diags
+	case opts.ScrubPrivate:
+		sel.Upgrades.Restore(state)
+		return result, diags
+	case opts.MatchCount:
+		// This only reports how the selectors were expanded, so we stop
+		// here without removing anything.
+		lines := make([]string, len(sel.Matches))
+		for i, match := range sel.Matches {
+			lines[i] = fmt.Sprintf("%s: %d", match.Selector, len(match.Addrs))
+		}
+		result.Report = strings.Join(lines, "\n")
+		return result, diags
+	}
+
+	moreDiags = checkStateRm(state, opts, sel, result)
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		return result, diags
+	}
+
+	sel.Upgrades.Restore(state)
+
+	// A removal is confirmed before anything is changed, unless it was
+	// approved already with -auto-approve or an approval token. Anything
+	// found so far is shown first, so that it can be taken into account.
+	if opts.Env != nil && !opts.DryRun && !opts.AutoApprove && opts.ConfirmFile == "" && !opts.StdinConfirmToken && opts.EmitRemovedBlock == "" && len(sel.Addrs) > 0 {
+		opts.Env.showDiagnostics(diags)
+		diags = nil
+
+		ok, moreDiags := opts.Env.confirmStateRm(state, sel.Addrs, sel.Modules, opts.ConfirmShowDiff)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return result, diags
+		}
+		if !ok {
+			result.Cancelled = true
+			return result, diags
+		}
+	}
+
+	// The output values are found before anything is removed, because an
+	// output value referring to a whole module needs the module's resource
+	// instances to still be in the state to match them.
+	var extraOutputs []addrs.AbsOutputValue
+	if opts.ModuleOutputCleanup && len(sel.Addrs) > 0 {
+		var moreDiags tfdiags.Diagnostics
+		result.StaleOutputs, moreDiags = opts.Env.findStaleOutputs(state, sel.Addrs)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return result, diags
+		}
+		for _, stale := range result.StaleOutputs {
+			extraOutputs = append(extraOutputs, stale.Addr)
+		}
+	}
+
+	removal := &stateRmOpts{
+		Addrs:           sel.Addrs,
+		Modules:         sel.Modules,
+		Outputs:         extraOutputs,
+		RemoveOutputs:   opts.RemoveOutputs,
+		DryRun:          opts.DryRun || opts.EmitRemovedBlock != "" || opts.chunked(),
+		ContinueOnError: opts.ContinueOnError,
+	}
+
+	// The size is projected before anything is removed, since the real
+	// removal changes the state it would be measured from.
+	// The option was already validated by validate.
+	maxStateSize, _ := parseStateRmSize(opts.MaxStateSize)
+	if (opts.BatchSizeReport || maxStateSize > 0) && opts.EmitRemovedBlock == "" {
+		var err error
+		result.Sizes, err = opts.Env.projectStateSize(state, *removal)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to measure state",
+				fmt.Sprintf("Could not serialize the state to measure its size: %s. Nothing has been removed.", err),
+			))
+			return result, diags
+		}
+	}
+	if maxStateSize > 0 && result.Sizes.After > maxStateSize {
+		result.TooLarge = result.TooLarge.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"State too large",
+			fmt.Sprintf("After the removal, the state would be %s, which is larger than the %s allowed by -max-state-size. Nothing has been removed. Remove more resource instances, or raise the limit if the backend allows it.", formatStateRmSize(result.Sizes.After), formatStateRmSize(maxStateSize)),
+		))
+		// A dry run still reports what it would remove, along with the
+		// projected size, before failing.
+		if !opts.DryRun {
+			diags = diags.Append(result.TooLarge)
+			return result, diags
+		}
+	}
+
+	// The kinds of things removed are counted against the state as it was
+	// loaded, before anything from it is removed.
+	result.Shape = newStateRmShape(state)
+
+	opts.Tracer.Phase("mutate")
+	moreDiags = removeStateRmInstances(state, removal, result)
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		return result, diags
+	}
+
+	// With -respect-depends-on-order the items are removed in steps, and
+	// reported in the order they are removed in.
+	if opts.RespectOrder {
+		var cycle []string
+		result.OrderLayers, cycle = stateRmDependencyLayers(result.Items)
+		if len(cycle) > 0 {
+			lines := make([]string, len(cycle))
+			for i, addr := range cycle {
+				lines[i] = "  " + addr
+			}
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Dependency cycle",
+				fmt.Sprintf("The dependencies recorded in the state between the following resource instances form a cycle, so there is no order to remove them in that -respect-depends-on-order could use:\n\n%s\n\nThe state has not been changed.", strings.Join(lines, "\n")),
+			))
+			return result, diags
+		}
+		result.Items = result.Items[:0]
+		for _, layer := range result.OrderLayers {
+			result.Items = append(result.Items, layer...)
+		}
+	}
+
+	// The template is rendered for every item before the state is saved, so
+	// that an error while rendering doesn't leave the removal done but only
+	// partly reported.
+	if outputTemplate != nil {
+		var err error
+		result.TemplateOutput, err = renderStateRmTemplate(outputTemplate, result.Items)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to render output template",
+				fmt.Sprintf("The output template could not be rendered: %s. The state has not been changed.", err),
+			))
+			return result, diags
+		}
+	}
+
+	if opts.DryRun && !opts.ApprovalToken && !opts.JSON && !opts.CSV && outputTemplate == nil {
+		result.Report = renderStateRmDryRun(opts, result)
+	}
+
+	return result, diags
+}
+
+// selectStateRm selects what the selection options given to runStateRm
+// select from the given state, along with the instances and modules given
+// directly in opts.Addrs and opts.Modules.
+func selectStateRm(state *states.State, opts *stateRmOpts) (*stateRmSelection, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	sel := &stateRmSelection{
+		Addrs:        append([]addrs.AbsResourceInstance(nil), opts.Addrs...),
+		Modules:      append([]addrs.ModuleInstance(nil), opts.Modules...),
+		Selectors:    append([]string(nil), opts.Selectors...),
+		RemovedFroms: append([]stateRmRemovedFrom(nil), opts.RemovedFroms...),
+	}
+
+	if len(opts.Merges) > 0 {
+		moreDiags := checkStateRmMerges(state, opts.Merges)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return sel, diags
+		}
+	}
+
+	// A removed block that was already applied, by an earlier run or by
+	// hand, no longer matches anything in the state, and is skipped so that
+	// the removed blocks can be applied again whenever more are added.
+	// A removed block always refers to every instance of its resource, so
+	// those are expanded as if with -expand-for-each.
+	configFromArgs := make(map[string]bool, len(opts.ConfigFroms))
+	for _, from := range opts.ConfigFroms {
+		if !stateRmSelectorInState(state, from.From) {
+			sel.AppliedFroms = append(sel.AppliedFroms, from)
+			continue
+		}
+		sel.RemovedFroms = append(sel.RemovedFroms, from)
+		configFromArgs[from.From] = true
+		sel.Selectors = append(sel.Selectors, from.From)
+	}
+
+	var modules []addrs.ModuleInstance
+	var moduleArgs []string
+	for i, rawAddr := range sel.Selectors {
+		// A wildcard in place of an instance key selects every instance
+		// with any key there, so it is expanded without -expand-for-each.
+		// Each module instance it matches is removed as if its address
+		// were given, and is counted under that address by -match-count.
+		if isStateRmKeyPattern(rawAddr) {
+			matched, instances, isModule, err := stateRmKeyPatternMatches(state, rawAddr)
+			if err != nil {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"Invalid address pattern",
+					fmt.Sprintf("The address %q can't be used as a pattern: %s.", rawAddr, err),
+				))
+				continue
+			}
+			for _, modAddr := range matched {
+				modules = append(modules, modAddr)
+				moduleArgs = append(moduleArgs, modAddr.String())
+			}
+			if len(instances) == 0 && len(matched) == 0 {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Warning,
+					"Address pattern matched nothing",
+					fmt.Sprintf("There is nothing in the current state matching %s.", rawAddr),
+				))
+			}
+			if !isModule {
+				sel.Addrs = append(sel.Addrs, instances...)
+				sel.Matches = append(sel.Matches, stateRmMatch{Selector: rawAddr, Addrs: instances})
+			}
+			continue
+		}
+		if modAddr, ok := parseModuleInstanceArg(rawAddr); ok {
+			modules = append(modules, modAddr)
+			moduleArgs = append(moduleArgs, rawAddr)
+			continue
+		}
+		addr, moreDiags := opts.Env.parseResourceInstanceAddr(rawAddr, fmt.Sprintf("<address %d>", i+1))
+		diags = diags.Append(moreDiags)
+
+		// An address without an instance key for a resource that uses count
+		// or for_each refers to all of its instances, but only if asked, so
+		// that a single address doesn't unexpectedly remove many instances.
+		if !moreDiags.HasErrors() {
+			if instances := stateResourceKeyedInstances(state, addr); len(instances) > 0 {
+				if !opts.ExpandForEach && !configFromArgs[rawAddr] {
+					diags = diags.Append(tfdiags.Sourceless(
+						tfdiags.Error,
+						"Resource has multiple instances",
+						fmt.Sprintf("The resource %s has %d instances, with the keys %s, so %s doesn't identify any one of them. Give the address of each instance to remove, or use -expand-for-each to remove all of them.", addr.ContainingResource(), len(instances), stateRmInstanceKeys(instances), rawAddr),
+					))
+					continue
+				}
+				sel.Addrs = append(sel.Addrs, instances...)
+				sel.Matches = append(sel.Matches, stateRmMatch{Selector: rawAddr, Addrs: instances})
+				sel.Expansions = append(sel.Expansions, stateRmMatch{Selector: rawAddr, Addrs: instances})
+				continue
+			}
+		}
+		sel.Addrs = append(sel.Addrs, addr)
+
+		match := stateRmMatch{Selector: rawAddr}
+		if !moreDiags.HasErrors() && state.ResourceInstance(addr) != nil {
+			match.Addrs = []addrs.AbsResourceInstance{addr}
+		}
+		sel.Matches = append(sel.Matches, match)
+	}
+	if diags.HasErrors() {
+		return sel, diags
+	}
+
+	// Removing a module removes all of the resource instances in it and in
+	// any of its descendent modules.
+	// A module that isn't in the state is only an error if something else
+	// was found to remove, so that addresses that match nothing at all are
+	// reported as such later.
+	var missingModules tfdiags.Diagnostics
+	for i, modAddr := range modules {
+		instances := stateModuleResourceInstances(state, modAddr)
+		sel.Matches = append(sel.Matches, stateRmMatch{Selector: moduleArgs[i], Addrs: instances})
+		if state.Module(modAddr) == nil && !opts.MatchCount {
+			missingModules = missingModules.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"No such module in state",
+				fmt.Sprintf("There is no module instance in the current state with the address %s.", modAddr),
+			))
+			continue
+		}
+		sel.Addrs = append(sel.Addrs, instances...)
+	}
+	sel.Modules = append(sel.Modules, modules...)
+	if missingModules.HasErrors() && (stateRmSelectedCount(state, sel.Addrs) > 0 || !stateRmFailIfEmpty(sel.Selectors, opts.FailIfEmpty)) {
+		diags = diags.Append(missingModules)
+	}
+	if diags.HasErrors() {
+		return sel, diags
+	}
+
+	if len(opts.OrphanKeys) > 0 {
+		orphans, moreDiags := opts.Env.orphanedInstances(state, opts.OrphanKeys)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return sel, diags
+		}
+		sel.Addrs = append(sel.Addrs, orphans...)
+
+		for _, rawAddr := range opts.OrphanKeys {
+			// The addresses were already validated by orphanedInstances.
+			addr, _ := addrs.ParseAbsResourceStr(rawAddr)
+			match := stateRmMatch{Selector: "-orphan-keys=" + rawAddr}
+			for _, orphan := range orphans {
+				if orphan.ContainingResource().Equal(addr) {
+					match.Addrs = append(match.Addrs, orphan)
+				}
+			}
+			sel.Matches = append(sel.Matches, match)
+		}
+	}
+
+	for _, ty := range opts.ResourceTypes {
+		instances := stateResourceTypeInstances(state, ty)
+		sel.Addrs = append(sel.Addrs, instances...)
+		sel.Matches = append(sel.Matches, stateRmMatch{Selector: "-resource-type=" + ty, Addrs: instances})
+	}
+
+	if opts.GCOrphanData {
+		orphans, moreDiags := opts.Env.orphanedDataInstances(state)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return sel, diags
+		}
+		sel.Addrs = append(sel.Addrs, orphans...)
+		sel.Matches = append(sel.Matches, stateRmMatch{Selector: "-gc-orphan-data", Addrs: orphans})
+	}
+
+	if opts.FromPlanJSON != "" {
+		deleted, moreDiags := planJSONDeletedInstances(opts.FromPlanJSON)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return sel, diags
+		}
+		sel.Addrs = append(sel.Addrs, deleted...)
+
+		var present []addrs.AbsResourceInstance
+		for _, addr := range deleted {
+			if state.ResourceInstance(addr) != nil {
+				present = append(present, addr)
+			}
+		}
+		sel.Matches = append(sel.Matches, stateRmMatch{Selector: "-from-plan-json=" + opts.FromPlanJSON, Addrs: present})
+	}
+
+	if opts.RemovedBetween != "" {
+		// The range was already validated by validate.
+		oldRev, newRev, _ := parseRevisionRange(opts.RemovedBetween)
+		removed, instances, removedModules, moreDiags := removedBetweenRevisions(state, oldRev, newRev)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return sel, diags
+		}
+		sel.RemovedConfig = removed
+		sel.Addrs = append(sel.Addrs, instances...)
+		sel.Modules = append(sel.Modules, removedModules...)
+		sel.Matches = append(sel.Matches, stateRmMatch{Selector: "-removed-between=" + opts.RemovedBetween, Addrs: instances})
+	}
+
+	return sel, diags
+}
+
+// filterStateRm narrows down the given selection with the filtering options
+// given to runStateRm. A module with any of its instances left out by them
+// is no longer removed as a whole, and so keeps its output values.
+func filterStateRm(state *states.State, opts *stateRmOpts, sel *stateRmSelection) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	// The options were already validated by validate.
+	if mode, _ := parseStateRmMode(opts.Mode); mode != addrs.InvalidResourceMode {
+		sel.Addrs = filterResourceInstancesByMode(sel.Addrs, mode)
+		for i := range sel.Matches {
+			sel.Matches[i].Addrs = filterResourceInstancesByMode(sel.Matches[i].Addrs, mode)
+		}
+	}
+
+	// The scope is a boundary that however broad the selectors are, nothing
+	// outside of it is removed, and so anything outside is skipped rather
+	// than reported as an error.
+	if scope, ok := parseModuleInstanceArg(opts.ScopeModule); ok {
+		sel.Addrs, sel.OutOfScope = filterResourceInstancesByModule(sel.Addrs, scope)
+		for i := range sel.Matches {
+			sel.Matches[i].Addrs, _ = filterResourceInstancesByModule(sel.Matches[i].Addrs, scope)
+		}
+		var scopedModules []addrs.ModuleInstance
+		for _, modAddr := range sel.Modules {
+			if moduleWithinScope(modAddr, scope) {
+				scopedModules = append(scopedModules, modAddr)
+			}
+		}
+		sel.Modules = scopedModules
+	}
+
+	// Like the scope, the exclusions apply however broad the selectors are.
+	// An exclusion that keeps nothing is most likely a typo, but it keeps
+	// nothing from being removed either, so it is only warned about.
+	if len(opts.Exclude) > 0 {
+		var exclusions []*stateRmExclusion
+		for _, raw := range opts.Exclude {
+			if e, err := newStateRmExclusion(state, raw); err == nil {
+				exclusions = append(exclusions, e)
+			}
+		}
+		var unused []*stateRmExclusion
+		sel.Addrs, sel.Excluded, unused = filterStateRmExclusions(sel.Addrs, exclusions)
+		for i := range sel.Matches {
+			sel.Matches[i].Addrs, _, _ = filterStateRmExclusions(sel.Matches[i].Addrs, exclusions)
+		}
+		sel.Modules = stateRmWholeModules(sel.Modules, sel.Excluded)
+		for _, e := range unused {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Exclusion matched nothing",
+				fmt.Sprintf("None of the selected resource instances are excluded by -exclude=%s, so it has no effect.", e.Raw),
+			))
+		}
+	}
+
+	// The upgrade comes before anything looks at the attributes of the
+	// selected instances, and is undone before anything is written.
+	if opts.SchemaUpgradeFirst && len(sel.Addrs) > 0 {
+		opts.Tracer.Phase("upgrade")
+		var moreDiags tfdiags.Diagnostics
+		sel.Upgrades, moreDiags = opts.Env.upgradeInstances(state, sel.Addrs, opts.skipDecodeErrors())
+		opts.Tracer.Done()
+		diags = diags.Append(moreDiags)
+		diags = diags.Append(sel.Upgrades.Report())
+		if diags.HasErrors() {
+			return diags
+		}
+	}
+
+	// The conditions narrow down what the selectors selected.
+	if len(opts.Where) > 0 || len(opts.WhereNot) > 0 {
+		where, _ := parseStateRmConditions(opts.Where, "-where")
+		whereNot, _ := parseStateRmConditions(opts.WhereNot, "-where-not")
+		var moreDiags tfdiags.Diagnostics
+		sel.Addrs, sel.Unmatched, moreDiags = filterInstancesByConditions(state, sel.Addrs, where, whereNot, opts.skipDecodeErrors())
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return diags
+		}
+		for i := range sel.Matches {
+			sel.Matches[i].Addrs, _, _ = filterInstancesByConditions(state, sel.Matches[i].Addrs, where, whereNot, opts.skipDecodeErrors())
+		}
+		sel.Modules = stateRmWholeModules(sel.Modules, sel.Unmatched)
+	}
+
+	// Like the conditions, -keep-latest narrows down what was selected.
+	if opts.KeepLatest > 0 {
+		var moreDiags tfdiags.Diagnostics
+		sel.Addrs, sel.Kept, moreDiags = keepLatestInstances(state, sel.Addrs, opts.ResourceTypes, opts.KeepLatest, opts.KeepLatestBy)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return diags
+		}
+		keepSet := make(map[string]bool, len(sel.Kept))
+		kept := make([]addrs.AbsResourceInstance, len(sel.Kept))
+		for i, k := range sel.Kept {
+			keepSet[k.Addr.String()] = true
+			kept[i] = k.Addr
+		}
+		for i := range sel.Matches {
+			var rest []addrs.AbsResourceInstance
+			for _, addr := range sel.Matches[i].Addrs {
+				if !keepSet[addr.String()] {
+					rest = append(rest, addr)
+				}
+			}
+			sel.Matches[i].Addrs = rest
+		}
+		sel.Modules = stateRmWholeModules(sel.Modules, kept)
+	}
+
+	// Instances recorded in the checkpoint by an earlier run that didn't
+	// complete are already gone, so rather than failing because they're
+	// missing we resume with those that remain.
+	if opts.Checkpoint != "" {
+		var err error
+		sel.Checkpoint, err = readStateRmCheckpoint(opts.Checkpoint)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to read checkpoint",
+				fmt.Sprintf("Could not read the checkpoint file %s: %s.", opts.Checkpoint, err),
+			))
+			return diags
+		}
+		sel.Addrs, sel.Resumed = filterCheckpointedInstances(state, sel.Addrs, sel.Checkpoint)
+	}
+
+	return diags
+}
+
+// stateRmWholeModules returns those of the given modules that none of the
+// given instances that were left out of the removal belong to, which are
+// still removed as a whole.
+func stateRmWholeModules(modules []addrs.ModuleInstance, leftOut []addrs.AbsResourceInstance) []addrs.ModuleInstance {
+	var ret []addrs.ModuleInstance
+	for _, modAddr := range modules {
+		whole := true
+		for _, addr := range leftOut {
+			if moduleWithinScope(addr.Module, modAddr) {
+				whole = false
+				break
+			}
+		}
+		if whole {
+			ret = append(ret, modAddr)
+		}
+	}
+	return ret
+}
+
+// checkStateRm checks the given selection against the guards given to
+// runStateRm, returning an error for the first that fails. With
+// -keep-if-referenced, it first leaves out the instances that something else
+// still depends on.
+func checkStateRm(state *states.State, opts *stateRmOpts, sel *stateRmSelection, result *stateRmResult) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	// Instances that something else still depends on are left in place
+	// rather than failing the whole removal, so that the rest can still be
+	// removed and the selection widened afterwards if that was intended.
+	if opts.KeepIfReferenced {
+		var kept []*stateRmReference
+		sel.Addrs, kept = filterReferencedInstances(state, sel.Addrs)
+		if len(kept) > 0 {
+			keptModules := make(map[string]bool)
+			var lines []string
+			for _, ref := range kept {
+				lines = append(lines, "  "+ref.String())
+				keptModules[ref.Addr.Module.String()] = true
+			}
+			// A module that keeps some of its instances also keeps its
+			// output values.
+			var remaining []addrs.ModuleInstance
+			for _, modAddr := range sel.Modules {
+				if !keptModules[modAddr.String()] {
+					remaining = append(remaining, modAddr)
+				}
+			}
+			sel.Modules = remaining
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Referenced resource instances kept",
+				fmt.Sprintf("The following %d selected resource instances are still depended on by resource instances that weren't selected, and so will not be removed because of -keep-if-referenced:\n\n%s\n\nTo remove them too, add the instances that depend on them to the selection.", len(kept), strings.Join(lines, "\n")),
+			))
+		}
+	}
+
+	// A run resumed from a checkpoint may have nothing left to remove
+	// because the earlier run removed it all, which isn't what this check
+	// is meant to catch. Addresses of instances that aren't in the state
+	// select nothing either, and would otherwise fail with the usual error
+	// for a missing instance, which a script can't tell apart from others.
+	if stateRmFailIfEmpty(sel.Selectors, opts.FailIfEmpty) && stateRmSelectedCount(state, sel.Addrs) == 0 && sel.Resumed == 0 {
+		result.NothingSelected = true
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Nothing selected for removal",
+			"No matching resources found in state. After all of the selection and filtering options were applied, no resource instances in the state were left to remove. Check whether the resources were renamed or removed, whether the addresses are mistyped, or whether a filter such as -where or -mode is excluding them.",
+		))
+		return diags
+	}
+
+	// Instances already removed by the run being resumed were selected all
+	// the same, so they count towards the assertion.
+	if opts.AssertCount != nil {
+		if count := stateRmSelectedCount(state, sel.Addrs) + sel.Resumed; count != *opts.AssertCount {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Unexpected number of resource instances selected",
+				fmt.Sprintf("After all of the selection and filtering options were applied, %d resource instances were selected for removal, but -assert-count expects exactly %d. Nothing has been removed. Review the selection with \"terraform state rm -dry-run\" to see whether the resources have changed since the count was chosen.", count, *opts.AssertCount),
+			))
+			return diags
+		}
+	}
+
+	// The percentage is of the state as it is now, so that a run resumed
+	// after some of its removals were made is compared against the smaller
+	// state it would finish.
+	if opts.MaxRemovalPct > 0 {
+		count := stateRmSelectedCount(state, sel.Addrs)
+		total := len(stateAllResourceInstances(state))
+		if total > 0 && count*100 > opts.MaxRemovalPct*total {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Too much of the state selected",
+				fmt.Sprintf("The %d resource instances selected for removal are %.1f%% of the %d resource instances in the state, which is more than the %d%% allowed by -max-removal-pct. Nothing has been removed. Check that a wildcard or module address isn't selecting more than intended.", count, float64(count*100)/float64(total), total, opts.MaxRemovalPct),
+			))
+			return diags
+		}
+	}
+
+	// This guard applies to dry runs too, so that a script fails at the
+	// same point whether or not it is only previewing the removal.
+	if protected := stateRmProtectedInstances(sel.Addrs, opts.FailOn); len(protected) > 0 {
+		var lines []string
+		for _, addr := range protected {
+			lines = append(lines, "  "+addr.String())
+		}
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Protected resource types selected",
+			fmt.Sprintf("The following resource instances were selected for removal, but their types are protected by -fail-on:\n\n%s\n\nNothing has been removed. Change the selection so that it doesn't include these instances.", strings.Join(lines, "\n")),
+		))
+		return diags
+	}
+
+	if opts.RespectPreventDestroy && len(sel.Addrs) > 0 {
+		diags = diags.Append(opts.Env.checkPreventDestroy(sel.Addrs))
+		if diags.HasErrors() {
+			return diags
+		}
+	}
+
+	result.ProviderCounts = stateRmProviderCounts(state, sel.Addrs)
+	if opts.MaxProviders > 0 && len(result.ProviderCounts) > opts.MaxProviders {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Too many providers selected",
+			fmt.Sprintf("The resource instances selected for removal belong to %d distinct provider configurations, which is more than the %d allowed by -max-providers:\n\n%s\n\nNothing has been removed. Check that the selection isn't broader than intended.", len(result.ProviderCounts), opts.MaxProviders, strings.Join(stateRmProviderLines(result.ProviderCounts), "\n")),
+		))
+		return diags
+	}
+
+	// Each merge keeps its destination, so removing that too would forget
+	// the real object entirely rather than merge its duplicates.
+	if len(opts.Merges) > 0 {
+		removing := make(map[string]bool, len(sel.Addrs))
+		for _, addr := range sel.Addrs {
+			removing[addr.String()] = true
+		}
+		for _, merge := range opts.Merges {
+			if removing[merge.Dest.String()] {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"Can't merge resource instances",
+					fmt.Sprintf("%s is the destination of a -merge option, so it must be kept, but it was also selected for removal. Nothing has been removed.", merge.Dest),
+				))
+			}
+		}
+		if diags.HasErrors() {
+			return diags
+		}
+	}
+
+	if opts.ValidateProviders && len(sel.Addrs) > 0 {
+		// Missing providers are only a warning, because removing the
+		// objects of a provider that is gone can be intended, but it's
+		// also what happens when a provider block was deleted by mistake.
+		diags = diags.Append(opts.Env.checkProvidersInstalled(state, sel.Addrs))
+	}
+
+	if opts.RequireCleanPlan && len(sel.Addrs) > 0 {
+		opts.Tracer.Phase("plan")
+		diags = diags.Append(opts.Env.checkCleanPlan(state, sel.Addrs))
+		opts.Tracer.Done()
+		if diags.HasErrors() {
+			return diags
+		}
+	}
+
+	// The caller holds the lock itself while removing in chunks, and then
+	// nothing else can be in progress.
+	locked := opts.chunked() && !opts.DryRun && opts.EmitRemovedBlock == ""
+	if opts.ConflictCheck && len(sel.Addrs) > 0 && !locked {
+		opts.Tracer.Phase("lock")
+		diags = diags.Append(opts.Env.checkConflicts(sel.Addrs))
+		opts.Tracer.Done()
+		if diags.HasErrors() {
+			return diags
+		}
+	}
+
+	if opts.RefuseOnDrift && len(sel.Addrs) > 0 {
+		opts.Tracer.Phase("refresh")
+		diags = diags.Append(opts.Env.checkDrift(state, sel.Addrs, opts.skipDecodeErrors()))
+		opts.Tracer.Done()
+		if diags.HasErrors() {
+			return diags
+		}
+	}
+
+	// The state doesn't record when each object was created, so there's
+	// nothing for this guard to compare against the configuration yet. We
+	// still accept the option so that scripts can use it now and get the
+	// protection once creation times are available.
+	if opts.IfNewerThanConfig && !opts.Force && len(sel.Addrs) > 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Creation times not recorded",
+			fmt.Sprintf("The -if-newer-than-config option protects resource instances created since the configuration was last changed, but the state does not record when its objects were created. None of the %d resource instances selected for removal could be checked.", len(sel.Addrs)),
+		))
+	}
+
+	if opts.ConfirmFile != "" && !opts.DryRun {
+		approved, err := ioutil.ReadFile(opts.ConfirmFile)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to read approval token",
+				fmt.Sprintf("Could not read the approval token file %s: %s.", opts.ConfirmFile, err),
+			))
+			return diags
+		}
+		if want := stateRmApprovalToken(sel.Addrs); strings.TrimSpace(string(approved)) != want {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Removal not approved",
+				fmt.Sprintf("The approval token in %s does not match the set of resource instances that would be removed, so the approver did not review exactly this removal. Review the output of \"terraform state rm -dry-run\" with the same arguments and then generate a new token using -dry-run -approval-token.", opts.ConfirmFile),
+			))
+			return diags
+		}
+	}
+
+	if opts.StdinConfirmToken && !opts.DryRun {
+		approved, err := opts.Env.readApprovalToken()
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to read approval token",
+				fmt.Sprintf("Could not read the approval token from stdin: %s.", err),
+			))
+			return diags
+		}
+		if want := stateRmApprovalToken(sel.Addrs); approved != want {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Removal not approved",
+				"The approval token given on stdin does not match the set of resource instances that would be removed, so the set has changed since it was approved. Review the output of \"terraform state rm -dry-run -stdin-confirm-token\" with the same arguments and approve the new token it prints.",
+			))
+			return diags
+		}
+	}
+
+	return diags
+}
+
+// removeStateRmInstances removes the given resource instances, modules and
+// output values from the given state, recording what it removed in the
+// given result. This is the removal made by runStateRm once it has selected
+// and checked what to remove, given only the options before Env.
+//
+// Either all of the given instances are removed or, if any of them cannot
+// be, the state is left unchanged and error diagnostics are returned. With
+// opts.ContinueOnError, the instances that cannot be removed are instead
+// recorded in the result's Skipped and the others are removed.
+func removeStateRmInstances(state *states.State, opts *stateRmOpts, result *stateRmResult) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	// We will first check that all of the instances are present, so we can
+	// either remove all of them successfully or make no change at all.
+	seen := make(map[string]bool, len(opts.Addrs))
+	for _, addr := range opts.Addrs {
+		if seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+
+		is := state.ResourceInstance(addr)
+		if is == nil {
+			diag := tfdiags.Sourceless(
+				tfdiags.Error,
+				"No such resource instance in state",
+				fmt.Sprintf("There is no resource instance in the current state with the address %s.", addr),
+			)
+			if opts.ContinueOnError {
+				result.Skipped = append(result.Skipped, &stateRmSkipped{Addr: addr, Diag: diag})
+				continue
+			}
+			diags = diags.Append(diag)
+			continue
+		}
+
+		item := &stateRmItem{
+			Addr:           addr,
+			Instance:       is.DeepCopy(),
+			ProviderConfig: state.Resource(addr.ContainingResource()).ProviderConfig,
+			Current:        is.Current != nil,
+		}
+		for k := range is.Deposed {
+			item.Deposed = append(item.Deposed, k)
+		}
+		sort.Slice(item.Deposed, func(i, j int) bool {
+			return item.Deposed[i] < item.Deposed[j]
+		})
+		result.Items = append(result.Items, item)
+
+		if item.Current {
+			result.CurrentCount++
+		}
+		result.DeposedCount += len(item.Deposed)
+	}
+	if diags.HasErrors() {
+		return diags
+	}
+
+	if opts.RemoveOutputs {
+		result.Outputs = stateModuleOutputValues(state, opts.Modules)
+	}
+	if len(opts.Outputs) > 0 {
+		result.Outputs = mergeStateOutputValues(state, result.Outputs, opts.Outputs)
+	}
+	if opts.DryRun {
+		return diags
+	}
+
+	// Now we will actually remove them. Due to our validation above, we should
+	// succeed in removing every one that wasn't skipped.
+	// We'll use the "SyncState" wrapper to do this not because we're doing
+	// any concurrent work here (we aren't) but because it guarantees to clean
+	// up any leftover empty module we might leave behind.
+	ss := state.SyncWrapper()
+	for _, item := range result.Items {
+		logStateRmObjects(item)
+		ss.ForgetResourceInstanceAll(item.Addr)
+	}
+	for _, addr := range result.Outputs {
+		ss.RemoveOutputValue(addr)
+	}
+
+	return diags
+}
+
+// stateRmSelectedCount returns the number of distinct resource instances
+// among the given instances that are in the given state, for -assert-count.
+// An instance can be selected by more than one selector, but is only removed
+// once.
+func stateRmSelectedCount(state *states.State, instances []addrs.AbsResourceInstance) int {
+	seen := make(map[string]bool, len(instances))
+	for _, addr := range instances {
+		if state.ResourceInstance(addr) != nil {
+			seen[addr.String()] = true
+		}
+	}
+	return len(seen)
+}
+
+// stateRmProtectedInstances returns the addresses of the given instances whose
+// resource types are among the given protected types, for the -fail-on
+// option. Data resource types are given with a "data." prefix, as in the
+// type summary.
+func stateRmProtectedInstances(instances []addrs.AbsResourceInstance, types []string) []addrs.AbsResourceInstance {
+	if len(types) == 0 {
+		return nil
+	}
+	protected := make(map[string]bool, len(types))
+	for _, ty := range types {
+		protected[ty] = true
+	}
+
+	var ret []addrs.AbsResourceInstance
+	seen := make(map[string]bool)
+	for _, addr := range instances {
+		if seen[addr.String()] || !protected[stateRmTypeKey(addr)] {
+			continue
+		}
+		seen[addr.String()] = true
+		ret = append(ret, addr)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Less(ret[j])
+	})
+	return ret
+}
+
+// stateRmReference describes a resource instance kept by -keep-if-referenced,
+// as found by filterReferencedInstances.
+type stateRmReference struct {
+	Addr addrs.AbsResourceInstance
+
+	// By are the instances that depend on Addr, in sorted order.
+	By []addrs.AbsResourceInstance
+}
+
+func (r *stateRmReference) String() string {
+	by := make([]string, len(r.By))
+	for i, addr := range r.By {
+		by[i] = addr.String()
+	}
+	return fmt.Sprintf("%s, depended on by %s", r.Addr, strings.Join(by, ", "))
+}
+
+// filterReferencedInstances separates the given instances into those that
+// can be removed and those that are still recorded as a dependency of one of
+// the objects of an instance that is not among them.
+//
+// A dependency on a resource or resource instance matches the instances of
+// that resource in the same module, and a dependency on a module call or one
+// of its outputs matches every instance within that module call.
+func filterReferencedInstances(state *states.State, instances []addrs.AbsResourceInstance) ([]addrs.AbsResourceInstance, []*stateRmReference) {
+	selected := make(map[string]bool, len(instances))
+	for _, addr := range instances {
+		selected[addr.String()] = true
+	}
+
+	type dependency struct {
+		Module addrs.ModuleInstance
+		Ref    addrs.Referenceable
+		By     addrs.AbsResourceInstance
+	}
+	var deps []dependency
+	for _, ms := range state.Modules {
+		for _, rs := range ms.Resources {
+			for key, is := range rs.Instances {
+				addr := rs.Addr.Instance(key).Absolute(ms.Addr)
+				if selected[addr.String()] {
+					continue
+				}
+				objs := make([]*states.ResourceInstanceObjectSrc, 0, len(is.Deposed)+1)
+				if is.Current != nil {
+					objs = append(objs, is.Current)
+				}
+				for _, obj := range is.Deposed {
+					objs = append(objs, obj)
+				}
+				for _, obj := range objs {
+					for _, ref := range obj.Dependencies {
+						deps = append(deps, dependency{Module: ms.Addr, Ref: ref, By: addr})
+					}
+				}
+			}
+		}
+	}
+
+	var remaining []addrs.AbsResourceInstance
+	var kept []*stateRmReference
+	for _, addr := range instances {
+		var by []addrs.AbsResourceInstance
+		seen := make(map[string]bool)
+		for _, dep := range deps {
+			if seen[dep.By.String()] || !dependencyMatches(dep.Module, dep.Ref, addr) {
+				continue
+			}
+			seen[dep.By.String()] = true
+			by = append(by, dep.By)
+		}
+		if len(by) == 0 {
+			remaining = append(remaining, addr)
+			continue
+		}
+		sort.Slice(by, func(i, j int) bool {
+			return by[i].Less(by[j])
+		})
+		kept = append(kept, &stateRmReference{Addr: addr, By: by})
+	}
+	return remaining, kept
+}
+
+// dependencyMatches returns true if the given dependency, recorded for an
+// object in the given module, refers to the given resource instance.
+//
+// Dependencies on whole resources are read back from a state file as
+// instances without a key, so such a dependency refers to every instance of
+// the resource.
+func dependencyMatches(modAddr addrs.ModuleInstance, ref addrs.Referenceable, addr addrs.AbsResourceInstance) bool {
+	switch ref := ref.(type) {
+	case addrs.Resource:
+		return addr.Module.Equal(modAddr) && addr.Resource.Resource.Equal(ref)
+	case addrs.ResourceInstance:
+		if ref.Key == addrs.NoKey {
+			return addr.Module.Equal(modAddr) && addr.Resource.Resource.Equal(ref.Resource)
+		}
+		return addr.Module.Equal(modAddr) && addr.Resource.Equal(ref)
+	case addrs.ModuleCall:
+		n := len(modAddr)
+		return len(addr.Module) > n && addr.Module[:n].Equal(modAddr) && addr.Module[n].Name == ref.Name
+	case addrs.ModuleCallInstance:
+		return moduleWithinScope(addr.Module, ref.ModuleInstance(modAddr))
+	case addrs.ModuleCallOutput:
+		return moduleWithinScope(addr.Module, ref.Call.ModuleInstance(modAddr))
+	default:
+		return false
+	}
+}
+
+// StateRmLogContentsEnvVar is the name of the environment variable that, if
+// set to anything but the empty string, makes logStateRmObjects include the
+// full content of each removed object in the debug log.
+const StateRmLogContentsEnvVar = "TF_STATE_RM_LOG_CONTENTS"
+
+// logStateRmObjects writes a record of each of the objects of the given item
+// to the debug log before they are removed.
+//
+// Only the address, status and schema version of each object are logged,
+// along with the sizes of its attributes and private data and the same
+// SHA-256 hash that -object-hash-manifest records. The attributes and
+// private data themselves are left out unless StateRmLogContentsEnvVar is
+// set: they often hold passwords and keys, and TF_LOG output is routinely
+// pasted into bug reports. The hash is enough to check an object recovered
+// from a backup or from the -save-removed or -export-before copies against
+// the one that was removed.
+func logStateRmObjects(item *stateRmItem) {
+	contents := os.Getenv(StateRmLogContentsEnvVar) != ""
+	if obj := item.Instance.Current; obj != nil {
+		logStateRmObject(item.Addr.String(), obj, contents)
+	}
+	for _, k := range item.Deposed {
+		logStateRmObject(fmt.Sprintf("%s deposed object %s", item.Addr, k), item.Instance.Deposed[k], contents)
+	}
+}
+
+func logStateRmObject(desc string, obj *states.ResourceInstanceObjectSrc, contents bool) {
+	size := len(obj.AttrsJSON)
+	if obj.AttrsJSON == nil {
+		for k, v := range obj.AttrsFlat {
+			size += len(k) + len(v)
+		}
+	}
+	sum := "unavailable"
+	if src, err := stateRmObjectSum(obj); err == nil {
+		sum = fmt.Sprintf("%x", src)
+	}
+	log.Printf("[DEBUG] command: removing %s (status %s, schema version %d): %d bytes of attributes, %d bytes of private data, sha256 %s", desc, obj.Status, obj.SchemaVersion, size, len(obj.Private), sum)
+
+	if !contents {
+		return
+	}
+	if obj.AttrsJSON != nil {
+		log.Printf("[DEBUG] command: content of %s: attributes %s, private %q", desc, obj.AttrsJSON, obj.Private)
+		return
+	}
+	log.Printf("[DEBUG] command: content of %s: flat attributes %#v, private %q", desc, obj.AttrsFlat, obj.Private)
+}
+
+// stateRmRemovedState returns a new state containing only the objects
+// removed by runStateRm, as described by the given result.
+//
+// Unless retainSchemaVersion is set, the schema version of each of the
+// objects is reset to zero, and so a provider will try to upgrade them from
+// its earliest schema version if they are later restored.
+func stateRmRemovedState(result *stateRmResult, retainSchemaVersion bool) *states.State {
+	return states.BuildState(func(s *states.SyncState) {
+		for _, item := range result.Items {
+			is := item.Instance.DeepCopy()
+			if !retainSchemaVersion {
+				if is.Current != nil {
+					is.Current.SchemaVersion = 0
+				}
+				for _, obj := range is.Deposed {
+					obj.SchemaVersion = 0
+				}
+			}
+
+			if is.Current != nil {
+				s.SetResourceInstanceCurrent(item.Addr, is.Current, item.ProviderConfig)
+			}
+			for _, k := range item.Deposed {
+				s.SetResourceInstanceDeposed(item.Addr, k, is.Deposed[k], item.ProviderConfig)
+			}
+		}
+	})
+}
+
+// stateRmCheckLineage checks whether the latest state snapshot from the given
+// manager belongs to the given lineage, returning an error if it doesn't.
+//
+// Lineage is recorded only for a state snapshot as a whole, not for the
+// individual objects within it, so objects from a foreign lineage that have
+// been merged into a snapshot can't be identified. If the snapshot's lineage
+// matches, the result is a warning describing that limitation.
+func stateRmCheckLineage(stateMgr statemgr.Full, expected string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	metaMgr, ok := stateMgr.(statemgr.PersistentMeta)
+	if !ok {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"State lineage not available",
+			"The current backend doesn't report the lineage of its state snapshots, so the -foreign-lineage option can't check it.",
+		))
+		return diags
+	}
+
+	lineage := metaMgr.StateSnapshotMeta().Lineage
+	if lineage != expected {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"State lineage mismatch",
+			fmt.Sprintf("The state snapshot has lineage %q, but -foreign-lineage expects %q.\n\nLineage isn't recorded for the individual objects in a state snapshot, so Terraform can't tell which of them came from the expected lineage, and so nothing has been removed. Use \"terraform state list\" to review the state, and then remove any objects that don't belong by giving their addresses.", lineage, expected),
+		))
+		return diags
+	}
+
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Foreign objects can't be identified",
+		fmt.Sprintf("The state snapshot has the expected lineage %q. Lineage isn't recorded for the individual objects in a state snapshot, so any objects that were merged into it from a snapshot with a different lineage can't be identified, and will not be removed by the -foreign-lineage option.", expected),
+	))
+	return diags
+}
+
+// stateRmAssertLineage returns an error unless the latest state snapshot read
+// by the given state manager has the given lineage, for -assert-lineage.
+func stateRmAssertLineage(stateMgr statemgr.Full, expected string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	metaMgr, ok := stateMgr.(statemgr.PersistentMeta)
+	if !ok {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"State lineage not available",
+			"The current backend doesn't report the lineage of its state snapshots, so the -assert-lineage option can't check it. Nothing has been changed.",
+		))
+		return diags
+	}
+
+	if actual := metaMgr.StateSnapshotMeta().Lineage; actual != expected {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Unexpected state lineage",
+			fmt.Sprintf("The -assert-lineage option expects the state to have lineage\n\n  %s\n\nbut the state that was loaded has lineage\n\n  %s\n\nThis may be the wrong state, or the wrong workspace. Nothing has been changed.", expected, actual),
+		))
+	}
+	return diags
+}
+
+// lineageReport prints the lineage, serial, and Terraform version of the
+// latest state snapshot read by the given state manager, along with counts of
+// the objects in the given state, for the -lineage-report option. If asserted
+// is set, the lineage was already checked against it by -assert-lineage.
+func (c *StateRmCommand) lineageReport(stateMgr statemgr.Full, state *states.State, asserted string) int {
+	lineage, serial, tfVersion := "unknown", "unknown", "unknown"
+	if metaMgr, ok := stateMgr.(statemgr.PersistentMeta); ok {
+		meta := metaMgr.StateSnapshotMeta()
+		if meta.Lineage != "" {
+			lineage = meta.Lineage
+		}
+		serial = strconv.FormatUint(meta.Serial, 10)
+		if meta.TerraformVersion != nil {
+			tfVersion = meta.TerraformVersion.String()
+		}
+	}
+
+	instances, current, deposed := 0, 0, 0
+	for _, ms := range state.Modules {
+		for _, rs := range ms.Resources {
+			for _, is := range rs.Instances {
+				instances++
+				if is.Current != nil {
+					current++
+				}
+				deposed += len(is.Deposed)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Lineage:            %s\n", lineage)
+	fmt.Fprintf(&buf, "Serial:             %s\n", serial)
+	fmt.Fprintf(&buf, "Terraform version:  %s\n", tfVersion)
+	fmt.Fprintf(&buf, "Modules:            %d\n", len(state.Modules))
+	fmt.Fprintf(&buf, "Resource instances: %d\n", instances)
+	fmt.Fprintf(&buf, "Objects:            %d (%d current, %d deposed)\n", current+deposed, current, deposed)
+	if asserted != "" {
+		buf.WriteString("\nThe lineage matches -assert-lineage. Lineage is recorded only for the state snapshot as a whole, so all of its objects share it.\n")
+	}
+	c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+	return 0
+}
+
+// planJSONDeletedInstances returns the addresses of all of the resource
+// instances that the JSON plan in the file at the given path, as produced by
+// "terraform show -json", would delete.
+//
+// Only changes whose sole action is "delete" are selected: an instance that
+// would be replaced is still managed by the configuration, and a change to a
+// deposed object doesn't affect the instance's current object.
+func planJSONDeletedInstances(path string) ([]addrs.AbsResourceInstance, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to read plan",
+			fmt.Sprintf("Could not read the JSON plan file %s: %s.", path, err),
+		))
+		return nil, diags
+	}
+
+	var plan struct {
+		ResourceChanges []struct {
+			Address string `json:"address"`
+			Deposed string `json:"deposed"`
+			Change  struct {
+				Actions []string `json:"actions"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(src, &plan); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid JSON plan",
+			fmt.Sprintf("The file %s does not contain a JSON plan as produced by \"terraform show -json\": %s.", path, err),
+		))
+		return nil, diags
+	}
+
+	var ret []addrs.AbsResourceInstance
+	for _, rc := range plan.ResourceChanges {
+		if rc.Deposed != "" || len(rc.Change.Actions) != 1 || rc.Change.Actions[0] != "delete" {
+			continue
+		}
+		addr, addrDiags := addrs.ParseAbsResourceInstanceStr(rc.Address)
+		if addrDiags.HasErrors() {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid JSON plan",
+				fmt.Sprintf("The plan in %s has a resource change with the invalid address %q.", path, rc.Address),
+			))
+			continue
+		}
+		ret = append(ret, addr)
+	}
+	return ret, diags
+}
+
+// parseStateRmMode parses the value of the -mode option, returning false if
+// it isn't valid. Resources of any mode are selected by "all", or by an empty
+// string, for which the mode returned is addrs.InvalidResourceMode.
+func parseStateRmMode(raw string) (addrs.ResourceMode, bool) {
+	switch raw {
+	case "managed":
+		return addrs.ManagedResourceMode, true
+	case "data":
+		return addrs.DataResourceMode, true
+	case "all", "":
+		return addrs.InvalidResourceMode, true
+	default:
+		return addrs.InvalidResourceMode, false
+	}
+}
+
+// filterResourceInstancesByMode returns only those of the given addresses
+// that belong to resources of the given mode, preserving their order.
+func filterResourceInstancesByMode(instances []addrs.AbsResourceInstance, mode addrs.ResourceMode) []addrs.AbsResourceInstance {
+	var ret []addrs.AbsResourceInstance
+	for _, addr := range instances {
+		if addr.Resource.Resource.Mode == mode {
+			ret = append(ret, addr)
+		}
+	}
+	return ret
+}
+
+// stateRmProviderCounts returns the number of the given resource instances
+// that belong to each provider configuration, keyed by the address of the
+// configuration, for the -diff-providers and -max-providers options.
+// Instances that aren't in the given state are not counted.
+func stateRmProviderCounts(state *states.State, instances []addrs.AbsResourceInstance) map[string]int {
+	ret := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, addr := range instances {
+		if seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		rs := state.Resource(addr.ContainingResource())
+		if rs == nil || rs.Instance(addr.Resource.Key) == nil {
+			continue
+		}
+		ret[rs.ProviderConfig.String()]++
+	}
+	return ret
+}
+
+// checkProvidersInstalled returns a warning if any of the given instances in
+// the given state belong to providers that aren't installed, for the
+// -validate-providers option.
+func (c *StateRmCommand) checkProvidersInstalled(state *states.State, instances []addrs.AbsResourceInstance) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	counts := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, addr := range instances {
+		if seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		rs := state.Resource(addr.ContainingResource())
+		if rs == nil || rs.Instance(addr.Resource.Key) == nil {
+			continue
+		}
+		counts[rs.ProviderConfig.ProviderConfig.Type]++
+	}
+
+	reqd := make(discovery.PluginRequirements, len(counts))
+	for name := range counts {
+		reqd[name] = &discovery.PluginConstraints{Versions: discovery.AllVersions}
+	}
+
+	var resolver providers.Resolver
+	if c.testingOverrides != nil {
+		resolver = c.testingOverrides.ProviderResolver
+	} else {
+		resolver = c.providerResolver()
+	}
+	factories, _ := resolver.ResolveProviders(reqd)
+
+	missing := make(map[string]int)
+	for name, count := range counts {
+		if _, ok := factories[name]; !ok {
+			missing["provider."+name] = count
+		}
+	}
+	if len(missing) == 0 {
+		return diags
+	}
+
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Providers not installed",
+		fmt.Sprintf("Some of the resource instances selected for removal belong to providers that aren't installed:\n\n%s\n\nIf the provider was removed from the configuration by mistake, restore it and run \"terraform init\" instead of removing its resource instances from the state.", strings.Join(stateRmProviderLines(missing), "\n")),
+	))
+	return diags
+}
+
+// stateRmProviderLines returns an indented line for each of the provider
+// configurations in the given counts, ordered by address.
+func stateRmProviderLines(counts map[string]int) []string {
+	names := make([]string, 0, len(counts))
+	for provider := range counts {
+		names = append(names, provider)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, provider := range names {
+		lines[i] = fmt.Sprintf("  %s: %d", provider, counts[provider])
+	}
+	return lines
+}
+
+// filterResourceInstancesByModule returns only those of the given instances
+// that belong to the given module instance or to one of its descendants, for
+// the -scope-module option, along with the number of instances that didn't.
+func filterResourceInstancesByModule(instances []addrs.AbsResourceInstance, scope addrs.ModuleInstance) ([]addrs.AbsResourceInstance, int) {
+	var ret []addrs.AbsResourceInstance
+	skipped := 0
+	for _, addr := range instances {
+		if moduleWithinScope(addr.Module, scope) {
+			ret = append(ret, addr)
+		} else {
+			skipped++
+		}
+	}
+	return ret, skipped
+}
 
-	var diags tfdiags.Diagnostics
+// moduleWithinScope returns true if the given module instance is the given
+// scope module instance or one of its descendants.
+func moduleWithinScope(addr, scope addrs.ModuleInstance) bool {
+	return addr.Equal(scope) || scope.IsAncestor(addr)
+}
 
-	if len(args) < 1 {
-		c.Ui.Error("At least one resource address is required.")
-		return 1
+// readAddressFile reads the addresses listed in the file at the given path,
+// for the -from-file option, in the given format. The
+// path "-" reads them from stdin instead.
+//
+// In the "text" format, each line of the file is a single address, and blank
+// lines and lines starting with "#" are ignored. In the "json" format, the
+// file is a JSON array whose elements are either address strings or objects
+// with an "address" property, as produced by "terraform state list -json".
+// If no format is given, files with a ".json" extension are read as JSON and
+// all others as text.
+func readAddressFile(path, format string) ([]string, error) {
+	if format == "" {
+		format = "text"
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			format = "json"
+		}
+	}
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("the -address-file-format option must be \"json\" or \"text\", not %q", format)
 	}
 
-	stateMgr, err := c.State()
+	var src []byte
+	var err error
+	if path == "-" {
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(path)
+	}
 	if err != nil {
-		c.Ui.Error(fmt.Sprintf(errStateLoadingState, err))
-		return 1
+		return nil, err
 	}
-	if err := stateMgr.RefreshState(); err != nil {
-		c.Ui.Error(fmt.Sprintf("Failed to load state: %s", err))
-		return 1
+	if format == "json" {
+		return parseAddressFileJSON(src)
 	}
 
-	state := stateMgr.State()
-	if state == nil {
-		c.Ui.Error(fmt.Sprintf(errStateNotFound))
-		return 1
+	var ret []string
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ret = append(ret, line)
+	}
+	return ret, nil
+}
+
+// parseAddressFileJSON parses the given JSON address file, as described for
+// readAddressFile. Errors include the offending part of the file, so that
+// it can be found and corrected in a hand-edited file.
+func parseAddressFileJSON(src []byte) ([]string, error) {
+	var elems []json.RawMessage
+	if err := json.Unmarshal(src, &elems); err != nil {
+		if synErr, ok := err.(*json.SyntaxError); ok {
+			line, text := addressFileLine(src, synErr.Offset)
+			return nil, fmt.Errorf("invalid JSON on line %d: %s:\n  %s", line, err, text)
+		}
+		return nil, fmt.Errorf("the file must contain a JSON array: %s", err)
+	}
+
+	ret := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		var addr string
+		if err := json.Unmarshal(elem, &addr); err == nil {
+			ret = append(ret, addr)
+			continue
+		}
+		var obj struct {
+			Address *string `json:"address"`
+		}
+		if err := json.Unmarshal(elem, &obj); err != nil || obj.Address == nil {
+			return nil, fmt.Errorf("each element of the JSON array must be an address string or an object with an \"address\" property, not:\n  %s", elem)
+		}
+		ret = append(ret, *obj.Address)
+	}
+	return ret, nil
+}
+
+// addressFileLine returns the line number and the trimmed text of the line
+// containing the given byte offset in the given source.
+func addressFileLine(src []byte, offset int64) (int, string) {
+	if offset > int64(len(src)) {
+		offset = int64(len(src))
+	}
+	before := src[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	start := bytes.LastIndexByte(before, '\n') + 1
+	end := bytes.IndexByte(src[start:], '\n')
+	if end < 0 {
+		end = len(src) - start
+	}
+	return line, strings.TrimSpace(string(src[start : start+end]))
+}
+
+// readRemovedBlocksFile reads the HCL file at the given path, for the
+// -by-resource-file option, and returns the "from" address of each of the
+// "removed" blocks in it, in the order they appear. Any other arguments and
+// blocks within a "removed" block, such as lifecycle, are ignored, as are
+// any other top-level blocks.
+func (c *StateRmCommand) readRemovedBlocksFile(path string) ([]string, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to read removed blocks",
+			fmt.Sprintf("Could not read %s: %s.", path, err),
+		))
+		return nil, diags
+	}
+	c.registerSynthConfigSource(path, src)
+
+	f, hclDiags := hclsyntax.ParseConfig(src, path, hcl.Pos{Line: 1, Column: 1})
+	diags = diags.Append(hclDiags)
+	if hclDiags.HasErrors() {
+		return nil, diags
+	}
+
+	content, _, hclDiags := f.Body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "removed"}},
+	})
+	diags = diags.Append(hclDiags)
+
+	var ret []string
+	for _, block := range content.Blocks {
+		blockContent, _, hclDiags := block.Body.PartialContent(&hcl.BodySchema{
+			Attributes: []hcl.AttributeSchema{{Name: "from", Required: true}},
+		})
+		diags = diags.Append(hclDiags)
+		attr, ok := blockContent.Attributes["from"]
+		if !ok {
+			continue
+		}
+
+		traversal, travDiags := hcl.AbsTraversalForExpr(attr.Expr)
+		diags = diags.Append(travDiags)
+		if travDiags.HasErrors() {
+			continue
+		}
+		if _, addrDiags := addrs.ParseModuleInstance(traversal); !addrDiags.HasErrors() {
+			ret = append(ret, strings.TrimSpace(string(attr.Expr.Range().SliceBytes(src))))
+			continue
+		}
+		if _, addrDiags := addrs.ParseAbsResourceInstance(traversal); addrDiags.HasErrors() {
+			diags = diags.Append(addrDiags)
+			continue
+		}
+		ret = append(ret, strings.TrimSpace(string(attr.Expr.Range().SliceBytes(src))))
+	}
+	return ret, diags
+}
+
+// stateRmRemovedFrom is the "from" address of a removed block, along with
+// the path of the file that the block is in.
+type stateRmRemovedFrom struct {
+	Path string
+	From string
+}
+
+// readConfigRemovedBlocks reads the "removed" blocks from the configuration
+// files of the root module in the given directory, for the
+// -apply-removed-blocks option, returning their "from" addresses in the
+// order of the files and of the blocks within each file.
+//
+// The configuration loader doesn't accept removed blocks, so the files are
+// read directly, as with -by-resource-file. Only the root module's own files
+// in the native syntax are read, and override files are read like any other.
+func (c *StateRmCommand) readConfigRemovedBlocks(dir string) ([]stateRmRemovedFrom, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to read configuration",
+			fmt.Sprintf("Could not read the configuration files in %s: %s.", dir, err),
+		))
+		return nil, diags
 	}
 
-	toRemove := make([]addrs.AbsResourceInstance, len(args))
-	for i, rawAddr := range args {
-		addr, moreDiags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+	var ret []stateRmRemovedFrom
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".tf") || configs.IsIgnoredFile(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		froms, moreDiags := c.readRemovedBlocksFile(path)
 		diags = diags.Append(moreDiags)
-		toRemove[i] = addr
+		for _, from := range froms {
+			ret = append(ret, stateRmRemovedFrom{Path: path, From: from})
+		}
 	}
 	if diags.HasErrors() {
-		c.showDiagnostics(diags)
-		return 1
+		return nil, diags
+	}
+	if len(ret) == 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No removed blocks in configuration",
+			fmt.Sprintf("The -apply-removed-blocks option found no removed blocks in the configuration files in %s.", dir),
+		))
+	}
+	return ret, diags
+}
+
+// stateRmSelectorInState returns true if the resource instance, resource, or
+// module instance at the given address has anything in the given state.
+func stateRmSelectorInState(state *states.State, rawAddr string) bool {
+	if modAddr, ok := parseModuleInstanceArg(rawAddr); ok {
+		return state.Module(modAddr) != nil
+	}
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+	if diags.HasErrors() {
+		// The address was already validated when it was read, but if it
+		// somehow isn't valid then we let the usual parsing report it.
+		return true
 	}
+	return state.ResourceInstance(addr) != nil || len(stateResourceKeyedInstances(state, addr)) > 0
+}
 
-	// We will first check that all of the instances are present, so we can
-	// either remove all of them successfully or make no change at all.
-	// (If we're in dry run mode, this is also where we print out what
-	// we would've done.)
-	var currentCount, deposedCount int
-	var dryRunBuf bytes.Buffer
-	for _, addr := range toRemove {
-		is := state.ResourceInstance(addr)
-		if is == nil {
-			diags = diags.Append(tfdiags.Sourceless(
-				tfdiags.Error,
-				"No such resource instance in state",
-				fmt.Sprintf("There is no resource instance in the current state with the address %s.", addr),
-			))
+// stateRmDecodeError returns the diagnostic for a resource instance whose
+// attributes in the state could not be decoded for the given purpose. With
+// -decode-errors=skip this is only a warning, since removing the instance
+// doesn't itself need its attributes, and the caller carries on as if the
+// purpose didn't apply to it. Otherwise, it is an error.
+func stateRmDecodeError(addr addrs.AbsResourceInstance, purpose string, err error, skip bool) tfdiags.Diagnostic {
+	if skip {
+		return tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Invalid resource instance object",
+			fmt.Sprintf("The attributes of %s in the state could not be decoded to %s: %s. It is still removed, without the check. Use -decode-errors=fail to stop instead.", addr, purpose, err),
+		)
+	}
+	return tfdiags.Sourceless(
+		tfdiags.Error,
+		"Invalid resource instance object",
+		fmt.Sprintf("The attributes of %s in the state could not be decoded to %s: %s.", addr, purpose, err),
+	)
+}
+
+// parseModuleInstanceArg parses the given raw command line argument as a
+// module instance address, returning false if it isn't a valid address for
+// a module other than the root module.
+func parseModuleInstanceArg(rawAddr string) (addrs.ModuleInstance, bool) {
+	addr, diags := addrs.ParseModuleInstanceStr(rawAddr)
+	if diags.HasErrors() || addr.IsRoot() {
+		return nil, false
+	}
+	return addr, true
+}
+
+// stateResourceKeyedInstances returns the addresses of all of the instances
+// of the resource containing the given instance address, in order, if the
+// address has no instance key and the resource has instances with keys.
+// Otherwise, the result is empty.
+func stateResourceKeyedInstances(state *states.State, addr addrs.AbsResourceInstance) []addrs.AbsResourceInstance {
+	if addr.Resource.Key != addrs.NoKey || state.ResourceInstance(addr) != nil {
+		return nil
+	}
+	rs := state.Resource(addr.ContainingResource())
+	if rs == nil {
+		return nil
+	}
+
+	var ret []addrs.AbsResourceInstance
+	for key := range rs.Instances {
+		ret = append(ret, addr.ContainingResource().Instance(key))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Less(ret[j])
+	})
+	return ret
+}
+
+// stateRmInstanceKeys returns the instance keys of the given addresses, as
+// they would appear in the addresses, separated by commas.
+func stateRmInstanceKeys(instances []addrs.AbsResourceInstance) string {
+	keys := make([]string, len(instances))
+	for i, addr := range instances {
+		keys[i] = addr.Resource.Key.String()
+	}
+	return strings.Join(keys, ", ")
+}
+
+// stateResourceTypeInstances returns the addresses of all of the instances of
+// resources of the given type in the given state, in sorted order, for the
+// -resource-type option. As with -fail-on, a data resource type is given
+// with a "data." prefix.
+func stateResourceTypeInstances(state *states.State, ty string) []addrs.AbsResourceInstance {
+	var ret []addrs.AbsResourceInstance
+	for _, addr := range stateAllResourceInstances(state) {
+		if stateRmTypeKey(addr) == ty {
+			ret = append(ret, addr)
+		}
+	}
+	return ret
+}
+
+// stateModuleResourceInstances returns the addresses of all of the resource
+// instances in the given module instance and its descendents, in order.
+func stateModuleResourceInstances(state *states.State, modAddr addrs.ModuleInstance) []addrs.AbsResourceInstance {
+	var ret []addrs.AbsResourceInstance
+	for _, ms := range state.Modules {
+		if !modAddr.TargetContains(ms.Addr) {
 			continue
 		}
-		if is.Current != nil {
-			currentCount++
+		for _, rs := range ms.Resources {
+			for key := range rs.Instances {
+				ret = append(ret, rs.Addr.Instance(key).Absolute(ms.Addr))
+			}
 		}
-		deposedCount += len(is.Deposed)
-		if dryRun {
-			if is.Current != nil {
-				fmt.Fprintf(&dryRunBuf, "Would remove %s\n", addr)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Less(ret[j])
+	})
+	return ret
+}
+
+// stateModuleOutputValues returns the addresses of all of the output values
+// in the given module instances and their descendents, in lexical order.
+func stateModuleOutputValues(state *states.State, modAddrs []addrs.ModuleInstance) []addrs.AbsOutputValue {
+	var ret []addrs.AbsOutputValue
+	for _, ms := range state.Modules {
+		for _, modAddr := range modAddrs {
+			if !modAddr.TargetContains(ms.Addr) {
+				continue
 			}
-			for k := range is.Deposed {
-				fmt.Fprintf(&dryRunBuf, "Would remove %s deposed object %s\n", addr, k)
+			for name := range ms.OutputValues {
+				ret = append(ret, ms.Addr.OutputValue(name))
 			}
+			break
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].String() < ret[j].String()
+	})
+	return ret
+}
+
+// mergeStateOutputValues returns the addresses in base along with those in
+// more that are in the given state and not already in base, in lexical order.
+func mergeStateOutputValues(state *states.State, base, more []addrs.AbsOutputValue) []addrs.AbsOutputValue {
+	seen := make(map[string]bool, len(base))
+	for _, addr := range base {
+		seen[addr.String()] = true
+	}
+	ret := base
+	for _, addr := range more {
+		if seen[addr.String()] || state.OutputValue(addr) == nil {
+			continue
+		}
+		seen[addr.String()] = true
+		ret = append(ret, addr)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].String() < ret[j].String()
+	})
+	return ret
+}
+
+// stateRmApprovalToken returns a token identifying the given set of resource
+// instance addresses, regardless of their order or any duplicates. The
+// same set of addresses always produces the same token.
+func stateRmApprovalToken(toRemove []addrs.AbsResourceInstance) string {
+	seen := make(map[string]bool, len(toRemove))
+	var addrStrs []string
+	for _, addr := range toRemove {
+		addrStr := addr.String()
+		if !seen[addrStr] {
+			seen[addrStr] = true
+			addrStrs = append(addrStrs, addrStr)
+		}
+	}
+	sort.Strings(addrStrs)
+
+	sum := sha256.Sum256([]byte(strings.Join(addrStrs, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// orphanedInstances returns the addresses of all instances of the given
+// resources that are present in the state but whose instance keys are no
+// longer declared by the configuration.
+//
+// Rather than trying to evaluate count and for_each itself, this relies on
+// a plan against the current configuration: any current object of one of
+// the given resources that the plan would delete is an orphaned instance.
+func (c *StateRmCommand) orphanedInstances(state *states.State, rawAddrs []string) ([]addrs.AbsResourceInstance, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	resources := make([]addrs.AbsResource, 0, len(rawAddrs))
+	for _, rawAddr := range rawAddrs {
+		addr, addrDiags := addrs.ParseAbsResourceStr(rawAddr)
+		if addrDiags.HasErrors() {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid resource address",
+				fmt.Sprintf("The -orphan-keys option requires a resource address without an instance key, but %q is not a valid resource address.", rawAddr),
+			))
+			continue
 		}
+		resources = append(resources, addr)
 	}
 	if diags.HasErrors() {
-		c.showDiagnostics(diags)
-		return 1
+		return nil, diags
 	}
 
-	if dryRun {
-		c.Ui.Output(fmt.Sprintf("%s\nWould've removed %d current and %d deposed objects, without -dry-run.", dryRunBuf.String(), currentCount, deposedCount))
-		return 0 // This is as far as we go in dry-run mode
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		return nil, diags
 	}
 
-	// Now we will actually remove them. Due to our validation above, we should
-	// succeed in removing every one.
-	// We'll use the "SyncState" wrapper to do this not because we're doing
-	// any concurrent work here (we aren't) but because it guarantees to clean
-	// up any leftover empty module we might leave behind.
-	ss := state.SyncWrapper()
-	for _, addr := range toRemove {
-		ss.ForgetResourceInstanceAll(addr)
+	// A resource that has been removed from the configuration altogether
+	// isn't what this option is for, since then all of its instances
+	// would be selected.
+	for _, addr := range resources {
+		modCfg := config.DescendentForInstance(addr.Module)
+		if modCfg == nil || modCfg.Module.ResourceByAddr(addr.Resource) == nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Resource not in configuration",
+				fmt.Sprintf("The -orphan-keys option selects instances of %s that are no longer declared, but the resource itself is not in the configuration. To remove all of its instances, give the resource instance addresses as arguments instead.", addr),
+			))
+		}
+	}
+	if diags.HasErrors() {
+		return nil, diags
 	}
 
-	switch {
-	case currentCount == 0:
-		c.Ui.Output(fmt.Sprintf("Removed %d deposed objects.", deposedCount))
-	case deposedCount == 0:
-		c.Ui.Output(fmt.Sprintf("Removed %d objects.", currentCount))
-	default:
-		c.Ui.Output(fmt.Sprintf("Removed %d current and %d deposed objects.", currentCount, deposedCount))
+	plan, planDiags := c.planAgainstState(config, state)
+	diags = diags.Append(planDiags)
+	if planDiags.HasErrors() {
+		return nil, diags
 	}
 
-	if err := stateMgr.WriteState(state); err != nil {
-		c.Ui.Error(fmt.Sprintf(errStateRmPersist, err))
-		return 1
+	var ret []addrs.AbsResourceInstance
+	for _, addr := range resources {
+		for _, change := range plan.Changes.Resources {
+			if change.Action != plans.Delete || change.DeposedKey != states.NotDeposed {
+				continue
+			}
+			if change.Addr.ContainingResource().Equal(addr) {
+				ret = append(ret, change.Addr)
+			}
+		}
 	}
 
-	if err := stateMgr.PersistState(); err != nil {
-		c.Ui.Error(fmt.Sprintf(errStateRmPersist, err))
-		return 1
+	return ret, diags
+}
+
+// orphanedDataInstances returns the addresses of all instances of data
+// resources in the state whose data blocks are no longer present in the
+// configuration in the current working directory. Managed resources are
+// never included, regardless of whether they are in the configuration.
+func (c *StateRmCommand) orphanedDataInstances(state *states.State) ([]addrs.AbsResourceInstance, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		return nil, diags
 	}
 
-	c.Ui.Output("Updated state written successfully.")
-	return 0
+	orphans := configOrphanedInstances(config, state)
+	return filterResourceInstancesByMode(orphans, addrs.DataResourceMode), diags
 }
 
 func (c *StateRmCommand) Help() string {
@@ -146,7 +3917,18 @@ Usage: terraform state rm [options] ADDRESS...
 
   This command removes one or more resource instances from the Terraform state
   based on the addresses given. You can view and list the available instances
-  with "terraform state list".
+  with "terraform state list". Giving the address of a module removes all of
+  the resource instances in that module and in its descendent modules.
+
+  An instance key in an address can be "[*]" to match any key, or ["*"] to
+  match any string key, such as module.workers["*"].aws_instance.node or
+  aws_instance.node[*]. Wildcards can't be used in resource types or names,
+  and a "*" in any other string key, as in ["a*b"], is taken literally.
+
+  Before removing anything, the command lists the items to remove and asks
+  for confirmation, which -auto-approve skips. Declining exits with status 0
+  without changing anything. If none of the addresses match anything in the
+  state, the command exits with status 3 without changing anything.
 
   This command creates a timestamped backup of the state on every invocation.
   This can't be disabled. Due to the destructive nature of this command,
@@ -154,17 +3936,563 @@ Usage: terraform state rm [options] ADDRESS...
 
 Options:
 
-  -dry-run            If set, prints out what would've been removed but
+  -address=ADDR       The address of an item to remove, as an alternative to
+                      giving it as an argument. Can be specified multiple
+                      times, and used together with arguments.
+
+  -by-resource-file=PATH  Also remove the item given by the "from" argument
+                      of each "removed" block in the HCL file at PATH.
+
+  -apply-removed-blocks  Also remove the item given by the "from" argument
+                      of each "removed" block in the configuration files of
+                      the root module in the current directory, skipping
+                      any that are no longer in the state.
+
+  -address-file-format=FORMAT  The format of the file given by -from-file:
+                      either "text", with one address per line, or "json",
+                      with a JSON array of addresses or of objects with an
+                      "address" property, such as the output of
+                      "terraform state list -json". By default, files with
+                      a ".json" extension are read as JSON.
+
+  -from-file=PATH     Also remove the items whose addresses are listed in the
+                      file at PATH, one per line, or in stdin if PATH is
+                      "-", such as when they are piped from another
+                      command. Blank lines and lines starting with "#" are
+                      ignored. See also -address-file-format.
+
+  -addresses-from=PATH  Another name for -from-file. Only one of the two
+                      can be given.
+
+  -dry-run            If set, prints out what would've been removed, with a
+                      count of the instances of each resource type, but
                       doesn't actually remove anything.
 
+  -diff-providers     In dry-run mode, also list the distinct provider
+                      configurations of the instances that would be removed,
+                      with the number of instances of each.
+
+  -max-providers=N    Fail without removing anything, even with -dry-run, if
+                      the selected instances belong to more than N distinct
+                      provider configurations.
+
+  -max-removal-pct=N  Fail without removing anything, even with -dry-run, if
+                      the selected instances are more than N percent of all
+                      of the resource instances in the state. The error
+                      gives the actual percentage.
+
+  -max-state-size=SIZE  Fail without removing anything if the state would
+                      still be larger than SIZE after the removal, such as
+                      4MiB, for backends that limit the size of the objects
+                      they store. With -dry-run, the projected size is always
+                      printed.
+
+  -batch-size-report  Print the projected size of the state after the
+                      removal, compared with its current size.
+
+  -validate-providers Warn if any of the resource instances to remove belong
+                      to providers that aren't installed, which can mean
+                      that a provider was removed from the configuration by
+                      mistake.
+
+  -dry-run-exit-code  In dry-run mode, exit with status 2 rather than 0 if
+                      anything would've been removed.
+
+  -address-transform=s/OLD/NEW/  With -dry-run, also print each address
+                      that would be removed alongside the address that the
+                      given substitution turns it into, as a mapping for
+                      importing the objects again under a new naming
+                      scheme. OLD is a regular expression, and NEW can
+                      refer to its groups as $1. Add "g" at the end to
+                      replace every match. Nothing is imported.
+
+  -fail-if-empty      Exit with status 3 without changing anything if no
+                      resource instances are left to remove once all of the
+                      selection and filtering options have been applied.
+                      This is always done when addresses are given, and
+                      applies with -dry-run too.
+
+  -assert-count=N     Fail without removing anything, even with -dry-run,
+                      unless exactly N resource instances are selected for
+                      removal once all of the selection and filtering
+                      options have been applied. The error gives the actual
+                      count.
+
+  -approval-token     In dry-run mode, print only a token identifying the
+                      set of instances that would be removed, for use with
+                      -confirm-file.
+
+  -respect-prevent-destroy  Load the configuration in the current directory
+                      first, and fail without removing anything if any of
+                      the selected resource instances belongs to a resource
+                      that sets prevent_destroy = true, listing them. This
+                      applies with -dry-run too. Removing an instance from
+                      the state otherwise sidesteps prevent_destroy, so this
+                      is recommended for scripts and automation.
+
+  -require-clean-plan  Create a plan for the configuration in the current
+                      directory first, and fail without removing anything if
+                      it would change any of the selected resource instances,
+                      listing them. This applies with -dry-run too.
+
+  -conflict-check     Before removing anything, check whether another
+                      operation, such as an apply, holds the lock on the
+                      state, and fail without removing anything if it does.
+                      This applies with -dry-run too. It does nothing for a
+                      backend that doesn't support locking.
+
+  -refuse-on-drift    Refresh the selected resource instances using the
+                      providers of the configuration in the current
+                      directory first, and fail without removing anything if
+                      any of their real objects no longer match the state,
+                      listing the differences. This applies with -dry-run
+                      too.
+
+  -simulate-plan      In dry-run mode, also plan the configuration in the
+                      current directory against the state as it would be
+                      after the removal, and summarize the planned action
+                      for each removed instance.
+
+  -plan-file-out=PATH Also plan the configuration in the current directory
+                      against the state as the removal leaves it, and save
+                      the plan to PATH to review with "terraform show" or
+                      apply with "terraform apply". With -dry-run, nothing
+                      is removed, but applying the plan makes the removal
+                      as well as the planned changes.
+
+  -preview-apply-destroy-count
+                      In dry-run mode, also plan the configuration in the
+                      current directory against the state as it is, and
+                      report how many of the selected resource instances
+                      terraform apply would destroy or replace if they
+                      weren't removed.
+
+  -expand-for-each    Allow an address of a resource without an instance key
+                      to remove all of the instances of the resource, when
+                      it uses count or for_each. In dry-run mode, the keys
+                      that each such address expands to are listed.
+
+  -merge=SOURCE=DEST  Remove the resource instance SOURCE, which tracks the
+                      same real object as the instance DEST, and keep DEST.
+                      Fails without removing anything unless both have
+                      objects of the same resource type with the same id.
+                      With -dry-run, each merge is described. Can be given
+                      more than once.
+
+  -match-count        Print the number of resource instances that each address
+                      and each of the other selection options matched, one
+                      per line, without removing anything.
+
+  -partition-by-workspace  Print, for each address, which workspaces in the
+                      configured backend have resource instances matching it
+                      and how many, without changing anything. An address
+                      can contain "*" to match any part of the address of
+                      each resource instance.
+
+  -workspace-pattern=GLOB  Remove the given addresses from each workspace in
+                      the configured backend whose name matches GLOB, such
+                      as "dev-*", rather than from the current workspace.
+                      Every matching workspace is locked before any is
+                      changed, each gets its own backup, and the result is
+                      reported per workspace. Works with -dry-run. Exits
+                      with status 3 if nothing matches in any workspace.
+
+  -continue-on-error  Skip any selected resource instances that can't be
+                      removed, such as addresses that aren't in the state,
+                      and remove the others. The skipped instances are
+                      listed at the end, and the command exits with an
+                      error status.
+
+  -confirm-file=PATH  Only remove anything if the file at PATH contains the
+                      token printed by -dry-run -approval-token for exactly
+                      the set of instances that would be removed. This
+                      allows a second person to approve a removal.
+
+  -stdin-confirm-token  Like -confirm-file, but read the token from the
+                      first line of stdin. With -dry-run, the token for the
+                      removal is printed after the usual output, so that a
+                      later automated step can remove exactly the approved
+                      set, failing if it has changed.
+
+  -gc-orphan-data     Remove all data resource instances whose data blocks
+                      are no longer in the configuration in the current
+                      directory. Managed resources are never removed by
+                      this option.
+
+  -resource-type=TYPE  Remove all instances of resources of the given type.
+                      Prefix the type with "data." for a data resource type.
+                      This can be given multiple times, and with -dry-run the
+                      instances are listed by type.
+
+  -keep-latest=N      With -resource-type, keep the N newest instances of
+                      each of the types instead of removing them, ordered by
+                      the RFC 3339 timestamp attribute given with
+                      -keep-latest-by=PATH, such as -keep-latest-by=created.
+                      With -dry-run, the instances kept are listed too.
+
+  -assert-lineage=LINEAGE  Refuse to do anything unless the state has the
+                      given lineage, so that a script can't modify the
+                      wrong state or workspace by mistake.
+
+  -lineage-report     Print the lineage, serial, and Terraform version of the
+                      state snapshot, and the number of modules, resource
+                      instances, and objects in it, without removing
+                      anything. With -assert-lineage, the lineage is
+                      checked first.
+
+  -foreign-lineage=LINEAGE  Check that the state snapshot has the given
+                      lineage before removing anything, failing if it
+                      doesn't. Lineage is only tracked for the snapshot as a
+                      whole, so foreign objects within a snapshot that has
+                      the expected lineage can't be detected.
+
+  -removed-between=OLD..NEW  Remove the instances of the resources and
+                      modules that are declared in the root module in the
+                      current directory at the git revision OLD, but not at
+                      the revision NEW.
+
+  -from-plan-json=PATH  Remove all of the resource instances that the JSON
+                      plan in the file at PATH would delete, as produced by
+                      "terraform show -json". Replaced instances are not
+                      removed.
+
+  -mode=MODE          Only remove instances of resources of the given mode,
+                      which can be "managed", "data", or "all". Instances
+                      selected by other arguments but of a different mode
+                      are ignored. Defaults to "all".
+
+  -where=PATH=VALUE   Only remove the selected instances whose attribute at
+                      PATH, as shown by "terraform state show", has the
+                      given value, such as -where=tags.env=dev. Can be given
+                      more than once, and all must match.
+
+  -where-not=PATH=VALUE  Don't remove the selected instances whose attribute
+                      at PATH has the given value, such as
+                      -where-not=tags.keep=true. Can be given more than
+                      once, and an instance matching any of them is kept.
+                      An attribute that doesn't exist doesn't match.
+
+  -exclude=ADDRESS    Don't remove the selected instances at or within
+                      ADDRESS, which can be of a resource instance, of a
+                      resource to keep all of its instances, or of a module
+                      instance to keep everything in it. A resource
+                      address without a module path keeps that resource
+                      in every module. Can be given more than once. An exclusion that keeps nothing is warned
+                      about.
+
+  -decode-errors=POLICY  What to do when the attributes of a selected
+                      instance can't be decoded for -where, -where-not,
+                      -refuse-on-drift, or the id column of -csv: "skip"
+                      the check with a warning and still remove the
+                      instance, or "fail" without removing anything.
+                      Defaults to "skip", since removal itself doesn't need
+                      the attributes.
+
+  -schema-upgrade-first  Before -where, -where-not, or -refuse-on-drift
+                      look at the attributes of the selected instances,
+                      upgrade those stored with an older schema version
+                      using their installed providers, as a plan would.
+                      The upgraded instances are listed in a warning. The
+                      state is still written with the objects as stored.
+
+  -orphan-keys=ADDR   Remove the instances of the resource at ADDR whose
+                      instance keys are no longer declared in the
+                      configuration in the current directory. Can be
+                      specified multiple times.
+
+  -preserve-outputs=false  When removing a whole module, also remove any
+                      output values recorded for the module and its
+                      descendents. Only the root module's output values are
+                      currently saved in state snapshots, so this usually
+                      has no effect.
+
+  -module-output-cleanup  Also remove any output values whose expressions in
+                      the configuration in the current directory refer to
+                      the removed resource instances. This is best-effort:
+                      output values that aren't in the configuration are
+                      left alone. With -dry-run, each one is listed with
+                      the reference that made it stale.
+
+  -emit-removed-block=PATH  Instead of removing anything from the state,
+                      write a removed block for each of the selected
+                      resource instances to the file at PATH, so that the
+                      removal can be made declaratively in configuration.
+                      With -dry-run, the removal is also previewed.
+
+  -checkpoint=PATH    Record the resource instances that have been removed
+                      and saved in the file at PATH, so that a re-run with
+                      the same option after an interruption skips them. The
+                      file is deleted once the removal completes.
+
+  -chunk-persist=N    Remove the instances in chunks of N, saving the state
+                      after each chunk, with the state locked throughout. If
+                      saving a chunk fails the earlier chunks stay removed,
+                      so use this with -checkpoint.
+
+  -respect-depends-on-order  Remove the instances in steps, dependents
+                      first, saving the state after each step, so that no
+                      saved state has an instance that depends on one
+                      already removed. The order used is printed. Fails if
+                      the recorded dependencies form a cycle. Can't be used
+                      with -chunk-persist.
+
+  -persist-interval=DURATION  Save the state whenever DURATION has passed
+                      since it was last saved during the removal, as well
+                      as at the end, with the state locked throughout.
+                      Can't be used with -chunk-persist or
+                      -respect-depends-on-order.
+
+  -save-removed=PATH  Write the removed resource instances to a new state
+                      file at PATH before removing them, so that they can
+                      later be inspected or restored.
+
+  -pre-plan-snapshot=PATH  Before removing anything, write the whole state
+                      to PATH, and a record of the arguments and of the
+                      resource instances they selected to PATH.meta.json,
+                      so that the removal can be reviewed or reproduced.
+                      If this fails, nothing is removed.
+
+  -object-hash-manifest=PATH  Before removing them, write the SHA-256 hash
+                      of each removed object to PATH, one per line along
+                      with its address, so that an audit can later prove
+                      exactly what was removed.
+
+  -emit-removed-ids=PATH  Write the id of each removed object of a managed
+                      resource to PATH, one "ADDRESS<tab>ID" line each, so
+                      that a script can delete the real objects that are
+                      no longer managed. With -dry-run, the ids of the
+                      objects that would be removed are written.
+
+  -export-before=TARGET  Before the modified state is saved, send a JSON
+                      document with every object of each resource instance
+                      being removed to TARGET, such as for archiving in a
+                      CMDB. If TARGET is an http or https URL the document
+                      is POSTed to it, and otherwise TARGET is run as a
+                      shell command with the document on stdin. If this
+                      fails, or the target takes longer than 30 seconds,
+                      nothing is removed.
+
+  -snapshot-diff-url=URL  After the modified state has been saved, POST a
+                      JSON document listing the removed resource instances
+                      and output values, with their counts, to URL, such as
+                      a webhook that posts to a chat channel. If this
+                      fails, Terraform only warns about it.
+
+  -retain-schema-version  Record the schema version of each removed object
+                      in the -save-removed file, so that restoring it later
+                      doesn't cause the provider to upgrade it again. This
+                      is advanced usage; see the documentation for details.
+
+  -group-by-module    List the removed instances grouped by module, with a
+                      subtotal for each module. With -json, the array has
+                      an object for each module, containing what was
+                      removed from it, its subtotals and a by_type count.
+
+  -template=TEMPLATE  Instead of the usual output, print the result of the Go
+                      text/template TEMPLATE for each instance removed,
+                      with the fields .Address, .Mode, .Type, .Name,
+                      .Module, .Provider, .ID, and .Deposed.
+
+  -template-file=PATH Like -template, but read the template from the file
+                      at PATH.
+
+  -output=PATH        With -dry-run, write what would be removed to the file
+                      at PATH rather than printing it. If PATH ends with
+                      ".json" or ".csv", the file is written in the format
+                      of -json or -csv.
+
+  -json               If set, the result is printed as a JSON array rather
+                      than as human-readable text, with an object giving
+                      the type and address of each module, resource, or
+                      resource instance removed, and what it contains.
+
+  -csv                If set, the result is printed as CSV, with a row for
+                      each removed instance giving its address, type, name,
+                      module, provider, id, and action, for reviewing the
+                      removal in a spreadsheet.
+
+  -summary-only       Print only a summary of the removal, with the number
+                      of instances removed for each resource type and module
+                      and the path of the backup, instead of a line for each
+                      instance. With -json, only the summary object is
+                      printed.
+
+  -if-newer-than-config  Refuse to remove resource instances created since
+                      the configuration was last changed. This is best-effort:
+                      it has no effect for objects whose creation time isn't
+                      recorded in the state.
+
+  -fail-on=TYPE       Fail without removing anything, even with -dry-run, if
+                      any instance of a resource of the given type is
+                      selected for removal. Prefix the type with "data." for
+                      a data resource type. This can be given multiple times.
+
+  -keep-if-referenced  Don't remove selected resource instances that other
+                      instances in the state still depend on, and list them
+                      in a warning instead.
+
+  -force              Skip the -if-newer-than-config check.
+
+  -confirm-show-diff  When asking for confirmation, show the top-level
+                      attributes of each resource instance to remove, with
+                      collections and long strings condensed, instead of
+                      the list of items.
+
+  -auto-approve       Change the state without asking for confirmation,
+                      in every mode that changes it, from removing the
+                      items to -normalize-only or restoring the backup
+                      found by -undo-last. This is required when input is
+                      disabled or stdin is not a terminal.
+
+  -allow-missing-state  Succeed without removing anything if there is no
+                      state yet, rather than failing.
+
+  -input=false        Disable interactive prompts, such as those for backend
+                      configuration. Removing items or restoring a backup
+                      with -undo-last then requires -auto-approve.
+
   -backup=PATH        Path where Terraform should write the backup
                       state. This can't be disabled. If not set, Terraform
                       will write it to the same path as the statefile with
                       a backup extension.
 
+  -verify-after       Once the state has been saved, read it back from the
+                      backend and fail if any of the removed items are still
+                      in it.
+
+  -rollback-on-verify-failure  With -verify-after, if any of the removed
+                      items are still in the saved state, save the state as
+                      it was before the removal again, so that a partly
+                      saved removal is undone, and report the rollback.
+
+  -reinsert-on-abort  With -verify-after, if any of the removed items are
+                      still in the saved state, put the removed objects back
+                      into the state from a copy kept in memory and save it
+                      again, and report the reinsertion.
+
+  -post-remove-plan-gate=N  Once the state has been saved, plan the
+                      configuration in the current directory against it,
+                      and fail if the plan would destroy or replace more
+                      than N resource instances, listing them. The removal
+                      is kept unless -post-remove-plan-gate-rollback is
+                      also given.
+
+  -post-remove-plan-gate-rollback  With -post-remove-plan-gate, if the plan
+                      would destroy or replace too much, save the state as
+                      it was before the removal again, and report the
+                      rollback.
+
+  -print-backup-path  Once the state has been saved, print the path of the
+                      backup that was written as the last line of output,
+                      or as "backup_path" with -json -summary-only.
+
+  -backup-to-backend  Also save the state as it was before the removal as a
+                      new workspace in the backend, named after the current
+                      workspace with a "staterm-backup" suffix. If the
+                      backend doesn't support workspaces, a local backup
+                      file is written instead. Backup workspaces are never
+                      deleted automatically; remove them with
+                      "terraform workspace delete -force".
+
+  -backup-retention=N After writing the timestamped backup, delete older
+                      timestamped backups of the same state, keeping only
+                      the most recent N. Only backups with the same lineage
+                      as the state are deleted.
+
+  -emit-metrics=PATH  Write a JSON file to PATH recording the outcome of the
+                      command, how long it took, the number of resource
+                      instances removed in total and by type, and the size
+                      of the backup. It's written whether or not the command
+                      succeeds.
+
+  -label=TEXT         Record TEXT, such as the change ticket the removal is
+                      for, with the removal in the -json -summary-only
+                      output, the -emit-metrics file, the
+                      -object-hash-manifest, and the documents sent by
+                      -export-before and -snapshot-diff-url. It doesn't
+                      change what is removed.
+
+  -retry=N            Retry saving the state up to N times if it fails with
+                      an error that looks transient, such as a timeout or
+                      throttling by a remote backend. Errors such as denied
+                      access or a conflict are not retried. Defaults to 0.
+
+  -retry-interval=DURATION  Time to wait before the first retry, which is
+                      doubled before each retry after that. Defaults to 1s.
+
   -state=PATH         Path to the source state file. Defaults to the configured
                       backend, or "terraform.tfstate"
 
+  -coalesce-instances List deposed objects that are identical to the current
+                      object of the same resource instance, or to another of
+                      its deposed objects, as can be left behind by provider
+                      bugs. Can't be used with addresses.
+
+  -fix                With -coalesce-instances or -dedupe-across-modules,
+                      remove the duplicates that were found.
+
+  -dedupe-across-modules  List managed resource instances in different
+                      modules whose objects have the same resource type and
+                      id, and so most likely track the same real object.
+                      With -fix, remove all but one of each set. Can't be
+                      used with addresses.
+
+  -keep-module=ADDRESS  With -dedupe-across-modules, keep the instance in
+                      the module instance at ADDRESS. Sets with no instance
+                      there are left alone. Without it, the instance with
+                      the lowest address is kept, which puts the root
+                      module first.
+
+  -dedupe-deposed     Remove each deposed object that is byte-for-byte
+                      identical to another deposed object of the same
+                      resource instance, keeping one of each, as can be
+                      left by repeated failed create-before-destroy cycles.
+                      With -dry-run, the duplicates are only listed. Can't
+                      be used with addresses.
+
+  -provider-rename=OLD=NEW  Instead of removing the selected resources, change
+                      the provider configuration recorded for those that use
+                      OLD to NEW, where both are provider configuration
+                      addresses such as provider.aws.west. With no
+                      addresses, all resources using OLD are changed.
+
+  -provider-migration-report=PATH  Instead of removing the selected
+                      resources, report which provider each uses and, using
+                      the mappings in the file at PATH, which provider and
+                      state each would move to. Each line of the file is
+                      "OLD NEW [STATE]". Nothing is changed. With no
+                      addresses, all resources are reported. Can be used
+                      with -json.
+
+  -scrub-private      Instead of removing the selected resource instances,
+                      clear the private data that their provider stored with
+                      each of their objects, and save the state. This can
+                      fix plan errors caused by private data that an
+                      upgraded provider no longer understands. With
+                      -dry-run, only list the objects that have private
+                      data.
+
+  -undo-last          Instead of removing anything, restore the most recent
+                      of the timestamped backups that state commands write
+                      by default, after listing the resource instances it
+                      would add, remove, or change and asking for
+                      confirmation. The backup must have the same lineage as
+                      the state. With -dry-run, only list the changes.
+
+  -scope-module=ADDR  Only remove resource instances in the module instance
+                      ADDR or in its descendent modules. Instances selected
+                      by any addresses or other options that are outside of
+                      it are skipped, so a broad selection can't reach past
+                      the module.
+
+  -normalize-only     Rewrite the state in the current snapshot format without
+                      removing anything, first backing up the original
+                      snapshot. Reports whether the stored bytes changed.
+                      Can't be used with addresses.
+
+  -trace              Report how long each phase of the command took (load,
+                      refresh, filter, mutate, write and persist) on stderr.
+
 `
 	return strings.TrimSpace(helpText)
 }
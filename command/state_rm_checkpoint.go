@@ -0,0 +1,71 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateRmRemoveCheckpoint deletes the -checkpoint file once the removal it
+// records has completed. It's a variable so that tests can make it fail.
+var stateRmRemoveCheckpoint = os.Remove
+
+// readStateRmCheckpoint returns the addresses recorded in the -checkpoint file
+// at the given path, as written by writeStateRmCheckpoint, or nil if there is
+// no such file because there is nothing to resume.
+func readStateRmCheckpoint(path string) (map[string]bool, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	rawAddrs, err := readAddressFile(path, "text")
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]bool, len(rawAddrs))
+	for _, rawAddr := range rawAddrs {
+		ret[rawAddr] = true
+	}
+	return ret, nil
+}
+
+// writeStateRmCheckpoint writes the given addresses of removed resource
+// instances to the -checkpoint file at the given path, one per line, replacing
+// any file that is already there. The file can also be read with -from-file.
+func writeStateRmCheckpoint(path string, done map[string]bool) error {
+	rawAddrs := make([]string, 0, len(done))
+	for rawAddr := range done {
+		rawAddrs = append(rawAddrs, rawAddr)
+	}
+	sort.Strings(rawAddrs)
+
+	var buf bytes.Buffer
+	buf.WriteString("# Resource instances already removed by \"terraform state rm\".\n")
+	for _, rawAddr := range rawAddrs {
+		fmt.Fprintln(&buf, rawAddr)
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// filterCheckpointedInstances returns only those of the given instances that
+// weren't already removed according to the given checkpoint, along with the
+// number that were. An instance that is recorded in the checkpoint but is in
+// the given state again is not skipped, since it must have been added back
+// since it was removed.
+func filterCheckpointedInstances(state *states.State, instances []addrs.AbsResourceInstance, done map[string]bool) ([]addrs.AbsResourceInstance, int) {
+	var ret []addrs.AbsResourceInstance
+	resumed := 0
+	for _, addr := range instances {
+		if done[addr.String()] && state.ResourceInstance(addr) == nil {
+			resumed++
+			continue
+		}
+		ret = append(ret, addr)
+	}
+	return ret, resumed
+}
@@ -0,0 +1,156 @@
+package command
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmChunkOpts configures persistStateRmChunks, for the -chunk-persist
+// and -persist-interval options.
+type stateRmChunkOpts struct {
+	// Size is the number of resource instances to remove in each chunk.
+	Size int
+
+	// Chunks, if set, are the chunks to remove the items in instead, as
+	// the steps found for -respect-depends-on-order. They must have all of
+	// the items of the result between them, in the same order.
+	Chunks [][]*stateRmItem
+
+	// Interval, if set, is used instead of Size to persist the state
+	// whenever at least this long has passed since it was last persisted,
+	// for -persist-interval, rather than after a fixed number of instances.
+	Interval time.Duration
+
+	Retry *stateRmRetryOpts
+
+	// Checkpoint, if non-nil, is updated with the instances of each chunk
+	// once the chunk has been persisted, and written to CheckpointPath, so
+	// that a run that fails part way through can be resumed.
+	Checkpoint     map[string]bool
+	CheckpointPath string
+
+	// Quiet suppresses the progress message after each chunk, for output
+	// formats that must be the only output.
+	Quiet bool
+}
+
+// persistStateRmChunks removes the items of the given result, which must
+// come from a dry run of runStateRm, from the given state in chunks, writing
+// and persisting the state after each chunk. Output values, if any are to be
+// removed, are removed with the last chunk.
+//
+// Each chunk is durable once persisted, so if a later one fails the earlier
+// removals are kept. Local state managers back up only the first snapshot
+// they replace, so there is still only one backup, of the original state.
+//
+// With an interval, the items are removed one at a time and the state is
+// persisted once the interval has passed since the last time, and after the
+// last item, so each checkpoint covers however many items were removed in
+// that time.
+func (c *StateRmCommand) persistStateRmChunks(stateMgr statemgr.Full, state *states.State, result *stateRmResult, opts *stateRmChunkOpts) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	total := len(result.Items)
+	all := opts.Chunks
+	switch {
+	case all != nil:
+	case opts.Interval > 0:
+		for _, item := range result.Items {
+			all = append(all, []*stateRmItem{item})
+		}
+	default:
+		for start := 0; start < total; start += opts.Size {
+			end := start + opts.Size
+			if end > total {
+				end = total
+			}
+			all = append(all, result.Items[start:end])
+		}
+	}
+	if len(all) == 0 {
+		// There are still output values to remove, or at least a state to
+		// write, so we make a single empty chunk.
+		all = [][]*stateRmItem{nil}
+	}
+	chunks := len(all)
+
+	ss := state.SyncWrapper()
+	done := 0
+	checkpoints := 0
+	var items []*stateRmItem
+	lastPersist := time.Now()
+	for chunk := 1; chunk <= chunks; chunk++ {
+		chunkItems := all[chunk-1]
+		items = append(items, chunkItems...)
+		for _, item := range chunkItems {
+			logStateRmObjects(item)
+			ss.ForgetResourceInstanceAll(item.Addr)
+		}
+		if chunk == chunks {
+			for _, addr := range result.Outputs {
+				ss.RemoveOutputValue(addr)
+			}
+		}
+		if opts.Interval > 0 && chunk < chunks && time.Since(lastPersist) < opts.Interval {
+			continue
+		}
+		checkpoints++
+
+		desc := fmt.Sprintf("chunk %d of %d", chunk, chunks)
+		switch {
+		case opts.Chunks != nil:
+			desc = fmt.Sprintf("step %d of %d", chunk, chunks)
+		case opts.Interval > 0:
+			desc = fmt.Sprintf("checkpoint %d", checkpoints)
+		}
+
+		err := stateMgr.WriteState(state)
+		if err == nil {
+			var attempts int
+			attempts, err = persistStateWithRetry(stateMgr, opts.Retry)
+			if err != nil && attempts > 1 {
+				err = fmt.Errorf("%s (after %d attempts)", err, attempts)
+			}
+		}
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to persist state",
+				fmt.Sprintf(errStateRmChunkPersist, desc, err, done, total),
+			))
+			return diags
+		}
+		done += len(items)
+		lastPersist = time.Now()
+
+		if opts.Checkpoint != nil {
+			for _, item := range items {
+				opts.Checkpoint[item.Addr.String()] = true
+			}
+			if err := writeStateRmCheckpoint(opts.CheckpointPath, opts.Checkpoint); err != nil {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Warning,
+					"Failed to write checkpoint",
+					fmt.Sprintf("Could not record the removed resource instances in the checkpoint file %s: %s. The state was saved.", opts.CheckpointPath, err),
+				))
+			}
+		}
+
+		if !opts.Quiet {
+			c.Ui.Output(fmt.Sprintf("Persisted %s: removed %d of %d resource instances.", desc, done, total))
+		}
+		items = nil
+	}
+	return diags
+}
+
+const errStateRmChunkPersist = `Error saving %s of the removal: %s
+
+The first %d of the %d resource instances to remove were already removed
+and persisted earlier, and the rest have not been removed. Run the
+same command again to remove them, using -checkpoint to record progress so
+that the instances already removed are skipped.`
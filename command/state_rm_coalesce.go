@@ -0,0 +1,308 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmDuplicate describes a deposed object that is a duplicate of another
+// object of the same resource instance, as found by findDuplicateObjects.
+type stateRmDuplicate struct {
+	Addr    addrs.AbsResourceInstance
+	Deposed states.DeposedKey
+
+	// Of is the key of the deposed object that this one duplicates, or
+	// states.NotDeposed if it duplicates the current object.
+	Of states.DeposedKey
+}
+
+func (d *stateRmDuplicate) String() string {
+	if d.Of == states.NotDeposed {
+		return fmt.Sprintf("%s: deposed object %s is identical to the current object", d.Addr, d.Deposed)
+	}
+	return fmt.Sprintf("%s: deposed object %s is identical to deposed object %s", d.Addr, d.Deposed, d.Of)
+}
+
+// findDuplicateObjects returns all of the deposed objects in the given state
+// that are identical to the current object of the same resource instance, or
+// to another of its deposed objects with a lower key. Such duplicates can be
+// left behind by provider bugs, and can be removed without losing track of
+// any remote object.
+//
+// The result is ordered by resource instance address and then deposed key.
+func findDuplicateObjects(state *states.State) []*stateRmDuplicate {
+	var ret []*stateRmDuplicate
+	for _, ms := range state.Modules {
+		for _, rs := range ms.Resources {
+			for key, is := range rs.Instances {
+				addr := rs.Addr.Instance(key).Absolute(ms.Addr)
+
+				deposed := make([]states.DeposedKey, 0, len(is.Deposed))
+				for k := range is.Deposed {
+					deposed = append(deposed, k)
+				}
+				sort.Slice(deposed, func(i, j int) bool {
+					return deposed[i] < deposed[j]
+				})
+
+				var kept []states.DeposedKey
+				for _, k := range deposed {
+					obj := is.Deposed[k]
+					if is.Current != nil && stateObjectsIdentical(obj, is.Current) {
+						ret = append(ret, &stateRmDuplicate{Addr: addr, Deposed: k, Of: states.NotDeposed})
+						continue
+					}
+					dup := false
+					for _, other := range kept {
+						if stateObjectsIdentical(obj, is.Deposed[other]) {
+							ret = append(ret, &stateRmDuplicate{Addr: addr, Deposed: k, Of: other})
+							dup = true
+							break
+						}
+					}
+					if !dup {
+						kept = append(kept, k)
+					}
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if !ret[i].Addr.Equal(ret[j].Addr) {
+			return ret[i].Addr.Less(ret[j].Addr)
+		}
+		return ret[i].Deposed < ret[j].Deposed
+	})
+	return ret
+}
+
+// stateObjectsIdentical returns true if the two given objects have the same
+// attributes and metadata. Attributes in JSON format are compared by value,
+// so differences in formatting alone are ignored.
+func stateObjectsIdentical(a, b *states.ResourceInstanceObjectSrc) bool {
+	if a.SchemaVersion != b.SchemaVersion || a.Status != b.Status {
+		return false
+	}
+	if !bytes.Equal(a.Private, b.Private) {
+		return false
+	}
+	if !reflect.DeepEqual(a.AttrsFlat, b.AttrsFlat) || !reflect.DeepEqual(a.Dependencies, b.Dependencies) {
+		return false
+	}
+	if a.AttrsJSON == nil || b.AttrsJSON == nil {
+		return a.AttrsJSON == nil && b.AttrsJSON == nil
+	}
+
+	var aVal, bVal interface{}
+	if err := json.Unmarshal(a.AttrsJSON, &aVal); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(b.AttrsJSON, &bVal); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(aVal, bVal)
+}
+
+// coalesceInstances looks for duplicate deposed objects in the given state,
+// as read from the given state manager, for the -coalesce-instances option.
+// Each duplicate is reported, and if fix is set they are removed and the
+// modified state is saved, once the user has confirmed it unless autoApprove
+// is set.
+func (c *StateRmCommand) coalesceInstances(stateMgr statemgr.Full, state *states.State, fix, autoApprove bool) int {
+	var diags tfdiags.Diagnostics
+
+	dups := findDuplicateObjects(state)
+	if len(dups) == 0 {
+		c.Ui.Output("No duplicate objects found.")
+		return 0
+	}
+
+	verb := "Found"
+	if fix && autoApprove {
+		verb = "Removed"
+	}
+	for _, dup := range dups {
+		c.Ui.Output(fmt.Sprintf("%s duplicate %s", verb, dup))
+	}
+	if !fix {
+		c.Ui.Output(fmt.Sprintf("\nFound %d duplicate objects. Run again with -fix to remove them.", len(dups)))
+		return 0
+	}
+	if !autoApprove {
+		ok, moreDiags := c.confirmStateChange("Do you want to remove these duplicate objects?")
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !ok {
+			c.Ui.Output("Removal cancelled.")
+			return 0
+		}
+	}
+
+	ss := state.SyncWrapper()
+	for _, dup := range dups {
+		ss.ForgetResourceInstanceDeposed(dup.Addr, dup.Deposed)
+	}
+
+	if err := stateMgr.WriteState(state); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to persist state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("\nRemoved %d duplicate objects. Updated state written successfully.", len(dups)))
+	return 0
+}
+
+// findDuplicateDeposed returns the deposed objects in the given state that
+// are byte-for-byte identical to another deposed object of the same resource
+// instance with a lower key, for the -dedupe-deposed option. Repeated failed
+// create-before-destroy cycles can leave such duplicates behind, and one of
+// each set of them is kept.
+//
+// Unlike findDuplicateObjects, the current object is never compared, and the
+// attributes must be stored identically rather than just have equal values.
+// The result is ordered by resource instance address and then deposed key.
+func findDuplicateDeposed(state *states.State) []*stateRmDuplicate {
+	var ret []*stateRmDuplicate
+	for _, ms := range state.Modules {
+		for _, rs := range ms.Resources {
+			for key, is := range rs.Instances {
+				addr := rs.Addr.Instance(key).Absolute(ms.Addr)
+
+				deposed := make([]states.DeposedKey, 0, len(is.Deposed))
+				for k := range is.Deposed {
+					deposed = append(deposed, k)
+				}
+				sort.Slice(deposed, func(i, j int) bool {
+					return deposed[i] < deposed[j]
+				})
+
+				var kept []states.DeposedKey
+				for _, k := range deposed {
+					dup := false
+					for _, other := range kept {
+						if stateObjectsByteIdentical(is.Deposed[k], is.Deposed[other]) {
+							ret = append(ret, &stateRmDuplicate{Addr: addr, Deposed: k, Of: other})
+							dup = true
+							break
+						}
+					}
+					if !dup {
+						kept = append(kept, k)
+					}
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if !ret[i].Addr.Equal(ret[j].Addr) {
+			return ret[i].Addr.Less(ret[j].Addr)
+		}
+		return ret[i].Deposed < ret[j].Deposed
+	})
+	return ret
+}
+
+// stateObjectsByteIdentical returns true if the two given objects have the
+// same metadata and their attributes are stored as exactly the same bytes.
+func stateObjectsByteIdentical(a, b *states.ResourceInstanceObjectSrc) bool {
+	if a.SchemaVersion != b.SchemaVersion || a.Status != b.Status {
+		return false
+	}
+	if !bytes.Equal(a.Private, b.Private) || !bytes.Equal(a.AttrsJSON, b.AttrsJSON) {
+		return false
+	}
+	return reflect.DeepEqual(a.AttrsFlat, b.AttrsFlat) && reflect.DeepEqual(a.Dependencies, b.Dependencies)
+}
+
+// dedupeDeposed removes the duplicate deposed objects found by
+// findDuplicateDeposed from the given state, as read from the given state
+// manager, reporting each one, and saves the modified state. With dryRun,
+// the duplicates are only listed, and otherwise the user must confirm their
+// removal unless autoApprove is set.
+func (c *StateRmCommand) dedupeDeposed(stateMgr statemgr.Full, state *states.State, dryRun, autoApprove bool) int {
+	var diags tfdiags.Diagnostics
+
+	dups := findDuplicateDeposed(state)
+	if len(dups) == 0 {
+		c.Ui.Output("No duplicate deposed objects found.")
+		return 0
+	}
+
+	verb := "Removed"
+	if dryRun || !autoApprove {
+		verb = "Would remove"
+	}
+	for _, dup := range dups {
+		c.Ui.Output(fmt.Sprintf("%s duplicate %s", verb, dup))
+	}
+	if dryRun {
+		c.Ui.Output(fmt.Sprintf("\nWould've removed %d duplicate deposed objects, without -dry-run.", len(dups)))
+		return 0
+	}
+	if !autoApprove {
+		ok, moreDiags := c.confirmStateChange("Do you want to remove these duplicate deposed objects?")
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !ok {
+			c.Ui.Output("Removal cancelled.")
+			return 0
+		}
+	}
+
+	ss := state.SyncWrapper()
+	for _, dup := range dups {
+		ss.ForgetResourceInstanceDeposed(dup.Addr, dup.Deposed)
+	}
+
+	if err := stateMgr.WriteState(state); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to persist state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("\nRemoved %d duplicate deposed objects. Updated state written successfully.", len(dups)))
+	return 0
+}
@@ -0,0 +1,252 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmStdinIsTerminal returns true if stdin is a terminal, and so there
+// may be someone to answer the confirmation prompt. It's a variable so that
+// tests can stand in for a terminal.
+var stateRmStdinIsTerminal = func() bool {
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
+
+// stateRmConfirmMaxValue is the length beyond which a string value in the
+// summary shown by -confirm-show-diff is cut short.
+const stateRmConfirmMaxValue = 60
+
+// confirmStateRm asks the user to confirm the removal of the given resource
+// instances and module instances from the given state, listing the items to
+// remove or, with showDiff, the attributes of each instance. Where nobody can
+// answer, this fails rather than going ahead unconfirmed or waiting for an
+// answer that will never come.
+func (c *StateRmCommand) confirmStateRm(state *states.State, toRemove []addrs.AbsResourceInstance, modules []addrs.ModuleInstance, showDiff bool) (bool, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	if reason := c.stateRmCantConfirm(); reason != "" {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Confirmation required",
+			fmt.Sprintf("Terraform asks for confirmation before removing anything from the state, but %s, so there is no way to answer. Use -auto-approve to remove the items without asking, or -dry-run to list them. Nothing has been removed.", reason),
+		))
+		return false, diags
+	}
+
+	if showDiff {
+		return c.confirmRemoval(state, toRemove)
+	}
+	return c.confirmRemovalItems(state, toRemove, modules)
+}
+
+// confirmStateChange asks the user to confirm a change to the state made by
+// one of the modes of "terraform state rm" that don't go through runStateRm,
+// such as -workspace-pattern or -coalesce-instances -fix. The caller must
+// already have listed what the change will do. Like confirmStateRm, this
+// fails where nobody can answer.
+func (c *StateRmCommand) confirmStateChange(query string) (bool, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	if reason := c.stateRmCantConfirm(); reason != "" {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Confirmation required",
+			fmt.Sprintf("Terraform asks for confirmation before changing the state, but %s, so there is no way to answer. Use -auto-approve to make the change without asking, or -dry-run to see what it would do. The state has not been changed.", reason),
+		))
+		return false, diags
+	}
+
+	ok, err := c.confirm(&terraform.InputOpts{
+		Id:          "state-rm",
+		Query:       query,
+		Description: "Only 'yes' will be accepted to confirm.",
+	})
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Change not confirmed",
+			fmt.Sprintf("Terraform could not ask for confirmation of the change: %s. The state has not been changed.", err),
+		))
+	}
+	return ok, diags
+}
+
+// stateRmCantConfirm returns the reason that nobody can answer a confirmation
+// prompt, or an empty string if someone may be there to answer one.
+func (c *StateRmCommand) stateRmCantConfirm() string {
+	switch {
+	case !c.Input():
+		return "input is disabled"
+	case !stateRmStdinIsTerminal():
+		return "stdin is not a terminal"
+	default:
+		return ""
+	}
+}
+
+// readApprovalToken reads the approval token given on stdin for the
+// -stdin-confirm-token option. Only the first line is read, so that the
+// token can be piped in from a file or a command that ends it with a newline.
+func (c *StateRmCommand) readApprovalToken() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// confirmRemoval shows a summary of the current object of each of the given
+// resource instances in the given state and asks the user to confirm their
+// removal, for the -confirm-show-diff option.
+func (c *StateRmCommand) confirmRemoval(state *states.State, toRemove []addrs.AbsResourceInstance) (bool, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	var buf bytes.Buffer
+	for _, addr := range toRemove {
+		writeStateRmConfirmSummary(&buf, addr, state.ResourceInstance(addr))
+	}
+	c.Ui.Output(fmt.Sprintf("The following resource instances will be removed from the state. Terraform will forget these objects, but won't destroy them:\n\n%s", buf.String()))
+
+	ok, err := c.confirm(&terraform.InputOpts{
+		Id:          "state-rm",
+		Query:       "Do you want to remove these resource instances?",
+		Description: "Only 'yes' will be accepted to confirm.",
+	})
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Removal not confirmed",
+			fmt.Sprintf("Terraform could not ask for confirmation of the removal: %s. The state has not been changed.", err),
+		))
+	}
+	return ok, diags
+}
+
+// confirmRemovalItems lists the items that removing the given resource
+// instances and module instances from the given state would remove, as
+// -dry-run would, and asks the user to confirm their removal, as is done
+// before every removal without -auto-approve.
+func (c *StateRmCommand) confirmRemovalItems(state *states.State, toRemove []addrs.AbsResourceInstance, modules []addrs.ModuleInstance) (bool, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	result, moreDiags := runStateRm(state, &stateRmOpts{
+		Addrs:   toRemove,
+		Modules: modules,
+		DryRun:  true,
+	})
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		return false, diags
+	}
+	var buf bytes.Buffer
+	writeStateRmItems(&buf, result.Items, "  ", "Would remove")
+	c.Ui.Output(fmt.Sprintf("The following items will be removed from the state. Terraform will forget these objects, but won't destroy them:\n\n%s", buf.String()))
+
+	ok, err := c.confirm(&terraform.InputOpts{
+		Id:          "state-rm",
+		Query:       "Do you want to remove these items?",
+		Description: "Only 'yes' will be accepted to confirm.",
+	})
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Removal not confirmed",
+			fmt.Sprintf("Terraform could not ask for confirmation of the removal: %s. The state has not been changed.", err),
+		))
+	}
+	return ok, diags
+}
+
+// writeStateRmConfirmSummary writes the address of the given resource
+// instance followed by its top-level attributes, one per line, with their
+// names aligned. Only scalar values are shown in full: a collection is shown
+// as the number of its elements, and a long string is cut short, so that
+// the summary of each instance stays within a screen.
+func writeStateRmConfirmSummary(buf *bytes.Buffer, addr addrs.AbsResourceInstance, is *states.ResourceInstance) {
+	fmt.Fprintf(buf, "  # %s\n", addr)
+	if is == nil || is.Current == nil {
+		buf.WriteString("      (no current object)\n\n")
+		return
+	}
+
+	attrs := stateRmConfirmAttrs(is.Current)
+	keys := make([]string, 0, len(attrs))
+	width := 0
+	for k := range attrs {
+		keys = append(keys, k)
+		if len(k) > width {
+			width = len(k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(buf, "      %-*s = %s\n", width, k, attrs[k])
+	}
+	if n := len(is.Deposed); n > 0 {
+		fmt.Fprintf(buf, "      (and %d deposed objects)\n", n)
+	}
+	buf.WriteString("\n")
+}
+
+// stateRmConfirmAttrs returns the condensed value of each top-level attribute
+// of the given object, by name, leaving out null values.
+func stateRmConfirmAttrs(obj *states.ResourceInstanceObjectSrc) map[string]string {
+	ret := make(map[string]string)
+
+	if obj.AttrsJSON == nil {
+		// In the legacy flatmap format, the number of elements of each
+		// collection is recorded alongside its elements.
+		for k, v := range obj.AttrsFlat {
+			switch {
+			case strings.HasSuffix(k, ".#") || strings.HasSuffix(k, ".%"):
+				if name := k[:len(k)-2]; !strings.Contains(name, ".") {
+					ret[name] = fmt.Sprintf("(%s elements)", v)
+				}
+			case !strings.Contains(k, "."):
+				ret[k] = stateRmConfirmString(v)
+			}
+		}
+		return ret
+	}
+
+	var vals map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(obj.AttrsJSON))
+	dec.UseNumber()
+	if err := dec.Decode(&vals); err != nil {
+		return map[string]string{"(attributes)": "(could not be decoded)"}
+	}
+	for k, v := range vals {
+		switch tv := v.(type) {
+		case nil:
+		case string:
+			ret[k] = stateRmConfirmString(tv)
+		case []interface{}:
+			ret[k] = fmt.Sprintf("(%d elements)", len(tv))
+		case map[string]interface{}:
+			ret[k] = fmt.Sprintf("(%d elements)", len(tv))
+		default:
+			ret[k] = fmt.Sprint(tv)
+		}
+	}
+	return ret
+}
+
+func stateRmConfirmString(s string) string {
+	if r := []rune(s); len(r) > stateRmConfirmMaxValue {
+		s = string(r[:stateRmConfirmMaxValue]) + "..."
+	}
+	return fmt.Sprintf("%q", s)
+}
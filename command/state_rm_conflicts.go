@@ -0,0 +1,72 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// checkConflicts looks for another operation in progress on the state
+// that could change any of the given instances, for the -conflict-check
+// option, and returns an error describing it if there is one.
+//
+// This is best-effort. Backends don't record which resource instances an
+// operation will change, so the only sign of one in progress is a lock held
+// on the state, which we find by briefly taking the lock ourselves. Any
+// operation holding it could change any of the instances, so all of them
+// conflict. A backend that doesn't support locking can't tell us anything,
+// which is only a warning.
+func (e *stateRmCommandEnv) checkConflicts(instances []addrs.AbsResourceInstance) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	locker, ok := e.stateMgr.(statemgr.Locker)
+	if !ok {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Conflicts not checked",
+			"The current backend doesn't support locking, so -conflict-check can't tell whether another operation is in progress.",
+		))
+		return diags
+	}
+
+	info := statemgr.NewLockInfo()
+	info.Operation = "state rm -conflict-check"
+	id, err := locker.Lock(info)
+	if err == nil {
+		if err := locker.Unlock(id); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to unlock state",
+				fmt.Sprintf("The state was locked to check for conflicts, but could not be unlocked again: %s. Nothing has been removed. Use \"terraform force-unlock %s\" once you are sure nothing else is using the state.", err, id),
+			))
+		}
+		return diags
+	}
+
+	held := "Another operation holds the lock on the state"
+	if lockErr, ok := err.(*statemgr.LockError); ok && lockErr.Info != nil {
+		held = fmt.Sprintf("The state is locked by %q", lockErr.Info.Operation)
+		if lockErr.Info.Who != "" {
+			held += " run by " + lockErr.Info.Who
+		}
+		if !lockErr.Info.Created.IsZero() {
+			held += " since " + lockErr.Info.Created.UTC().Format("2006-01-02 15:04:05 MST")
+		}
+		if lockErr.Info.ID != "" {
+			held += fmt.Sprintf(", with lock ID %s", lockErr.Info.ID)
+		}
+	}
+	lines := make([]string, len(instances))
+	for i, addr := range instances {
+		lines[i] = "  " + addr.String()
+	}
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Conflicting operation in progress",
+		fmt.Sprintf("%s. It could change any of the following resource instances that were selected for removal:\n\n%s\n\nNothing has been removed. Wait for the other operation to finish, and then try again.", held, strings.Join(lines, "\n")),
+	))
+	return diags
+}
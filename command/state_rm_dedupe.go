@@ -0,0 +1,201 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmModuleDuplicates is a set of managed resource instances in more than
+// one module whose current objects are of the same resource type and have the
+// same id, as found by findCrossModuleDuplicates. They most likely all track
+// the same real object, such as after a module block was copied.
+type stateRmModuleDuplicates struct {
+	Type string
+	ID   string
+
+	// Addrs are the addresses of the instances, ordered by address.
+	Addrs []addrs.AbsResourceInstance
+}
+
+// findCrossModuleDuplicates returns each set of managed resource instances in
+// the given state whose current objects have the same resource type and id,
+// where the instances are in at least two different modules, for the
+// -dedupe-across-modules option. Objects without an id are ignored.
+//
+// Duplicates within a single module are left to -merge, since they are more
+// often a deliberate import of the same object at two addresses. The result
+// is ordered by resource type and then id.
+func findCrossModuleDuplicates(state *states.State) []*stateRmModuleDuplicates {
+	byID := make(map[string]*stateRmModuleDuplicates)
+	for _, ms := range state.Modules {
+		for _, rs := range ms.Resources {
+			if rs.Addr.Mode != addrs.ManagedResourceMode {
+				continue
+			}
+			for key, is := range rs.Instances {
+				id := states.LegacyInstanceObjectID(is.Current)
+				if id == "" {
+					continue
+				}
+				k := rs.Addr.Type + "\x00" + id
+				dups, ok := byID[k]
+				if !ok {
+					dups = &stateRmModuleDuplicates{Type: rs.Addr.Type, ID: id}
+					byID[k] = dups
+				}
+				dups.Addrs = append(dups.Addrs, rs.Addr.Instance(key).Absolute(ms.Addr))
+			}
+		}
+	}
+
+	var ret []*stateRmModuleDuplicates
+	for _, dups := range byID {
+		modules := make(map[string]bool)
+		for _, addr := range dups.Addrs {
+			modules[addr.Module.String()] = true
+		}
+		if len(modules) < 2 {
+			continue
+		}
+		sort.Slice(dups.Addrs, func(i, j int) bool {
+			return dups.Addrs[i].Less(dups.Addrs[j])
+		})
+		ret = append(ret, dups)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Type != ret[j].Type {
+			return ret[i].Type < ret[j].Type
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// keep returns the index of the address in the set to keep, which is the
+// first one in the given module, or without a module the first one overall.
+// It returns -1 if none of the instances are in the given module.
+func (d *stateRmModuleDuplicates) keep(module addrs.ModuleInstance) int {
+	if module == nil {
+		return 0
+	}
+	for i, addr := range d.Addrs {
+		if addr.Module.Equal(module) {
+			return i
+		}
+	}
+	return -1
+}
+
+// dedupeAcrossModules looks for resource instances in different modules of
+// the given state, as read from the given state manager, that track the same
+// real object, for the -dedupe-across-modules option. Each set of them is
+// reported, along with the one that would be kept, and if fix is set all of
+// the others are removed and the modified state is saved, once the user has
+// confirmed it unless autoApprove is set.
+//
+// The instance kept is the one in keepModule, if given, or otherwise the one
+// with the lowest address. Sets with no instance in keepModule are reported
+// but left alone.
+func (c *StateRmCommand) dedupeAcrossModules(stateMgr statemgr.Full, state *states.State, keepModule addrs.ModuleInstance, fix, autoApprove bool) int {
+	var diags tfdiags.Diagnostics
+
+	sets := findCrossModuleDuplicates(state)
+	if len(sets) == 0 {
+		c.Ui.Output("No resource instances duplicated across modules found.")
+		return 0
+	}
+
+	verb := "duplicate"
+	if fix && autoApprove {
+		verb = "removed"
+	}
+	var buf bytes.Buffer
+	var toRemove []addrs.AbsResourceInstance
+	var unresolved []string
+	for _, set := range sets {
+		fmt.Fprintf(&buf, "%s with id %q is in %d resource instances:\n", set.Type, set.ID, len(set.Addrs))
+		keep := set.keep(keepModule)
+		if keep < 0 {
+			unresolved = append(unresolved, fmt.Sprintf("  %s %q", set.Type, set.ID))
+		}
+		for i, addr := range set.Addrs {
+			switch {
+			case keep < 0:
+				fmt.Fprintf(&buf, "  %s\n", addr)
+			case i == keep:
+				fmt.Fprintf(&buf, "  %s (keep)\n", addr)
+			default:
+				fmt.Fprintf(&buf, "  %s (%s)\n", addr, verb)
+				toRemove = append(toRemove, addr)
+			}
+		}
+	}
+	c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+
+	if len(unresolved) > 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"No instance in the module to keep",
+			fmt.Sprintf("None of the instances with the following ids are in %s, so they have been left alone:\n\n%s\n\nRun again with a -keep-module that has one of them to remove the others.", keepModule, strings.Join(unresolved, "\n")),
+		))
+	}
+	if !fix {
+		c.showDiagnostics(diags)
+		c.Ui.Output(fmt.Sprintf("\nFound %d resource instances duplicating another in a different module. Run again with -fix to remove them.", len(toRemove)))
+		return 0
+	}
+	if len(toRemove) == 0 {
+		c.showDiagnostics(diags)
+		c.Ui.Output("\nNo resource instances removed.")
+		return 0
+	}
+	if !autoApprove {
+		ok, moreDiags := c.confirmStateChange("Do you want to remove the instances marked as duplicates?")
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !ok {
+			c.Ui.Output("Removal cancelled.")
+			return 0
+		}
+	}
+
+	_, moreDiags := runStateRm(state, &stateRmOpts{Addrs: toRemove})
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	if err := stateMgr.WriteState(state); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to persist state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	c.showDiagnostics(diags)
+	c.Ui.Output(fmt.Sprintf("\nRemoved %d resource instances duplicating another in a different module. Updated state written successfully.", len(toRemove)))
+	return 0
+}
@@ -0,0 +1,41 @@
+package command
+
+import (
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmEnv is what runStateRm needs beyond the state it removes from: the
+// configuration and providers that some of the selection options and guards
+// check the state against, the state manager, and the UI for asking for
+// confirmation and showing what it found before asking.
+type stateRmEnv interface {
+	parseResourceInstanceAddr(rawAddr, filename string) (addrs.AbsResourceInstance, tfdiags.Diagnostics)
+	orphanedInstances(state *states.State, rawAddrs []string) ([]addrs.AbsResourceInstance, tfdiags.Diagnostics)
+	orphanedDataInstances(state *states.State) ([]addrs.AbsResourceInstance, tfdiags.Diagnostics)
+	upgradeInstances(state *states.State, instances []addrs.AbsResourceInstance, skipDecodeErrors bool) (*stateRmUpgrades, tfdiags.Diagnostics)
+
+	checkPreventDestroy(instances []addrs.AbsResourceInstance) tfdiags.Diagnostics
+	checkProvidersInstalled(state *states.State, instances []addrs.AbsResourceInstance) tfdiags.Diagnostics
+	checkCleanPlan(state *states.State, instances []addrs.AbsResourceInstance) tfdiags.Diagnostics
+	checkConflicts(instances []addrs.AbsResourceInstance) tfdiags.Diagnostics
+	checkDrift(state *states.State, instances []addrs.AbsResourceInstance, skipDecodeErrors bool) tfdiags.Diagnostics
+	readApprovalToken() (string, error)
+
+	showDiagnostics(vals ...interface{})
+	confirmStateRm(state *states.State, toRemove []addrs.AbsResourceInstance, modules []addrs.ModuleInstance, showDiff bool) (bool, tfdiags.Diagnostics)
+
+	findStaleOutputs(state *states.State, removed []addrs.AbsResourceInstance) ([]stateRmStaleOutput, tfdiags.Diagnostics)
+	projectStateSize(state *states.State, opts stateRmOpts) (stateRmSizes, error)
+}
+
+// stateRmCommandEnv is the stateRmEnv of "terraform state rm", removing from
+// the state read from the given state manager.
+type stateRmCommandEnv struct {
+	*StateRmCommand
+	stateMgr statemgr.Full
+}
+
+var _ stateRmEnv = (*stateRmCommandEnv)(nil)
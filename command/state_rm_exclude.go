@@ -0,0 +1,70 @@
+package command
+
+import (
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateRmExclusion is an address given to the -exclude option of
+// "terraform state rm", which keeps whatever it contains in the state however
+// broad the selectors are.
+type stateRmExclusion struct {
+	Raw string
+
+	// instances is the set of resource instances that the address contains,
+	// by address, as found by states.Filter. A resource address excludes
+	// every instance of its resource, and a module instance address
+	// everything in it and in its descendent modules.
+	instances map[string]bool
+}
+
+// newStateRmExclusion finds what the address given to -exclude contains in
+// the given state. The address can be of anything that states.Filter
+// accepts, including a pattern with wildcards in place of instance keys.
+func newStateRmExclusion(state *states.State, raw string) (*stateRmExclusion, error) {
+	results, err := (&states.Filter{State: state}).Filter(raw)
+	if err != nil {
+		return nil, err
+	}
+	ret := &stateRmExclusion{
+		Raw:       raw,
+		instances: make(map[string]bool),
+	}
+	for _, addr := range stateFilterResultInstances(results) {
+		ret.instances[addr.String()] = true
+	}
+	return ret, nil
+}
+
+// Excludes returns true if the given resource instance is one of those that
+// the exclusion contains.
+func (e *stateRmExclusion) Excludes(addr addrs.AbsResourceInstance) bool {
+	return e.instances[addr.String()]
+}
+
+// filterStateRmExclusions returns the given instances that none of the given
+// exclusions exclude, along with those that any of them do. It also returns
+// the exclusions that excluded nothing, so that they can be warned about.
+func filterStateRmExclusions(instances []addrs.AbsResourceInstance, exclusions []*stateRmExclusion) (kept, excluded []addrs.AbsResourceInstance, unused []*stateRmExclusion) {
+	used := make([]bool, len(exclusions))
+	for _, addr := range instances {
+		skip := false
+		for i, e := range exclusions {
+			if e.Excludes(addr) {
+				used[i] = true
+				skip = true
+			}
+		}
+		if skip {
+			excluded = append(excluded, addr)
+		} else {
+			kept = append(kept, addr)
+		}
+	}
+	for i, e := range exclusions {
+		if !used[i] {
+			unused = append(unused, e)
+		}
+	}
+	return kept, excluded, unused
+}
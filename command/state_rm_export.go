@@ -0,0 +1,195 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmExportTimeout is how long the -export-before target has to accept
+// the document, whether it is a URL or a command, before the export is
+// abandoned. It is a variable so that tests can shorten it.
+var stateRmExportTimeout = 30 * time.Second
+
+// stateRmExportJSON is the document given to the -export-before command or
+// URL, describing each of the resource instances about to be removed along
+// with all of their objects.
+type stateRmExportJSON struct {
+	Label     string                  `json:"label,omitempty"`
+	Instances []stateRmExportInstance `json:"instances"`
+}
+
+type stateRmExportInstance struct {
+	Address  string      `json:"address"`
+	Mode     string      `json:"mode"`
+	Type     string      `json:"type"`
+	Name     string      `json:"name"`
+	Module   string      `json:"module"`
+	Provider string      `json:"provider"`
+	IndexKey interface{} `json:"index_key"`
+
+	// Current is null if the instance has only deposed objects.
+	Current *stateRmExportObject           `json:"current"`
+	Deposed map[string]stateRmExportObject `json:"deposed"`
+}
+
+type stateRmExportObject struct {
+	Status        string `json:"status"`
+	SchemaVersion uint64 `json:"schema_version"`
+
+	// Exactly one of Attributes or AttributesFlat is set, depending on the
+	// format the object's attributes are stored in.
+	Attributes     json.RawMessage   `json:"attributes,omitempty"`
+	AttributesFlat map[string]string `json:"attributes_flat,omitempty"`
+
+	Dependencies []string `json:"dependencies,omitempty"`
+}
+
+// marshalStateRmExport returns the document describing the removed items of
+// the given result for -export-before.
+func marshalStateRmExport(result *stateRmResult) ([]byte, error) {
+	out := stateRmExportJSON{
+		Label:     result.Label,
+		Instances: make([]stateRmExportInstance, 0, len(result.Items)),
+	}
+	for _, item := range result.Items {
+		// The common properties are the same as for "terraform state list
+		// -json", so the two can be matched up by an external system.
+		rs := &states.Resource{ProviderConfig: item.ProviderConfig}
+		listed := stateListJSON(item.Addr, rs, item.Instance)
+		inst := stateRmExportInstance{
+			Address:  listed.Address,
+			Mode:     listed.Mode,
+			Type:     listed.Type,
+			Name:     listed.Name,
+			Module:   listed.Module,
+			Provider: listed.Provider,
+			IndexKey: listed.IndexKey,
+			Deposed:  make(map[string]stateRmExportObject, len(item.Deposed)),
+		}
+		if obj := item.Instance.Current; obj != nil {
+			exported := stateRmExportObj(obj)
+			inst.Current = &exported
+		}
+		for _, k := range item.Deposed {
+			inst.Deposed[string(k)] = stateRmExportObj(item.Instance.Deposed[k])
+		}
+		out.Instances = append(out.Instances, inst)
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+func stateRmExportObj(obj *states.ResourceInstanceObjectSrc) stateRmExportObject {
+	ret := stateRmExportObject{
+		Status:         "ready",
+		SchemaVersion:  obj.SchemaVersion,
+		AttributesFlat: obj.AttrsFlat,
+	}
+	if obj.Status == states.ObjectTainted {
+		ret.Status = "tainted"
+	}
+	if obj.AttrsJSON != nil {
+		ret.Attributes = json.RawMessage(obj.AttrsJSON)
+		ret.AttributesFlat = nil
+	}
+	for _, dep := range obj.Dependencies {
+		ret.Dependencies = append(ret.Dependencies, dep.String())
+	}
+	sort.Strings(ret.Dependencies)
+	return ret
+}
+
+// exportBefore sends the document describing the removed items of the given
+// result to the given target for the -export-before option: as the body of a
+// POST request if the target is an http or https URL, or otherwise on the
+// stdin of the target run as a shell command.
+//
+// Any failure, including a non-zero exit status, a response status other
+// than 2xx, or the target taking longer than stateRmExportTimeout, is an
+// error, so that nothing is forgotten without being archived. The timeout
+// keeps an archive that is hanging from holding the state lock
+// indefinitely.
+func (c *StateRmCommand) exportBefore(target string, result *stateRmResult) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	src, err := marshalStateRmExport(result)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to export removed instances",
+			fmt.Sprintf("Could not encode the resource instances to remove as JSON: %s. The state has not been changed.", err),
+		))
+		return diags
+	}
+
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		err = stateRmExportHTTP(target, src, stateRmExportTimeout)
+	} else {
+		err = stateRmExportCommand(target, src, stateRmExportTimeout)
+	}
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to export removed instances",
+			fmt.Sprintf("The -export-before target %s failed: %s. The state has not been changed.", target, err),
+		))
+	}
+	return diags
+}
+
+func stateRmExportHTTP(url string, src []byte, timeout time.Duration) error {
+	client := cleanhttp.DefaultClient()
+	client.Timeout = timeout
+	resp, err := client.Post(url, "application/json", bytes.NewReader(src))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return fmt.Errorf("the server responded with %s: %s", resp.Status, msg)
+		}
+		return fmt.Errorf("the server responded with %s", resp.Status)
+	}
+	return nil
+}
+
+func stateRmExportCommand(command string, src []byte, timeout time.Duration) error {
+	// The command is run by the shell, as for the local-exec provisioner, so
+	// that it can be a pipeline or take arguments.
+	shell := []string{"/bin/sh", "-c"}
+	if runtime.GOOS == "windows" {
+		shell = []string{"cmd", "/C"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, shell[0], append(shell[1:], command)...)
+	cmd.Stdin = bytes.NewReader(src)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("the command didn't finish within %s", timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/plans"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// checkPostRemovePlan creates a plan for the configuration in the current
+// working directory against the given state, as saved after the removal,
+// for the -post-remove-plan-gate option, and returns an error if it would
+// destroy or replace more than the given number of resource instances.
+//
+// Forgetting an object can cascade into other changes, such as the
+// replacement of everything that was computed from its attributes, and this
+// catches a removal whose effect on the next apply is bigger than intended.
+func (c *StateRmCommand) checkPostRemovePlan(state *states.State, max int) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		return diags
+	}
+	plan, planDiags := c.planAgainstState(config, state)
+	diags = diags.Append(planDiags)
+	if planDiags.HasErrors() {
+		return diags
+	}
+
+	var lines []string
+	for _, rc := range plan.Changes.Resources {
+		switch rc.Action {
+		case plans.Delete, plans.DeleteThenCreate, plans.CreateThenDelete:
+			addr := rc.Addr.String()
+			if rc.DeposedKey != states.NotDeposed {
+				addr = fmt.Sprintf("%s (deposed object %s)", addr, rc.DeposedKey)
+			}
+			lines = append(lines, fmt.Sprintf("  %s %s", stateRmActionVerb(rc.Action), addr))
+		}
+	}
+	if len(lines) <= max {
+		return diags
+	}
+	sort.Strings(lines)
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Post-removal plan gate failed",
+		fmt.Sprintf("A plan against the state after the removal would destroy or replace %d resource instances, which is more than the %d allowed by -post-remove-plan-gate:\n\n%s\n\nThe removal may have had effects on other resources that weren't intended.", len(lines), max, strings.Join(lines, "\n")),
+	))
+	return diags
+}
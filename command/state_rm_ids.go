@@ -0,0 +1,89 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmRemovedID is the id of a single removed object, for the
+// -emit-removed-ids option.
+type stateRmRemovedID struct {
+	// Object is the address of the resource instance the object belonged
+	// to, followed by "deposed" and its key for a deposed object, as for
+	// stateRmObjectHash.
+	Object string
+	ID     string
+}
+
+// stateRmRemovedIDs returns the id attribute of each of the managed resource
+// objects removed in the given result, in the same order as the result's
+// items, with the current object of each instance before its deposed
+// objects. These are the ids of the real objects that are no longer managed
+// once the instances have been removed from the state.
+//
+// Data resources are left out, since there is no real object to clean up
+// for them, and so are objects without an id, which are reported in a
+// warning. An object whose attributes can't be decoded is an error unless
+// skipDecodeErrors is set.
+func stateRmRemovedIDs(result *stateRmResult, skipDecodeErrors bool) ([]stateRmRemovedID, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	var ret []stateRmRemovedID
+	var noID []string
+	for _, item := range result.Items {
+		if item.Addr.Resource.Resource.Mode != addrs.ManagedResourceMode {
+			continue
+		}
+		objects := make(map[string]*states.ResourceInstanceObjectSrc, len(item.Deposed)+1)
+		descs := make([]string, 0, len(item.Deposed)+1)
+		if obj := item.Instance.Current; obj != nil {
+			desc := item.Addr.String()
+			objects[desc] = obj
+			descs = append(descs, desc)
+		}
+		for _, k := range item.Deposed {
+			desc := fmt.Sprintf("%s deposed %s", item.Addr, k)
+			objects[desc] = item.Instance.Deposed[k]
+			descs = append(descs, desc)
+		}
+		for _, desc := range descs {
+			attrs, err := stateShowFlatAttrs(objects[desc])
+			if err != nil {
+				diags = diags.Append(stateRmDecodeError(item.Addr, "find its id for -emit-removed-ids", err, skipDecodeErrors))
+				continue
+			}
+			id := attrs["id"]
+			if id == "" {
+				noID = append(noID, "  "+desc)
+				continue
+			}
+			ret = append(ret, stateRmRemovedID{Object: desc, ID: id})
+		}
+	}
+	if len(noID) > 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Removed objects without ids",
+			fmt.Sprintf("The following objects have no id attribute, so they have been left out of the list of removed ids:\n\n%s", strings.Join(noID, "\n")),
+		))
+	}
+	return ret, diags
+}
+
+// writeStateRmRemovedIDs writes the given ids to the -emit-removed-ids file at
+// the given path, replacing any file that is already there. Each line is the
+// object followed by a tab and its id, so that a script can read the file
+// to delete the real objects.
+func writeStateRmRemovedIDs(path string, ids []stateRmRemovedID) error {
+	var buf bytes.Buffer
+	for _, id := range ids {
+		fmt.Fprintf(&buf, "%s\t%s\n", id.Object, id.ID)
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,77 @@
+package command
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateRmObjectHash is the SHA-256 hash of a single removed object, for the
+// -object-hash-manifest option.
+type stateRmObjectHash struct {
+	// Object is the address of the resource instance the object belonged
+	// to, followed by "deposed" and its key for a deposed object.
+	Object string
+	Sum    [sha256.Size]byte
+}
+
+// stateRmObjectHashes returns the hash of each of the objects removed in the
+// given result, in the same order as the result's items, with the current
+// object of each instance before its deposed objects.
+//
+// Each hash is of the object's JSON representation in the document sent by
+// -export-before, encoded without any indentation, so that the contents of
+// the removed objects can later be checked against an archived copy of that
+// document.
+func stateRmObjectHashes(result *stateRmResult) ([]stateRmObjectHash, error) {
+	var ret []stateRmObjectHash
+	for _, item := range result.Items {
+		if obj := item.Instance.Current; obj != nil {
+			sum, err := stateRmObjectSum(obj)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode %s: %s", item.Addr, err)
+			}
+			ret = append(ret, stateRmObjectHash{Object: item.Addr.String(), Sum: sum})
+		}
+		for _, k := range item.Deposed {
+			sum, err := stateRmObjectSum(item.Instance.Deposed[k])
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode deposed object %s of %s: %s", k, item.Addr, err)
+			}
+			ret = append(ret, stateRmObjectHash{
+				Object: fmt.Sprintf("%s deposed %s", item.Addr, k),
+				Sum:    sum,
+			})
+		}
+	}
+	return ret, nil
+}
+
+func stateRmObjectSum(obj *states.ResourceInstanceObjectSrc) ([sha256.Size]byte, error) {
+	src, err := json.Marshal(stateRmExportObj(obj))
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(src), nil
+}
+
+// writeStateRmObjectHashes writes the given hashes to the -object-hash-manifest
+// file at the given path, replacing any file that is already there. Each line
+// is a hash in hexadecimal followed by two spaces and the object it is of, as
+// for sha256sum. The label given with -label, if any, is recorded in a
+// comment after the header.
+func writeStateRmObjectHashes(path, label string, hashes []stateRmObjectHash) error {
+	var buf bytes.Buffer
+	buf.WriteString("# SHA-256 hashes of the objects removed by \"terraform state rm\".\n")
+	if label != "" {
+		fmt.Fprintf(&buf, "# Label: %s\n", label)
+	}
+	for _, hash := range hashes {
+		fmt.Fprintf(&buf, "%x  %s\n", hash.Sum, hash.Object)
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,117 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmMerge is a pair of resource instances given to the -merge option as
+// SOURCE=DEST, whose objects both represent the same real object. The source
+// is removed and the destination is kept.
+type stateRmMerge struct {
+	Source, Dest addrs.AbsResourceInstance
+
+	// ID is the id of the real object, once checkStateRmMerges has found
+	// that both objects have it.
+	ID string
+}
+
+// parseStateRmMerges parses the raw SOURCE=DEST arguments given to -merge.
+func (c *StateRmCommand) parseStateRmMerges(raws []string) ([]*stateRmMerge, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	ret := make([]*stateRmMerge, 0, len(raws))
+	for _, raw := range raws {
+		eq := strings.Index(raw, "=")
+		if eq < 1 || eq == len(raw)-1 {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -merge option",
+				fmt.Sprintf("The -merge option must be given as SOURCE=DEST, where SOURCE is the resource instance to remove and DEST is the one to keep, not %q.", raw),
+			))
+			continue
+		}
+		source, moreDiags := c.parseResourceInstanceAddr(raw[:eq], fmt.Sprintf("-merge=%q", raw))
+		diags = diags.Append(moreDiags)
+		dest, moreDiags := c.parseResourceInstanceAddr(raw[eq+1:], fmt.Sprintf("-merge=%q", raw))
+		diags = diags.Append(moreDiags)
+		if diags.HasErrors() {
+			continue
+		}
+		if source.Equal(dest) {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -merge option",
+				fmt.Sprintf("The -merge option %q gives the same resource instance as both the source and the destination.", raw),
+			))
+			continue
+		}
+		ret = append(ret, &stateRmMerge{Source: source, Dest: dest})
+	}
+	return ret, diags
+}
+
+// checkStateRmMerges checks that the source and destination of each of the
+// given merges have current objects of the same resource type in the given
+// state, with the same id, recording the id in each merge. Anything else
+// means that the two may not represent the same real object, and so is an
+// error.
+func checkStateRmMerges(state *states.State, merges []*stateRmMerge) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+	for _, merge := range merges {
+		var ids [2]string
+		for i, addr := range []addrs.AbsResourceInstance{merge.Source, merge.Dest} {
+			is := state.ResourceInstance(addr)
+			if is == nil || is.Current == nil {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"Can't merge resource instances",
+					fmt.Sprintf("There is no current object for %s in the state, so it can't be merged with %s.", addr, stateRmMergeOther(merge, addr)),
+				))
+				break
+			}
+			attrs, err := stateShowFlatAttrs(is.Current)
+			if err != nil {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"Invalid resource instance object",
+					fmt.Sprintf("The attributes of %s in the state could not be decoded to check its id: %s.", addr, err),
+				))
+				break
+			}
+			ids[i] = attrs["id"]
+		}
+		if diags.HasErrors() {
+			continue
+		}
+
+		source, dest := merge.Source.Resource.Resource, merge.Dest.Resource.Resource
+		switch {
+		case source.Mode != dest.Mode || source.Type != dest.Type:
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Can't merge resource instances",
+				fmt.Sprintf("%s and %s are of different resource types, so they can't represent the same real object.", merge.Source, merge.Dest),
+			))
+		case ids[0] == "" || ids[0] != ids[1]:
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Can't merge resource instances",
+				fmt.Sprintf("%s has id %q but %s has id %q, so they may not represent the same real object. Nothing has been removed.", merge.Source, ids[0], merge.Dest, ids[1]),
+			))
+		default:
+			merge.ID = ids[0]
+		}
+	}
+	return diags
+}
+
+func stateRmMergeOther(merge *stateRmMerge, addr addrs.AbsResourceInstance) addrs.AbsResourceInstance {
+	if addr.Equal(merge.Source) {
+		return merge.Dest
+	}
+	return merge.Source
+}
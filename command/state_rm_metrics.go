@@ -0,0 +1,81 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmMetrics collects what is recorded about a run of "terraform state rm"
+// for the -emit-metrics option, as the run progresses.
+type stateRmMetrics struct {
+	Start time.Time
+
+	// Result is the result of the removal, if the command got that far.
+	Result *stateRmResult
+	DryRun bool
+
+	// Label is the -label given for the removal, if any.
+	Label string
+
+	// BackupPath is the path of the local backup of the state, once the
+	// modified state has been persisted.
+	BackupPath string
+}
+
+// stateRmMetricsJSON is the format of the file written by -emit-metrics.
+type stateRmMetricsJSON struct {
+	Command         string         `json:"command"`
+	Success         bool           `json:"success"`
+	ExitStatus      int            `json:"exit_status"`
+	DryRun          bool           `json:"dry_run"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Label           string         `json:"label,omitempty"`
+	Removed         int            `json:"removed"`
+	ByType          map[string]int `json:"by_type"`
+
+	// BackupBytes is null when no local backup was written, such as for a
+	// dry run or a remote backend.
+	BackupBytes *int64 `json:"backup_bytes"`
+}
+
+// writeStateRmMetrics writes the given metrics for a run that exited with the
+// given status to the given path. A failure to write them is only a warning,
+// because it doesn't change the outcome of the run being measured.
+func (c *StateRmCommand) writeStateRmMetrics(path string, metrics *stateRmMetrics, code int) {
+	out := stateRmMetricsJSON{
+		Command:         "state rm",
+		Success:         code == 0 || (metrics.DryRun && code == 2),
+		ExitStatus:      code,
+		DryRun:          metrics.DryRun,
+		DurationSeconds: time.Since(metrics.Start).Seconds(),
+		Label:           metrics.Label,
+		ByType:          map[string]int{},
+	}
+	if metrics.Result != nil {
+		out.Removed = len(metrics.Result.Items)
+		out.ByType = metrics.Result.countByType()
+	}
+	if metrics.BackupPath != "" {
+		if info, err := os.Stat(metrics.BackupPath); err == nil {
+			size := info.Size()
+			out.BackupBytes = &size
+		}
+	}
+
+	src, err := json.MarshalIndent(out, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(path, append(src, '\n'), 0644)
+	}
+	if err != nil {
+		c.showDiagnostics(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Failed to write metrics",
+			fmt.Sprintf("Could not write the metrics for this run to %s: %s.", path, err),
+		))
+	}
+}
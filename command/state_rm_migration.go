@@ -0,0 +1,215 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateRmProviderMapping is a line of the mapping file given to the
+// -provider-migration-report option: the resources using the provider
+// configuration From would move to To, and to the state at State if given.
+type stateRmProviderMapping struct {
+	From, To addrs.AbsProviderConfig
+	State    string
+}
+
+// readProviderMappingFile reads the mappings listed in the file at the given
+// path, for the -provider-migration-report option. Each line of the file is
+// the provider configuration address used now followed by the one to move
+// to, and optionally by the path of the state to move the resources to, all
+// separated by whitespace. Blank lines and lines starting with "#" are
+// ignored.
+func readProviderMappingFile(path string) ([]*stateRmProviderMapping, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*stateRmProviderMapping
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("line %d must be a provider configuration address and the address to move to, optionally followed by a state path, separated by spaces, not:\n  %s", i+1, line)
+		}
+		from, diags := addrs.ParseAbsProviderConfigStr(fields[0])
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("line %d has an invalid provider configuration address %q: %s", i+1, fields[0], diags.Err())
+		}
+		to, diags := addrs.ParseAbsProviderConfigStr(fields[1])
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("line %d has an invalid provider configuration address %q: %s", i+1, fields[1], diags.Err())
+		}
+		if seen[from.String()] {
+			return nil, fmt.Errorf("line %d maps %s, which an earlier line already maps", i+1, from)
+		}
+		seen[from.String()] = true
+
+		mapping := &stateRmProviderMapping{From: from, To: to}
+		if len(fields) == 3 {
+			mapping.State = fields[2]
+		}
+		ret = append(ret, mapping)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("the file doesn't list any providers to map")
+	}
+	return ret, nil
+}
+
+// stateRmMigration is a resource in the report made by the
+// -provider-migration-report option, with the mapping for its provider
+// configuration, which is nil if the mapping file doesn't cover it.
+type stateRmMigration struct {
+	Addr     addrs.AbsResource
+	Provider addrs.AbsProviderConfig
+	Mapping  *stateRmProviderMapping
+}
+
+// planProviderMigration returns the resources of the given instances in the
+// given state, each once and in order, along with where the given mappings
+// would move them. Providers are set for whole resources rather than for
+// each instance, so the report is of resources too.
+func planProviderMigration(state *states.State, instances []addrs.AbsResourceInstance, mappings []*stateRmProviderMapping) []*stateRmMigration {
+	byProvider := make(map[string]*stateRmProviderMapping, len(mappings))
+	for _, mapping := range mappings {
+		byProvider[mapping.From.String()] = mapping
+	}
+
+	var ret []*stateRmMigration
+	seen := make(map[string]bool)
+	for _, addr := range instances {
+		resAddr := addr.ContainingResource()
+		if seen[resAddr.String()] {
+			continue
+		}
+		seen[resAddr.String()] = true
+
+		rs := state.Resource(resAddr)
+		if rs == nil {
+			continue
+		}
+		ret = append(ret, &stateRmMigration{
+			Addr:     resAddr,
+			Provider: rs.ProviderConfig,
+			Mapping:  byProvider[rs.ProviderConfig.String()],
+		})
+	}
+	return ret
+}
+
+// stateRmMigrationJSON is the JSON representation of the report made by the
+// -provider-migration-report option.
+type stateRmMigrationJSON struct {
+	Resources         []stateRmMigrationResourceJSON `json:"resources"`
+	UnmappedProviders []string                       `json:"unmapped_providers"`
+}
+
+type stateRmMigrationResourceJSON struct {
+	Address     string `json:"address"`
+	Provider    string `json:"provider"`
+	NewProvider string `json:"new_provider,omitempty"`
+	NewState    string `json:"new_state,omitempty"`
+}
+
+// providerMigrationReport prints where each resource of the given instances
+// in the given state would move to under the given provider mappings, for
+// the -provider-migration-report option, returning the exit status for the
+// command. Nothing is changed: the report is for planning a migration to be
+// made afterwards with "terraform state replace-provider" and
+// "terraform state mv".
+func (c *StateRmCommand) providerMigrationReport(state *states.State, instances []addrs.AbsResourceInstance, mappings []*stateRmProviderMapping, jsonOutput bool) int {
+	migrations := planProviderMigration(state, instances, mappings)
+
+	var unmapped []string
+	unmappedSeen := make(map[string]bool)
+	moving := 0
+	for _, m := range migrations {
+		if m.Mapping != nil {
+			moving++
+			continue
+		}
+		if provider := m.Provider.String(); !unmappedSeen[provider] {
+			unmappedSeen[provider] = true
+			unmapped = append(unmapped, provider)
+		}
+	}
+	sort.Strings(unmapped)
+
+	if jsonOutput {
+		out := stateRmMigrationJSON{
+			Resources:         make([]stateRmMigrationResourceJSON, len(migrations)),
+			UnmappedProviders: unmapped,
+		}
+		if out.UnmappedProviders == nil {
+			out.UnmappedProviders = []string{}
+		}
+		for i, m := range migrations {
+			out.Resources[i] = stateRmMigrationResourceJSON{
+				Address:  m.Addr.String(),
+				Provider: m.Provider.String(),
+			}
+			if m.Mapping != nil {
+				out.Resources[i].NewProvider = m.Mapping.To.String()
+				out.Resources[i].NewState = m.Mapping.State
+			}
+		}
+		src, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to marshal report to JSON: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(src))
+		return 0
+	}
+
+	if len(migrations) == 0 {
+		c.Ui.Output("No resources selected.")
+		return 0
+	}
+
+	rows := [][]string{{"RESOURCE", "PROVIDER", "NEW PROVIDER", "NEW STATE"}}
+	for _, m := range migrations {
+		row := []string{m.Addr.String(), m.Provider.String(), "(unmapped)", "-"}
+		if m.Mapping != nil {
+			row[2] = m.Mapping.To.String()
+			if m.Mapping.State != "" {
+				row[3] = m.Mapping.State
+			}
+		}
+		rows = append(rows, row)
+	}
+	widths := make([]int, len(rows[0])-1)
+	for _, row := range rows {
+		for i := range widths {
+			if len(row[i]) > widths[i] {
+				widths[i] = len(row[i])
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, row := range rows {
+		for i, width := range widths {
+			fmt.Fprintf(&buf, "%-*s  ", width, row[i])
+		}
+		fmt.Fprintf(&buf, "%s\n", row[len(row)-1])
+	}
+	fmt.Fprintf(&buf, "\n%d of %d resources would move to a new provider.", moving, len(migrations))
+	if len(unmapped) > 0 {
+		fmt.Fprintf(&buf, " The mapping doesn't cover these providers:\n  %s", strings.Join(unmapped, "\n  "))
+	}
+	c.Ui.Output(buf.String())
+	return 0
+}
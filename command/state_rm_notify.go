@@ -0,0 +1,70 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmNotifyJSON is the payload POSTed to the -snapshot-diff-url webhook
+// once the modified state has been saved, describing the difference between
+// the previous snapshot and the new one.
+type stateRmNotifyJSON struct {
+	Command   string `json:"command"`
+	Workspace string `json:"workspace"`
+	Label     string `json:"label,omitempty"`
+
+	// Lineage and Serial are those of the new snapshot, and are omitted if
+	// the backend doesn't report them.
+	Lineage string  `json:"lineage,omitempty"`
+	Serial  *uint64 `json:"serial,omitempty"`
+
+	Removed []string `json:"removed"`
+	Outputs []string `json:"outputs"`
+
+	CurrentCount int            `json:"current_count"`
+	DeposedCount int            `json:"deposed_count"`
+	ByType       map[string]int `json:"by_type"`
+}
+
+// notifySnapshotDiff POSTs a description of what the given result removed
+// from the state saved by the given state manager to the given URL, for the
+// -snapshot-diff-url option. The state has already been saved by then, so any
+// failure is only a warning.
+func (c *StateRmCommand) notifySnapshotDiff(url string, stateMgr statemgr.Full, result *stateRmResult) {
+	out := stateRmNotifyJSON{
+		Command:      "state rm",
+		Workspace:    c.Workspace(),
+		Label:        result.Label,
+		Removed:      make([]string, 0, len(result.Items)),
+		Outputs:      make([]string, 0, len(result.Outputs)),
+		CurrentCount: result.CurrentCount,
+		DeposedCount: result.DeposedCount,
+		ByType:       result.countByType(),
+	}
+	if metaMgr, ok := stateMgr.(statemgr.PersistentMeta); ok {
+		meta := metaMgr.StateSnapshotMeta()
+		out.Lineage = meta.Lineage
+		out.Serial = &meta.Serial
+	}
+	for _, item := range result.Items {
+		out.Removed = append(out.Removed, item.Addr.String())
+	}
+	for _, addr := range result.Outputs {
+		out.Outputs = append(out.Outputs, addr.String())
+	}
+
+	src, err := json.Marshal(out)
+	if err == nil {
+		err = stateRmExportHTTP(url, src, stateRmExportTimeout)
+	}
+	if err != nil {
+		c.showDiagnostics(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Failed to send notification",
+			fmt.Sprintf("Could not notify %s of the removal: %s. The state was saved.", url, err),
+		))
+	}
+}
@@ -0,0 +1,103 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateRmDependencyLayers groups the given items, for the
+// -respect-depends-on-order option, into the steps in which to remove them so
+// that, after each step, none of the items still in the state depends on an
+// item that has been removed. The first step has the items that none of the
+// others depend on, the next those that only the first step depended on, and
+// so on. Within each step the items keep their order.
+//
+// Only the dependencies recorded between the given items count, since those
+// on other instances aren't affected by the removal. If the dependencies
+// form a cycle, the addresses of the items in it or depending on it are
+// returned instead, since they can't be ordered.
+func stateRmDependencyLayers(items []*stateRmItem) ([][]*stateRmItem, []string) {
+	// dependsOn[i] are the indexes of the items that items[i] depends on,
+	// and dependents[j] is how many of the items not yet removed depend on
+	// items[j].
+	dependsOn := make([][]int, len(items))
+	dependents := make([]int, len(items))
+	for i, item := range items {
+		for j, other := range items {
+			if i != j && stateRmItemDependsOn(item, other) {
+				dependsOn[i] = append(dependsOn[i], j)
+				dependents[j]++
+			}
+		}
+	}
+
+	var layers [][]*stateRmItem
+	removed := make([]bool, len(items))
+	left := len(items)
+	for left > 0 {
+		var layer []int
+		for i := range items {
+			if !removed[i] && dependents[i] == 0 {
+				layer = append(layer, i)
+			}
+		}
+		if len(layer) == 0 {
+			var cycle []string
+			for i, item := range items {
+				if !removed[i] {
+					cycle = append(cycle, item.Addr.String())
+				}
+			}
+			return nil, cycle
+		}
+
+		step := make([]*stateRmItem, len(layer))
+		for n, i := range layer {
+			step[n] = items[i]
+			removed[i] = true
+			for _, j := range dependsOn[i] {
+				dependents[j]--
+			}
+		}
+		layers = append(layers, step)
+		left -= len(layer)
+	}
+	return layers, nil
+}
+
+// stateRmItemDependsOn returns true if any of the objects of the given item
+// has a recorded dependency on the instance of the other item.
+func stateRmItemDependsOn(item, other *stateRmItem) bool {
+	objs := make([]*states.ResourceInstanceObjectSrc, 0, len(item.Instance.Deposed)+1)
+	if item.Instance.Current != nil {
+		objs = append(objs, item.Instance.Current)
+	}
+	for _, obj := range item.Instance.Deposed {
+		objs = append(objs, obj)
+	}
+	for _, obj := range objs {
+		for _, ref := range obj.Dependencies {
+			if dependencyMatches(item.Addr.Module, ref, other.Addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeStateRmOrder writes the steps of a removal ordered by
+// -respect-depends-on-order to the given buffer, with a line listing the
+// resource instances removed in each step.
+func writeStateRmOrder(buf *bytes.Buffer, layers [][]*stateRmItem) {
+	fmt.Fprintf(buf, "Removal order, respecting dependencies, in %d steps:\n", len(layers))
+	for i, layer := range layers {
+		names := make([]string, len(layer))
+		for j, item := range layer {
+			names[j] = item.Addr.String()
+		}
+		fmt.Fprintf(buf, "  %d. %s\n", i+1, strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,744 @@
+package command
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/hcl2/hclwrite"
+	"github.com/mitchellh/cli"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/plans"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateRmModuleGroup is the subset of the items in a stateRmResult that
+// belong to a single module instance, along with their subtotals.
+type stateRmModuleGroup struct {
+	Module addrs.ModuleInstance
+	Items  []*stateRmItem
+
+	CurrentCount, DeposedCount int
+}
+
+// groupByModule partitions the items in the result by the module instance
+// they belong to. The groups are ordered by module address, starting with
+// the root module, and the items within each group keep their order from
+// the result.
+func (r *stateRmResult) groupByModule() []*stateRmModuleGroup {
+	var groups []*stateRmModuleGroup
+	byModule := make(map[string]*stateRmModuleGroup)
+	for _, item := range r.Items {
+		key := item.Addr.Module.String()
+		group, ok := byModule[key]
+		if !ok {
+			group = &stateRmModuleGroup{Module: item.Addr.Module}
+			byModule[key] = group
+			groups = append(groups, group)
+		}
+		group.Items = append(group.Items, item)
+		if item.Current {
+			group.CurrentCount++
+		}
+		group.DeposedCount += len(item.Deposed)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Module.Less(groups[j].Module)
+	})
+	return groups
+}
+
+// countByType returns the number of resource instances in the result for
+// each resource type. Data resource types are prefixed with "data." so that
+// they are counted separately from managed resources of the same type.
+func (r *stateRmResult) countByType() map[string]int {
+	ret := make(map[string]int)
+	for _, item := range r.Items {
+		ret[stateRmTypeKey(item.Addr)]++
+	}
+	return ret
+}
+
+func stateRmTypeKey(addr addrs.AbsResourceInstance) string {
+	res := addr.Resource.Resource
+	if res.Mode == addrs.DataResourceMode {
+		return "data." + res.Type
+	}
+	return res.Type
+}
+
+// writeStateRmTypeSummary writes a single line to the given buffer giving the
+// number of resource instances of each type in the result, ordered by type.
+func writeStateRmTypeSummary(buf *bytes.Buffer, result *stateRmResult) {
+	counts := result.countByType()
+	types := make([]string, 0, len(counts))
+	for ty := range counts {
+		types = append(types, ty)
+	}
+	sort.Strings(types)
+
+	parts := make([]string, len(types))
+	for i, ty := range types {
+		parts[i] = fmt.Sprintf("%s: %d", ty, counts[ty])
+	}
+	fmt.Fprintf(buf, "By type: %s\n", strings.Join(parts, ", "))
+}
+
+// stateRmShape records the module instances in a state and the number of
+// instances of each of its resources, so that what a removal took away can be
+// counted after the state has changed.
+type stateRmShape struct {
+	Modules []addrs.ModuleInstance
+
+	// Instances is the number of instances of each resource, by address.
+	Instances map[string]int
+}
+
+// newStateRmShape records the shape of the given state.
+func newStateRmShape(state *states.State) *stateRmShape {
+	ret := &stateRmShape{Instances: make(map[string]int)}
+	for _, ms := range state.Modules {
+		ret.Modules = append(ret.Modules, ms.Addr)
+		for _, rs := range ms.Resources {
+			ret.Instances[rs.Addr.Absolute(ms.Addr).String()] = len(rs.Instances)
+		}
+	}
+	return ret
+}
+
+// writeStateRmKindSummary writes a single line to the given buffer giving the
+// number of module instances, resources and resource instances that the
+// result removed from a state of the given shape, as it was before the
+// removal. The given modules are those removed as a whole, and each of their
+// descendent modules is counted too. A resource is only counted once all of
+// its instances are removed, so removing some instances of a resource that
+// uses count or for_each removes none of the resource itself. The deposed
+// objects and output values removed are added to the line if there are any,
+// so that it is the only summary of the removal.
+func writeStateRmKindSummary(buf *bytes.Buffer, shape *stateRmShape, modules []addrs.ModuleInstance, result *stateRmResult) {
+	moduleCount := 0
+	for _, addr := range shape.Modules {
+		for _, modAddr := range modules {
+			if moduleWithinScope(addr, modAddr) {
+				moduleCount++
+				break
+			}
+		}
+	}
+
+	removed := make(map[string]int)
+	seen := make(map[string]bool, len(result.Items))
+	for _, item := range result.Items {
+		if seen[item.Addr.String()] {
+			continue
+		}
+		seen[item.Addr.String()] = true
+		removed[item.Addr.ContainingResource().String()]++
+	}
+	resourceCount := 0
+	for addr, n := range removed {
+		if n == shape.Instances[addr] {
+			resourceCount++
+		}
+	}
+
+	parts := []string{stateRmCount(moduleCount, "module"), stateRmCount(resourceCount, "resource"), stateRmCount(len(result.Items), "resource instance")}
+	if result.DeposedCount > 0 {
+		parts = append(parts, stateRmCount(result.DeposedCount, "deposed object"))
+	}
+	if len(result.Outputs) > 0 {
+		parts = append(parts, stateRmCount(len(result.Outputs), "output value"))
+	}
+	fmt.Fprintf(buf, "Removed %s.\n", strings.Join(parts, ", "))
+}
+
+// stateRmCount returns the given count followed by the given noun, which is
+// made plural unless the count is exactly one.
+func stateRmCount(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// writeStateRmModuleSummary writes a single line to the given buffer giving
+// the number of resource instances in each module in the result, ordered by
+// module address.
+func writeStateRmModuleSummary(buf *bytes.Buffer, result *stateRmResult) {
+	groups := result.groupByModule()
+	parts := make([]string, len(groups))
+	for i, group := range groups {
+		name := group.Module.String()
+		if group.Module.IsRoot() {
+			name = "root module"
+		}
+		parts[i] = fmt.Sprintf("%s: %d", name, len(group.Items))
+	}
+	fmt.Fprintf(buf, "By module: %s\n", strings.Join(parts, ", "))
+}
+
+// writeStateRmItems writes one line per object of each of the given items to
+// the given buffer, each starting with the given prefix and verb.
+func writeStateRmItems(buf *bytes.Buffer, items []*stateRmItem, prefix, verb string) {
+	for _, item := range items {
+		if item.Current {
+			fmt.Fprintf(buf, "%s%s %s\n", prefix, verb, item.Addr)
+		}
+		for _, k := range item.Deposed {
+			fmt.Fprintf(buf, "%s%s %s deposed object %s\n", prefix, verb, item.Addr, k)
+		}
+	}
+}
+
+// writeStateRmGroups writes the items of the given result to the given
+// buffer as a tree, with a header and a subtotal for each module.
+func writeStateRmGroups(buf *bytes.Buffer, result *stateRmResult, verb string) {
+	for _, group := range result.groupByModule() {
+		name := group.Module.String()
+		if group.Module.IsRoot() {
+			name = "root module"
+		}
+		fmt.Fprintf(buf, "%s:\n", name)
+		writeStateRmItems(buf, group.Items, "  ", verb)
+		fmt.Fprintf(buf, "  Subtotal: %d current and %d deposed objects\n\n", group.CurrentCount, group.DeposedCount)
+	}
+}
+
+// writeStateRmTypeGroups writes the items of the given result to the given
+// buffer with a header for each resource type, ordered by type, as with
+// -resource-type.
+func writeStateRmTypeGroups(buf *bytes.Buffer, result *stateRmResult, verb string) {
+	byType := make(map[string][]*stateRmItem)
+	for _, item := range result.Items {
+		ty := stateRmTypeKey(item.Addr)
+		byType[ty] = append(byType[ty], item)
+	}
+	types := make([]string, 0, len(byType))
+	for ty := range byType {
+		types = append(types, ty)
+	}
+	sort.Strings(types)
+
+	for _, ty := range types {
+		fmt.Fprintf(buf, "%s:\n", ty)
+		writeStateRmItems(buf, byType[ty], "  ", verb)
+		buf.WriteString("\n")
+	}
+}
+
+// renderStateRmDryRun returns the human-readable report of a dry run with
+// the given options, which had the given result.
+func renderStateRmDryRun(opts *stateRmOpts, result *stateRmResult) string {
+	sel := result.Selection
+	removedFroms, appliedFroms, removedConfig, expansions, merges, kept := sel.RemovedFroms, sel.AppliedFroms, sel.RemovedConfig, sel.Expansions, opts.Merges, sel.Kept
+	if opts.SummaryOnly {
+		// Everything up to the totals is one line per selector or
+		// instance, which is what -summary-only leaves out.
+		removedFroms, appliedFroms, removedConfig, expansions, merges, kept = nil, nil, nil, nil, nil, nil
+	}
+
+	var buf bytes.Buffer
+	resolved := make(map[string][]addrs.AbsResourceInstance, len(sel.Matches))
+	for _, match := range sel.Matches {
+		resolved[match.Selector] = match.Addrs
+	}
+	for _, from := range removedFroms {
+		fmt.Fprintf(&buf, "From a removed block in %s: %s\n", from.Path, from.From)
+		if instances := resolved[from.From]; len(instances) != 1 || instances[0].String() != from.From {
+			for _, addr := range instances {
+				fmt.Fprintf(&buf, "  %s\n", addr)
+			}
+		}
+	}
+	for _, from := range appliedFroms {
+		fmt.Fprintf(&buf, "Already applied removed block in %s: %s\n", from.Path, from.From)
+	}
+	if len(removedFroms) > 0 || len(appliedFroms) > 0 {
+		buf.WriteString("\n")
+	}
+	for _, merge := range merges {
+		fmt.Fprintf(&buf, "Merging %s into %s, which has the same id %q\n", merge.Source, merge.Dest, merge.ID)
+	}
+	if len(merges) > 0 {
+		buf.WriteString("\n")
+	}
+	if len(removedConfig) > 0 {
+		oldRev, newRev, _ := parseRevisionRange(opts.RemovedBetween)
+		for _, addr := range removedConfig {
+			fmt.Fprintf(&buf, "Removed from the configuration between %s and %s: %s\n", oldRev, newRev, addr)
+		}
+		buf.WriteString("\n")
+	}
+	for _, expansion := range expansions {
+		fmt.Fprintf(&buf, "%s expands to %d instances: %s\n", expansion.Selector, len(expansion.Addrs), stateRmInstanceKeys(expansion.Addrs))
+	}
+	if len(expansions) > 0 {
+		buf.WriteString("\n")
+	}
+	switch {
+	case opts.SummaryOnly:
+		if len(result.Outputs) > 0 {
+			fmt.Fprintf(&buf, "Would remove %d output values.\n", len(result.Outputs))
+		}
+	case opts.GroupByModule:
+		writeStateRmGroups(&buf, result, "Would remove")
+	case len(opts.ResourceTypes) > 0:
+		writeStateRmTypeGroups(&buf, result, "Would remove")
+	default:
+		writeStateRmItems(&buf, result.Items, "", "Would remove")
+	}
+	if opts.RespectOrder && !opts.SummaryOnly {
+		buf.WriteString("\n")
+		writeStateRmOrder(&buf, result.OrderLayers)
+	}
+	if opts.AddressTransform != "" && len(result.Items) > 0 {
+		// The option was already validated by validate.
+		transform, _ := parseStateRmAddressTransform(opts.AddressTransform)
+		buf.WriteString("\n")
+		writeStateRmAddressTransform(&buf, result.Items, transform)
+	}
+	for _, k := range kept {
+		fmt.Fprintf(&buf, "Would keep %s, one of the %d newest by %s = %s\n", k.Addr, opts.KeepLatest, opts.KeepLatestBy, k.Timestamp)
+	}
+
+	if !opts.SummaryOnly {
+		staleRefs := make(map[string]string, len(result.StaleOutputs))
+		for _, stale := range result.StaleOutputs {
+			staleRefs[stale.Addr.String()] = stale.Ref
+		}
+		for _, addr := range result.Outputs {
+			if ref, ok := staleRefs[addr.String()]; ok {
+				fmt.Fprintf(&buf, "Would remove output %s, which refers to removed %s\n", addr, ref)
+				continue
+			}
+			fmt.Fprintf(&buf, "Would remove output %s\n", addr)
+		}
+	}
+
+	managedCount, dataCount := 0, 0
+	for _, item := range result.Items {
+		switch item.Addr.Resource.Resource.Mode {
+		case addrs.ManagedResourceMode:
+			managedCount++
+		case addrs.DataResourceMode:
+			dataCount++
+		}
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = "all"
+	}
+	fmt.Fprintf(&buf, "\nSelected %d managed and %d data resource instances using -mode=%s.\n", managedCount, dataCount, mode)
+	if sel.OutOfScope > 0 {
+		scope, _ := parseModuleInstanceArg(opts.ScopeModule)
+		fmt.Fprintf(&buf, "Skipped %d selected resource instances outside of %s.\n", sel.OutOfScope, scope)
+	}
+	if len(sel.Excluded) > 0 {
+		fmt.Fprintf(&buf, "Skipped %d selected resource instances because of -exclude.\n", len(sel.Excluded))
+	}
+	if len(sel.Unmatched) > 0 {
+		fmt.Fprintf(&buf, "Skipped %d selected resource instances because of -where or -where-not.\n", len(sel.Unmatched))
+	}
+	if sel.Resumed > 0 {
+		fmt.Fprintf(&buf, "Skipped %d selected resource instances already removed according to %s.\n", sel.Resumed, opts.Checkpoint)
+	}
+	if len(result.Items) > 0 {
+		writeStateRmTypeSummary(&buf, result)
+		if opts.SummaryOnly {
+			writeStateRmModuleSummary(&buf, result)
+		}
+	}
+	if opts.DiffProviders && len(result.ProviderCounts) > 0 {
+		fmt.Fprintf(&buf, "\nThe selected instances belong to %d provider configurations:\n%s\n", len(result.ProviderCounts), strings.Join(stateRmProviderLines(result.ProviderCounts), "\n"))
+	}
+	fmt.Fprintf(&buf, "\nWould've removed %d current and %d deposed objects, without -dry-run.", result.CurrentCount, result.DeposedCount)
+	return buf.String()
+}
+
+// writeStateRmPlanSummary writes a summary of the given plan, made against
+// the state after the removal described by the given result, to the given
+// buffer. The planned action for each removed resource instance is listed,
+// followed by the total number of changes in the plan in the same terms as
+// "terraform plan".
+func writeStateRmPlanSummary(buf *bytes.Buffer, plan *plans.Plan, result *stateRmResult) {
+	actions := make(map[string]plans.Action)
+	var add, change, destroy int
+	for _, rc := range plan.Changes.Resources {
+		if rc.DeposedKey == states.NotDeposed {
+			actions[rc.Addr.String()] = rc.Action
+		}
+		switch rc.Action {
+		case plans.Create:
+			add++
+		case plans.Update:
+			change++
+		case plans.Delete:
+			destroy++
+		case plans.DeleteThenCreate, plans.CreateThenDelete:
+			add++
+			destroy++
+		}
+	}
+
+	fmt.Fprintf(buf, "\nA plan against the state after the removal would:\n")
+	for _, item := range result.Items {
+		addr := item.Addr.String()
+		action, ok := actions[addr]
+		switch {
+		case !ok || action == plans.NoOp:
+			fmt.Fprintf(buf, "  leave %s unchanged\n", addr)
+		case action == plans.Create:
+			fmt.Fprintf(buf, "  create %s again, since it is still in the configuration\n", addr)
+		default:
+			fmt.Fprintf(buf, "  %s %s\n", stateRmActionVerb(action), addr)
+		}
+	}
+	fmt.Fprintf(buf, "\nPlan: %d to add, %d to change, %d to destroy.\n", add, change, destroy)
+}
+
+func stateRmActionVerb(action plans.Action) string {
+	switch action {
+	case plans.Read:
+		return "read"
+	case plans.Update:
+		return "update"
+	case plans.Delete:
+		return "destroy"
+	case plans.DeleteThenCreate, plans.CreateThenDelete:
+		return "replace"
+	default:
+		return action.String()
+	}
+}
+
+// stateRmOutputFileUi is a cli.Ui that writes its output to a file for the
+// -output option, while everything else, including errors and warnings, still
+// goes to the wrapped Ui.
+type stateRmOutputFileUi struct {
+	cli.Ui
+	w io.Writer
+}
+
+func (u *stateRmOutputFileUi) Output(msg string) {
+	fmt.Fprintln(u.w, msg)
+}
+
+// stateRmRemovedBlocks returns the source of an HCL file with a "removed"
+// block for each of the given items, for -emit-removed-block. The result can
+// be read back with -by-resource-file.
+func stateRmRemovedBlocks(items []*stateRmItem) []byte {
+	var buf bytes.Buffer
+	for i, item := range items {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "removed {\n  from = %s\n}\n", item.Addr)
+	}
+	return hclwrite.Format(buf.Bytes())
+}
+
+// stateRmTemplateItem is the data given to the -template for each removed
+// resource instance.
+type stateRmTemplateItem struct {
+	Address  string
+	Mode     string
+	Type     string
+	Name     string
+	Module   string
+	Provider string
+
+	// ID is the legacy "id" attribute of the current object, or an empty
+	// string if there is no current object or it has no such attribute.
+	ID string
+
+	// Deposed are the keys of the instance's deposed objects.
+	Deposed []string
+}
+
+// parseStateRmTemplate parses the given text as a -template. The template is
+// also rendered once for an empty item, so that a reference to a field that
+// doesn't exist is caught before anything is removed.
+func parseStateRmTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("template").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(ioutil.Discard, &stateRmTemplateItem{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// renderStateRmTemplate renders the given template once for each of the
+// given items, ending each with a newline if the template doesn't, and
+// returns the result without the final newline.
+func renderStateRmTemplate(tmpl *template.Template, items []*stateRmItem) (string, error) {
+	var buf bytes.Buffer
+	for _, item := range items {
+		res := item.Addr.Resource.Resource
+		data := &stateRmTemplateItem{
+			Address:  item.Addr.String(),
+			Type:     res.Type,
+			Name:     res.Name,
+			Module:   item.Addr.Module.String(),
+			Provider: item.ProviderConfig.String(),
+			Deposed:  []string{},
+		}
+		if item.Instance.Current != nil {
+			data.ID = states.LegacyInstanceObjectID(item.Instance.Current)
+		}
+		switch res.Mode {
+		case addrs.ManagedResourceMode:
+			data.Mode = "managed"
+		case addrs.DataResourceMode:
+			data.Mode = "data"
+		}
+		for _, k := range item.Deposed {
+			data.Deposed = append(data.Deposed, string(k))
+		}
+
+		start := buf.Len()
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("%s: %s", item.Addr, err)
+		}
+		if buf.Len() > start && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString("\n")
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// stateRmJSONEntry is an entry in the JSON representation of a
+// stateRmResult, as produced by "terraform state rm -json", which is an array
+// of these. Type is "module" for a module removed as a whole, "resource" for
+// a resource with all of its instances removed, or "resource_instance" for
+// one instance removed from a resource that has others. Contains lists the
+// resource instances removed along with a module, or with a resource that
+// uses count or for_each.
+type stateRmJSONEntry struct {
+	Type     string             `json:"type"`
+	Address  string             `json:"address"`
+	Contains []stateRmJSONEntry `json:"contains,omitempty"`
+}
+
+// stateRmJSONEntries returns the JSON representation of the given result,
+// with the instances of the given modules removed as a whole nested under
+// them, and ordered by the first instance removed from each entry. The
+// shape is that of the state before the removal, which tells whether all of
+// a resource's instances were removed.
+func stateRmJSONEntries(result *stateRmResult, modules []addrs.ModuleInstance, shape *stateRmShape) []stateRmJSONEntry {
+	// A module inside another that was removed is part of that one.
+	var outer []addrs.ModuleInstance
+	for _, modAddr := range modules {
+		nested := false
+		for _, other := range modules {
+			if !other.Equal(modAddr) && moduleWithinScope(modAddr, other) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			outer = append(outer, modAddr)
+		}
+	}
+
+	removed := make(map[string]int)
+	seen := make(map[string]bool, len(result.Items))
+	var items []*stateRmItem
+	for _, item := range result.Items {
+		if seen[item.Addr.String()] {
+			continue
+		}
+		seen[item.Addr.String()] = true
+		items = append(items, item)
+		removed[item.Addr.ContainingResource().String()]++
+	}
+
+	ret := []stateRmJSONEntry{}
+	index := make(map[string]int)
+	for _, item := range items {
+		instance := stateRmJSONEntry{Type: "resource_instance", Address: item.Addr.String()}
+
+		var module *addrs.ModuleInstance
+		for i := range outer {
+			if moduleWithinScope(item.Addr.Module, outer[i]) {
+				module = &outer[i]
+				break
+			}
+		}
+		if module != nil {
+			key := "module " + module.String()
+			if _, ok := index[key]; !ok {
+				index[key] = len(ret)
+				ret = append(ret, stateRmJSONEntry{Type: "module", Address: module.String()})
+			}
+			ret[index[key]].Contains = append(ret[index[key]].Contains, instance)
+			continue
+		}
+
+		resAddr := item.Addr.ContainingResource().String()
+		if removed[resAddr] != shape.Instances[resAddr] {
+			ret = append(ret, instance)
+			continue
+		}
+		key := "resource " + resAddr
+		if _, ok := index[key]; !ok {
+			index[key] = len(ret)
+			ret = append(ret, stateRmJSONEntry{Type: "resource", Address: resAddr})
+		}
+		if item.Addr.Resource.Key != addrs.NoKey {
+			ret[index[key]].Contains = append(ret[index[key]].Contains, instance)
+		}
+	}
+	return ret
+}
+
+// stateRmJSONModuleGroup is an element of the JSON representation of a
+// stateRmResult grouped by module, as produced by "terraform state rm -json
+// -group-by-module", which is an array of these. Contains are the entries
+// for what was removed from the module itself, as for stateRmJSONEntries,
+// followed by its subtotals and the number of instances of each type.
+type stateRmJSONModuleGroup struct {
+	Type     string             `json:"type"`
+	Address  string             `json:"address"`
+	Contains []stateRmJSONEntry `json:"contains"`
+
+	CurrentCount int            `json:"current_count"`
+	DeposedCount int            `json:"deposed_count"`
+	ByType       map[string]int `json:"by_type"`
+}
+
+// stateRmJSONModuleGroups returns the JSON representation of the given
+// result grouped by module, with an element for each module instance that
+// anything was removed from, in the order of groupByModule. The root module
+// has an empty address. The shape is that of the state before the removal.
+func stateRmJSONModuleGroups(result *stateRmResult, shape *stateRmShape) []stateRmJSONModuleGroup {
+	ret := []stateRmJSONModuleGroup{}
+	for _, group := range result.groupByModule() {
+		items := &stateRmResult{Items: group.Items}
+		ret = append(ret, stateRmJSONModuleGroup{
+			Type:         "module",
+			Address:      group.Module.String(),
+			Contains:     stateRmJSONEntries(items, nil, shape),
+			CurrentCount: group.CurrentCount,
+			DeposedCount: group.DeposedCount,
+			ByType:       items.countByType(),
+		})
+	}
+	return ret
+}
+
+// marshalStateRmJSON returns the JSON representation of the given result,
+// as described for stateRmJSONEntries, or grouped by module as described
+// for stateRmJSONModuleGroups.
+func marshalStateRmJSON(result *stateRmResult, modules []addrs.ModuleInstance, shape *stateRmShape, groupByModule bool) ([]byte, error) {
+	if groupByModule {
+		return json.MarshalIndent(stateRmJSONModuleGroups(result, shape), "", "  ")
+	}
+	return json.MarshalIndent(stateRmJSONEntries(result, modules, shape), "", "  ")
+}
+
+// stateRmSummaryJSON is the JSON representation of the summary of a
+// stateRmResult, as produced by "terraform state rm -json -summary-only".
+type stateRmSummaryJSON struct {
+	DryRun bool   `json:"dry_run"`
+	Mode   string `json:"mode"`
+	Label  string `json:"label,omitempty"`
+
+	ResourceCount int `json:"resource_count"`
+	CurrentCount  int `json:"current_count"`
+	DeposedCount  int `json:"deposed_count"`
+	OutputCount   int `json:"output_count"`
+	SkippedCount  int `json:"skipped_count"`
+
+	ByType   map[string]int `json:"by_type"`
+	ByModule map[string]int `json:"by_module"`
+
+	// BackupPath is null when no local backup was written, such as for a
+	// dry run or a remote backend.
+	BackupPath *string `json:"backup_path"`
+}
+
+// marshalStateRmSummaryJSON returns the JSON representation of the summary
+// of the given result, giving the path of the backup written for it, if any.
+func marshalStateRmSummaryJSON(result *stateRmResult, dryRun bool, mode, backupPath string) ([]byte, error) {
+	out := stateRmSummaryJSON{
+		DryRun:        dryRun,
+		Mode:          mode,
+		Label:         result.Label,
+		ResourceCount: len(result.Items),
+		CurrentCount:  result.CurrentCount,
+		DeposedCount:  result.DeposedCount,
+		OutputCount:   len(result.Outputs),
+		SkippedCount:  len(result.Skipped),
+		ByType:        result.countByType(),
+		ByModule:      make(map[string]int),
+	}
+	for _, group := range result.groupByModule() {
+		out.ByModule[group.Module.String()] = len(group.Items)
+	}
+	if backupPath != "" && !dryRun {
+		out.BackupPath = &backupPath
+	}
+
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// stateRmCSVHeader is the header row of the CSV representation of a
+// stateRmResult, as produced by "terraform state rm -csv".
+var stateRmCSVHeader = []string{"address", "type", "name", "module", "provider", "id", "action"}
+
+// marshalStateRmCSV returns the CSV representation of the given result, with
+// a row for each of its resource instances in the order they were removed.
+//
+// The rows are made from the same result as the JSON representation, so the
+// two always describe the same instances. Output values and skipped
+// instances have no row, because they don't fit the columns. With
+// skipDecodeErrors, an instance whose attributes can't be decoded has an
+// empty id, as one with only deposed objects does.
+func marshalStateRmCSV(result *stateRmResult, dryRun, skipDecodeErrors bool) ([]byte, error) {
+	action := "removed"
+	if dryRun {
+		action = "would-remove"
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(stateRmCSVHeader); err != nil {
+		return nil, err
+	}
+	for _, item := range result.Items {
+		// An instance with only deposed objects has no current object to
+		// take the id from, and so an empty id.
+		var id string
+		if obj := item.Instance.Current; obj != nil {
+			attrs, err := stateShowFlatAttrs(obj)
+			if err != nil && !skipDecodeErrors {
+				return nil, fmt.Errorf("%s: %s", item.Addr, err)
+			}
+			id = attrs["id"]
+		}
+		err := w.Write([]string{
+			item.Addr.String(),
+			item.Addr.Resource.Resource.Type,
+			item.Addr.Resource.Resource.Name,
+			item.Addr.Module.String(),
+			item.ProviderConfig.String(),
+			id,
+			action,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
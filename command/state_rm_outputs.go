@@ -0,0 +1,86 @@
+package command
+
+import (
+	"sort"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/configs"
+	"github.com/hashicorp/terraform/lang"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmStaleOutput describes an output value in the state that refers to a
+// resource instance being removed, for the -module-output-cleanup option.
+type stateRmStaleOutput struct {
+	Addr addrs.AbsOutputValue
+
+	// Ref is the first reference in the output's expression that refers to
+	// a removed resource instance, as written in the configuration.
+	Ref string
+}
+
+// staleOutputValues returns the output values recorded in the given state
+// whose expressions in the given configuration refer to any of the given
+// resource instances, in lexical order.
+//
+// This is best-effort: the state doesn't record what each output value was
+// computed from, so an output value that isn't declared in the configuration,
+// or whose module isn't, can't be checked and is left alone.
+func staleOutputValues(config *configs.Config, state *states.State, removed []addrs.AbsResourceInstance) []stateRmStaleOutput {
+	var ret []stateRmStaleOutput
+	for _, ms := range state.Modules {
+		modConfig := config.DescendentForInstance(ms.Addr)
+		if modConfig == nil {
+			continue
+		}
+		for name := range ms.OutputValues {
+			oc, ok := modConfig.Module.Outputs[name]
+			if !ok {
+				continue
+			}
+			// Any errors in the references were already reported when the
+			// configuration was loaded, and we just check the ones we can
+			// make sense of.
+			refs, _ := lang.ReferencesInExpr(oc.Expr)
+			if ref := staleOutputRef(ms.Addr, refs, removed); ref != nil {
+				ret = append(ret, stateRmStaleOutput{
+					Addr: ms.Addr.OutputValue(name),
+					Ref:  ref.Subject.String(),
+				})
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Addr.String() < ret[j].Addr.String()
+	})
+	return ret
+}
+
+// staleOutputRef returns the first of the given references, from an output
+// value in the given module, that refers to any of the given resource
+// instances, or nil if none of them do.
+func staleOutputRef(modAddr addrs.ModuleInstance, refs []*addrs.Reference, removed []addrs.AbsResourceInstance) *addrs.Reference {
+	for _, ref := range refs {
+		for _, addr := range removed {
+			if dependencyMatches(modAddr, ref.Subject, addr) {
+				return ref
+			}
+		}
+	}
+	return nil
+}
+
+// findStaleOutputs loads the configuration in the current directory and
+// returns the output values that refer to any of the given resource
+// instances, as staleOutputValues does.
+func (c *StateRmCommand) findStaleOutputs(state *states.State, removed []addrs.AbsResourceInstance) ([]stateRmStaleOutput, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		return nil, diags
+	}
+	return staleOutputValues(config, state, removed), diags
+}
@@ -0,0 +1,63 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/plans/planfile"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// writePlanFile creates a plan for the configuration in the current working
+// directory against the given state, as the removal leaves it, and saves it
+// as a plan file at the given path, for the -plan-file-out option.
+//
+// The plan file records the lineage and serial of the state as the given
+// state manager last read or wrote it, as "terraform plan -out" does, so that
+// "terraform apply" refuses the plan if the state has changed since. After a
+// dry run that is the state before the removal, so applying the plan also
+// makes the removal.
+func (c *StateRmCommand) writePlanFile(stateMgr statemgr.Full, after *states.State, path string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	loader, err := c.initConfigLoader()
+	if err != nil {
+		diags = diags.Append(err)
+		return diags
+	}
+	config, configSnap, hclDiags := loader.LoadConfigWithSnapshot(".")
+	diags = diags.Append(hclDiags)
+	if hclDiags.HasErrors() {
+		return diags
+	}
+
+	plan, planDiags := c.planAgainstState(config, after)
+	diags = diags.Append(planDiags)
+	if planDiags.HasErrors() {
+		return diags
+	}
+
+	// c.Backend populates c.backendState, which is what the plan records as
+	// the backend to apply it with.
+	b, backendDiags := c.Backend(nil)
+	diags = diags.Append(backendDiags)
+	if backendDiags.HasErrors() {
+		return diags
+	}
+	planBackend, err := c.backendState.ForPlan(b.ConfigSchema(), c.Workspace())
+	if err != nil {
+		diags = diags.Append(fmt.Errorf("Failed to encode backend configuration for plan: %s", err))
+		return diags
+	}
+	plan.Backend = *planBackend
+
+	if err := planfile.Create(path, configSnap, statemgr.PlannedStateUpdate(stateMgr, after), plan); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write plan file",
+			fmt.Sprintf("The plan file %s could not be written: %s.", path, err),
+		))
+	}
+	return diags
+}
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmKept is a resource instance kept by the -keep-latest option because
+// it is one of the newest of its resource type.
+type stateRmKept struct {
+	Addr addrs.AbsResourceInstance
+
+	// Timestamp is the value of the -keep-latest-by attribute, as recorded in
+	// the state.
+	Timestamp string
+}
+
+// keepLatestInstances returns those of the given instances that aren't among
+// the n newest instances of each of the given resource types, and those that
+// are, for the -keep-latest option. Instances of other types are all
+// returned in remove, keeping their order.
+//
+// How new an instance is comes from the RFC 3339 timestamp at the given
+// flatmap path in the attributes of its current object. An instance of one of
+// the types without a current object, or without a valid timestamp, is an
+// error, since we can't tell whether it should be kept.
+func keepLatestInstances(state *states.State, instances []addrs.AbsResourceInstance, types []string, n int, path string) (remove []addrs.AbsResourceInstance, kept []stateRmKept, diags tfdiags.Diagnostics) {
+	retained := make(map[string]bool, len(types))
+	for _, ty := range types {
+		retained[ty] = true
+	}
+
+	type candidate struct {
+		addr addrs.AbsResourceInstance
+		raw  string
+		time time.Time
+	}
+	byType := make(map[string][]candidate)
+	seen := make(map[string]bool, len(instances))
+	for _, addr := range instances {
+		ty := stateRmTypeKey(addr)
+		is := state.ResourceInstance(addr)
+		if !retained[ty] || is == nil || seen[addr.String()] {
+			// An instance that isn't in the state will be reported as
+			// missing as usual.
+			continue
+		}
+		seen[addr.String()] = true
+		if is.Current == nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Missing timestamp",
+				fmt.Sprintf("%s has no current object, so it has no %s attribute for -keep-latest to compare. Nothing has been removed.", addr, path),
+			))
+			continue
+		}
+		attrs, err := stateShowFlatAttrs(is.Current)
+		if err != nil {
+			diags = diags.Append(stateRmDecodeError(addr, "find its -keep-latest-by timestamp", err, false))
+			continue
+		}
+		raw, ok := attrs[path]
+		if !ok || raw == "" {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Missing timestamp",
+				fmt.Sprintf("%s has no %s attribute for -keep-latest to compare. Nothing has been removed.", addr, path),
+			))
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid timestamp",
+				fmt.Sprintf("The %s attribute of %s is %q, which is not an RFC 3339 timestamp such as 2006-01-02T15:04:05Z, so -keep-latest can't compare it. Nothing has been removed.", path, addr, raw),
+			))
+			continue
+		}
+		byType[ty] = append(byType[ty], candidate{addr: addr, raw: raw, time: t})
+	}
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+
+	keep := make(map[string]bool)
+	for _, candidates := range byType {
+		sort.Slice(candidates, func(i, j int) bool {
+			if !candidates[i].time.Equal(candidates[j].time) {
+				return candidates[i].time.After(candidates[j].time)
+			}
+			return candidates[i].addr.Less(candidates[j].addr)
+		})
+		for i := 0; i < n && i < len(candidates); i++ {
+			kept = append(kept, stateRmKept{Addr: candidates[i].addr, Timestamp: candidates[i].raw})
+			keep[candidates[i].addr.String()] = true
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].Addr.Less(kept[j].Addr)
+	})
+
+	for _, addr := range instances {
+		if !keep[addr.String()] {
+			remove = append(remove, addr)
+		}
+	}
+	return remove, kept, diags
+}
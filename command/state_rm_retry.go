@@ -0,0 +1,96 @@
+package command
+
+import (
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/states/statemgr"
+)
+
+// stateRmRetryOpts configures how persistStateWithRetry retries a failed
+// PersistState call, for the -retry and -retry-interval options.
+type stateRmRetryOpts struct {
+	// Retries is the number of times to retry after the first attempt.
+	Retries int
+
+	// Interval is the time to wait before the first retry, which is doubled
+	// before each retry after that.
+	Interval time.Duration
+
+	// Sleep waits for the given time. It's a field so that tests don't have
+	// to wait; if nil, time.Sleep is used.
+	Sleep func(time.Duration)
+}
+
+// persistStateWithRetry persists the state written to the given state
+// manager, retrying with exponential backoff while the error looks
+// transient. It returns the number of attempts made along with the error
+// from the last of them.
+//
+// Only PersistState is retried, so any backup that the state manager writes
+// along with the state, as the local one does in WriteState, is only written
+// once.
+func persistStateWithRetry(mgr statemgr.Persister, opts *stateRmRetryOpts) (int, error) {
+	sleep := opts.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	interval := opts.Interval
+	attempts := 0
+	for {
+		attempts++
+		err := mgr.PersistState()
+		if err == nil || attempts > opts.Retries || !stateRmRetryable(err) {
+			return attempts, err
+		}
+		log.Printf("[WARN] state rm: attempt %d to persist the state failed, retrying in %s: %s", attempts, interval, err)
+		sleep(interval)
+		interval *= 2
+	}
+}
+
+// stateRmNonRetryable are the fragments of error messages that mean an error
+// from a backend won't go away by trying again, which are checked before
+// stateRmTransient so that, for example, a 403 that mentions a timeout isn't
+// retried.
+var stateRmNonRetryable = []string{
+	"401", "403", "409", "412",
+	"unauthorized", "forbidden", "access denied", "accessdenied",
+	"permission denied", "invalid credentials", "expired token",
+	"conflict", "precondition failed", "lineage", "serial", "lock",
+}
+
+// stateRmTransient are the fragments of error messages that mean an error
+// from a backend is likely to go away by trying again.
+var stateRmTransient = []string{
+	"429", "500", "502", "503", "504",
+	"timeout", "timed out", "temporary", "temporarily",
+	"connection reset", "connection refused", "broken pipe", "eof",
+	"throttl", "rate exceeded", "too many requests", "slow down",
+	"service unavailable", "internal server error", "bad gateway",
+}
+
+// stateRmRetryable returns true if the given error from persisting the state
+// looks transient. The backends don't classify their errors, so apart from
+// network errors we can only go by the message, and an error that isn't
+// recognized is not retried.
+func stateRmRetryable(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range stateRmNonRetryable {
+		if strings.Contains(msg, s) {
+			return false
+		}
+	}
+	if netErr, ok := err.(net.Error); ok && (netErr.Timeout() || netErr.Temporary()) {
+		return true
+	}
+	for _, s := range stateRmTransient {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// scrubPrivate clears the provider's private data from the current and
+// deposed objects of the given instances in the given state, as read from
+// the given state manager, for the -scrub-private option, reporting each
+// object that had any, and saves the modified state. With dryRun, the
+// objects are only listed, and otherwise the user must confirm the change
+// unless autoApprove is set.
+//
+// The objects are otherwise left as they are. Providers already have to cope
+// with objects that have no private data, such as those just imported, so
+// this is usually enough to get past private data that an upgraded provider
+// no longer understands.
+func (c *StateRmCommand) scrubPrivate(stateMgr statemgr.Full, state *states.State, instances []addrs.AbsResourceInstance, dryRun, autoApprove bool) int {
+	var diags tfdiags.Diagnostics
+
+	verb := "Cleared"
+	if dryRun || !autoApprove {
+		verb = "Would clear"
+	}
+
+	seen := make(map[string]bool, len(instances))
+	ss := state.SyncWrapper()
+	count := 0
+	for _, addr := range instances {
+		if seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		rs := state.Resource(addr.ContainingResource())
+		is := state.ResourceInstance(addr)
+		if rs == nil || is == nil {
+			continue
+		}
+
+		if obj := is.Current; obj != nil && len(obj.Private) > 0 {
+			c.Ui.Output(fmt.Sprintf("%s the private data of %s (%d bytes)", verb, addr, len(obj.Private)))
+			count++
+			if !dryRun {
+				obj = obj.DeepCopy()
+				obj.Private = nil
+				ss.SetResourceInstanceCurrent(addr, obj, rs.ProviderConfig)
+			}
+		}
+
+		keys := make([]states.DeposedKey, 0, len(is.Deposed))
+		for k := range is.Deposed {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys {
+			obj := is.Deposed[k]
+			if len(obj.Private) == 0 {
+				continue
+			}
+			c.Ui.Output(fmt.Sprintf("%s the private data of deposed object %s of %s (%d bytes)", verb, k, addr, len(obj.Private)))
+			count++
+			if !dryRun {
+				obj = obj.DeepCopy()
+				obj.Private = nil
+				ss.SetResourceInstanceDeposed(addr, k, obj, rs.ProviderConfig)
+			}
+		}
+	}
+
+	if dryRun {
+		c.Ui.Output(fmt.Sprintf("\nWould've cleared the private data of %d objects, without -dry-run.", count))
+		return 0
+	}
+	if count == 0 {
+		c.Ui.Output("None of the selected resource instances have any private data, so the state has not been changed.")
+		return 0
+	}
+	if !autoApprove {
+		ok, moreDiags := c.confirmStateChange("Do you want to clear the private data of these objects?")
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !ok {
+			c.Ui.Output("Change cancelled.")
+			return 0
+		}
+	}
+
+	if err := stateMgr.WriteState(state); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to persist state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("\nCleared the private data of %d objects. Updated state written successfully.", count))
+	return 0
+}
@@ -0,0 +1,117 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+	"github.com/hashicorp/terraform/states/statemgr"
+)
+
+// stateRmSizes is the size of the state snapshot before and after a removal,
+// for the -batch-size-report and -max-state-size options.
+type stateRmSizes struct {
+	Before, After int64
+}
+
+func (s stateRmSizes) String() string {
+	change := "larger"
+	diff := s.After - s.Before
+	if diff < 0 {
+		change = "smaller"
+		diff = -diff
+	}
+	return fmt.Sprintf("Projected state size: %s, %s %s than the current %s.", formatStateRmSize(s.After), formatStateRmSize(diff), change, formatStateRmSize(s.Before))
+}
+
+// projectStateSize returns the size of the given state as it would be
+// serialized by the state manager, before and after a removal with the
+// given options, without changing the given state.
+//
+// The removal is made to a copy, so that the projection is of exactly what
+// would be saved, including any output values and empty modules it would
+// remove. The size is of the snapshot in the local state file format, which
+// is what most backends store, with the lineage and next serial of the
+// current snapshot.
+func (e *stateRmCommandEnv) projectStateSize(state *states.State, opts stateRmOpts) (stateRmSizes, error) {
+	var lineage string
+	var serial uint64
+	if metaMgr, ok := e.stateMgr.(statemgr.PersistentMeta); ok {
+		meta := metaMgr.StateSnapshotMeta()
+		lineage, serial = meta.Lineage, meta.Serial
+	}
+
+	var sizes stateRmSizes
+	var err error
+	if sizes.Before, err = stateRmSnapshotSize(state, lineage, serial); err != nil {
+		return sizes, err
+	}
+
+	after := state.DeepCopy()
+	opts.DryRun = false
+	// Anything that fails is left in the copy, as -continue-on-error would
+	// leave it, and reported by the real removal instead.
+	opts.ContinueOnError = true
+	removeStateRmInstances(after, &opts, &stateRmResult{})
+	if sizes.After, err = stateRmSnapshotSize(after, lineage, serial+1); err != nil {
+		return sizes, err
+	}
+	return sizes, nil
+}
+
+func stateRmSnapshotSize(state *states.State, lineage string, serial uint64) (int64, error) {
+	var buf bytes.Buffer
+	if err := statefile.Write(statefile.New(state, lineage, serial), &buf); err != nil {
+		return 0, err
+	}
+	return int64(buf.Len()), nil
+}
+
+// stateRmSizeUnits are the suffixes accepted by parseStateRmSize, with the
+// number of bytes in each, longest first so that "KiB" isn't taken for "B".
+var stateRmSizeUnits = []struct {
+	Suffix string
+	Bytes  int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// parseStateRmSize parses the value of the -max-state-size option, which is a
+// whole number of bytes, optionally followed by a unit such as "KB" or "MiB".
+func parseStateRmSize(raw string) (int64, error) {
+	num, unit := strings.TrimSpace(raw), int64(1)
+	for _, u := range stateRmSizeUnits {
+		if strings.HasSuffix(strings.ToUpper(num), strings.ToUpper(u.Suffix)) {
+			num, unit = strings.TrimSpace(num[:len(num)-len(u.Suffix)]), u.Bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("must be a positive whole number of bytes, optionally followed by a unit such as KB, MB, KiB, or MiB")
+	}
+	return n * unit, nil
+}
+
+// formatStateRmSize formats the given number of bytes for a person to read.
+func formatStateRmSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB (%d bytes)", float64(n)/(1<<20), n)
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB (%d bytes)", float64(n)/(1<<10), n)
+	case n == 1:
+		return "1 byte"
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
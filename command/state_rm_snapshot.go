@@ -0,0 +1,74 @@
+package command
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
+)
+
+// stateRmSnapshotMetaJSON is the companion metadata file written alongside
+// the state snapshot by the -pre-plan-snapshot option, recording the
+// removal that the snapshot was taken before.
+type stateRmSnapshotMetaJSON struct {
+	Command   string `json:"command"`
+	CreatedAt string `json:"created_at"`
+	Label     string `json:"label,omitempty"`
+
+	// Args are the arguments given to the command, as given, so that the
+	// removal can be run again.
+	Args []string `json:"args"`
+
+	// Addresses are the resource instances that the arguments selected for
+	// removal, so that a re-run can be checked to make the same removal
+	// even after the state or configuration has changed.
+	Addresses []string `json:"addresses"`
+
+	Lineage string `json:"lineage,omitempty"`
+	Serial  uint64 `json:"serial"`
+}
+
+// stateRmSnapshotMetaPath returns the path of the metadata file written
+// alongside the -pre-plan-snapshot file at the given path.
+func stateRmSnapshotMetaPath(path string) string {
+	return path + ".meta.json"
+}
+
+// writeStateRmPrePlanSnapshot writes the given state, as last read from the
+// given state manager, to the given path for the -pre-plan-snapshot option,
+// along with a metadata file recording the given arguments of the command
+// and the resource instances they selected for removal.
+//
+// This must be called before the state is changed at all. Unlike the
+// backup, which local state managers write only when the new state is
+// persisted, the snapshot is written whatever backend is in use.
+func writeStateRmPrePlanSnapshot(path string, stateMgr statemgr.Full, state *states.State, args []string, toRemove []addrs.AbsResourceInstance, label string) error {
+	f := statemgr.StateFile(stateMgr, state)
+	if err := writeStateFile(path, f); err != nil {
+		return err
+	}
+
+	out := stateRmSnapshotMetaJSON{
+		Command:   "state rm",
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Label:     label,
+		Args:      args,
+		Addresses: make([]string, len(toRemove)),
+		Lineage:   f.Lineage,
+		Serial:    f.Serial,
+	}
+	if out.Args == nil {
+		out.Args = []string{}
+	}
+	for i, addr := range toRemove {
+		out.Addresses[i] = addr.String()
+	}
+	src, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stateRmSnapshotMetaPath(path), append(src, '\n'), 0644)
+}
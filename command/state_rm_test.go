@@ -1,16 +1,40 @@
 package command
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mitchellh/cli"
+	"github.com/zclconf/go-cty/cty"
 
 	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/backend/remote-state/inmem"
+	"github.com/hashicorp/terraform/configs/configschema"
 	"github.com/hashicorp/terraform/helper/copy"
+	"github.com/hashicorp/terraform/plans"
+	"github.com/hashicorp/terraform/plans/planfile"
+	"github.com/hashicorp/terraform/providers"
+	"github.com/hashicorp/terraform/state/remote"
 	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+	"github.com/hashicorp/terraform/states/statemgr"
 	"github.com/hashicorp/terraform/terraform"
 )
 
@@ -56,6 +80,7 @@ func TestStateRm(t *testing.T) {
 
 	args := []string{
 		"-state", statePath,
+		"-auto-approve",
 		"test_instance.foo",
 	}
 	if code := c.Run(args); code != 0 {
@@ -168,16 +193,37 @@ func TestStateRmNonExist(t *testing.T) {
 
 	args := []string{
 		"-state", statePath,
+		"-auto-approve",
 		"test_instance.baz", // doesn't exist in the state constructed above
 	}
+	if code := c.Run(args); code != stateRmEmptyExitStatus {
+		t.Errorf("wrong exit status %d; want %d", code, stateRmEmptyExitStatus)
+	}
+
+	if msg := ui.ErrorWriter.String(); !strings.Contains(msg, "No matching resources found in state.") {
+		t.Errorf("not the error we were looking for:\n%s", msg)
+	}
+	if got := testStateRead(t, statePath); got.ResourceInstance(mustResourceInstanceAddr("test_instance.foo")) == nil {
+		t.Errorf("test_instance.foo was removed")
+	}
+	if backups, _ := filepath.Glob(statePath + ".*"); len(backups) != 0 {
+		t.Errorf("backups written although nothing changed: %s", backups)
+	}
+
+	// If anything matches, an address that doesn't is the usual error.
+	c, ui = testStateRmCommand(p)
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"test_instance.foo",
+		"test_instance.baz",
+	}
 	if code := c.Run(args); code != 1 {
 		t.Errorf("wrong exit status %d; want %d", code, 1)
 	}
-
 	if msg := ui.ErrorWriter.String(); !strings.Contains(msg, "No such resource instance in state") {
 		t.Errorf("not the error we were looking for:\n%s", msg)
 	}
-
 }
 
 func TestStateRm_backupExplicit(t *testing.T) {
@@ -227,6 +273,7 @@ func TestStateRm_backupExplicit(t *testing.T) {
 	args := []string{
 		"-backup", backupPath,
 		"-state", statePath,
+		"-auto-approve",
 		"test_instance.foo",
 	}
 	if code := c.Run(args); code != 0 {
@@ -352,6 +399,7 @@ func TestStateRm_backendState(t *testing.T) {
 
 	args := []string{
 		"-backup", backupPath,
+		"-auto-approve",
 		"test_instance.foo",
 	}
 	if code := c.Run(args); code != 0 {
@@ -365,23 +413,6667 @@ func TestStateRm_backendState(t *testing.T) {
 	testStateOutput(t, backupPath, testStateRmOutputOriginal)
 }
 
-const testStateRmOutputOriginal = `
-test_instance.bar:
-  ID = foo
-  provider = provider.test
-  bar = value
-  foo = value
-test_instance.foo:
-  ID = bar
-  provider = provider.test
-  bar = value
-  foo = value
-`
+func TestStateRm_invalidAddress(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
 
-const testStateRmOutput = `
-test_instance.bar:
-  ID = foo
-  provider = provider.test
-  bar = value
-  foo = value
-`
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &StateRmCommand{
+		StateMeta{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(p),
+				Ui:               ui,
+			},
+		},
+	}
+
+	args := []string{
+		"-state", statePath,
+		"test_instance.foo",
+		"test_instance.bar[",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Errorf("wrong exit status %d; want %d", code, 1)
+	}
+
+	// The diagnostic should say which of the given addresses was invalid.
+	msg := ui.ErrorWriter.String()
+	if !strings.Contains(msg, "on <address 2> line 1") {
+		t.Errorf("error does not refer to the invalid address:\n%s", msg)
+	}
+	if !strings.Contains(msg, "test_instance.bar[") {
+		t.Errorf("error does not include a snippet of the invalid address:\n%s", msg)
+	}
+
+	// Nothing should have been removed.
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateRm_instanceKeys(t *testing.T) {
+	cases := map[string]struct {
+		addr string
+		want []addrs.InstanceKey
+	}{
+		"string key": {
+			`test_instance.web["old"]`,
+			[]addrs.InstanceKey{addrs.IntKey(0), addrs.IntKey(1), addrs.IntKey(2)},
+		},
+		"int key": {
+			`test_instance.web[1]`,
+			[]addrs.InstanceKey{addrs.IntKey(0), addrs.IntKey(2), addrs.StringKey("old")},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			statePath := testStateFile(t, testStateRmKeyedState())
+
+			p := testProvider()
+			ui := new(cli.MockUi)
+			c := &StateRmCommand{
+				StateMeta{
+					Meta: Meta{
+						testingOverrides: metaOverridesForProvider(p),
+						Ui:               ui,
+					},
+				},
+			}
+
+			args := []string{
+				"-state", statePath,
+				"-auto-approve",
+				tc.addr,
+			}
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+
+			testStateRmInstanceKeys(t, statePath, "web", tc.want)
+		})
+	}
+}
+
+func TestStateRm_orphanKeys(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-orphan-keys"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	statePath := testStateFile(t, testStateRmKeyedState())
+
+	p := planFixtureProvider()
+	ui := new(cli.MockUi)
+	c := &StateRmCommand{
+		StateMeta{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(p),
+				Ui:               ui,
+			},
+		},
+	}
+
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-orphan-keys", "test_instance.web",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	// The configuration has count = 2, so only the instances with keys
+	// within that range survive.
+	testStateRmInstanceKeys(t, statePath, "web", []addrs.InstanceKey{addrs.IntKey(0), addrs.IntKey(1)})
+}
+
+func TestStateRm_orphanKeysNotInConfig(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-orphan-keys"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	statePath := testStateFile(t, testStateRmState())
+
+	p := planFixtureProvider()
+	ui := new(cli.MockUi)
+	c := &StateRmCommand{
+		StateMeta{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(p),
+				Ui:               ui,
+			},
+		},
+	}
+
+	args := []string{
+		"-state", statePath,
+		"-orphan-keys", "test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want %d", code, 1)
+	}
+	if msg := ui.ErrorWriter.String(); !strings.Contains(msg, "Resource not in configuration") {
+		t.Errorf("not the error we were looking for:\n%s", msg)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+// testStateRmState returns a state containing the two resource instances
+// that most of the state rm tests operate on.
+func testStateRmState() *states.State {
+	return states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "test_instance",
+				Name: "foo",
+			}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"bar","foo":"value","bar":"value"}`),
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+		s.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "test_instance",
+				Name: "bar",
+			}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"foo","foo":"value","bar":"value"}`),
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+	})
+}
+
+func TestStateRm_confirmFile(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-approval-token",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	confirmPath := filepath.Join(filepath.Dir(statePath), "approval")
+	if err := ioutil.WriteFile(confirmPath, ui.OutputWriter.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token for a different set of instances is rejected.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-confirm-file", confirmPath,
+		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want %d", code, 1)
+	}
+	if msg := ui.ErrorWriter.String(); !strings.Contains(msg, "Removal not approved") {
+		t.Errorf("not the error we were looking for:\n%s", msg)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	// The token for exactly the approved set is accepted.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-confirm-file", confirmPath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_stdinConfirmToken(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-stdin-confirm-token",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	lines := strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\n")
+	token := lines[len(lines)-1]
+	if want := stateRmApprovalToken([]addrs.AbsResourceInstance{mustResourceInstanceAddr("test_instance.foo")}); token != want {
+		t.Fatalf("wrong token %q; want %q", token, want)
+	}
+
+	// A token for a different set of instances is rejected.
+	defer testStdinPipe(t, strings.NewReader(token+"\n"))()
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-stdin-confirm-token",
+		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want %d", code, 1)
+	}
+	if msg := ui.ErrorWriter.String(); !strings.Contains(msg, "Removal not approved") {
+		t.Errorf("not the error we were looking for:\n%s", msg)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	// The token for exactly the approved set is accepted.
+	defer testStdinPipe(t, strings.NewReader(token+"\n"))()
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-stdin-confirm-token",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_gcOrphanData(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-gc-orphan-data"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	dataAddr := func(name string) addrs.AbsResourceInstance {
+		return addrs.Resource{
+			Mode: addrs.DataResourceMode,
+			Type: "test_data_source",
+			Name: name,
+		}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance)
+	}
+	managedAddr := addrs.Resource{
+		Mode: addrs.ManagedResourceMode,
+		Type: "test_instance",
+		Name: "gone",
+	}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(dataAddr("kept"), obj, provider)
+		s.SetResourceInstanceCurrent(dataAddr("stale"), obj, provider)
+		s.SetResourceInstanceCurrent(managedAddr, obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	// Dry run lists only the stale data resource.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-gc-orphan-data",
+		"-dry-run",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Would remove data.test_data_source.stale\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("wrong dry-run output\ngot:  %s\nwant: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-gc-orphan-data",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.State.ResourceInstance(dataAddr("stale")) != nil {
+		t.Errorf("stale data resource was not removed")
+	}
+	if f.State.ResourceInstance(dataAddr("kept")) == nil {
+		t.Errorf("data resource still in configuration was removed")
+	}
+	if f.State.ResourceInstance(managedAddr) == nil {
+		t.Errorf("managed resource was removed")
+	}
+}
+
+func TestStateRm_mode(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	managedAddr := mustResourceInstanceAddr("test_instance.foo")
+	dataAddr := mustResourceInstanceAddr("data.test_data_source.foo")
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(managedAddr, obj, provider)
+		s.SetResourceInstanceCurrent(dataAddr, obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-mode=data",
+		"-dry-run",
+		managedAddr.String(),
+		dataAddr.String(),
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	if strings.Contains(got, "Would remove "+managedAddr.String()) {
+		t.Errorf("dry run selected managed resource\n%s", got)
+	}
+	if want := "Selected 0 managed and 1 data resource instances using -mode=data."; !strings.Contains(got, want) {
+		t.Errorf("dry run output does not report mode\ngot:  %s\nwant: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-mode=data",
+		managedAddr.String(),
+		dataAddr.String(),
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.State.ResourceInstance(dataAddr) != nil {
+		t.Errorf("%s was not removed", dataAddr)
+	}
+	if f.State.ResourceInstance(managedAddr) == nil {
+		t.Errorf("%s was removed", managedAddr)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-mode=resource",
+		managedAddr.String(),
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid resource mode"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_saveRemoved(t *testing.T) {
+	addr := mustResourceInstanceAddr("test_instance.foo")
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			addr,
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON:     []byte(`{"id":"bar"}`),
+				SchemaVersion: 3,
+				Status:        states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+	})
+
+	for _, retain := range []bool{false, true} {
+		t.Run(fmt.Sprintf("retain=%t", retain), func(t *testing.T) {
+			statePath := testStateFile(t, state)
+			savePath := filepath.Join(filepath.Dir(statePath), "removed.tfstate")
+
+			c, ui := testStateRmCommand(testProvider())
+			args := []string{
+				"-state", statePath,
+				"-auto-approve",
+				"-save-removed", savePath,
+			}
+			if retain {
+				args = append(args, "-retain-schema-version")
+			}
+			args = append(args, addr.String())
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+
+			f, err := readStateFile(savePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			is := f.State.ResourceInstance(addr)
+			if is == nil || is.Current == nil {
+				t.Fatalf("%s is not in the saved file", addr)
+			}
+			want := uint64(0)
+			if retain {
+				want = 3
+			}
+			if got := is.Current.SchemaVersion; got != want {
+				t.Errorf("wrong schema version %d; want %d", got, want)
+			}
+
+			f, err = readStateFile(statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.State.ResourceInstance(addr) != nil {
+				t.Errorf("%s is still in the state", addr)
+			}
+		})
+	}
+}
+
+func TestStateRm_failureLeavesState(t *testing.T) {
+	cases := map[string]func(dir string) []string{
+		"save removed": func(dir string) []string {
+			// A directory can't be written as a file.
+			return []string{"-save-removed", dir}
+		},
+		"persist": func(dir string) []string {
+			// The backup is written before the state, so a backup that can't
+			// be written fails the persist.
+			return []string{"-backup", dir}
+		},
+	}
+	for name, extra := range cases {
+		t.Run(name, func(t *testing.T) {
+			statePath := testStateFile(t, testStateRmState())
+			want, err := ioutil.ReadFile(statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := filepath.Join(filepath.Dir(statePath), "dir")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			c, ui := testStateRmCommand(testProvider())
+			args := append([]string{"-state", statePath}, extra(dir)...)
+			args = append(args, "test_instance.foo")
+			if code := c.Run(args); code != 1 {
+				t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.ErrorWriter.String())
+			}
+			got, err := ioutil.ReadFile(statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("state file changed\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestStateRm_prePlanSnapshot(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	before, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotPath := filepath.Join(filepath.Dir(statePath), "before.tfstate")
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-pre-plan-snapshot", snapshotPath,
+		"-label", "CHG-1234",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	snapshot, err := readStateFile(snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(stateAllResourceInstances(snapshot.State)), 2; got != want {
+		t.Errorf("snapshot has %d instances; want %d", got, want)
+	}
+	if snapshot.Lineage != before.Lineage {
+		t.Errorf("wrong lineage %q; want %q", snapshot.Lineage, before.Lineage)
+	}
+
+	src, err := ioutil.ReadFile(snapshotPath + ".meta.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta stateRmSnapshotMetaJSON
+	if err := json.Unmarshal(src, &meta); err != nil {
+		t.Fatalf("invalid JSON: %s\n\n%s", err, src)
+	}
+	if !reflect.DeepEqual(meta.Args, args) {
+		t.Errorf("wrong args\ngot:  %#v\nwant: %#v", meta.Args, args)
+	}
+	if got, want := meta.Addresses, []string{"test_instance.foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong addresses %#v; want %#v", got, want)
+	}
+	if meta.Command != "state rm" || meta.Label != "CHG-1234" || meta.Lineage != before.Lineage {
+		t.Errorf("wrong metadata %#v", meta)
+	}
+
+	if got, want := len(stateAllResourceInstances(testStateRead(t, statePath))), 1; got != want {
+		t.Errorf("state has %d instances; want %d", got, want)
+	}
+}
+
+func TestStateRm_objectHashManifest(t *testing.T) {
+	addr := mustResourceInstanceAddr("test_instance.foo")
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	deposed := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"old"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			addr,
+			obj,
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+		s.SetResourceInstanceDeposed(
+			addr,
+			states.DeposedKey("00000001"),
+			deposed,
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.bar"),
+			obj,
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+	})
+	statePath := testStateFile(t, state)
+	manifestPath := filepath.Join(filepath.Dir(statePath), "removed.sha256")
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-object-hash-manifest", manifestPath,
+		addr.String(),
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	sum := func(obj *states.ResourceInstanceObjectSrc) string {
+		src, err := json.Marshal(stateRmExportObj(obj))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("%x", sha256.Sum256(src))
+	}
+	got, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`# SHA-256 hashes of the objects removed by "terraform state rm".
+%s  test_instance.foo
+%s  test_instance.foo deposed 00000001
+`, sum(obj), sum(deposed))
+	if string(got) != want {
+		t.Errorf("wrong manifest\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmObjectHashManifestOutput)
+}
+
+func TestStateRm_emitRemovedIDs(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.foo"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"i-abc123"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceDeposed(
+			mustResourceInstanceAddr("test_instance.foo"),
+			states.DeposedKey("00000001"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"i-old"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.bar"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"name":"no-id"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("data.test_data_source.baz"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"data"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+	})
+	statePath := testStateFile(t, state)
+	idsPath := filepath.Join(filepath.Dir(statePath), "removed-ids.txt")
+	want := "test_instance.foo\ti-abc123\ntest_instance.foo deposed 00000001\ti-old\n"
+
+	// A dry run writes the ids it would remove, without changing the state.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-emit-removed-ids", idsPath,
+		"test_instance.foo",
+		"test_instance.bar",
+		"data.test_data_source.baz",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got, err := ioutil.ReadFile(idsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("wrong ids\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := ui.ErrorWriter.String(), "test_instance.bar"; !strings.Contains(got, want) {
+		t.Errorf("missing warning about %s\n\n%s", want, got)
+	}
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(stateAllResourceInstances(f.State)); got != 3 {
+		t.Fatalf("dry run changed the state: %d resource instances left", got)
+	}
+
+	os.Remove(idsPath)
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-emit-removed-ids", idsPath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got, err = ioutil.ReadFile(idsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("wrong ids\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	f, err = readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.State.ResourceInstance(mustResourceInstanceAddr("test_instance.foo")) != nil {
+		t.Errorf("test_instance.foo is still in the state")
+	}
+}
+
+func TestStateRm_groupByModule(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.foo"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.bar"), obj, provider)
+	})
+	statePath := testStateFile(t, state)
+	addrArgs := []string{
+		"module.child.test_instance.foo",
+		"test_instance.foo",
+		"module.child.test_instance.bar",
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := append([]string{"-state", statePath, "-group-by-module", "-dry-run"}, addrArgs...)
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `root module:
+  Would remove test_instance.foo
+  Subtotal: 1 current and 0 deposed objects
+
+module.child:
+  Would remove module.child.test_instance.foo
+  Would remove module.child.test_instance.bar
+  Subtotal: 2 current and 0 deposed objects
+`
+	if got := ui.OutputWriter.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant prefix:\n%s", got, want)
+	}
+
+	// With -json, each module is an element nesting what was removed from
+	// it, with its subtotals.
+	c, ui = testStateRmCommand(testProvider())
+	args = append([]string{"-state", statePath, "-group-by-module", "-json", "-dry-run"}, addrArgs...)
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var groups []stateRmJSONModuleGroup
+	if err := json.Unmarshal([]byte(ui.OutputWriter.String()), &groups); err != nil {
+		t.Fatalf("invalid JSON: %s\n\n%s", err, ui.OutputWriter.String())
+	}
+	wantGroups := []stateRmJSONModuleGroup{
+		{
+			Type:    "module",
+			Address: "",
+			Contains: []stateRmJSONEntry{
+				{Type: "resource", Address: "test_instance.foo"},
+			},
+			CurrentCount: 1,
+			ByType:       map[string]int{"test_instance": 1},
+		},
+		{
+			Type:    "module",
+			Address: "module.child",
+			Contains: []stateRmJSONEntry{
+				{Type: "resource", Address: "module.child.test_instance.foo"},
+				{Type: "resource", Address: "module.child.test_instance.bar"},
+			},
+			CurrentCount: 2,
+			ByType:       map[string]int{"test_instance": 2},
+		},
+	}
+	if !reflect.DeepEqual(groups, wantGroups) {
+		t.Errorf("wrong groups\ngot:  %#v\nwant: %#v", groups, wantGroups)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = append([]string{"-state", statePath, "-auto-approve", "-group-by-module"}, addrArgs...)
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.State.Empty() {
+		t.Errorf("state is not empty after removing all instances")
+	}
+}
+
+func TestStateRm_dryRunJSONEmpty(t *testing.T) {
+	statePath := testStateFile(t, testStateRmKeyedState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{"-state", statePath, "-dry-run", "-json", "-resource-type", "test_other"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "[]\n"; got != want {
+		t.Errorf("wrong output\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+func TestStateRm_json(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			`test_instance.foo`,
+			`test_instance.node[0]`,
+			`test_instance.node[1]`,
+			`module.child.test_instance.foo`,
+			`module.child.module.grandchild.test_instance.foo`,
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"one resource": {
+			[]string{`test_instance.foo`},
+			`[{"type":"resource","address":"test_instance.foo"}]`,
+		},
+		"module with children": {
+			[]string{`module.child`},
+			`[{"type":"module","address":"module.child","contains":[` +
+				`{"type":"resource_instance","address":"module.child.test_instance.foo"},` +
+				`{"type":"resource_instance","address":"module.child.module.grandchild.test_instance.foo"}]}]`,
+		},
+		"all instances": {
+			[]string{`test_instance.node[0]`, `test_instance.node[1]`},
+			`[{"type":"resource","address":"test_instance.node","contains":[` +
+				`{"type":"resource_instance","address":"test_instance.node[0]"},` +
+				`{"type":"resource_instance","address":"test_instance.node[1]"}]}]`,
+		},
+		"some instances": {
+			[]string{`test_instance.node[1]`},
+			`[{"type":"resource_instance","address":"test_instance.node[1]"}]`,
+		},
+	}
+	for name, tc := range cases {
+		for _, dryRun := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s dry-run=%t", name, dryRun), func(t *testing.T) {
+				statePath := testStateFile(t, state)
+				c, ui := testStateRmCommand(testProvider())
+				args := []string{"-state", statePath, "-auto-approve", "-json"}
+				if dryRun {
+					args = append(args, "-dry-run")
+				}
+				if code := c.Run(append(args, tc.args...)); code != 0 {
+					t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+				}
+				var buf bytes.Buffer
+				if err := json.Compact(&buf, ui.OutputWriter.Bytes()); err != nil {
+					t.Fatalf("output is not only JSON: %s\n%s", err, ui.OutputWriter.String())
+				}
+				if got := buf.String(); got != tc.want {
+					t.Errorf("wrong output\ngot:  %s\nwant: %s", got, tc.want)
+				}
+			})
+		}
+	}
+
+	t.Run("no match", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-json", "test_instance.gone"}); code != stateRmEmptyExitStatus {
+			t.Fatalf("wrong exit status %d; want %d\n\n%s", code, stateRmEmptyExitStatus, ui.ErrorWriter.String())
+		}
+		if got, want := ui.OutputWriter.String(), "[]\n"; got != want {
+			t.Errorf("wrong output\ngot:  %q\nwant: %q", got, want)
+		}
+	})
+}
+
+func TestStateRm_dryRunExitCode(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	cases := map[string]struct {
+		args []string
+		want int
+	}{
+		"pending removal": {
+			[]string{"test_instance.foo"},
+			2,
+		},
+		"nothing to remove": {
+			[]string{"-mode=data", "test_instance.foo"},
+			stateRmEmptyExitStatus,
+		},
+		"json": {
+			[]string{"-json", "test_instance.foo"},
+			2,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c, ui := testStateRmCommand(testProvider())
+			args := append([]string{"-state", statePath, "-dry-run", "-dry-run-exit-code"}, tc.args...)
+			if code := c.Run(args); code != tc.want {
+				t.Fatalf("wrong exit status %d; want %d\n\n%s", code, tc.want, ui.ErrorWriter.String())
+			}
+		})
+	}
+
+	// Without the option, a dry run always succeeds.
+	c, ui := testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-dry-run", "test_instance.foo"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateRm_fromPlanJSON(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	planPath := filepath.Join(filepath.Dir(statePath), "plan.json")
+	plan := `{
+  "format_version": "0.1",
+  "resource_changes": [
+    {
+      "address": "test_instance.foo",
+      "change": {"actions": ["delete"]}
+    },
+    {
+      "address": "test_instance.bar",
+      "change": {"actions": ["delete", "create"]}
+    },
+    {
+      "address": "test_instance.bar",
+      "deposed": "00000001",
+      "change": {"actions": ["delete"]}
+    }
+  ]
+}`
+	if err := ioutil.WriteFile(planPath, []byte(plan), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-from-plan-json", planPath,
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	if err := ioutil.WriteFile(planPath, []byte("not a plan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid JSON plan"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_byTypeSummary(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	addrArgs := []string{
+		"test_instance.foo",
+		"test_instance.bar",
+		"test_resource.foo",
+		"data.test_instance.foo",
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range addrArgs {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := append([]string{"-state", statePath, "-dry-run"}, addrArgs...)
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := "By type: data.test_instance: 1, test_instance: 2, test_resource: 1\n"
+	if got := ui.OutputWriter.String(); !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant line: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = append([]string{"-state", statePath, "-dry-run", "-json", "-summary-only"}, addrArgs...)
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var got stateRmSummaryJSON
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	wantByType := map[string]int{
+		"data.test_instance": 1,
+		"test_instance":      2,
+		"test_resource":      1,
+	}
+	if !reflect.DeepEqual(got.ByType, wantByType) {
+		t.Errorf("wrong by_type\ngot:  %#v\nwant: %#v", got.ByType, wantByType)
+	}
+}
+
+func TestStateRm_foreignLineage(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("mismatch", func(t *testing.T) {
+		c, ui := testStateRmCommand(testProvider())
+		args := []string{
+			"-state", statePath,
+			"-auto-approve",
+			"-foreign-lineage", "not-" + f.Lineage,
+			"test_instance.foo",
+		}
+		if code := c.Run(args); code != 1 {
+			t.Fatalf("wrong exit status %d; want 1", code)
+		}
+		if got, want := ui.ErrorWriter.String(), "State lineage mismatch"; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+		testStateOutput(t, statePath, testStateRmOutputOriginal)
+	})
+
+	t.Run("match without addresses", func(t *testing.T) {
+		c, ui := testStateRmCommand(testProvider())
+		args := []string{
+			"-state", statePath,
+			"-auto-approve",
+			"-foreign-lineage", f.Lineage,
+		}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		if got, want := ui.ErrorWriter.String(), "Foreign objects can't be identified"; !strings.Contains(got, want) {
+			t.Errorf("limitation not reported\ngot:  %s\nwant: %s", got, want)
+		}
+		testStateOutput(t, statePath, testStateRmOutputOriginal)
+	})
+
+	t.Run("match", func(t *testing.T) {
+		c, ui := testStateRmCommand(testProvider())
+		args := []string{
+			"-state", statePath,
+			"-auto-approve",
+			"-foreign-lineage", f.Lineage,
+			"test_instance.foo",
+		}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		testStateOutput(t, statePath, testStateRmOutput)
+	})
+}
+
+func TestStateRm_backupToBackend(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("inmem-backend"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+	defer inmem.Reset()
+
+	ui := new(cli.MockUi)
+	initCmd := &InitCommand{
+		Meta: Meta{Ui: ui},
+	}
+	if code := initCmd.Run([]string{}); code != 0 {
+		t.Fatalf("bad: \n%s", ui.ErrorWriter.String())
+	}
+
+	// The inmem backend resets the default workspace each time it's
+	// configured, so we work in a separate one.
+	ui = new(cli.MockUi)
+	newCmd := &WorkspaceNewCommand{
+		Meta: Meta{Ui: ui},
+	}
+	if code := newCmd.Run([]string{"test"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter)
+	}
+
+	b := backend.TestBackendConfig(t, inmem.New(), nil)
+	sMgr, err := b.StateMgr("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sMgr.RefreshState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := statemgr.WriteAndPersist(sMgr, testStateRmState()); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-backup-to-backend",
+		"-auto-approve",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	workspaces, err := b.Workspaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backupName string
+	for _, name := range workspaces {
+		if strings.HasPrefix(name, "test.staterm-backup-") {
+			backupName = name
+		}
+	}
+	if backupName == "" {
+		t.Fatalf("no backup workspace in %#v", workspaces)
+	}
+	if got, want := ui.OutputWriter.String(), fmt.Sprintf("Backed up the state to the workspace %q.", backupName); !strings.Contains(got, want) {
+		t.Errorf("backup not reported\ngot:  %s\nwant: %s", got, want)
+	}
+
+	backupMgr, err := b.StateMgr(backupName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backupMgr.RefreshState(); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"test_instance.foo", "test_instance.bar"} {
+		if backupMgr.State().ResourceInstance(mustResourceInstanceAddr(addr)) == nil {
+			t.Errorf("%s is not in the backup", addr)
+		}
+	}
+
+	if err := sMgr.RefreshState(); err != nil {
+		t.Fatal(err)
+	}
+	if sMgr.State().ResourceInstance(mustResourceInstanceAddr("test_instance.foo")) != nil {
+		t.Errorf("test_instance.foo was not removed")
+	}
+
+	// The backup workspace must have been unlocked again once it was written.
+	locker := backupMgr.(statemgr.Locker)
+	id, err := locker.Lock(statemgr.NewLockInfo())
+	if err != nil {
+		t.Fatalf("backup workspace is still locked: %s", err)
+	}
+	if err := locker.Unlock(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// It's an ordinary workspace, so it's cleaned up with "workspace delete".
+	ui = new(cli.MockUi)
+	delCmd := &WorkspaceDeleteCommand{
+		Meta: Meta{Ui: ui},
+	}
+	if code := delCmd.Run([]string{"-force", backupName}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter)
+	}
+	workspaces, err = b.Workspaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range workspaces {
+		if name == backupName {
+			t.Errorf("backup workspace %q was not deleted", backupName)
+		}
+	}
+}
+
+func TestBackupWorkspaceName(t *testing.T) {
+	now := time.Unix(1540000000, 0)
+	tests := map[string]struct {
+		existing []string
+		want     string
+	}{
+		"unused": {
+			[]string{"default", "test"},
+			"test.staterm-backup-1540000000",
+		},
+		"taken by an earlier backup": {
+			[]string{"test", "test.staterm-backup-1540000000"},
+			"test.staterm-backup-1540000000-2",
+		},
+		"suffix also taken": {
+			[]string{"test", "test.staterm-backup-1540000000", "test.staterm-backup-1540000000-2"},
+			"test.staterm-backup-1540000000-3",
+		},
+		"other workspace's backup": {
+			[]string{"other.staterm-backup-1540000000"},
+			"test.staterm-backup-1540000000",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := backupWorkspaceName(test.existing, "test", "staterm", now); got != test.want {
+				t.Errorf("wrong name\ngot:  %s\nwant: %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStateRm_partitionByWorkspace(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("inmem-backend"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+	defer inmem.Reset()
+
+	ui := new(cli.MockUi)
+	initCmd := &InitCommand{
+		Meta: Meta{Ui: ui},
+	}
+	if code := initCmd.Run([]string{}); code != 0 {
+		t.Fatalf("bad: \n%s", ui.ErrorWriter.String())
+	}
+
+	b := backend.TestBackendConfig(t, inmem.New(), nil)
+	for _, name := range []string{"production", "staging"} {
+		ui = new(cli.MockUi)
+		newCmd := &WorkspaceNewCommand{
+			Meta: Meta{Ui: ui},
+		}
+		if code := newCmd.Run([]string{name}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter)
+		}
+	}
+	writeState := func(name string, state *states.State) {
+		t.Helper()
+		sMgr, err := b.StateMgr(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sMgr.RefreshState(); err != nil {
+			t.Fatal(err)
+		}
+		if err := statemgr.WriteAndPersist(sMgr, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeState("production", testStateRmKeyedState())
+	writeState("staging", testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-partition-by-workspace",
+		"test_instance.web",
+		"test_instance.*",
+		"test_instance.missing",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `test_instance.web:
+  production: 4
+  4 resource instances in 1 of 3 workspaces
+test_instance.*:
+  production: 4
+  staging: 2
+  6 resource instances in 2 of 3 workspaces
+test_instance.missing:
+  (not in any workspace)
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Nothing was removed from either workspace.
+	for name, want := range map[string]int{"production": 4, "staging": 2} {
+		sMgr, err := b.StateMgr(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sMgr.RefreshState(); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(stateAllResourceInstances(sMgr.State())); got != want {
+			t.Errorf("workspace %s has %d resource instances; want %d", name, got, want)
+		}
+	}
+}
+
+func TestStateRm_workspacePattern(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("inmem-backend"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+	defer inmem.Reset()
+
+	ui := new(cli.MockUi)
+	initCmd := &InitCommand{
+		Meta: Meta{Ui: ui},
+	}
+	if code := initCmd.Run([]string{}); code != 0 {
+		t.Fatalf("bad: \n%s", ui.ErrorWriter.String())
+	}
+
+	b := backend.TestBackendConfig(t, inmem.New(), nil)
+	for _, name := range []string{"dev-a", "dev-b", "prod"} {
+		ui = new(cli.MockUi)
+		newCmd := &WorkspaceNewCommand{
+			Meta: Meta{Ui: ui},
+		}
+		if code := newCmd.Run([]string{name}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter)
+		}
+	}
+	stateMgr := func(name string) statemgr.Full {
+		t.Helper()
+		sMgr, err := b.StateMgr(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sMgr.RefreshState(); err != nil {
+			t.Fatal(err)
+		}
+		return sMgr
+	}
+	for _, name := range []string{"dev-a", "prod"} {
+		if err := statemgr.WriteAndPersist(stateMgr(name), testStateRmState()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkCounts := func(want map[string]int) {
+		t.Helper()
+		for name, want := range want {
+			state := stateMgr(name).State()
+			if got := len(stateAllResourceInstances(state)); got != want {
+				t.Errorf("workspace %s has %d resource instances; want %d", name, got, want)
+			}
+		}
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-workspace-pattern", "dev-*",
+		"-dry-run",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `dev-a:
+  Would remove test_instance.foo
+dev-b: no matching resource instances
+
+Would've removed 1 resource instances from 1 of 2 matching workspaces, without -dry-run.
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	checkCounts(map[string]int{"dev-a": 2, "prod": 2})
+
+	// Without -auto-approve the removal must be confirmed before any of the
+	// workspaces is changed, and can't be where nobody can answer.
+	args = []string{
+		"-workspace-pattern", "dev-*",
+		"test_instance.foo",
+	}
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Confirmation required"; !strings.Contains(got, want) {
+		t.Errorf("error doesn't contain %q\n\n%s", want, got)
+	}
+	checkCounts(map[string]int{"dev-a": 2, "prod": 2})
+
+	cleanup := testStateRmTerminalInput(t, []string{"no"})
+	c, ui = testStateRmCommand(testProvider())
+	code := c.Run(args)
+	cleanup()
+	if code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	for _, want := range []string{"dev-a:\n  Would remove test_instance.foo\n", "Removal cancelled."} {
+		if got := ui.OutputWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("output doesn't contain %q\n\n%s", want, got)
+		}
+	}
+	checkCounts(map[string]int{"dev-a": 2, "prod": 2})
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-workspace-pattern", "dev-*",
+		"-auto-approve",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Removed 1 resource instances from 1 of 2 matching workspaces."; !strings.Contains(got, want) {
+		t.Errorf("output doesn't contain %q\n\n%s", want, got)
+	}
+	checkCounts(map[string]int{"dev-a": 1, "prod": 2})
+
+	// Addresses that match nothing in any of the workspaces fail as in a
+	// single workspace, before anything is asked.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-workspace-pattern", "dev-*",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != stateRmEmptyExitStatus {
+		t.Fatalf("wrong exit status %d; want %d\n\n%s", code, stateRmEmptyExitStatus, ui.ErrorWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Nothing selected for removal"; !strings.Contains(got, want) {
+		t.Errorf("error doesn't contain %q\n\n%s", want, got)
+	}
+	if got := ui.OutputWriter.String(); got != "" {
+		t.Errorf("unexpected output\n%s", got)
+	}
+
+	// A pattern that matches nothing is an error.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-workspace-pattern", "qa-*",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "No matching workspaces"; !strings.Contains(got, want) {
+		t.Errorf("error doesn't contain %q\n\n%s", want, got)
+	}
+	checkCounts(map[string]int{"dev-a": 1, "prod": 2})
+}
+
+func TestStateRm_confirmOtherModes(t *testing.T) {
+	// The modes that change the state without going through the usual
+	// removal ask for confirmation too.
+	tests := map[string][]string{
+		"normalize-only":  {"-normalize-only"},
+		"provider-rename": {"-provider-rename", "provider.test=provider.test.renamed", "test_instance.foo"},
+	}
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+			statePath := testStateFile(t, testStateRmState())
+			before, err := ioutil.ReadFile(statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, ui := testStateRmCommand(testProvider())
+			if code := c.Run(append([]string{"-state", statePath}, args...)); code != 1 {
+				t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+			}
+			if got, want := ui.ErrorWriter.String(), "Confirmation required"; !strings.Contains(got, want) {
+				t.Errorf("error doesn't contain %q\n\n%s", want, got)
+			}
+
+			defer testStateRmTerminalInput(t, []string{"no"})()
+			c, ui = testStateRmCommand(testProvider())
+			if code := c.Run(append([]string{"-state", statePath}, args...)); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got, want := ui.OutputWriter.String(), "Change cancelled."; !strings.Contains(got, want) {
+				t.Errorf("output doesn't contain %q\n\n%s", want, got)
+			}
+
+			after, err := ioutil.ReadFile(statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(before, after) {
+				t.Errorf("state was changed without confirmation")
+			}
+		})
+	}
+}
+
+func TestStateRm_ifNewerThanConfig(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	// Creation times aren't recorded in the state, so the guard can only
+	// warn that it couldn't check anything.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-if-newer-than-config",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Creation times not recorded"; !strings.Contains(got, want) {
+		t.Errorf("limitation not reported\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-if-newer-than-config",
+		"-force",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got := ui.ErrorWriter.String(); got != "" {
+		t.Errorf("unexpected warnings with -force\n%s", got)
+	}
+}
+
+func TestStateRm_module(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.foo"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.module.grandchild.test_instance.foo"), obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"module.child",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `Would remove module.child.test_instance.foo
+Would remove module.child.module.grandchild.test_instance.foo
+`
+	if got := ui.OutputWriter.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("wrong dry-run output\ngot:\n%s\nwant prefix:\n%s", got, want)
+	}
+	if got := ui.OutputWriter.String(); strings.Contains(got, "Removed 2 modules") {
+		t.Errorf("summary printed in dry-run mode\n%s", got)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"module.child",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.State.Modules) != 1 {
+		t.Errorf("removed modules are still in the state")
+	}
+	if f.State.ResourceInstance(mustResourceInstanceAddr("test_instance.foo")) == nil {
+		t.Errorf("resource in root module was removed")
+	}
+	// The modules' instances are counted, not the modules as one each.
+	if got, want := ui.OutputWriter.String(), "Removed 2 modules, 2 resources, 2 resource instances.\nUpdated state written successfully."; !strings.Contains(got, want) {
+		t.Errorf("wrong summary\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"module.child",
+	}
+	if code := c.Run(args); code != stateRmEmptyExitStatus {
+		t.Fatalf("wrong exit status %d; want %d", code, stateRmEmptyExitStatus)
+	}
+	if got, want := ui.ErrorWriter.String(), "No matching resources found in state."; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+
+	// Along with something that is in the state, a missing module is an
+	// error of its own.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"module.child",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+	if got, want := ui.ErrorWriter.String(), "No such module in state"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestWriteStateRmKindSummary(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			`test_instance.node[0]`,
+			`test_instance.node[1]`,
+			`test_instance.single`,
+			`module.child.test_instance.foo`,
+			`module.child.test_instance.bar`,
+			`module.child.module.grandchild.test_instance.foo`,
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+
+	cases := map[string]struct {
+		modules []string
+		items   []string
+		want    string
+	}{
+		"partial removal": {
+			nil,
+			[]string{`test_instance.node[0]`},
+			"Removed 0 modules, 0 resources, 1 resource instance.\n",
+		},
+		"all instances": {
+			nil,
+			[]string{`test_instance.node[0]`, `test_instance.node[1]`},
+			"Removed 0 modules, 1 resource, 2 resource instances.\n",
+		},
+		"single": {
+			nil,
+			[]string{`test_instance.single`},
+			"Removed 0 modules, 1 resource, 1 resource instance.\n",
+		},
+		"whole module": {
+			[]string{`module.child`},
+			[]string{`module.child.test_instance.foo`, `module.child.test_instance.bar`, `module.child.module.grandchild.test_instance.foo`},
+			"Removed 2 modules, 3 resources, 3 resource instances.\n",
+		},
+		"nested module": {
+			[]string{`module.child.module.grandchild`},
+			[]string{`module.child.module.grandchild.test_instance.foo`, `test_instance.node[1]`},
+			"Removed 1 module, 1 resource, 2 resource instances.\n",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var modules []addrs.ModuleInstance
+			for _, raw := range tc.modules {
+				addr, ok := parseModuleInstanceArg(raw)
+				if !ok {
+					t.Fatalf("invalid module address %s", raw)
+				}
+				modules = append(modules, addr)
+			}
+			result := &stateRmResult{}
+			for _, raw := range tc.items {
+				result.Items = append(result.Items, &stateRmItem{Addr: mustResourceInstanceAddr(raw)})
+			}
+			var buf bytes.Buffer
+			writeStateRmKindSummary(&buf, newStateRmShape(state), modules, result)
+			if got := buf.String(); got != tc.want {
+				t.Errorf("wrong summary\ngot:  %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+
+	// Deposed objects and output values are only counted if there are any.
+	t.Run("deposed objects and outputs", func(t *testing.T) {
+		result := &stateRmResult{
+			Items:        []*stateRmItem{{Addr: mustResourceInstanceAddr(`test_instance.single`)}},
+			DeposedCount: 2,
+			Outputs:      []addrs.AbsOutputValue{addrs.OutputValue{Name: "foo"}.Absolute(addrs.RootModuleInstance)},
+		}
+		var buf bytes.Buffer
+		writeStateRmKindSummary(&buf, newStateRmShape(state), nil, result)
+		want := "Removed 0 modules, 1 resource, 1 resource instance, 2 deposed objects, 1 output value.\n"
+		if got := buf.String(); got != want {
+			t.Errorf("wrong summary\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+}
+
+func TestRunStateRm(t *testing.T) {
+	fooAddr := mustResourceInstanceAddr("test_instance.foo")
+	barAddr := mustResourceInstanceAddr("test_instance.bar")
+
+	t.Run("dry run", func(t *testing.T) {
+		state := testStateRmState()
+		result, diags := runStateRm(state, &stateRmOpts{
+			Addrs:  []addrs.AbsResourceInstance{fooAddr, fooAddr},
+			DryRun: true,
+		})
+		if diags.HasErrors() {
+			t.Fatalf("unexpected errors: %s", diags.Err())
+		}
+		if got, want := len(result.Items), 1; got != want {
+			t.Fatalf("wrong number of items %d; want %d", got, want)
+		}
+		if result.CurrentCount != 1 || result.DeposedCount != 0 {
+			t.Errorf("wrong counts %d current, %d deposed", result.CurrentCount, result.DeposedCount)
+		}
+		if !state.Equal(testStateRmState()) {
+			t.Errorf("dry run modified the state")
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		state := testStateRmState()
+		result, diags := runStateRm(state, &stateRmOpts{
+			Addrs: []addrs.AbsResourceInstance{fooAddr},
+		})
+		if diags.HasErrors() {
+			t.Fatalf("unexpected errors: %s", diags.Err())
+		}
+		if state.ResourceInstance(fooAddr) != nil {
+			t.Errorf("%s is still in the state", fooAddr)
+		}
+		if state.ResourceInstance(barAddr) == nil {
+			t.Errorf("%s was removed", barAddr)
+		}
+		if got := result.Items[0].Instance; got == nil || got.Current == nil {
+			t.Errorf("result does not include the removed instance")
+		}
+	})
+
+	t.Run("module outputs", func(t *testing.T) {
+		child := addrs.RootModuleInstance.Child("child", addrs.NoKey)
+		grandchild := child.Child("grandchild", addrs.NoKey)
+		instAddr := mustResourceInstanceAddr("module.child.test_instance.foo")
+		build := func() *states.State {
+			return states.BuildState(func(s *states.SyncState) {
+				s.SetResourceInstanceCurrent(
+					instAddr,
+					&states.ResourceInstanceObjectSrc{
+						AttrsJSON: []byte(`{"id":"bar"}`),
+						Status:    states.ObjectReady,
+					},
+					addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+				)
+				s.SetOutputValue(child.OutputValue("id"), cty.StringVal("bar"), false)
+				s.SetOutputValue(grandchild.OutputValue("id"), cty.StringVal("bar"), false)
+			})
+		}
+
+		state := build()
+		_, diags := runStateRm(state, &stateRmOpts{
+			Addrs:   []addrs.AbsResourceInstance{instAddr},
+			Modules: []addrs.ModuleInstance{child},
+		})
+		if diags.HasErrors() {
+			t.Fatalf("unexpected errors: %s", diags.Err())
+		}
+		if state.OutputValue(child.OutputValue("id")) == nil {
+			t.Errorf("output value was removed without RemoveOutputs")
+		}
+
+		state = build()
+		result, diags := runStateRm(state, &stateRmOpts{
+			Addrs:         []addrs.AbsResourceInstance{instAddr},
+			Modules:       []addrs.ModuleInstance{child},
+			RemoveOutputs: true,
+		})
+		if diags.HasErrors() {
+			t.Fatalf("unexpected errors: %s", diags.Err())
+		}
+		if got, want := len(result.Outputs), 2; got != want {
+			t.Errorf("wrong number of outputs removed %d; want %d", got, want)
+		}
+		if state.Module(child) != nil || state.Module(grandchild) != nil {
+			t.Errorf("removed modules are still in the state")
+		}
+	})
+
+	t.Run("empty modules", func(t *testing.T) {
+		child := addrs.RootModuleInstance.Child("child", addrs.NoKey)
+		instAddr := mustResourceInstanceAddr("module.child.test_instance.foo")
+		state := states.BuildState(func(s *states.SyncState) {
+			s.SetResourceInstanceCurrent(
+				instAddr,
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(`{"id":"bar"}`),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		})
+
+		_, diags := runStateRm(state, &stateRmOpts{
+			Addrs: []addrs.AbsResourceInstance{instAddr},
+		})
+		if diags.HasErrors() {
+			t.Fatalf("unexpected errors: %s", diags.Err())
+		}
+		if state.Module(child) != nil {
+			t.Errorf("empty module was not pruned")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		state := testStateRmState()
+		_, diags := runStateRm(state, &stateRmOpts{
+			Addrs: []addrs.AbsResourceInstance{fooAddr, mustResourceInstanceAddr("test_instance.baz")},
+		})
+		if !diags.HasErrors() {
+			t.Fatalf("expected errors")
+		}
+		if !state.Equal(testStateRmState()) {
+			t.Errorf("state was modified despite errors")
+		}
+	})
+
+	t.Run("selectors", func(t *testing.T) {
+		c, _ := testStateRmCommand(testProvider())
+		state := testStateRmState()
+		result, diags := runStateRm(state, &stateRmOpts{
+			Env:         &stateRmCommandEnv{StateRmCommand: c},
+			Selectors:   []string{"test_instance.foo"},
+			AutoApprove: true,
+		})
+		if diags.HasErrors() {
+			t.Fatalf("unexpected errors: %s", diags.Err())
+		}
+		if state.ResourceInstance(fooAddr) != nil {
+			t.Errorf("%s is still in the state", fooAddr)
+		}
+		if state.ResourceInstance(barAddr) == nil {
+			t.Errorf("%s was removed", barAddr)
+		}
+		if got, want := result.Addrs(), []addrs.AbsResourceInstance{fooAddr}; !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong items\ngot:  %s\nwant: %s", got, want)
+		}
+		if got, want := result.Selection.Matches, []stateRmMatch{{Selector: "test_instance.foo", Addrs: []addrs.AbsResourceInstance{fooAddr}}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong matches\ngot:  %#v\nwant: %#v", got, want)
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		c, _ := testStateRmCommand(testProvider())
+		state := testStateRmState()
+		result, diags := runStateRm(state, &stateRmOpts{
+			Env:       &stateRmCommandEnv{StateRmCommand: c},
+			Selectors: []string{"test_instance.foo", "test_instance.bar"},
+			Exclude:   []string{"test_instance.bar"},
+			DryRun:    true,
+		})
+		if diags.HasErrors() {
+			t.Fatalf("unexpected errors: %s", diags.Err())
+		}
+		if got, want := result.Addrs(), []addrs.AbsResourceInstance{fooAddr}; !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong items\ngot:  %s\nwant: %s", got, want)
+		}
+		if got, want := result.Selection.Excluded, []addrs.AbsResourceInstance{barAddr}; !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong excluded instances\ngot:  %s\nwant: %s", got, want)
+		}
+		for _, want := range []string{
+			"Would remove test_instance.foo\n",
+			"Skipped 1 selected resource instances because of -exclude.",
+			"Would've removed 1 current and 0 deposed objects, without -dry-run.",
+		} {
+			if !strings.Contains(result.Report, want) {
+				t.Errorf("report is missing %q\n%s", want, result.Report)
+			}
+		}
+		if !state.Equal(testStateRmState()) {
+			t.Errorf("dry run modified the state")
+		}
+	})
+
+	t.Run("nothing selected", func(t *testing.T) {
+		c, _ := testStateRmCommand(testProvider())
+		state := testStateRmState()
+		result, diags := runStateRm(state, &stateRmOpts{
+			Env:         &stateRmCommandEnv{StateRmCommand: c},
+			Selectors:   []string{"test_instance.baz"},
+			AutoApprove: true,
+		})
+		if !diags.HasErrors() {
+			t.Fatalf("expected errors")
+		}
+		if !result.NothingSelected {
+			t.Errorf("NothingSelected is not set")
+		}
+		if !state.Equal(testStateRmState()) {
+			t.Errorf("state was modified despite errors")
+		}
+	})
+
+	t.Run("guards", func(t *testing.T) {
+		count := 2
+		for name, opts := range map[string]*stateRmOpts{
+			"fail-on":      {FailOn: []string{"test_instance"}},
+			"assert-count": {AssertCount: &count},
+			"max-removal":  {MaxRemovalPct: 10},
+		} {
+			t.Run(name, func(t *testing.T) {
+				c, _ := testStateRmCommand(testProvider())
+				opts.Env = &stateRmCommandEnv{StateRmCommand: c}
+				opts.Selectors = []string{"test_instance.foo"}
+				opts.AutoApprove = true
+
+				state := testStateRmState()
+				if _, diags := runStateRm(state, opts); !diags.HasErrors() {
+					t.Fatalf("expected errors")
+				}
+				if !state.Equal(testStateRmState()) {
+					t.Errorf("state was modified despite errors")
+				}
+			})
+		}
+	})
+
+	t.Run("match count", func(t *testing.T) {
+		c, _ := testStateRmCommand(testProvider())
+		state := testStateRmState()
+		result, diags := runStateRm(state, &stateRmOpts{
+			Env:        &stateRmCommandEnv{StateRmCommand: c},
+			Selectors:  []string{"test_instance.foo", "test_instance.baz"},
+			MatchCount: true,
+		})
+		if diags.HasErrors() {
+			t.Fatalf("unexpected errors: %s", diags.Err())
+		}
+		if got, want := result.Report, "test_instance.foo: 1\ntest_instance.baz: 0"; got != want {
+			t.Errorf("wrong report\ngot:  %q\nwant: %q", got, want)
+		}
+		if !state.Equal(testStateRmState()) {
+			t.Errorf("-match-count modified the state")
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		defer testStateRmTerminalInput(t, []string{"no"})()
+		c, ui := testStateRmCommand(testProvider())
+		c.Meta.input = true
+		state := testStateRmState()
+		result, diags := runStateRm(state, &stateRmOpts{
+			Env:       &stateRmCommandEnv{StateRmCommand: c},
+			Selectors: []string{"test_instance.foo"},
+		})
+		if diags.HasErrors() {
+			t.Fatalf("unexpected errors: %s", diags.Err())
+		}
+		if !result.Cancelled {
+			t.Errorf("Cancelled is not set")
+		}
+		if got, want := ui.OutputWriter.String(), "Would remove test_instance.foo"; !strings.Contains(got, want) {
+			t.Errorf("items to remove not listed\ngot:  %s\nwant: %s", got, want)
+		}
+		if !state.Equal(testStateRmState()) {
+			t.Errorf("cancelled removal modified the state")
+		}
+	})
+
+	t.Run("no terminal", func(t *testing.T) {
+		c, _ := testStateRmCommand(testProvider())
+		state := testStateRmState()
+		_, diags := runStateRm(state, &stateRmOpts{
+			Env:       &stateRmCommandEnv{StateRmCommand: c},
+			Selectors: []string{"test_instance.foo"},
+		})
+		if !diags.HasErrors() {
+			t.Fatalf("expected errors")
+		}
+		if got, want := diags.Err().Error(), "Confirmation required"; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+		if !state.Equal(testStateRmState()) {
+			t.Errorf("state was modified despite errors")
+		}
+	})
+}
+
+func TestLogStateRmObjects(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	addr := mustResourceInstanceAddr("test_instance.foo")
+	is := &states.ResourceInstance{
+		Current: &states.ResourceInstanceObjectSrc{
+			AttrsJSON:     []byte(`{"id":"foo","password":"hunter2"}`),
+			Private:       []byte("private-secret"),
+			SchemaVersion: 2,
+			Status:        states.ObjectReady,
+		},
+		Deposed: map[states.DeposedKey]*states.ResourceInstanceObjectSrc{
+			"00000001": {
+				AttrsFlat: map[string]string{"id": "old", "password": "hunter3"},
+				Status:    states.ObjectTainted,
+			},
+		},
+	}
+	logStateRmObjects(&stateRmItem{
+		Addr:     addr,
+		Instance: is,
+		Current:  true,
+		Deposed:  []states.DeposedKey{"00000001"},
+	})
+
+	got := buf.String()
+	for _, secret := range []string{"hunter2", "hunter3", "private-secret"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("log contains %q\n%s", secret, got)
+		}
+	}
+	sum, err := stateRmObjectSum(is.Current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("removing test_instance.foo (status %s, schema version 2): 33 bytes of attributes, 14 bytes of private data, sha256 %x", states.ObjectReady, sum)
+	if !strings.Contains(got, want) {
+		t.Errorf("current object not logged\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if want := "removing test_instance.foo deposed object 00000001"; !strings.Contains(got, want) {
+		t.Errorf("deposed object not logged\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The full content is only logged when it's asked for.
+	defer os.Unsetenv(StateRmLogContentsEnvVar)
+	os.Setenv(StateRmLogContentsEnvVar, "1")
+	buf.Reset()
+	logStateRmObjects(&stateRmItem{
+		Addr:     addr,
+		Instance: is,
+		Current:  true,
+		Deposed:  []states.DeposedKey{"00000001"},
+	})
+	got = buf.String()
+	for _, secret := range []string{"hunter2", "hunter3", "private-secret"} {
+		if !strings.Contains(got, secret) {
+			t.Errorf("log with %s set doesn't contain %q\n%s", StateRmLogContentsEnvVar, secret, got)
+		}
+	}
+}
+
+func TestPersistStateWithRetry(t *testing.T) {
+	tests := map[string]struct {
+		Errs     []error
+		Retries  int
+		Attempts int
+		Err      bool
+		Sleeps   []time.Duration
+	}{
+		"success": {
+			Retries:  3,
+			Attempts: 1,
+		},
+		"transient then success": {
+			Errs:     []error{errors.New("503 Service Unavailable"), errors.New("request timed out")},
+			Retries:  3,
+			Attempts: 3,
+			Sleeps:   []time.Duration{time.Second, 2 * time.Second},
+		},
+		"out of retries": {
+			Errs:     []error{errors.New("Throttling: Rate exceeded"), errors.New("Throttling: Rate exceeded")},
+			Retries:  1,
+			Attempts: 2,
+			Err:      true,
+			Sleeps:   []time.Duration{time.Second},
+		},
+		"not retryable": {
+			Errs:     []error{errors.New("AccessDenied: 403 Forbidden")},
+			Retries:  3,
+			Attempts: 1,
+			Err:      true,
+		},
+		"unrecognized": {
+			Errs:     []error{errors.New("something went wrong")},
+			Retries:  3,
+			Attempts: 1,
+			Err:      true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mgr := &testFlakyPersister{errs: test.Errs}
+			var sleeps []time.Duration
+			attempts, err := persistStateWithRetry(mgr, &stateRmRetryOpts{
+				Retries:  test.Retries,
+				Interval: time.Second,
+				Sleep: func(d time.Duration) {
+					sleeps = append(sleeps, d)
+				},
+			})
+			if (err != nil) != test.Err {
+				t.Fatalf("wrong error %v; want error %t", err, test.Err)
+			}
+			if attempts != test.Attempts {
+				t.Errorf("wrong number of attempts %d; want %d", attempts, test.Attempts)
+			}
+			if !reflect.DeepEqual(sleeps, test.Sleeps) {
+				t.Errorf("wrong sleeps %v; want %v", sleeps, test.Sleeps)
+			}
+		})
+	}
+}
+
+// testFlakyPersister is a statemgr.Persister that fails with each of the
+// given errors in turn, and then succeeds.
+type testFlakyPersister struct {
+	errs []error
+}
+
+func (p *testFlakyPersister) PersistState() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	err := p.errs[0]
+	p.errs = p.errs[1:]
+	return err
+}
+
+// testStateRmCommand returns a StateRmCommand using the given provider and
+// a new mock UI, which is also returned so that callers can inspect output.
+func TestStateRm_trace(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-trace",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	got := ui.ErrorWriter.String()
+	for _, phase := range []string{"load", "refresh", "filter", "mutate", "write", "persist"} {
+		if want := fmt.Sprintf("[trace] %s: ", phase); !strings.Contains(got, want) {
+			t.Errorf("missing trace for the %s phase\n%s", phase, got)
+		}
+	}
+	if strings.Contains(ui.OutputWriter.String(), "[trace]") {
+		t.Errorf("trace written to stdout\n%s", ui.OutputWriter.String())
+	}
+
+	// Without -trace, nothing is reported.
+	statePath = testStateFile(t, testStateRmState())
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got := ui.ErrorWriter.String(); strings.Contains(got, "[trace]") {
+		t.Errorf("unexpected trace output\n%s", got)
+	}
+}
+
+func TestStateRm_normalizeOnly(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	canonical, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same snapshot, but formatted differently.
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, canonical); err != nil {
+		t.Fatal(err)
+	}
+	original := buf.Bytes()
+	if err := ioutil.WriteFile(statePath, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+	backupPath := statePath + ".backup"
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-normalize-only",
+		"-dry-run",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, _ := ioutil.ReadFile(statePath); !bytes.Equal(got, original) {
+		t.Fatalf("dry run changed the state file\n%s", got)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-backup", backupPath,
+		"-normalize-only",
+		"-auto-approve",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Normalized the state snapshot, changing its stored bytes."; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+	if got, _ := ioutil.ReadFile(statePath); !bytes.Equal(got, canonical) {
+		t.Errorf("state file was not normalized\ngot:\n%s\nwant:\n%s", got, canonical)
+	}
+	if got, _ := ioutil.ReadFile(backupPath); !bytes.Equal(got, original) {
+		t.Errorf("backup does not match the original snapshot\n%s", got)
+	}
+
+	// Now that the snapshot is normalized, doing it again changes nothing.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-normalize-only",
+		"-auto-approve",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "already normalized"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-normalize-only",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+}
+
+func TestStateRm_addressFlags(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, name := range []string{"a", "b", "c", "d"} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance."+name), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	addrFile := filepath.Join(filepath.Dir(statePath), "addrs.txt")
+	if err := ioutil.WriteFile(addrFile, []byte("# from a script\ntest_instance.c\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-address", "test_instance.b",
+		"-from-file", addrFile,
+		"test_instance.a",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if addr := mustResourceInstanceAddr("test_instance." + name); f.State.ResourceInstance(addr) != nil {
+			t.Errorf("%s was not removed", addr)
+		}
+	}
+	if addr := mustResourceInstanceAddr("test_instance.d"); f.State.ResourceInstance(addr) == nil {
+		t.Errorf("%s was removed", addr)
+	}
+}
+
+func TestStateRm_addressFileFormat(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, name := range []string{"a", "b", "c"} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance."+name), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+	dir := filepath.Dir(statePath)
+
+	// A file with a .json extension is read as JSON by default.
+	stringsFile := filepath.Join(dir, "addrs.json")
+	if err := ioutil.WriteFile(stringsFile, []byte(`["test_instance.a"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The output of "terraform state list -json" can be used as it is.
+	listFile := filepath.Join(dir, "inventory.txt")
+	if err := ioutil.WriteFile(listFile, []byte("[\n{\"address\":\"test_instance.b\",\"mode\":\"managed\"}\n]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	badFile := filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(badFile, []byte("[\n\"test_instance.a\",\n\"test_instance.c\" oops\n]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"-from-file", stringsFile},
+		{"-from-file", listFile, "-address-file-format", "json"},
+	} {
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run(append([]string{"-state", statePath, "-auto-approve"}, args...)); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if addr := mustResourceInstanceAddr("test_instance." + name); f.State.ResourceInstance(addr) != nil {
+			t.Errorf("%s was not removed", addr)
+		}
+	}
+	if addr := mustResourceInstanceAddr("test_instance.c"); f.State.ResourceInstance(addr) == nil {
+		t.Errorf("%s was removed", addr)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-from-file", badFile}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), `"test_instance.c" oops`; !strings.Contains(got, want) {
+		t.Errorf("offending content not shown\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_fromFileStdin(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, name := range []string{"a", "b", "c", "d"} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance."+name), obj, provider)
+		}
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		defer testStdinPipe(t, strings.NewReader("# refactored\ntest_instance.a\n\n  test_instance.b  \n"))()
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-from-file", "-", "test_instance.c"}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		var got []string
+		for _, addr := range stateAllResourceInstances(testStateRead(t, statePath)) {
+			got = append(got, addr.String())
+		}
+		if want := []string{"test_instance.d"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong remaining instances\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		defer testStdinPipe(t, strings.NewReader("\n  \n# nothing\n"))()
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-from-file", "-"}); code != 1 {
+			t.Fatalf("wrong exit status %d; want 1", code)
+		}
+		if got, want := ui.ErrorWriter.String(), "At least one resource address is required."; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+
+	t.Run("invalid address", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		addrFile := filepath.Join(filepath.Dir(statePath), "addrs.txt")
+		if err := ioutil.WriteFile(addrFile, []byte("test_instance.a\nnot an address\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, _ := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-from-file", addrFile}); code != 1 {
+			t.Fatalf("wrong exit status %d; want 1", code)
+		}
+		if testStateRead(t, statePath).ResourceInstance(mustResourceInstanceAddr("test_instance.a")) == nil {
+			t.Errorf("test_instance.a was removed")
+		}
+	})
+
+	t.Run("alias", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		defer testStdinPipe(t, strings.NewReader("test_instance.a\n"))()
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-addresses-from", "-"}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		if testStateRead(t, statePath).ResourceInstance(mustResourceInstanceAddr("test_instance.a")) != nil {
+			t.Errorf("test_instance.a was not removed")
+		}
+
+		c, ui = testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-addresses-from", "-", "-from-file", "addrs.txt"}); code != cli.RunResultHelp {
+			t.Fatalf("wrong exit status %d; want %d", code, cli.RunResultHelp)
+		}
+		if got, want := ui.ErrorWriter.String(), "another name for -from-file"; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+
+	t.Run("stdin confirm token", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-from-file", "-", "-stdin-confirm-token"}); code != 1 {
+			t.Fatalf("wrong exit status %d; want 1", code)
+		}
+		if got, want := ui.ErrorWriter.String(), "Invalid combination of options"; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+}
+
+func TestStateRm_simulatePlan(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-orphan-keys"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	statePath := testStateFile(t, testStateRmKeyedState())
+
+	c, ui := testStateRmCommand(planFixtureProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-simulate-plan",
+		"test_instance.web[0]",
+		`test_instance.web["old"]`,
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	got := ui.OutputWriter.String()
+	for _, want := range []string{
+		"create test_instance.web[0] again, since it is still in the configuration",
+		`leave test_instance.web["old"] unchanged`,
+		// test_instance.web[1] is updated because its state has none of
+		// the attributes set by the provider, and web[2] is destroyed
+		// because it is beyond the configured count.
+		"Plan: 1 to add, 1 to change, 1 to destroy.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output\n%s", want, got)
+		}
+	}
+
+	// The state is not changed by the simulated plan.
+	testStateRmInstanceKeys(t, statePath, "web", []addrs.InstanceKey{
+		addrs.IntKey(0), addrs.IntKey(1), addrs.IntKey(2), addrs.StringKey("old"),
+	})
+
+	c, ui = testStateRmCommand(planFixtureProvider())
+	args = []string{
+		"-state", statePath,
+		"-simulate-plan",
+		"test_instance.web[0]",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+}
+
+func TestStateRm_postRemovePlanGate(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-orphan-keys"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	// After removing test_instance.web[0], the plan creates it again and
+	// destroys test_instance.web[2] and test_instance.web["old"], which the
+	// configured count doesn't include.
+	statePath := testStateFile(t, testStateRmKeyedState())
+	c, ui := testStateRmCommand(planFixtureProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-post-remove-plan-gate", "0",
+		"-post-remove-plan-gate-rollback",
+		"test_instance.web[0]",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	got := ui.ErrorWriter.String()
+	for _, want := range []string{"Post-removal plan gate failed", "destroy test_instance.web[2]", "Removal rolled back"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in error\n%s", want, got)
+		}
+	}
+	testStateRmInstanceKeys(t, statePath, "web", []addrs.InstanceKey{
+		addrs.IntKey(0), addrs.IntKey(1), addrs.IntKey(2), addrs.StringKey("old"),
+	})
+
+	// Without the rollback, the removal is kept.
+	c, ui = testStateRmCommand(planFixtureProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-post-remove-plan-gate", "0",
+		"test_instance.web[0]",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Removal not rolled back"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateRmInstanceKeys(t, statePath, "web", []addrs.InstanceKey{
+		addrs.IntKey(1), addrs.IntKey(2), addrs.StringKey("old"),
+	})
+
+	statePath = testStateFile(t, testStateRmKeyedState())
+	c, ui = testStateRmCommand(planFixtureProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-post-remove-plan-gate", "2",
+		"test_instance.web[0]",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateRmInstanceKeys(t, statePath, "web", []addrs.InstanceKey{
+		addrs.IntKey(1), addrs.IntKey(2), addrs.StringKey("old"),
+	})
+}
+
+func TestStateRm_planFileOut(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-orphan-keys"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	statePath := testStateFile(t, testStateRmKeyedState())
+	planPath := filepath.Join(td, "removal.tfplan")
+
+	c, ui := testStateRmCommand(planFixtureProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-plan-file-out", planPath,
+		"test_instance.web[0]",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Saved a plan against the updated state to "+planPath; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant: %s", got, want)
+	}
+
+	// test_instance.web[0] is still in the configuration, so the plan
+	// creates it again.
+	plan := testReadPlan(t, planPath)
+	created := false
+	for _, rc := range plan.Changes.Resources {
+		if rc.Addr.String() == "test_instance.web[0]" && rc.Action == plans.Create {
+			created = true
+		}
+	}
+	if !created {
+		t.Errorf("plan doesn't create test_instance.web[0]")
+	}
+
+	// The plan records the serial of the state as it was saved, so that it
+	// can be applied.
+	pf, err := planfile.Open(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+	planState, err := pf.ReadStateFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planState.Lineage != saved.Lineage || planState.Serial != saved.Serial {
+		t.Errorf("plan is for lineage %q serial %d, but the state has lineage %q serial %d", planState.Lineage, planState.Serial, saved.Lineage, saved.Serial)
+	}
+	if planState.State.ResourceInstance(mustResourceInstanceAddr("test_instance.web[0]")) != nil {
+		t.Errorf("test_instance.web[0] is still in the planned state")
+	}
+}
+
+func TestStateRm_requireCleanPlan(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-orphan-keys"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	statePath := testStateFile(t, testStateRmKeyedState())
+
+	// test_instance.web[2] is beyond the configured count, so the plan would
+	// destroy it.
+	c, ui := testStateRmCommand(planFixtureProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-require-clean-plan",
+		"test_instance.web[2]",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	got := ui.ErrorWriter.String()
+	for _, want := range []string{
+		"Pending changes to selected instances",
+		"test_instance.web[2]: destroy",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in error\n%s", want, got)
+		}
+	}
+
+	// Once test_instance.web[0] has all of the attributes in the schema, the
+	// plan leaves it unchanged and so it can be removed.
+	state := testStateRmKeyedState()
+	state.SyncWrapper().SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "test_instance",
+			Name: "web",
+		}.Instance(addrs.IntKey(0)).Absolute(addrs.RootModuleInstance),
+		&states.ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(`{"id":"0","ami":null,"network_interface":[]}`),
+			Status:    states.ObjectReady,
+		},
+		addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+	)
+	statePath = testStateFile(t, state)
+
+	c, ui = testStateRmCommand(planFixtureProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-require-clean-plan",
+		"test_instance.web[0]",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateRmInstanceKeys(t, statePath, "web", []addrs.InstanceKey{
+		addrs.IntKey(1), addrs.IntKey(2), addrs.StringKey("old"),
+	})
+}
+
+func TestStateRm_previewApplyDestroyCount(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-orphan-keys"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	statePath := testStateFile(t, testStateRmKeyedState())
+
+	// Only test_instance.web[2] and test_instance.web["old"] are beyond the
+	// configured count, so only they would be destroyed.
+	c, ui := testStateRmCommand(planFixtureProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-expand-for-each",
+		"-preview-apply-destroy-count",
+		"test_instance.web",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	want := "If they were kept in the state, terraform apply would destroy 2 of the 4 selected resource instances."
+	if !strings.Contains(got, want) {
+		t.Errorf("missing %q in output\n%s", want, got)
+	}
+	testStateRmInstanceKeys(t, statePath, "web", []addrs.InstanceKey{
+		addrs.IntKey(0), addrs.IntKey(1), addrs.IntKey(2), addrs.StringKey("old"),
+	})
+
+	c, ui = testStateRmCommand(planFixtureProvider())
+	args = []string{
+		"-state", statePath,
+		"-preview-apply-destroy-count",
+		"test_instance.web[2]",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid combination of options"; !strings.Contains(got, want) {
+		t.Errorf("missing %q in error\n%s", want, got)
+	}
+}
+
+func TestStateRm_conflictCheck(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	unlock, err := testLockState("./testdata", statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{"-state", statePath, "-auto-approve", "-conflict-check", "test_instance.foo"}
+	if code := c.Run(args); code != 1 {
+		unlock()
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	unlock()
+	got := ui.ErrorWriter.String()
+	for _, want := range []string{
+		"Conflicting operation in progress",
+		"  test_instance.foo\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in error\n%s", want, got)
+		}
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	// Once the lock is released, the removal goes ahead.
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_refuseOnDrift(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-orphan-keys"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, key := range []addrs.InstanceKey{addrs.IntKey(0), addrs.IntKey(1)} {
+			s.SetResourceInstanceCurrent(
+				addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "test_instance",
+					Name: "web",
+				}.Instance(key).Absolute(addrs.RootModuleInstance),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(fmt.Sprintf(`{"id":%q,"ami":"foo","network_interface":[]}`, key.String())),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	// The real object of test_instance.web[1] has a different AMI than the
+	// state records.
+	driftProvider := func() *terraform.MockProvider {
+		p := planFixtureProvider()
+		p.ReadResourceFn = func(req providers.ReadResourceRequest) providers.ReadResourceResponse {
+			if req.PriorState.GetAttr("id").AsString() != "[1]" {
+				return providers.ReadResourceResponse{NewState: req.PriorState}
+			}
+			attrs := req.PriorState.AsValueMap()
+			attrs["ami"] = cty.StringVal("bar")
+			return providers.ReadResourceResponse{NewState: cty.ObjectVal(attrs)}
+		}
+		return p
+	}
+
+	c, ui := testStateRmCommand(driftProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-refuse-on-drift",
+		"-expand-for-each",
+		"test_instance.web",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	got := ui.ErrorWriter.String()
+	for _, want := range []string{
+		"Selected instances have drifted",
+		"test_instance.web[1]:",
+		"~ ami = foo -> bar",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in error\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "test_instance.web[0]") {
+		t.Errorf("unchanged instance reported as drifted\n%s", got)
+	}
+	testStateRmInstanceKeys(t, statePath, "web", []addrs.InstanceKey{
+		addrs.IntKey(0), addrs.IntKey(1),
+	})
+
+	// The real object of test_instance.web[0] still matches the state, so it
+	// can be removed.
+	c, ui = testStateRmCommand(driftProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-refuse-on-drift",
+		"test_instance.web[0]",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateRmInstanceKeys(t, statePath, "web", []addrs.InstanceKey{
+		addrs.IntKey(1),
+	})
+}
+
+func TestStateRm_undoLast(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "test_instance.foo"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-undo-last", "-dry-run"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "\n\n  + test_instance.foo\n"; !strings.Contains(got, want) {
+		t.Errorf("changes not listed\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	// Without input, the restore can't be confirmed.
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-undo-last", "-input=false"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Restore not confirmed"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	func() {
+		defer testInteractiveInput(t, []string{"no"})()
+		c, ui = testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-undo-last"}); code != 1 {
+			t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+		}
+	}()
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	func() {
+		defer testInteractiveInput(t, []string{"yes"})()
+		c, ui = testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-undo-last"}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+	}()
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateRm_confirmShowDiff(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	func() {
+		defer testStateRmTerminalInput(t, []string{"no"})()
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-confirm-show-diff", "test_instance.foo"}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		want := "  # test_instance.foo\n      bar = \"value\"\n      foo = \"value\"\n      id  = \"bar\"\n"
+		if got := ui.OutputWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("summary not shown\ngot:\n%s\nwant:\n%s", got, want)
+		}
+	}()
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	// With -auto-approve, nothing is shown and nothing is asked.
+	c, ui := testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-confirm-show-diff", "-input=false", "-auto-approve", "test_instance.foo"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got := ui.OutputWriter.String(); strings.Contains(got, "# test_instance.foo") {
+		t.Errorf("summary shown with -auto-approve\n%s", got)
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	statePath = testStateFile(t, testStateRmState())
+	func() {
+		defer testStateRmTerminalInput(t, []string{"yes"})()
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-confirm-show-diff", "test_instance.foo"}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+	}()
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_confirm(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	// Declining leaves the state and exits successfully.
+	func() {
+		defer testStateRmTerminalInput(t, []string{"no"})()
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "test_instance.foo"}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		got := ui.OutputWriter.String()
+		if want := "  Would remove test_instance.foo\n"; !strings.Contains(got, want) {
+			t.Errorf("items not listed\ngot:\n%s\nwant:\n%s", got, want)
+		}
+		if want := "Removal cancelled."; !strings.Contains(got, want) {
+			t.Errorf("cancellation not reported\ngot:\n%s", got)
+		}
+	}()
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+	if backups, _ := filepath.Glob(statePath + ".*"); len(backups) != 0 {
+		t.Errorf("backups written after declining: %s", backups)
+	}
+
+	// Without input, or with stdin not a terminal, there's nobody to ask,
+	// so the removal needs -auto-approve.
+	c, ui := testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-input=false", "test_instance.foo"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "input is disabled"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	func() {
+		defer testInteractiveInput(t, []string{"yes"})()
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "test_instance.foo"}); code != 1 {
+			t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+		}
+		if got, want := ui.ErrorWriter.String(), "stdin is not a terminal"; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	}()
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-input=false", "-auto-approve", "test_instance.foo"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	statePath = testStateFile(t, testStateRmState())
+	func() {
+		defer testStateRmTerminalInput(t, []string{"yes"})()
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "test_instance.foo"}); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+	}()
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+// testStateRmTerminalInput is like testInteractiveInput, but also has
+// "terraform state rm" treat stdin as a terminal, so that it asks for
+// confirmation and takes the given answers.
+func testStateRmTerminalInput(t *testing.T, answers []string) func() {
+	t.Helper()
+
+	isTerminal := stateRmStdinIsTerminal
+	stateRmStdinIsTerminal = func() bool { return true }
+	cleanup := testInteractiveInput(t, answers)
+	return func() {
+		cleanup()
+		stateRmStdinIsTerminal = isTerminal
+	}
+}
+
+func TestStateRm_allowMissingState(t *testing.T) {
+	td := tempDir(t)
+	defer os.RemoveAll(td)
+	statePath := filepath.Join(td, "missing.tfstate")
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-allow-missing-state",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "nothing to remove"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("state file was created")
+	}
+}
+
+func TestStateRm_coalesceInstances(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := func(attrs string) *states.ResourceInstanceObjectSrc {
+		return &states.ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(attrs),
+			Status:    states.ObjectReady,
+		}
+	}
+	addr := mustResourceInstanceAddr("test_instance.foo")
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(addr, obj(`{"id":"foo","bar":"value"}`), provider)
+		// Identical to the current object, despite the formatting.
+		s.SetResourceInstanceDeposed(addr, states.DeposedKey("00000001"), obj(`{"bar": "value", "id": "foo"}`), provider)
+		s.SetResourceInstanceDeposed(addr, states.DeposedKey("00000002"), obj(`{"id":"old"}`), provider)
+		// Identical to the deposed object 00000002.
+		s.SetResourceInstanceDeposed(addr, states.DeposedKey("00000003"), obj(`{"id":"old"}`), provider)
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-coalesce-instances",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	for _, want := range []string{
+		"Found duplicate test_instance.foo: deposed object 00000001 is identical to the current object\n",
+		"Found duplicate test_instance.foo: deposed object 00000003 is identical to deposed object 00000002\n",
+		"Found 2 duplicate objects.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output\n%s", want, got)
+		}
+	}
+	if f, err := readStateFile(statePath); err != nil {
+		t.Fatal(err)
+	} else if n := len(f.State.ResourceInstance(addr).Deposed); n != 3 {
+		t.Fatalf("state was changed without -fix: %d deposed objects", n)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-coalesce-instances",
+		"-fix",
+		"-auto-approve",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := f.State.ResourceInstance(addr)
+	if is.Current == nil {
+		t.Fatalf("current object was removed")
+	}
+	var keys []states.DeposedKey
+	for k := range is.Deposed {
+		keys = append(keys, k)
+	}
+	if want := []states.DeposedKey{"00000002"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("wrong deposed objects %#v; want %#v", keys, want)
+	}
+}
+
+func TestStateRm_dedupeAcrossModules(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := func(id string) *states.ResourceInstanceObjectSrc {
+		return &states.ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(`{"id":"` + id + `"}`),
+			Status:    states.ObjectReady,
+		}
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.a.test_instance.foo"), obj("shared"), provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.b.test_instance.foo"), obj("shared"), provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.b.test_instance.other"), obj("other"), provider)
+		// The same id in a single module is left to -merge.
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.x"), obj("local"), provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.y"), obj("local"), provider)
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-dedupe-across-modules"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `test_instance with id "shared" is in 2 resource instances:
+  module.a.test_instance.foo (keep)
+  module.b.test_instance.foo (duplicate)
+
+Found 1 resource instances duplicating another in a different module. Run again with -fix to remove them.
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got := len(stateAllResourceInstances(testStateRead(t, statePath))); got != 5 {
+		t.Fatalf("state was changed without -fix: %d instances", got)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-dedupe-across-modules", "-fix", "-keep-module", "module.b"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "  module.a.test_instance.foo (removed)\n  module.b.test_instance.foo (keep)\n"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+	f := testStateRead(t, statePath)
+	if f.ResourceInstance(mustResourceInstanceAddr("module.a.test_instance.foo")) != nil {
+		t.Errorf("duplicate in module.a was not removed")
+	}
+	if got := len(stateAllResourceInstances(f)); got != 4 {
+		t.Errorf("wrong number of remaining instances %d; want 4", got)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-keep-module", "module.b", "test_instance.x"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "applies only to -dedupe-across-modules"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_dedupeDeposed(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := func(attrs string) *states.ResourceInstanceObjectSrc {
+		return &states.ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(attrs),
+			Status:    states.ObjectReady,
+		}
+	}
+	addr := mustResourceInstanceAddr("test_instance.foo")
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(addr, obj(`{"id":"foo"}`), provider)
+		// Identical to the current object, which isn't compared.
+		s.SetResourceInstanceDeposed(addr, states.DeposedKey("00000001"), obj(`{"id":"foo"}`), provider)
+		s.SetResourceInstanceDeposed(addr, states.DeposedKey("00000002"), obj(`{"id":"old","bar":"value"}`), provider)
+		s.SetResourceInstanceDeposed(addr, states.DeposedKey("00000003"), obj(`{"id":"old","bar":"value"}`), provider)
+		// Equal in value to 00000002, but not stored identically.
+		s.SetResourceInstanceDeposed(addr, states.DeposedKey("00000004"), obj(`{"bar":"value","id":"old"}`), provider)
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dedupe-deposed",
+		"-auto-approve",
+	}
+	if code := c.Run(append(args, "-dry-run")); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `Would remove duplicate test_instance.foo: deposed object 00000003 is identical to deposed object 00000002
+
+Would've removed 1 duplicate deposed objects, without -dry-run.
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if f, err := readStateFile(statePath); err != nil {
+		t.Fatal(err)
+	} else if n := len(f.State.ResourceInstance(addr).Deposed); n != 4 {
+		t.Fatalf("state was changed by -dry-run: %d deposed objects", n)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Removed duplicate test_instance.foo: deposed object 00000003"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant: %s", got, want)
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range f.State.ResourceInstance(addr).Deposed {
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+	if want := []string{"00000001", "00000002", "00000004"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("wrong deposed objects %#v; want %#v", keys, want)
+	}
+}
+
+func TestStateRm_matchCount(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.a"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.b"), obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-match-count",
+		"test_instance.foo",
+		"test_instance.missing",
+		"module.child",
+		"module.missing",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	want := "test_instance.foo: 1\ntest_instance.missing: 0\nmodule.child: 2\nmodule.missing: 0\n"
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Nothing is removed.
+	testStateOutput(t, statePath, strings.TrimSpace(state.String()))
+}
+
+func TestStateRm_inputFalse(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-input=false",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if c.Input() {
+		t.Fatalf("input is still enabled")
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := mustResourceInstanceAddr("test_instance.foo"); f.State.ResourceInstance(addr) != nil {
+		t.Errorf("%s was not removed", addr)
+	}
+}
+
+func TestStateRm_byResourceFile(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.bar"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.a"), obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	removedPath := filepath.Join(filepath.Dir(statePath), "removed.tf")
+	removed := `
+removed {
+  from = test_instance.foo
+
+  lifecycle {
+    destroy = false
+  }
+}
+
+removed {
+  from = module.child
+}
+`
+	if err := ioutil.WriteFile(removedPath, []byte(removed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-by-resource-file", removedPath,
+		"-dry-run",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	for _, want := range []string{
+		fmt.Sprintf("From a removed block in %s: test_instance.foo\n", removedPath),
+		fmt.Sprintf("From a removed block in %s: module.child\n", removedPath),
+		"Would remove module.child.test_instance.a\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output\n%s", want, got)
+		}
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-by-resource-file", removedPath,
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"test_instance.foo", "module.child.test_instance.a"} {
+		if f.State.ResourceInstance(mustResourceInstanceAddr(addr)) != nil {
+			t.Errorf("%s was not removed", addr)
+		}
+	}
+	if f.State.ResourceInstance(mustResourceInstanceAddr("test_instance.bar")) == nil {
+		t.Errorf("test_instance.bar was removed")
+	}
+
+	if err := ioutil.WriteFile(removedPath, []byte("removed {\n  from = \"test_instance.bar\"\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+}
+
+func TestStateRm_applyRemovedBlocks(t *testing.T) {
+	td := tempDir(t)
+	os.MkdirAll(td, 0755)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo[0]"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo[1]"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.bar"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.a"), obj, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	config := `
+resource "test_instance" "bar" {}
+
+removed {
+  from = test_instance.foo
+}
+`
+	if err := ioutil.WriteFile("main.tf", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("removed.tf", []byte("removed {\n  from = module.child\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{"-state", statePath, "-auto-approve", "-apply-removed-blocks"}
+	if code := c.Run(append(args, "-dry-run")); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `From a removed block in main.tf: test_instance.foo
+  test_instance.foo[0]
+  test_instance.foo[1]
+From a removed block in removed.tf: module.child
+  module.child.test_instance.a
+`
+	if got := ui.OutputWriter.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant prefix:\n%s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	remaining := stateAllResourceInstances(testStateRead(t, statePath))
+	if got, want := len(remaining), 1; got != want || remaining[0].String() != "test_instance.bar" {
+		t.Fatalf("wrong remaining instances %s", remaining)
+	}
+
+	// The removed blocks have all been applied now, so they're skipped.
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(append(args, "-dry-run")); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Already applied removed block in removed.tf: module.child\n"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_persistFailure(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("inmem-backend"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+	defer inmem.Reset()
+
+	ui := new(cli.MockUi)
+	initCmd := &InitCommand{
+		Meta: Meta{Ui: ui},
+	}
+	if code := initCmd.Run([]string{}); code != 0 {
+		t.Fatalf("bad: \n%s", ui.ErrorWriter.String())
+	}
+
+	// The inmem backend resets the default workspace each time it's
+	// configured, so we work in a separate one.
+	ui = new(cli.MockUi)
+	newCmd := &WorkspaceNewCommand{
+		Meta: Meta{Ui: ui},
+	}
+	if code := newCmd.Run([]string{"test"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter)
+	}
+
+	b := backend.TestBackendConfig(t, inmem.New(), nil)
+	sMgr, err := b.StateMgr("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sMgr.RefreshState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := statemgr.WriteAndPersist(sMgr, testStateRmState()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The inmem backend returns the same state manager on every call, so
+	// the command will use this client, which can't save anything.
+	remoteMgr := sMgr.(*remote.State)
+	client := remoteMgr.Client
+	before, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteMgr.Client = stateRmFailingPutClient{client}
+	defer func() { remoteMgr.Client = client }()
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-auto-approve",
+		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Failed to persist state"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+
+	after, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after.Data, before.Data) {
+		t.Fatalf("remote state changed after a failed persist\nbefore:\n%s\nafter:\n%s", before.Data, after.Data)
+	}
+}
+
+// stateRmFailingPutClient is a remote.Client that fails to save any state,
+// while reading from the wrapped client as usual.
+type stateRmFailingPutClient struct {
+	remote.Client
+}
+
+func (c stateRmFailingPutClient) Put([]byte) error {
+	return errors.New("simulated failure to save state")
+}
+
+func TestStateRm_expandForEach(t *testing.T) {
+	statePath := testStateFile(t, testStateRmKeyedState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"test_instance.web",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Resource has multiple instances"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-expand-for-each",
+		"-dry-run",
+		"test_instance.web",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), `test_instance.web expands to 4 instances: [0], [1], [2], ["old"]`; !strings.Contains(got, want) {
+		t.Errorf("expansion not listed\ngot:  %s\nwant: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-expand-for-each",
+		"test_instance.web",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateRmInstanceKeys(t, statePath, "web", nil)
+}
+
+func TestStateRm_providerRename(t *testing.T) {
+	state := testStateRmState()
+	state.SyncWrapper().SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "test_instance",
+			Name: "baz",
+		}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+		&states.ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(`{"id":"baz"}`),
+			Status:    states.ObjectReady,
+		},
+		addrs.ProviderConfig{Type: "test", Alias: "other"}.Absolute(addrs.RootModuleInstance),
+	)
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-provider-rename", "provider.test=provider.test.renamed",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := strings.TrimSpace(`
+Would change the provider of test_instance.bar from provider.test to provider.test.renamed
+Would change the provider of test_instance.foo from provider.test to provider.test.renamed
+
+Would've changed the provider of 2 resources, without -dry-run.
+`)
+	if got := strings.TrimSpace(ui.OutputWriter.String()); got != want {
+		t.Errorf("wrong output\ngot:\n%s\n\nwant:\n%s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-provider-rename", "provider.test=provider.test.renamed",
+		"-auto-approve",
+		"test_instance.foo",
+		"test_instance.baz",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantProviders := map[string]string{
+		"foo": "provider.test.renamed",
+		"bar": "provider.test",
+		"baz": "provider.test.other",
+	}
+	for name, want := range wantProviders {
+		rs := f.State.Resource(addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "test_instance",
+			Name: name,
+		}.Absolute(addrs.RootModuleInstance))
+		if rs == nil {
+			t.Fatalf("test_instance.%s was removed", name)
+		}
+		if got := rs.ProviderConfig.String(); got != want {
+			t.Errorf("wrong provider for test_instance.%s %s; want %s", name, got, want)
+		}
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-provider-rename", "provider.test",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid -provider-rename option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_providerMigrationReport(t *testing.T) {
+	state := testStateRmState()
+	state.SyncWrapper().SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "test_instance",
+			Name: "baz",
+		}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+		&states.ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(`{"id":"baz"}`),
+			Status:    states.ObjectReady,
+		},
+		addrs.ProviderConfig{Type: "test", Alias: "other"}.Absolute(addrs.RootModuleInstance),
+	)
+	statePath := testStateFile(t, state)
+	mappingPath := filepath.Join(filepath.Dir(statePath), "mapping")
+	mapping := "# split the default provider out\nprovider.test provider.test.east east.tfstate\n"
+	if err := ioutil.WriteFile(mappingPath, []byte(mapping), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-provider-migration-report", mappingPath,
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := strings.TrimSpace(`
+RESOURCE           PROVIDER             NEW PROVIDER        NEW STATE
+test_instance.bar  provider.test        provider.test.east  east.tfstate
+test_instance.baz  provider.test.other  (unmapped)          -
+test_instance.foo  provider.test        provider.test.east  east.tfstate
+
+2 of 3 resources would move to a new provider. The mapping doesn't cover these providers:
+  provider.test.other
+`)
+	if got := strings.TrimSpace(ui.OutputWriter.String()); got != want {
+		t.Errorf("wrong output\ngot:\n%s\n\nwant:\n%s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-json",
+		"-provider-migration-report", mappingPath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var got stateRmMigrationJSON
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %s\n\n%s", err, ui.OutputWriter.String())
+	}
+	wantJSON := stateRmMigrationJSON{
+		Resources: []stateRmMigrationResourceJSON{
+			{Address: "test_instance.foo", Provider: "provider.test", NewProvider: "provider.test.east", NewState: "east.tfstate"},
+		},
+		UnmappedProviders: []string{},
+	}
+	if !reflect.DeepEqual(got, wantJSON) {
+		t.Errorf("wrong report\ngot:  %#v\nwant: %#v", got, wantJSON)
+	}
+
+	// Nothing is removed.
+	if got, want := len(stateAllResourceInstances(testStateRead(t, statePath))), 3; got != want {
+		t.Errorf("state has %d instances; want %d", got, want)
+	}
+
+	if err := ioutil.WriteFile(mappingPath, []byte("provider.test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-provider-migration-report", mappingPath}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid -provider-migration-report option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_failOn(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	for _, dryRun := range []bool{true, false} {
+		c, ui := testStateRmCommand(testProvider())
+		args := []string{
+			"-state", statePath,
+			"-auto-approve",
+			"-fail-on", "test_other",
+			"-fail-on", "test_instance",
+			"test_instance.foo",
+		}
+		if dryRun {
+			args = append([]string{"-dry-run"}, args...)
+		}
+		if code := c.Run(args); code != 1 {
+			t.Fatalf("wrong exit status %d with dry run %t; want 1\n\n%s", code, dryRun, ui.OutputWriter.String())
+		}
+		got := ui.ErrorWriter.String()
+		if want := "Protected resource types selected"; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+		if want := "  test_instance.foo\n"; !strings.Contains(got, want) {
+			t.Errorf("protected instance not listed\ngot:  %s\nwant: %s", got, want)
+		}
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-fail-on", "data.test_instance",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_resourceType(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"test_instance.a",
+			"test_other.a",
+			"data.test_instance.a",
+			"module.child.test_instance.b",
+			"module.child.test_other.b",
+			"module.other.test_instance.c",
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-resource-type", "test_instance",
+		"-resource-type", "test_other",
+		"-scope-module", "module.child",
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	if code := c.Run(append([]string{"-dry-run"}, args...)); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `test_instance:
+  Would remove module.child.test_instance.b
+
+test_other:
+  Would remove module.child.test_other.b
+`
+	if got := ui.OutputWriter.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant prefix:\n%s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	remaining := testStateRead(t, statePath)
+	if got, want := len(stateAllResourceInstances(remaining)), 4; got != want {
+		t.Errorf("wrong number of remaining instances %d; want %d", got, want)
+	}
+
+	// Data resource types are selected separately, with a prefix.
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-resource-type", "data.test_instance"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	remaining = testStateRead(t, statePath)
+	if remaining.ResourceInstance(mustResourceInstanceAddr("data.test_instance.a")) != nil {
+		t.Error("data.test_instance.a was not removed")
+	}
+	if remaining.ResourceInstance(mustResourceInstanceAddr("test_instance.a")) == nil {
+		t.Error("test_instance.a was removed")
+	}
+}
+
+func TestStateRm_printBackupPath(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	backupPath := filepath.Join(filepath.Dir(statePath), "printed.backup")
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-backup", backupPath,
+		"-print-backup-path",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	lines := strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\n")
+	if got := lines[len(lines)-1]; got != backupPath {
+		t.Errorf("wrong last line %q; want %q", got, backupPath)
+	}
+	testStateOutput(t, backupPath, testStateRmOutputOriginal)
+
+	statePath = testStateFile(t, testStateRmState())
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-backup", backupPath,
+		"-print-backup-path",
+		"-json",
+		"-summary-only",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var out struct {
+		BackupPath string `json:"backup_path"`
+	}
+	if err := json.Unmarshal([]byte(ui.OutputWriter.String()), &out); err != nil {
+		t.Fatalf("output is not a single JSON object: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if out.BackupPath != backupPath {
+		t.Errorf("wrong backup_path %q; want %q", out.BackupPath, backupPath)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-print-backup-path", "-dry-run", "test_instance.bar"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+
+	// The -json array has no room for the path.
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-print-backup-path", "-json", "test_instance.bar"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid combination of options"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_verifyAfter(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-verify-after",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Verified that the removed items are no longer in the saved state.\n"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	// An item that is still in the saved state fails the verification.
+	barAddr := mustResourceInstanceAddr("test_instance.bar")
+	diags := c.verifyRemoved(&stateRmResult{
+		Items: []*stateRmItem{{Addr: barAddr}},
+	})
+	if !diags.HasErrors() {
+		t.Fatal("expected errors")
+	}
+	if got, want := diags.Err().Error(), "Removed items still in state"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_rollbackOnVerifyFailure(t *testing.T) {
+	state := testStateRmState()
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-rollback-on-verify-failure",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "requires -verify-after"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+
+	// A verification failure can't be caused through the local backend, so
+	// the rollback is tested after a removal that was saved.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-auto-approve", "test_instance.foo"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	diags := c.rollbackRemoval(statemgr.NewFilesystem(statePath), state, "-rollback-on-verify-failure")
+	if diags.HasErrors() {
+		t.Fatalf("unexpected errors: %s", diags.Err())
+	}
+	if len(diags) != 1 || diags[0].Description().Summary != "Removal rolled back" {
+		t.Errorf("wrong diagnostics: %#v", diags)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateRm_reinsertOnAbort(t *testing.T) {
+	state := testStateRmState()
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-verify-after",
+		"-rollback-on-verify-failure",
+		"-reinsert-on-abort",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "-reinsert-on-abort option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+
+	// As for -rollback-on-verify-failure, a verification failure can't be
+	// caused through the local backend, so the reinsertion is tested after
+	// a removal that was saved.
+	before := state.DeepCopy()
+	result, diags := runStateRm(state, &stateRmOpts{
+		Addrs: []addrs.AbsResourceInstance{mustResourceInstanceAddr("test_instance.foo")},
+	})
+	if diags.HasErrors() {
+		t.Fatalf("unexpected errors: %s", diags.Err())
+	}
+	stateMgr := statemgr.NewFilesystem(statePath)
+	if err := stateMgr.WriteState(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		t.Fatal(err)
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	c, _ = testStateRmCommand(testProvider())
+	diags = c.reinsertRemoved(stateMgr, state, before, result)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected errors: %s", diags.Err())
+	}
+	if len(diags) != 1 || diags[0].Description().Summary != "Removed objects reinserted" {
+		t.Errorf("wrong diagnostics: %#v", diags)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateRm_moduleOutputCleanup(t *testing.T) {
+	td := tempDir(t)
+	os.MkdirAll(td, 0755)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo"), obj, provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.bar"), obj, provider)
+		for _, name := range []string{"foo_id", "bar_id", "both", "undeclared"} {
+			s.SetOutputValue(addrs.OutputValue{Name: name}.Absolute(addrs.RootModuleInstance), cty.StringVal("bar"), false)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	config := `
+resource "test_instance" "foo" {}
+resource "test_instance" "bar" {}
+
+output "foo_id" {
+  value = test_instance.foo.id
+}
+
+output "bar_id" {
+  value = test_instance.bar.id
+}
+
+output "both" {
+  value = "${test_instance.bar.id}-${test_instance.foo.id}"
+}
+`
+	if err := ioutil.WriteFile("main.tf", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{"-state", statePath, "-auto-approve", "-module-output-cleanup"}
+	if code := c.Run(append(args, "-dry-run", "test_instance.foo")); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	for _, want := range []string{
+		"Would remove output output.both, which refers to removed test_instance.foo\n",
+		"Would remove output output.foo_id, which refers to removed test_instance.foo\n",
+	} {
+		if got := ui.OutputWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("wrong output\ngot:\n%s\nwant: %s", got, want)
+		}
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(append(args, "test_instance.foo")); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	outputs := testStateRead(t, statePath).RootModule().OutputValues
+	var got []string
+	for name := range outputs {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	if want := []string{"bar_id", "undeclared"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong remaining outputs %s; want %s", got, want)
+	}
+}
+
+func TestStateRm_csv(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := testStateRmState()
+	state.SyncWrapper().SetResourceInstanceCurrent(
+		mustResourceInstanceAddr(`module.child.test_instance.baz["a,b"]`),
+		&states.ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(`{"id":"baz"}`),
+			Status:    states.ObjectReady,
+		},
+		provider,
+	)
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{"-state", statePath, "-auto-approve", "-csv", "test_instance.foo", `module.child.test_instance.baz["a,b"]`}
+	if code := c.Run(append([]string{"-dry-run"}, args...)); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `address,type,name,module,provider,id,action
+test_instance.foo,test_instance,foo,,provider.test,bar,would-remove
+"module.child.test_instance.baz[""a,b""]",test_instance,baz,module.child,provider.test,baz,would-remove
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want = strings.Replace(want, "would-remove", "removed", -1)
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	remaining := stateAllResourceInstances(testStateRead(t, statePath))
+	if got, want := len(remaining), 1; got != want || remaining[0].String() != "test_instance.bar" {
+		t.Fatalf("wrong remaining instances %s", remaining)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-csv", "-json", "test_instance.bar"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid combination of options"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_failIfEmpty(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-fail-if-empty",
+		"-where", "id=nope",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != stateRmEmptyExitStatus {
+		t.Fatalf("wrong exit status %d; want %d\n\n%s", code, stateRmEmptyExitStatus, ui.ErrorWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Nothing selected for removal"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	// Addresses that aren't in the state select nothing too.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-fail-if-empty",
+		"-dry-run",
+		"test_instance.gone",
+		"test_instance.typo",
+	}
+	if code := c.Run(args); code != stateRmEmptyExitStatus {
+		t.Fatalf("wrong exit status %d; want %d\n\n%s", code, stateRmEmptyExitStatus, ui.ErrorWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "No matching resources found in state."; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+
+	// Something left to remove is removed as usual.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-fail-if-empty",
+		"-where", "id=bar",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_assertCount(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	// The same instance given twice is counted once.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-assert-count", "2",
+		"test_instance.foo",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "expects exactly 2"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-assert-count", "1",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_maxRemovalPct(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	// Both instances are 100% of the state.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-max-removal-pct", "50",
+		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "are 100.0% of the 2 resource"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-max-removal-pct", "50",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_exportBefore(t *testing.T) {
+	defer func(timeout time.Duration) { stateRmExportTimeout = timeout }(stateRmExportTimeout)
+	stateRmExportTimeout = 100 * time.Millisecond
+
+	var received []byte
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			http.Error(w, "archive unavailable", http.StatusServiceUnavailable)
+			return
+		case "/hang":
+			<-hang
+			return
+		}
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	// A failed or hanging export aborts the removal.
+	for path, want := range map[string]string{"/fail": "archive unavailable", "/hang": "Failed to export removed instances"} {
+		statePath := testStateFile(t, testStateRmState())
+		c, ui := testStateRmCommand(testProvider())
+		args := []string{"-state", statePath, "-auto-approve", "-export-before", server.URL + path, "test_instance.foo"}
+		if code := c.Run(args); code != 1 {
+			t.Fatalf("wrong exit status %d for %s; want 1\n\n%s", code, path, ui.OutputWriter.String())
+		}
+		if got := ui.ErrorWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("wrong error for %s\ngot:  %s\nwant: %s", path, got, want)
+		}
+		testStateOutput(t, statePath, testStateRmOutputOriginal)
+	}
+
+	statePath := testStateFile(t, testStateRmState())
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{"-state", statePath, "-auto-approve", "-export-before", server.URL, "test_instance.foo"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	var got stateRmExportJSON
+	if err := json.Unmarshal(received, &got); err != nil {
+		t.Fatalf("invalid export: %s\n%s", err, received)
+	}
+	if len(got.Instances) != 1 {
+		t.Fatalf("wrong number of instances exported\n%s", received)
+	}
+	inst := got.Instances[0]
+	if inst.Address != "test_instance.foo" || inst.Provider != "provider.test" || inst.Current == nil {
+		t.Fatalf("wrong instance exported\n%s", received)
+	}
+	var attrs map[string]string
+	if err := json.Unmarshal(inst.Current.Attributes, &attrs); err != nil || attrs["id"] != "bar" {
+		t.Fatalf("wrong attributes exported\n%s", received)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// A command receives the same document on stdin.
+	exportPath := filepath.Join(filepath.Dir(statePath), "export.json")
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-auto-approve", "-export-before", "cat > " + exportPath, "test_instance.bar"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	src, err := ioutil.ReadFile(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"address": "test_instance.bar"`; !strings.Contains(string(src), want) {
+		t.Errorf("wrong export\n%s", src)
+	}
+
+	// A command that doesn't finish in time is abandoned, and aborts the
+	// removal too.
+	statePath = testStateFile(t, testStateRmState())
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-auto-approve", "-export-before", "exec sleep 10", "test_instance.foo"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "didn't finish"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateRm_snapshotDiffURL(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "channel not found", http.StatusNotFound)
+			return
+		}
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	statePath := testStateFile(t, testStateRmState())
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{"-state", statePath, "-auto-approve", "-snapshot-diff-url", server.URL, "test_instance.foo"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	var got stateRmNotifyJSON
+	if err := json.Unmarshal(received, &got); err != nil {
+		t.Fatalf("invalid notification: %s\n%s", err, received)
+	}
+	if !reflect.DeepEqual(got.Removed, []string{"test_instance.foo"}) || got.CurrentCount != 1 || got.ByType["test_instance"] != 1 {
+		t.Errorf("wrong notification\n%s", received)
+	}
+	if got.Workspace != "default" || got.Lineage == "" || got.Serial == nil {
+		t.Errorf("wrong snapshot in notification\n%s", received)
+	}
+
+	// A failed notification is only a warning, since the state was saved.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-auto-approve", "-snapshot-diff-url", server.URL + "/fail", "test_instance.bar"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "channel not found"; !strings.Contains(got, want) {
+		t.Errorf("wrong warning\ngot:  %s\nwant: %s", got, want)
+	}
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stateAllResourceInstances(f.State)) != 0 {
+		t.Errorf("test_instance.bar was not removed\n%s", f.State.String())
+	}
+}
+
+func TestStateRm_summaryOnly(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	build := func() *states.State {
+		return states.BuildState(func(s *states.SyncState) {
+			for _, addr := range []string{
+				"test_instance.a",
+				"test_other.a",
+				"module.child.test_instance.b",
+			} {
+				s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+			}
+		})
+	}
+	addrArgs := []string{"test_instance.a", "test_other.a", "module.child.test_instance.b"}
+
+	statePath := testStateFile(t, build())
+	c, ui := testStateRmCommand(testProvider())
+	args := append([]string{"-state", statePath, "-summary-only", "-dry-run"}, addrArgs...)
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	if strings.Contains(got, "Would remove test_instance.a") {
+		t.Errorf("per-instance lines were printed\n%s", got)
+	}
+	for _, want := range []string{
+		"By type: test_instance: 2, test_other: 1\n",
+		"By module: root module: 2, module.child: 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("wrong output\ngot:\n%s\nwant: %s", got, want)
+		}
+	}
+
+	backupPath := filepath.Join(filepath.Dir(statePath), "summary.backup")
+	c, ui = testStateRmCommand(testProvider())
+	args = append([]string{"-state", statePath, "-auto-approve", "-backup", backupPath, "-summary-only"}, addrArgs...)
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "The original state was backed up to "+backupPath+".\n"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant: %s", got, want)
+	}
+	if got := len(stateAllResourceInstances(testStateRead(t, statePath))); got != 0 {
+		t.Errorf("%d resource instances remain", got)
+	}
+
+	statePath = testStateFile(t, build())
+	c, ui = testStateRmCommand(testProvider())
+	args = append([]string{"-state", statePath, "-auto-approve", "-backup", backupPath, "-summary-only", "-json"}, addrArgs...)
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var summary map[string]interface{}
+	if err := json.Unmarshal([]byte(ui.OutputWriter.String()), &summary); err != nil {
+		t.Fatalf("output is not a single JSON object: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if _, ok := summary["removed"]; ok {
+		t.Errorf("summary includes the removed instances")
+	}
+	if got, want := summary["resource_count"], float64(3); got != want {
+		t.Errorf("wrong resource_count %v; want %v", got, want)
+	}
+	if got, want := summary["backup_path"], backupPath; got != want {
+		t.Errorf("wrong backup_path %v; want %v", got, want)
+	}
+	if got, want := summary["by_module"], map[string]interface{}{"": float64(2), "module.child": float64(1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong by_module %v; want %v", got, want)
+	}
+}
+
+func TestStateRm_keepLatest(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for addr, attrs := range map[string]string{
+			"test_instance.snap[0]": `{"id":"a","created":"2020-01-01T00:00:00Z"}`,
+			"test_instance.snap[1]": `{"id":"b","created":"2020-03-01T00:00:00Z"}`,
+			"test_instance.snap[2]": `{"id":"c","created":"2020-02-01T00:00:00Z"}`,
+			"test_instance.snap[3]": `{"id":"d","created":"2019-12-01T00:00:00Z"}`,
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(attrs),
+					Status:    states.ObjectReady,
+				},
+				provider,
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{"-state", statePath, "-dry-run", "-resource-type", "test_instance", "-keep-latest", "2", "-keep-latest-by", "created"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	for _, want := range []string{
+		"Would keep test_instance.snap[1], one of the 2 newest by created = 2020-03-01T00:00:00Z\n",
+		"Would keep test_instance.snap[2], one of the 2 newest by created = 2020-02-01T00:00:00Z\n",
+		"Would've removed 2 current and 0 deposed objects",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output\n%s", want, got)
+		}
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-auto-approve", "-resource-type", "test_instance", "-keep-latest", "2", "-keep-latest-by", "created"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateRmInstanceKeys(t, statePath, "snap", []addrs.InstanceKey{addrs.IntKey(1), addrs.IntKey(2)})
+
+	// An instance without the timestamp can't be ordered, so nothing is
+	// removed.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-auto-approve", "-resource-type", "test_instance", "-keep-latest", "1", "-keep-latest-by", "id"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid timestamp"; !strings.Contains(got, want) {
+		t.Errorf("missing %q in error\n%s", want, got)
+	}
+	testStateRmInstanceKeys(t, statePath, "snap", []addrs.InstanceKey{addrs.IntKey(1), addrs.IntKey(2)})
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-auto-approve", "-keep-latest", "1", "-keep-latest-by", "created", "test_instance.snap"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid -keep-latest option"; !strings.Contains(got, want) {
+		t.Errorf("missing %q in error\n%s", want, got)
+	}
+}
+
+func TestStateRm_where(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for addr, attrs := range map[string]string{
+			"test_instance.dev":      `{"id":"a","tags":{"env":"dev"}}`,
+			"test_instance.keep":     `{"id":"b","tags":{"env":"dev","keep":"true"}}`,
+			"test_instance.prod":     `{"id":"c","tags":{"env":"prod"}}`,
+			"test_instance.untagged": `{"id":"d"}`,
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(attrs),
+					Status:    states.ObjectReady,
+				},
+				provider,
+			)
+		}
+	})
+
+	cases := map[string]struct {
+		args []string
+		want []string
+	}{
+		"where": {
+			[]string{"-where", "tags.env=dev"},
+			[]string{"test_instance.prod", "test_instance.untagged"},
+		},
+		"where-not": {
+			[]string{"-where-not", "tags.keep=true"},
+			[]string{"test_instance.keep"},
+		},
+		"both": {
+			[]string{"-where", "tags.env=dev", "-where-not", "tags.keep=true"},
+			[]string{"test_instance.keep", "test_instance.prod", "test_instance.untagged"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			statePath := testStateFile(t, state)
+			c, ui := testStateRmCommand(testProvider())
+			args := append([]string{"-state", statePath, "-auto-approve", "-resource-type", "test_instance"}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+
+			var got []string
+			for _, addr := range stateAllResourceInstances(testStateRead(t, statePath)) {
+				got = append(got, addr.String())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wrong remaining instances\ngot:  %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		c, ui := testStateRmCommand(testProvider())
+		args := []string{"-state", statePath, "-resource-type", "test_instance", "-where-not", "tags.env=prod", "-dry-run"}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		got := ui.OutputWriter.String()
+		if strings.Contains(got, "Would remove test_instance.prod") {
+			t.Errorf("excluded instance listed\n%s", got)
+		}
+		if want := "Skipped 1 selected resource instances because of -where or -where-not.\n"; !strings.Contains(got, want) {
+			t.Errorf("wrong output\ngot:\n%s\nwant: %s", got, want)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-where", "tags.env", "test_instance.dev"}); code != 1 {
+			t.Fatalf("wrong exit status %d; want 1", code)
+		}
+		if got, want := ui.ErrorWriter.String(), "Invalid -where option"; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+}
+
+func TestStateRm_keyWildcards(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			`module.workers["a"].test_instance.node`,
+			`module.workers["b"].test_instance.node`,
+			`module.workers["b"].test_instance.other`,
+			`test_instance.node[0]`,
+			`test_instance.node[1]`,
+			`test_instance.other`,
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+
+	cases := map[string]struct {
+		args []string
+		want []string
+	}{
+		"module key": {
+			[]string{`module.workers["*"].test_instance.node`},
+			[]string{`test_instance.node[0]`, `test_instance.node[1]`, `test_instance.other`, `module.workers["b"].test_instance.other`},
+		},
+		"index": {
+			[]string{`test_instance.node[*]`},
+			[]string{`test_instance.other`, `module.workers["a"].test_instance.node`, `module.workers["b"].test_instance.node`, `module.workers["b"].test_instance.other`},
+		},
+		"overlapping": {
+			[]string{`test_instance.node[*]`, `test_instance.node[0]`, `module.workers["*"]`, `module.workers["a"].test_instance.node`},
+			[]string{`test_instance.other`},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			statePath := testStateFile(t, state)
+			c, ui := testStateRmCommand(testProvider())
+			if code := c.Run(append([]string{"-state", statePath, "-auto-approve"}, tc.args...)); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			var got []string
+			for _, addr := range stateAllResourceInstances(testStateRead(t, statePath)) {
+				got = append(got, addr.String())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wrong remaining instances\ngot:  %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("type or name", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		for _, pattern := range []string{`test_instance.*`, `test_*.node[0]`, `module.*.test_instance.node`} {
+			c, ui := testStateRmCommand(testProvider())
+			if code := c.Run([]string{"-state", statePath, "-auto-approve", pattern}); code != 1 {
+				t.Fatalf("wrong exit status %d for %s; want 1\n\n%s", code, pattern, ui.ErrorWriter.String())
+			}
+		}
+		if got := len(stateAllResourceInstances(testStateRead(t, statePath))); got != 6 {
+			t.Errorf("%d instances left; want 6", got)
+		}
+
+		// A "*" beside a wildcard token is still rejected as a pattern.
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", `test_*.node[*]`}); code != 1 {
+			t.Fatalf("wrong exit status %d; want 1", code)
+		}
+		if got, want := ui.ErrorWriter.String(), "Invalid address pattern"; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+
+	t.Run("literal key", func(t *testing.T) {
+		state := states.BuildState(func(s *states.SyncState) {
+			for _, addr := range []string{
+				`module.workers["a"].test_instance.node["a*b"]`,
+				`module.workers["a"].test_instance.node["axb"]`,
+				`test_instance.node["a*b"]`,
+				`test_instance.node["axb"]`,
+				`test_instance.node["ab"]`,
+			} {
+				s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+			}
+		})
+		cases := map[string]struct {
+			arg  string
+			want []string
+		}{
+			"alone": {
+				`test_instance.node["a*b"]`,
+				[]string{`test_instance.node["ab"]`, `test_instance.node["axb"]`, `module.workers["a"].test_instance.node["a*b"]`, `module.workers["a"].test_instance.node["axb"]`},
+			},
+			"with a wildcard": {
+				`module.workers["*"].test_instance.node["a*b"]`,
+				[]string{`test_instance.node["a*b"]`, `test_instance.node["ab"]`, `test_instance.node["axb"]`, `module.workers["a"].test_instance.node["axb"]`},
+			},
+		}
+		for name, tc := range cases {
+			t.Run(name, func(t *testing.T) {
+				statePath := testStateFile(t, state)
+				c, ui := testStateRmCommand(testProvider())
+				if code := c.Run([]string{"-state", statePath, "-auto-approve", tc.arg}); code != 0 {
+					t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+				}
+				var got []string
+				for _, addr := range stateAllResourceInstances(testStateRead(t, statePath)) {
+					got = append(got, addr.String())
+				}
+				if !reflect.DeepEqual(got, tc.want) {
+					t.Errorf("wrong remaining instances\ngot:  %s\nwant: %s", got, tc.want)
+				}
+			})
+		}
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-fail-if-empty", `test_instance.other[*]`}); code != stateRmEmptyExitStatus {
+			t.Fatalf("wrong exit status %d; want %d\n\n%s", code, stateRmEmptyExitStatus, ui.ErrorWriter.String())
+		}
+		if got, want := ui.ErrorWriter.String(), "Address pattern matched nothing"; !strings.Contains(got, want) {
+			t.Errorf("wrong warning\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+}
+
+func TestStateRm_exclude(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			`module.network.test_instance.keep`,
+			`module.network.test_instance.subnet["a"]`,
+			`module.network.test_instance.subnet["b"]`,
+			`test_instance.node[0]`,
+			`test_instance.node[1]`,
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+
+	cases := map[string]struct {
+		args []string
+		want []string
+	}{
+		"instance in module": {
+			[]string{"-exclude=module.network.test_instance.keep", "module.network"},
+			[]string{`test_instance.node[0]`, `test_instance.node[1]`, `module.network.test_instance.keep`},
+		},
+		"resource": {
+			[]string{"-resource-type=test_instance", "-exclude=module.network.test_instance.subnet"},
+			[]string{`module.network.test_instance.subnet["a"]`, `module.network.test_instance.subnet["b"]`},
+		},
+		"module": {
+			[]string{"-resource-type=test_instance", "-exclude=module.network"},
+			[]string{`module.network.test_instance.keep`, `module.network.test_instance.subnet["a"]`, `module.network.test_instance.subnet["b"]`},
+		},
+		"pattern": {
+			[]string{"-resource-type=test_instance", "-exclude=test_instance.node[*]", `-exclude=module.network.test_instance.subnet["*"]`},
+			[]string{`test_instance.node[0]`, `test_instance.node[1]`, `module.network.test_instance.subnet["a"]`, `module.network.test_instance.subnet["b"]`},
+		},
+		"resource in any module": {
+			[]string{"-resource-type=test_instance", "-exclude=test_instance.keep"},
+			[]string{`module.network.test_instance.keep`},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			statePath := testStateFile(t, state)
+			c, ui := testStateRmCommand(testProvider())
+			if code := c.Run(append([]string{"-state", statePath, "-auto-approve"}, tc.args...)); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			var got []string
+			for _, addr := range stateAllResourceInstances(testStateRead(t, statePath)) {
+				got = append(got, addr.String())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wrong remaining instances\ngot:  %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		c, ui := testStateRmCommand(testProvider())
+		args := []string{
+			"-state", statePath,
+			"-dry-run",
+			"-exclude=module.network.test_instance.keep",
+			"-exclude=test_instance.node[0]",
+			"module.network",
+		}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		got := ui.OutputWriter.String()
+		if strings.Contains(got, "Would remove module.network.test_instance.keep") {
+			t.Errorf("excluded instance listed\n%s", got)
+		}
+		if want := "Skipped 1 selected resource instances because of -exclude.\n"; !strings.Contains(got, want) {
+			t.Errorf("exclusions not counted\ngot:\n%s\nwant:\n%s", got, want)
+		}
+		if got, want := ui.ErrorWriter.String(), "Exclusion matched nothing"; !strings.Contains(got, want) {
+			t.Errorf("no warning for unused exclusion\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run([]string{"-state", statePath, "-auto-approve", "-exclude=not an address", "module.network"}); code != 1 {
+			t.Fatalf("wrong exit status %d; want 1", code)
+		}
+		if got, want := ui.ErrorWriter.String(), "Invalid -exclude option"; !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	})
+}
+
+// testStateRmUpgradingProvider is a mock provider whose schema for
+// test_instance is at version 1, where the env attribute of version 0 was
+// renamed to environment.
+type testStateRmUpgradingProvider struct {
+	*terraform.MockProvider
+}
+
+func testStateRmUpgrading() *testStateRmUpgradingProvider {
+	p := testProvider()
+	p.UpgradeResourceStateFn = func(req providers.UpgradeResourceStateRequest) providers.UpgradeResourceStateResponse {
+		var old map[string]string
+		if err := json.Unmarshal(req.RawStateJSON, &old); err != nil {
+			var resp providers.UpgradeResourceStateResponse
+			resp.Diagnostics = resp.Diagnostics.Append(err)
+			return resp
+		}
+		return providers.UpgradeResourceStateResponse{
+			UpgradedState: cty.ObjectVal(map[string]cty.Value{
+				"id":          cty.StringVal(old["id"]),
+				"environment": cty.StringVal(old["env"]),
+			}),
+		}
+	}
+	return &testStateRmUpgradingProvider{p}
+}
+
+func (p *testStateRmUpgradingProvider) GetSchema() providers.GetSchemaResponse {
+	p.MockProvider.GetSchema()
+	return providers.GetSchemaResponse{
+		ResourceTypes: map[string]providers.Schema{
+			"test_instance": {
+				Version: 1,
+				Block: &configschema.Block{
+					Attributes: map[string]*configschema.Attribute{
+						"id":          {Type: cty.String, Computed: true},
+						"environment": {Type: cty.String, Optional: true},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestStateRm_schemaUpgradeFirst(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for addr, attrs := range map[string]string{
+			"test_instance.dev":  `{"id":"a","env":"dev"}`,
+			"test_instance.prod": `{"id":"b","env":"prod"}`,
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(attrs),
+					Status:    states.ObjectReady,
+				},
+				provider,
+			)
+		}
+	})
+
+	t.Run("upgraded", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		c, ui := testStateRmCommand(testStateRmUpgrading())
+		args := []string{"-state", statePath, "-auto-approve", "-resource-type", "test_instance", "-where", "environment=dev", "-schema-upgrade-first"}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		got := ui.ErrorWriter.String()
+		for _, want := range []string{"Resource instances upgraded in memory", "  test_instance.dev\n", "  test_instance.prod\n"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("missing %q in warnings\n%s", want, got)
+			}
+		}
+
+		// The instance that is left keeps its object as it was stored.
+		is := testStateRead(t, statePath).ResourceInstance(mustResourceInstanceAddr("test_instance.prod"))
+		if is == nil || is.Current == nil {
+			t.Fatalf("test_instance.prod was removed")
+		}
+		var attrs map[string]string
+		if err := json.Unmarshal(is.Current.AttrsJSON, &attrs); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{"id": "b", "env": "prod"}; !reflect.DeepEqual(attrs, want) {
+			t.Errorf("wrong stored attributes\ngot:  %#v\nwant: %#v", attrs, want)
+		}
+		if got := is.Current.SchemaVersion; got != 0 {
+			t.Errorf("wrong stored schema version %d; want 0", got)
+		}
+		if testStateRead(t, statePath).ResourceInstance(mustResourceInstanceAddr("test_instance.dev")) != nil {
+			t.Errorf("test_instance.dev was not removed")
+		}
+	})
+
+	t.Run("not upgraded", func(t *testing.T) {
+		statePath := testStateFile(t, state)
+		c, ui := testStateRmCommand(testStateRmUpgrading())
+		args := []string{"-state", statePath, "-auto-approve", "-resource-type", "test_instance", "-where", "environment=dev"}
+		c.Run(args)
+		if got := ui.ErrorWriter.String(); strings.Contains(got, "upgraded in memory") {
+			t.Errorf("unexpected upgrade\n%s", got)
+		}
+		if testStateRead(t, statePath).ResourceInstance(mustResourceInstanceAddr("test_instance.dev")) == nil {
+			t.Errorf("test_instance.dev was removed without the upgrade")
+		}
+	})
+}
+
+func TestStateRm_decodeErrors(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for addr, attrs := range map[string]string{
+			"test_instance.corrupt": `["not", "an", "object"]`,
+			"test_instance.prod":    `{"id":"c","tags":{"env":"prod"}}`,
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(attrs),
+					Status:    states.ObjectReady,
+				},
+				provider,
+			)
+		}
+	})
+
+	// By default, the instance that can't be decoded is still removed.
+	statePath := testStateFile(t, state)
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{"-state", statePath, "-auto-approve", "-resource-type", "test_instance", "-where-not", "tags.env=prod"}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "The attributes of test_instance.corrupt in the state could not be decoded"; !strings.Contains(got, want) {
+		t.Errorf("missing warning %q\n%s", want, got)
+	}
+	var got []string
+	for _, addr := range stateAllResourceInstances(testStateRead(t, statePath)) {
+		got = append(got, addr.String())
+	}
+	if want := []string{"test_instance.prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong remaining instances\ngot:  %s\nwant: %s", got, want)
+	}
+
+	statePath = testStateFile(t, state)
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-auto-approve", "-decode-errors", "fail", "-resource-type", "test_instance", "-where-not", "tags.env=prod"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got := len(stateAllResourceInstances(testStateRead(t, statePath))); got != 2 {
+		t.Errorf("%d instances left in the state; want 2", got)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-auto-approve", "-decode-errors", "ignore", "test_instance.prod"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid -decode-errors option"; !strings.Contains(got, want) {
+		t.Errorf("missing %q in error\n%s", want, got)
+	}
+}
+func TestStateRm_scopeModule(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	addrStrs := []string{
+		"test_instance.a",
+		"module.child.test_instance.a",
+		"module.child.module.grandchild.test_instance.a",
+		"module.other.test_instance.a",
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range addrStrs {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-scope-module", "module.child",
+		"test_instance.a",
+		"module.other",
+		"module.child.module.grandchild",
+		"module.child.test_instance.a",
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	if code := c.Run(append([]string{"-dry-run"}, args...)); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Skipped 2 selected resource instances outside of module.child."; !strings.Contains(got, want) {
+		t.Errorf("skipped instances not reported\ngot:  %s\nwant: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, addr := range addrStrs {
+		removed := f.State.ResourceInstance(mustResourceInstanceAddr(addr)) == nil
+		if want := i == 1 || i == 2; removed != want {
+			t.Errorf("wrong result for %s: removed is %t, but want %t", addr, removed, want)
+		}
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-scope-module", "test_instance.a", "module.other"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid -scope-module option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_continueOnError(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	// Without the option, nothing is removed if any instance can't be.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"test_instance.foo",
+		"test_instance.missing",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-continue-on-error",
+		"-dry-run",
+		"test_instance.foo",
+		"test_instance.missing",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Would remove test_instance.foo"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-continue-on-error",
+		"test_instance.foo",
+		"test_instance.missing",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "test_instance.missing: No such resource instance in state"; !strings.Contains(got, want) {
+		t.Errorf("skipped instance not reported\ngot:  %s\nwant: %s", got, want)
+	}
+	if got, want := ui.OutputWriter.String(), "Updated state written successfully."; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_emitRemovedBlock(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	blocksPath := filepath.Join(filepath.Dir(statePath), "removed.tf")
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-emit-removed-block", blocksPath,
+		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Wrote 2 removed blocks to"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	src, err := ioutil.ReadFile(blocksPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "removed {\n  from = test_instance.foo\n}\n\nremoved {\n  from = test_instance.bar\n}\n"
+	if got := string(src); got != want {
+		t.Errorf("wrong removed blocks\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The generated file selects the same instances when read back.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-by-resource-file", blocksPath,
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.State.Empty() {
+		t.Errorf("state not empty after removal:\n%s", f.State)
+	}
+}
+
+func TestStateRm_backupRetention(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []string{"100", "200", "300"} {
+		if err := writeStateFile(statePath+"."+ts+DefaultBackupExtension, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A backup of a state with another lineage, and a file that isn't a
+	// state at all, must both be left alone.
+	foreign := statefile.New(f.State, "foreign", 1)
+	if err := writeStateFile(statePath+".400"+DefaultBackupExtension, foreign); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(statePath+".50"+DefaultBackupExtension, []byte("not a state"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-backup-retention", "2",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "Deleted 2 old backups, keeping the most recent 2."; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	for ts, want := range map[string]bool{"50": true, "100": false, "200": false, "300": true, "400": true} {
+		_, err := os.Stat(statePath + "." + ts + DefaultBackupExtension)
+		if got := err == nil; got != want {
+			t.Errorf("backup %s exists = %t; want %t", ts, got, want)
+		}
+	}
+	backups, err := timestampedStateBackups(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 4 {
+		t.Errorf("wrong backups remaining %#v", backups)
+	}
+}
+
+func TestStateRm_validateProviders(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.a"), obj, addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance))
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("gone_instance.b"), obj, addrs.ProviderConfig{Type: "gone"}.Absolute(addrs.RootModuleInstance))
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-validate-providers",
+		"-dry-run",
+		"test_instance.a",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got := ui.ErrorWriter.String(); got != "" {
+		t.Errorf("unexpected warning for installed provider:\n%s", got)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-validate-providers",
+		"test_instance.a",
+		"gone_instance.b",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Providers not installed"; !strings.Contains(got, want) {
+		t.Errorf("missing warning\ngot:  %s\nwant: %s", got, want)
+	}
+	if got, want := ui.ErrorWriter.String(), "provider.gone: 1"; !strings.Contains(got, want) {
+		t.Errorf("missing provider not listed\ngot:  %s\nwant: %s", got, want)
+	}
+	if got := ui.ErrorWriter.String(); strings.Contains(got, "provider.test:") {
+		t.Errorf("installed provider listed as missing:\n%s", got)
+	}
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.State.Empty() {
+		t.Errorf("instances not removed:\n%s", f.State)
+	}
+}
+
+func TestStateRm_template(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	// A template that refers to a field that doesn't exist is rejected
+	// before anything is removed.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-template", "{{.Address}} {{.Nope}}",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid output template"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-template", "{{.Address}},{{.Type}},{{.ID}},{{.Provider}}",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "test_instance.foo,test_instance,bar,provider.test\n"; got != want {
+		t.Errorf("wrong output\ngot:  %q\nwant: %q", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_checkpoint(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	checkpointPath := filepath.Join(filepath.Dir(statePath), "rm.checkpoint")
+
+	// If some instances are skipped, the checkpoint records those that
+	// were removed and is kept for the next run.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-checkpoint", checkpointPath,
+		"-continue-on-error",
+		"test_instance.foo",
+		"test_instance.missing",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	done, err := readStateRmCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"test_instance.foo": true}; !reflect.DeepEqual(done, want) {
+		t.Fatalf("wrong checkpoint %#v; want %#v", done, want)
+	}
+
+	// A re-run skips the instance that is already gone.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-checkpoint", checkpointPath,
+		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "1 of the selected resource instances were already removed"; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.State.Empty() {
+		t.Errorf("state not empty:\n%s", f.State)
+	}
+	if _, err := os.Stat(checkpointPath); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not removed after completion: %v", err)
+	}
+}
+
+func TestStateRm_checkpointWarnings(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	// A checkpoint that can't be written doesn't stop the removal.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-checkpoint", filepath.Join(filepath.Dir(statePath), "missing", "rm.checkpoint"),
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	for _, want := range []string{
+		"Failed to write checkpoint",
+		"Could not record the removed resource instances in the checkpoint file",
+	} {
+		if got := ui.ErrorWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("wrong warning\ngot:  %s\nwant: %s", got, want)
+		}
+	}
+
+	// Nor does one that can't be removed once the removal has completed.
+	removeCheckpoint := stateRmRemoveCheckpoint
+	defer func() { stateRmRemoveCheckpoint = removeCheckpoint }()
+	stateRmRemoveCheckpoint = func(string) error { return os.ErrPermission }
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-checkpoint", filepath.Join(filepath.Dir(statePath), "rm.checkpoint"),
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	for _, want := range []string{
+		"Failed to remove checkpoint",
+		"The removal completed, but the checkpoint file",
+	} {
+		if got := ui.ErrorWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("wrong warning\ngot:  %s\nwant: %s", got, want)
+		}
+	}
+}
+
+func TestStateRm_assertLineage(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-assert-lineage", "not-this-one",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	for _, want := range []string{"Unexpected state lineage", "not-this-one", f.Lineage} {
+		if got := ui.ErrorWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("error doesn't mention %q\ngot: %s", want, got)
+		}
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-assert-lineage", f.Lineage,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+}
+
+func TestStateRm_lineageReport(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-assert-lineage", f.Lineage,
+		"-lineage-report",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	for _, want := range []string{
+		"Lineage:            " + f.Lineage + "\n",
+		fmt.Sprintf("Serial:             %d\n", f.Serial),
+		// testStateFile doesn't record the version of Terraform.
+		"Terraform version:  unknown\n",
+		"Resource instances: 2\n",
+		"Objects:            2 (2 current, 0 deposed)\n",
+		"The lineage matches -assert-lineage.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in output\n%s", want, got)
+		}
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	// The report can't be combined with a removal.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-lineage-report",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateRm_merge(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		for addr, id := range map[string]string{
+			"test_instance.old":   "i-1",
+			"test_instance.new":   "i-1",
+			"test_instance.other": "i-2",
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(fmt.Sprintf(`{"id":%q}`, id)),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-merge", "test_instance.old=test_instance.new",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), `Merging test_instance.old into test_instance.new, which has the same id "i-1"`; !strings.Contains(got, want) {
+		t.Errorf("merge not described\ngot:  %s\nwant: %s", got, want)
+	}
+
+	for name, args := range map[string][]string{
+		"different ids":      {"-merge", "test_instance.old=test_instance.other"},
+		"missing source":     {"-merge", "test_instance.gone=test_instance.new"},
+		"destination chosen": {"-merge", "test_instance.old=test_instance.new", "test_instance.new"},
+	} {
+		c, ui := testStateRmCommand(testProvider())
+		if code := c.Run(append([]string{"-state", statePath, "-auto-approve"}, args...)); code != 1 {
+			t.Fatalf("%s: wrong exit status %d; want 1\n\n%s", name, code, ui.OutputWriter.String())
+		}
+		if got := ui.ErrorWriter.String(); !strings.Contains(got, "Can't merge resource instances") {
+			t.Errorf("%s: wrong error\n%s", name, got)
+		}
+	}
+	if got := len(stateAllResourceInstances(testStateRead(t, statePath))); got != 3 {
+		t.Fatalf("state has %d resource instances; want 3", got)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-merge", "test_instance.old=test_instance.new",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	remaining := testStateRead(t, statePath)
+	if remaining.ResourceInstance(mustResourceInstanceAddr("test_instance.old")) != nil {
+		t.Errorf("test_instance.old was not removed")
+	}
+	for _, addr := range []string{"test_instance.new", "test_instance.other"} {
+		if remaining.ResourceInstance(mustResourceInstanceAddr(addr)) == nil {
+			t.Errorf("%s was removed", addr)
+		}
+	}
+}
+
+func TestStateRm_output(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	dir := filepath.Dir(statePath)
+
+	c, ui := testStateRmCommand(testProvider())
+	textPath := filepath.Join(dir, "removal.txt")
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-output", textPath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got := ui.OutputWriter.String(); got != "" {
+		t.Errorf("unexpected output:\n%s", got)
+	}
+	src, err := ioutil.ReadFile(textPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(src), "Would remove test_instance.foo\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("wrong file content\ngot:  %s\nwant: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	jsonPath := filepath.Join(dir, "removal.json")
+	args = []string{
+		"-state", statePath,
+		"-dry-run",
+		"-output", jsonPath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	src, err = ioutil.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []stateRmJSONEntry
+	if err := json.Unmarshal(src, &got); err != nil {
+		t.Fatalf("file is not valid JSON: %s\n%s", err, src)
+	}
+	if len(got) != 1 || got[0].Address != "test_instance.foo" {
+		t.Errorf("wrong JSON %s", src)
+	}
+
+	// A file that can't be created is an error before anything else.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-dry-run",
+		"-output", filepath.Join(dir, "missing", "removal.txt"),
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Failed to create output file"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+}
+
+func TestStateRm_removedBetween(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	td := tempDir(t)
+	os.MkdirAll(td, 0755)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	git := func(args ...string) {
+		t.Helper()
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	commit := func(config string) {
+		t.Helper()
+		if err := ioutil.WriteFile("main.tf", []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "main.tf")
+		git("commit", "-q", "-m", "update")
+	}
+	git("init", "-q")
+	commit(`
+resource "test_instance" "foo" {}
+resource "test_instance" "bar" {}
+module "child" {
+  source = "./child"
+}
+`)
+	commit(`
+resource "test_instance" "foo" {}
+`)
+
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{
+			"test_instance.foo",
+			"test_instance.bar",
+			"module.child.test_instance.baz",
+		} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-removed-between", "HEAD~1..HEAD",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	for _, want := range []string{
+		"Removed from the configuration between HEAD~1 and HEAD: test_instance.bar\n",
+		"Removed from the configuration between HEAD~1 and HEAD: module.child\n",
+		"Would remove test_instance.bar\n",
+		"Would remove module.child.test_instance.baz\n",
+	} {
+		if got := ui.OutputWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("output doesn't contain %q\ngot: %s", want, got)
+		}
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-removed-between", "HEAD~1..HEAD",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := (&states.Filter{State: f.State}).Filter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var remaining []string
+	for _, result := range results {
+		if _, ok := result.Value.(*states.ResourceInstance); ok {
+			remaining = append(remaining, result.Address)
+		}
+	}
+	if want := []string{"test_instance.foo"}; !reflect.DeepEqual(remaining, want) {
+		t.Errorf("wrong remaining instances %#v; want %#v", remaining, want)
+	}
+}
+
+func TestStateRm_keepIfReferenced(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	objDependingOn := func(deps ...addrs.Referenceable) *states.ResourceInstanceObjectSrc {
+		return &states.ResourceInstanceObjectSrc{
+			AttrsJSON:    []byte(`{"id":"bar"}`),
+			Status:       states.ObjectReady,
+			Dependencies: deps,
+		}
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.foo"), objDependingOn(), provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.qux"), objDependingOn(), provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.a"), objDependingOn(), provider)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.bar"),
+			objDependingOn(addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "test_instance",
+				Name: "foo",
+			}),
+			provider,
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.baz"),
+			objDependingOn(addrs.ModuleCallInstance{Call: addrs.ModuleCall{Name: "child"}}),
+			provider,
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-keep-if-referenced",
+		"test_instance.foo",
+		"test_instance.qux",
+		"module.child",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.ErrorWriter.String()
+	if want := "Referenced resource instances kept"; !strings.Contains(got, want) {
+		t.Errorf("wrong warning\ngot:  %s\nwant: %s", got, want)
+	}
+	for _, want := range []string{
+		"  test_instance.foo, depended on by test_instance.bar\n",
+		"  module.child.test_instance.a, depended on by test_instance.baz\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("kept instance not listed\ngot:  %s\nwant: %s", got, want)
+		}
+	}
+
+	remaining := testStateRead(t, statePath)
+	if remaining.ResourceInstance(mustResourceInstanceAddr("test_instance.qux")) != nil {
+		t.Error("test_instance.qux was not removed")
+	}
+	for _, addr := range []string{"test_instance.foo", "module.child.test_instance.a"} {
+		if remaining.ResourceInstance(mustResourceInstanceAddr(addr)) == nil {
+			t.Errorf("%s was removed", addr)
+		}
+	}
+
+	// Once the dependent instance is selected too, both are removed.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-keep-if-referenced",
+		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got := ui.ErrorWriter.String(); got != "" {
+		t.Errorf("unexpected warning\n%s", got)
+	}
+	remaining = testStateRead(t, statePath)
+	for _, addr := range []string{"test_instance.foo", "test_instance.bar"} {
+		if remaining.ResourceInstance(mustResourceInstanceAddr(addr)) != nil {
+			t.Errorf("%s was not removed", addr)
+		}
+	}
+}
+
+func TestStateRm_emitMetrics(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	metricsPath := filepath.Join(filepath.Dir(statePath), "metrics.json")
+	backupPath := filepath.Join(filepath.Dir(statePath), "backup")
+
+	readMetrics := func() map[string]interface{} {
+		t.Helper()
+		src, err := ioutil.ReadFile(metricsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(src, &got); err != nil {
+			t.Fatalf("metrics are not valid JSON: %s\n%s", err, src)
+		}
+		return got
+	}
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-backup", backupPath,
+		"-emit-metrics", metricsPath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readMetrics()
+	if got["success"] != true || got["exit_status"] != 0.0 || got["dry_run"] != false {
+		t.Errorf("wrong outcome in metrics %#v", got)
+	}
+	if got["removed"] != 1.0 || !reflect.DeepEqual(got["by_type"], map[string]interface{}{"test_instance": 1.0}) {
+		t.Errorf("wrong counts in metrics %#v", got)
+	}
+	if got["backup_bytes"] != float64(info.Size()) {
+		t.Errorf("wrong backup size %v; want %d", got["backup_bytes"], info.Size())
+	}
+	if _, ok := got["duration_seconds"].(float64); !ok {
+		t.Errorf("no duration in metrics %#v", got)
+	}
+
+	// Failures are recorded too.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-emit-metrics", metricsPath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != stateRmEmptyExitStatus {
+		t.Fatalf("wrong exit status %d; want %d\n\n%s", code, stateRmEmptyExitStatus, ui.OutputWriter.String())
+	}
+	got = readMetrics()
+	if got["success"] != false || got["exit_status"] != float64(stateRmEmptyExitStatus) || got["backup_bytes"] != nil {
+		t.Errorf("wrong metrics for failure %#v", got)
+	}
+}
+
+func TestStateRm_label(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	metricsPath := filepath.Join(filepath.Dir(statePath), "metrics.json")
+	manifestPath := filepath.Join(filepath.Dir(statePath), "manifest")
+	label := "decommission project X"
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-backup", "-",
+		"-json",
+		"-summary-only",
+		"-label", label,
+		"-emit-metrics", metricsPath,
+		"-object-hash-manifest", manifestPath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &out); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if out["label"] != label {
+		t.Errorf("wrong label in output %#v", out["label"])
+	}
+
+	src, err := ioutil.ReadFile(metricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics map[string]interface{}
+	if err := json.Unmarshal(src, &metrics); err != nil {
+		t.Fatalf("metrics are not valid JSON: %s\n%s", err, src)
+	}
+	if metrics["label"] != label {
+		t.Errorf("wrong label in metrics %#v", metrics["label"])
+	}
+
+	src, err = ioutil.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\n# Label: " + label + "\n"; !strings.Contains(string(src), want) {
+		t.Errorf("label not in manifest\ngot:  %s\nwant: %s", src, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-label", "two\nlines", "test_instance.bar"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid -label option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_chunkPersist(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{"a", "b", "c", "d", "e", "keep"} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance."+addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+	backupPath := filepath.Join(filepath.Dir(statePath), "backup")
+	checkpointPath := filepath.Join(filepath.Dir(statePath), "checkpoint")
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-backup", backupPath,
+		"-checkpoint", checkpointPath,
+		"-chunk-persist", "2",
+		"test_instance.a",
+		"test_instance.b",
+		"test_instance.c",
+		"test_instance.d",
+		"test_instance.e",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	for _, want := range []string{
+		"Persisted chunk 1 of 3: removed 2 of 5 resource instances.\n",
+		"Persisted chunk 2 of 3: removed 4 of 5 resource instances.\n",
+		"Persisted chunk 3 of 3: removed 5 of 5 resource instances.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("progress not reported\ngot:  %s\nwant: %s", got, want)
+		}
+	}
+
+	remaining := stateAllResourceInstances(testStateRead(t, statePath))
+	if len(remaining) != 1 || remaining[0].String() != "test_instance.keep" {
+		t.Errorf("wrong remaining instances %s", remaining)
+	}
+
+	// The backup is of the original state, not of an intermediate chunk.
+	backup := testStateRead(t, backupPath)
+	if got, want := len(stateAllResourceInstances(backup)), 6; got != want {
+		t.Errorf("backup has %d instances; want %d", got, want)
+	}
+	if _, err := os.Stat(checkpointPath); !os.IsNotExist(err) {
+		t.Errorf("checkpoint was not deleted after success")
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-chunk-persist", "-1", "test_instance.keep"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid -chunk-persist option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_respectDependsOnOrder(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	dependsOn := func(name string) []addrs.Referenceable {
+		return []addrs.Referenceable{addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_instance", Name: name}}
+	}
+	buildState := func(deps map[string][]addrs.Referenceable) string {
+		return testStateFile(t, states.BuildState(func(s *states.SyncState) {
+			for addr, deps := range deps {
+				s.SetResourceInstanceCurrent(
+					mustResourceInstanceAddr(addr),
+					&states.ResourceInstanceObjectSrc{
+						AttrsJSON:    []byte(`{"id":"foo"}`),
+						Status:       states.ObjectReady,
+						Dependencies: deps,
+					},
+					provider,
+				)
+			}
+		}))
+	}
+	statePath := buildState(map[string][]addrs.Referenceable{
+		"test_instance.vpc":    nil,
+		"test_instance.subnet": dependsOn("vpc"),
+		"test_instance.web[0]": dependsOn("subnet"),
+		"test_instance.web[1]": dependsOn("subnet"),
+		"test_instance.other":  nil,
+		"test_instance.keep":   dependsOn("vpc"),
+	})
+	selectors := []string{"test_instance.vpc", "test_instance.subnet", "test_instance.web[0]", "test_instance.web[1]", "test_instance.other"}
+	order := "Removal order, respecting dependencies, in 3 steps:\n" +
+		"  1. test_instance.web[0], test_instance.web[1], test_instance.other\n" +
+		"  2. test_instance.subnet\n" +
+		"  3. test_instance.vpc\n"
+
+	c, ui := testStateRmCommand(testProvider())
+	if code := c.Run(append([]string{"-state", statePath, "-dry-run", "-respect-depends-on-order"}, selectors...)); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got := ui.OutputWriter.String(); !strings.Contains(got, order) {
+		t.Errorf("order not reported\ngot:  %s\nwant: %s", got, order)
+	}
+	if got, want := len(stateAllResourceInstances(testStateRead(t, statePath))), 6; got != want {
+		t.Fatalf("dry run changed the state: %d instances; want %d", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run(append([]string{"-state", statePath, "-auto-approve", "-backup", "-", "-respect-depends-on-order"}, selectors...)); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	for _, want := range []string{
+		order,
+		"Persisted step 1 of 3: removed 3 of 5 resource instances.\n",
+		"Persisted step 2 of 3: removed 4 of 5 resource instances.\n",
+		"Persisted step 3 of 3: removed 5 of 5 resource instances.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+		}
+	}
+	remaining := stateAllResourceInstances(testStateRead(t, statePath))
+	if len(remaining) != 1 || remaining[0].String() != "test_instance.keep" {
+		t.Errorf("wrong remaining instances %s", remaining)
+	}
+
+	// Instances whose dependencies form a cycle can't be ordered.
+	statePath = buildState(map[string][]addrs.Referenceable{
+		"test_instance.a": dependsOn("b"),
+		"test_instance.b": dependsOn("a"),
+	})
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-respect-depends-on-order", "test_instance.a", "test_instance.b"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Dependency cycle"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	if got, want := len(stateAllResourceInstances(testStateRead(t, statePath))), 2; got != want {
+		t.Errorf("state changed despite the cycle: %d instances; want %d", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-respect-depends-on-order", "-chunk-persist", "1", "test_instance.a"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid combination of options"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_persistInterval(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{"a", "b", "c", "keep"} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance."+addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+	backupPath := filepath.Join(filepath.Dir(statePath), "backup")
+
+	// An interval this short has always passed by the time the next instance
+	// is removed, so the state is saved after each one.
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-backup", backupPath,
+		"-persist-interval", "1ns",
+		"test_instance.a",
+		"test_instance.b",
+		"test_instance.c",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	got := ui.OutputWriter.String()
+	for _, want := range []string{
+		"Persisted checkpoint 1: removed 1 of 3 resource instances.\n",
+		"Persisted checkpoint 2: removed 2 of 3 resource instances.\n",
+		"Persisted checkpoint 3: removed 3 of 3 resource instances.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("progress not reported\ngot:  %s\nwant: %s", got, want)
+		}
+	}
+
+	remaining := stateAllResourceInstances(testStateRead(t, statePath))
+	if len(remaining) != 1 || remaining[0].String() != "test_instance.keep" {
+		t.Errorf("wrong remaining instances %s", remaining)
+	}
+	backup := testStateRead(t, backupPath)
+	if got, want := len(stateAllResourceInstances(backup)), 4; got != want {
+		t.Errorf("backup has %d instances; want %d", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	if code := c.Run([]string{"-state", statePath, "-auto-approve", "-persist-interval", "1s", "-chunk-persist", "2", "test_instance.keep"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid combination of options"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_addressTransform(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		for _, addr := range []string{"module.app.test_instance.web[0]", "module.app.test_instance.web[1]", "test_instance.other"} {
+			s.SetResourceInstanceCurrent(mustResourceInstanceAddr(addr), obj, provider)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-address-transform", `s|^module\.app\.test_instance\.(\w+)|module.$1.test_instance.main|`,
+		"module.app",
+		"test_instance.other",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `Addresses after -address-transform s|^module\.app\.test_instance\.(\w+)|module.$1.test_instance.main|:
+  test_instance.other -> test_instance.other (unchanged)
+  module.app.test_instance.web[0] -> module.web.test_instance.main[0]
+  module.app.test_instance.web[1] -> module.web.test_instance.main[1]
+`
+	if got := ui.OutputWriter.String(); !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	for raw, want := range map[string]string{
+		"s/foo/bar/":      "test_instance.bar_foo",
+		"s/foo/bar/g":     "test_instance.bar_bar",
+		`s/_(\w+)$/[$1]/`: "test_instance.foo[foo]",
+		`s#\.#\##`:        "test_instance#foo_foo",
+	} {
+		transform, err := parseStateRmAddressTransform(raw)
+		if err != nil {
+			t.Fatalf("%s: %s", raw, err)
+		}
+		if got := transform.Apply("test_instance.foo_foo"); got != want {
+			t.Errorf("%s: got %q; want %q", raw, got, want)
+		}
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{"-state", statePath, "-dry-run", "-address-transform", "s/foo/bar", "test_instance.other"}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid -address-transform option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_diffProviders(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"bar"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.a"), obj, addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance))
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.b"), obj, addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance))
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.c"), obj, addrs.ProviderConfig{Type: "test", Alias: "west"}.Absolute(addrs.RootModuleInstance))
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-diff-providers",
+		"test_instance.a",
+		"test_instance.b",
+		"test_instance.c",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := "The selected instances belong to 2 provider configurations:\n  provider.test: 2\n  provider.test.west: 1\n"
+	if got := ui.OutputWriter.String(); !strings.Contains(got, want) {
+		t.Errorf("providers not listed\ngot:  %s\nwant: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-max-providers", "1",
+		"test_instance.a",
+		"test_instance.c",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Too many providers selected"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-max-providers", "1",
+		"test_instance.a",
+		"test_instance.b",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	f, err := readStateFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := mustResourceInstanceAddr("test_instance.c"); f.State.ResourceInstance(addr) == nil {
+		t.Errorf("%s was removed", addr)
+	}
+	if addr := mustResourceInstanceAddr("test_instance.a"); f.State.ResourceInstance(addr) != nil {
+		t.Errorf("%s was not removed", addr)
+	}
+}
+
+func testStateRmCommand(p providers.Interface) (*StateRmCommand, *cli.MockUi) {
+	ui := cli.NewMockUi()
+	c := &StateRmCommand{
+		StateMeta{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(p),
+				Ui:               ui,
+			},
+		},
+	}
+	return c, ui
+}
+
+// testStateRmKeyedState returns a state containing a single resource
+// test_instance.web with a mixture of integer and string instance keys.
+func testStateRmKeyedState() *states.State {
+	return states.BuildState(func(s *states.SyncState) {
+		keys := []addrs.InstanceKey{
+			addrs.IntKey(0),
+			addrs.IntKey(1),
+			addrs.IntKey(2),
+			addrs.StringKey("old"),
+		}
+		for _, key := range keys {
+			s.SetResourceInstanceCurrent(
+				addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "test_instance",
+					Name: "web",
+				}.Instance(key).Absolute(addrs.RootModuleInstance),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(fmt.Sprintf(`{"id":%q}`, key.String())),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		}
+	})
+}
+
+// testStateRmInstanceKeys reads the state at the given path and checks that
+// the root module resource test_instance.NAME has exactly the given instance
+// keys.
+func testStateRmInstanceKeys(t *testing.T, path string, name string, want []addrs.InstanceKey) {
+	t.Helper()
+
+	f, err := readStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := f.State.Resource(addrs.Resource{
+		Mode: addrs.ManagedResourceMode,
+		Type: "test_instance",
+		Name: name,
+	}.Absolute(addrs.RootModuleInstance))
+	if rs == nil {
+		t.Fatalf("test_instance.%s is not in the state", name)
+	}
+
+	got := make(map[addrs.InstanceKey]bool)
+	for key := range rs.Instances {
+		got[key] = true
+	}
+	if len(got) != len(want) {
+		t.Errorf("wrong number of instances %d; want %d", len(got), len(want))
+	}
+	for _, key := range want {
+		if !got[key] {
+			t.Errorf("instance key %s is missing", key)
+		}
+	}
+}
+
+const testStateRmOutputOriginal = `
+test_instance.bar:
+  ID = foo
+  provider = provider.test
+  bar = value
+  foo = value
+test_instance.foo:
+  ID = bar
+  provider = provider.test
+  bar = value
+  foo = value
+`
+
+const testStateRmOutput = `
+test_instance.bar:
+  ID = foo
+  provider = provider.test
+  bar = value
+  foo = value
+`
+
+const testStateRmObjectHashManifestOutput = `
+test_instance.bar:
+  ID = bar
+  provider = provider.test
+`
+
+func TestStateRm_respectPreventDestroy(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("state-rm-prevent-destroy"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-respect-prevent-destroy",
+		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	got := ui.ErrorWriter.String()
+	for _, want := range []string{
+		"Resource instances protected by prevent_destroy",
+		"  test_instance.foo\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in error\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "test_instance.bar") {
+		t.Errorf("unprotected instance listed\n%s", got)
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	// The unprotected instance can still be removed.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-respect-prevent-destroy",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	state := testStateRead(t, statePath)
+	if state.ResourceInstance(mustResourceInstanceAddr("test_instance.bar")) != nil {
+		t.Fatal("test_instance.bar was not removed")
+	}
+}
+
+func TestStateRm_scrubPrivate(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	foo := mustResourceInstanceAddr("test_instance.foo")
+	bar := mustResourceInstanceAddr("test_instance.bar")
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			foo,
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"foo"}`),
+				Private:   []byte(`{"schema_version":"1"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceDeposed(
+			foo,
+			states.DeposedKey("00000001"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"old"}`),
+				Private:   []byte(`stale`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceCurrent(
+			bar,
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"bar"}`),
+				Private:   []byte(`keep`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-scrub-private",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := strings.TrimSpace(`
+Would clear the private data of test_instance.foo (22 bytes)
+Would clear the private data of deposed object 00000001 of test_instance.foo (5 bytes)
+
+Would've cleared the private data of 2 objects, without -dry-run.
+`)
+	if got := strings.TrimSpace(ui.OutputWriter.String()); got != want {
+		t.Errorf("wrong output\ngot:\n%s\n\nwant:\n%s", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-scrub-private",
+		"-auto-approve",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	after := testStateRead(t, statePath)
+	is := after.ResourceInstance(foo)
+	if is == nil || is.Current == nil {
+		t.Fatal("test_instance.foo was removed")
+	}
+	if got := is.Current.Private; len(got) != 0 {
+		t.Errorf("private data of test_instance.foo not cleared: %q", got)
+	}
+	if attrs, err := stateShowFlatAttrs(is.Current); err != nil || attrs["id"] != "foo" {
+		t.Errorf("wrong attributes for test_instance.foo %s", is.Current.AttrsJSON)
+	}
+	if obj := is.Deposed[states.DeposedKey("00000001")]; obj == nil || len(obj.Private) != 0 {
+		t.Errorf("private data of the deposed object not cleared: %#v", obj)
+	}
+	if got, want := string(after.ResourceInstance(bar).Current.Private), "keep"; got != want {
+		t.Errorf("wrong private data for test_instance.bar %q; want %q", got, want)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-scrub-private",
+		"-provider-rename", "provider.test=provider.test.renamed",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid combination of options"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRm_maxStateSize(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	c, ui := testStateRmCommand(testProvider())
+	args := []string{
+		"-state", statePath,
+		"-dry-run",
+		"-batch-size-report",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "smaller than the current"; !strings.Contains(got, want) {
+		t.Errorf("missing %q in output\n%s", want, got)
+	}
+
+	// Even a dry run fails once it has listed what it would remove.
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-dry-run",
+		"-max-state-size", "10B",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	for _, want := range []string{"Would remove test_instance.foo", "Projected state size: "} {
+		if got := ui.OutputWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("missing %q in output\n%s", want, got)
+		}
+	}
+	if got, want := ui.ErrorWriter.String(), "State too large"; !strings.Contains(got, want) {
+		t.Errorf("missing %q in error\n%s", want, got)
+	}
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-max-state-size", "10B",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-max-state-size", "1MiB",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	c, ui = testStateRmCommand(testProvider())
+	args = []string{
+		"-state", statePath,
+		"-auto-approve",
+		"-max-state-size", "lots",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid -max-state-size option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateRmOptsValidate(t *testing.T) {
+	tests := map[string]struct {
+		opts    stateRmOpts
+		wantErr string
+	}{
+		"valid": {
+			opts: stateRmOpts{Selectors: []string{"test_instance.foo"}, Mode: "all", DecodeErrors: "skip"},
+		},
+		"no selectors": {
+			opts:    stateRmOpts{},
+			wantErr: "No resource addresses given",
+		},
+		"fix without coalesce": {
+			opts:    stateRmOpts{Selectors: []string{"test_instance.foo"}, Fix: true},
+			wantErr: "-fix",
+		},
+		"output without dry run": {
+			opts:    stateRmOpts{Selectors: []string{"test_instance.foo"}, Output: "plan.json"},
+			wantErr: "-output",
+		},
+		"bad mode": {
+			opts:    stateRmOpts{Selectors: []string{"test_instance.foo"}, Mode: "both"},
+			wantErr: "-mode",
+		},
+		"negative chunk": {
+			opts:    stateRmOpts{Selectors: []string{"test_instance.foo"}, ChunkPersist: -1},
+			wantErr: "-chunk-persist",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			diags := test.opts.validate()
+			if test.wantErr == "" {
+				if diags.HasErrors() {
+					t.Fatalf("unexpected errors: %s", diags.Err())
+				}
+				return
+			}
+			if !diags.HasErrors() {
+				t.Fatalf("expected errors")
+			}
+			if got := diags.Err().Error(); !strings.Contains(got, test.wantErr) {
+				t.Errorf("wrong error\ngot:  %s\nwant: %s", got, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+)
+
+// stateRmAddressTransform is a sed-style substitution given to the
+// -address-transform option, used to suggest the address that each removed
+// resource instance would have under a new naming scheme.
+type stateRmAddressTransform struct {
+	Raw string
+
+	re   *regexp.Regexp
+	repl string
+
+	// global is set by the "g" flag, to replace every match rather than
+	// only the first.
+	global bool
+}
+
+// parseStateRmAddressTransform parses the given substitution, which is of
+// the form s/PATTERN/REPLACEMENT/ with an optional "g" flag after it. Any
+// character can be used as the delimiter in place of "/", and a delimiter
+// preceded by a backslash is part of the pattern or replacement instead.
+//
+// The pattern is a Go regular expression, and the replacement can refer to
+// its submatches as $1 or ${name}, as for regexp.Regexp.Expand.
+func parseStateRmAddressTransform(raw string) (*stateRmAddressTransform, error) {
+	if len(raw) < 2 || raw[0] != 's' {
+		return nil, fmt.Errorf("must be a substitution such as s/old/new/, not %q", raw)
+	}
+	delim := raw[1:2]
+
+	var parts []string
+	var part strings.Builder
+	rest := raw[2:]
+	for len(rest) > 0 {
+		switch {
+		case strings.HasPrefix(rest, `\`+delim):
+			part.WriteString(delim)
+			rest = rest[1+len(delim):]
+		case strings.HasPrefix(rest, delim):
+			parts = append(parts, part.String())
+			part.Reset()
+			rest = rest[len(delim):]
+		default:
+			part.WriteByte(rest[0])
+			rest = rest[1:]
+		}
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("must be a substitution such as s/old/new/, not %q", raw)
+	}
+	flags := part.String()
+	if flags != "" && flags != "g" {
+		return nil, fmt.Errorf("the only flag allowed after a substitution is \"g\", not %q", flags)
+	}
+
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", parts[0], err)
+	}
+	return &stateRmAddressTransform{
+		Raw:    raw,
+		re:     re,
+		repl:   parts[1],
+		global: flags == "g",
+	}, nil
+}
+
+// Apply returns the given address with the substitution made.
+func (t *stateRmAddressTransform) Apply(addr string) string {
+	if t.global {
+		return t.re.ReplaceAllString(addr, t.repl)
+	}
+	match := t.re.FindStringSubmatchIndex(addr)
+	if match == nil {
+		return addr
+	}
+	dst := t.re.ExpandString(nil, t.repl, addr, match)
+	return addr[:match[0]] + string(dst) + addr[match[1]:]
+}
+
+// writeStateRmAddressTransform writes each of the given items with the
+// address the given transform produces for it, for -address-transform, so
+// that the result can be used as a mapping to import the removed objects
+// again under the new addresses. Addresses the transform doesn't change,
+// or changes into something that isn't a resource instance address, are
+// noted.
+func writeStateRmAddressTransform(w io.Writer, items []*stateRmItem, t *stateRmAddressTransform) {
+	fmt.Fprintf(w, "Addresses after -address-transform %s:\n", t.Raw)
+	for _, item := range items {
+		from := item.Addr.String()
+		to := t.Apply(from)
+		switch {
+		case to == from:
+			fmt.Fprintf(w, "  %s -> %s (unchanged)\n", from, to)
+		case !stateRmValidInstanceAddr(to):
+			fmt.Fprintf(w, "  %s -> %s (not a valid resource instance address)\n", from, to)
+		default:
+			fmt.Fprintf(w, "  %s -> %s\n", from, to)
+		}
+	}
+}
+
+func stateRmValidInstanceAddr(raw string) bool {
+	_, diags := addrs.ParseAbsResourceInstanceStr(raw)
+	return !diags.HasErrors()
+}
@@ -0,0 +1,334 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateBackup is one of the timestamped backups that state commands write by
+// default, as named by defaultStateBackupPath.
+type stateBackup struct {
+	Path string
+	Time int64
+}
+
+// timestampedStateBackups returns the timestamped backups of the state stored
+// at the given path, most recent first. Files that merely share the prefix
+// but don't have a timestamp in their names are ignored.
+func timestampedStateBackups(stateOutPath string) ([]stateBackup, error) {
+	paths, err := filepath.Glob(stateOutPath + ".*" + DefaultBackupExtension)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []stateBackup
+	for _, path := range paths {
+		raw := strings.TrimSuffix(strings.TrimPrefix(path, stateOutPath+"."), DefaultBackupExtension)
+		t, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			// Not one of our timestamped backups.
+			continue
+		}
+		ret = append(ret, stateBackup{Path: path, Time: t})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Time != ret[j].Time {
+			return ret[i].Time > ret[j].Time
+		}
+		return ret[i].Path > ret[j].Path
+	})
+	return ret, nil
+}
+
+// latestStateBackup returns the path of the most recent of the timestamped
+// backups that state commands write by default for the state stored at the
+// given path, as named by defaultStateBackupPath, or an empty string if there
+// are none.
+func latestStateBackup(stateOutPath string) (string, error) {
+	backups, err := timestampedStateBackups(stateOutPath)
+	if err != nil || len(backups) == 0 {
+		return "", err
+	}
+	return backups[0].Path, nil
+}
+
+// pruneStateBackups deletes all but the most recent keep of the timestamped
+// backups of the state stored at the given path, for -backup-retention, and
+// returns the paths of the deleted files.
+//
+// Only backups of a state with the given lineage are counted or deleted, so
+// that files which can't be read as state, or that belong to some other
+// state that happens to have been written to the same path, are left alone.
+func pruneStateBackups(stateOutPath, lineage string, keep int) ([]string, error) {
+	backups, err := timestampedStateBackups(stateOutPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	kept := 0
+	for _, backup := range backups {
+		f, err := readStateFile(backup.Path)
+		if err != nil || f.Lineage != lineage {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		if err := os.Remove(backup.Path); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, backup.Path)
+	}
+	return deleted, nil
+}
+
+// stateBackupChanges describes the difference between the current state and
+// a backup that would be restored over it, as lines giving the address of
+// each resource instance that restoring the backup would add (+), remove (-),
+// or change (~), ordered by address.
+func stateBackupChanges(current, backup *states.State) ([]string, error) {
+	curResults, err := (&states.Filter{State: current}).Filter()
+	if err != nil {
+		return nil, err
+	}
+	backupResults, err := (&states.Filter{State: backup}).Filter()
+	if err != nil {
+		return nil, err
+	}
+
+	inCurrent := make(map[string]bool)
+	for _, result := range curResults {
+		inCurrent[result.Address] = true
+	}
+	inBackup := make(map[string]bool)
+	for _, result := range backupResults {
+		inBackup[result.Address] = true
+	}
+
+	changed := changedInstances(backupResults, curResults)
+	sort.Strings(changed)
+	lines := make([]string, len(changed))
+	for i, addr := range changed {
+		switch {
+		case !inCurrent[addr]:
+			lines[i] = "  + " + addr
+		case !inBackup[addr]:
+			lines[i] = "  - " + addr
+		default:
+			lines[i] = "  ~ " + addr
+		}
+	}
+	return lines, nil
+}
+
+// undoLast restores the most recent timestamped backup of the given state,
+// as read from the given state manager, for the -undo-last option. The
+// changes that restoring it would make are reported first, and unless
+// autoApprove is set the user must confirm them.
+func (c *StateRmCommand) undoLast(stateMgr statemgr.Full, state *states.State, dryRun, autoApprove bool) int {
+	var diags tfdiags.Diagnostics
+
+	path, err := latestStateBackup(c.stateOutPath)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to find backups",
+			fmt.Sprintf("Could not search for backups of %s: %s.", c.stateOutPath, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if path == "" {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No backup found",
+			fmt.Sprintf("There are no timestamped backups of %s to restore. Only the backups that state commands write by default, named like %s, can be found by -undo-last.", c.stateOutPath, defaultStateBackupPath(c.stateOutPath)),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	backup, err := readStateFile(path)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to read backup",
+			fmt.Sprintf("Could not read the backup %s: %s.", path, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	current := statemgr.StateFile(stateMgr, state)
+	if current.Lineage != "" && backup.Lineage != current.Lineage {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Backup lineage does not match",
+			fmt.Sprintf("The most recent backup %s has the lineage %q, but the current state has the lineage %q, so it is a backup of a different state. The state has not been changed.", path, backup.Lineage, current.Lineage),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	changes, err := stateBackupChanges(state, backup.State)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(errStateFilter, err))
+		return 1
+	}
+	if len(changes) == 0 {
+		c.Ui.Output(fmt.Sprintf("The most recent backup %s matches the current state, so there is nothing to undo.", path))
+		return 0
+	}
+	c.Ui.Output(fmt.Sprintf("Restoring the most recent backup %s would make the following changes:\n\n%s\n", path, strings.Join(changes, "\n")))
+	if dryRun {
+		return 0
+	}
+
+	if !autoApprove {
+		ok, err := c.confirm(&terraform.InputOpts{
+			Id:          "undo-last",
+			Query:       "Do you want to restore this backup?",
+			Description: "Terraform will replace the current state with the backup.\nOnly 'yes' will be accepted to confirm.",
+		})
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Restore not confirmed",
+				fmt.Sprintf("Restoring a backup with -undo-last must be confirmed, but Terraform could not ask for confirmation: %s. Use -auto-approve to restore it without confirmation.", err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !ok {
+			c.Ui.Output("Undo cancelled.")
+			return 1
+		}
+	}
+
+	if err := stateMgr.WriteState(backup.State); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to write state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to persist state",
+			fmt.Sprintf(errStateRmPersist, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("Restored the state from %s. Updated state written successfully.", path))
+	return 0
+}
+
+// rollbackRemoval restores the given state, as it was before a removal, using
+// the given state manager, for the given option, which is either
+// -rollback-on-verify-failure or -post-remove-plan-gate-rollback. It is only
+// used once a check after the removal has failed, so the result is reported
+// as an addition to that error.
+func (c *StateRmCommand) rollbackRemoval(stateMgr statemgr.Full, before *states.State, option string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	backup := "no local backup was written"
+	if path := c.writtenBackupPath(); path != "" {
+		backup = fmt.Sprintf("a backup is also at %s", path)
+	}
+
+	err := stateMgr.WriteState(before)
+	if err == nil {
+		err = stateMgr.PersistState()
+	}
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to roll back the removal",
+			fmt.Sprintf("Because of %s, Terraform tried to restore the state as it was before the removal, but could not save it: %s.\n\nThe state may be only partly changed. Restore it from the backup before doing anything else; %s.", option, err, backup),
+		))
+		return diags
+	}
+
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Removal rolled back",
+		fmt.Sprintf("Because of %s, the state as it was before the removal has been saved again, so nothing has been removed; %s. Check the state with \"terraform state list\" before trying again.", option, backup),
+	))
+	return diags
+}
+
+// reinsertRemoved puts each of the resource instances and output values
+// removed by the given result back into the given state, copying them from
+// the given state as it was before the removal, and saves it again using the
+// given state manager, for the -reinsert-on-abort option.
+//
+// Unlike rollbackRemoval, only the removed items are put back, so anything
+// else about the state that the command changed along with the removal is
+// kept. As for rollbackRemoval, the result is reported as an addition to the
+// error that caused the removal to be abandoned.
+func (c *StateRmCommand) reinsertRemoved(stateMgr statemgr.Full, state, before *states.State, result *stateRmResult) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	ss := state.SyncWrapper()
+	instances := 0
+	for _, item := range result.Items {
+		is := before.ResourceInstance(item.Addr)
+		if is == nil {
+			continue
+		}
+		if is.Current != nil {
+			ss.SetResourceInstanceCurrent(item.Addr, is.Current.DeepCopy(), item.ProviderConfig)
+		}
+		for k, obj := range is.Deposed {
+			ss.SetResourceInstanceDeposed(item.Addr, k, obj.DeepCopy(), item.ProviderConfig)
+		}
+		instances++
+	}
+	outputs := 0
+	for _, addr := range result.Outputs {
+		if ov := before.OutputValue(addr); ov != nil {
+			ss.SetOutputValue(addr, ov.Value, ov.Sensitive)
+			outputs++
+		}
+	}
+
+	err := stateMgr.WriteState(state)
+	if err == nil {
+		err = stateMgr.PersistState()
+	}
+	if err != nil {
+		backup := "no local backup was written"
+		if path := c.writtenBackupPath(); path != "" {
+			backup = fmt.Sprintf("a backup is at %s", path)
+		}
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to reinsert the removed objects",
+			fmt.Sprintf("Because of -reinsert-on-abort, Terraform put the %d removed resource instances and %d removed output values back into the state, but could not save it: %s.\n\nThe removal may still be saved. Restore the state from the backup before doing anything else; %s.", instances, outputs, err, backup),
+		))
+		return diags
+	}
+
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Removed objects reinserted",
+		fmt.Sprintf("Because of -reinsert-on-abort, the %d removed resource instances and %d removed output values have been put back into the state from the copy kept in memory, and the state has been saved again, so nothing has been removed. Check the state with \"terraform state list\" before trying again.", instances, outputs),
+	))
+	return diags
+}
@@ -0,0 +1,201 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/plugin/discovery"
+	"github.com/hashicorp/terraform/providers"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmUpgrades records the resource instance objects replaced in memory by
+// the -schema-upgrade-first option, so that the objects as stored can be put
+// back before anything is written.
+type stateRmUpgrades struct {
+	// Addrs are the addresses of the instances whose current objects were
+	// upgraded, sorted.
+	Addrs []addrs.AbsResourceInstance
+
+	stored map[string]*states.ResourceInstanceObjectSrc
+}
+
+// upgradeInstances upgrades the current object of each of the given managed
+// resource instances in the given state whose schema version is older than
+// the current schema version of its resource type, using the installed
+// provider's state upgraders, as the next plan would. The objects are
+// replaced only in the given state, and only until the returned upgrades are
+// restored.
+//
+// The options that select or check instances by their attributes then see
+// them as a plan would, rather than with attributes that the provider has
+// since renamed or restructured. With skipDecodeErrors, an object that can't
+// be upgraded is only warned about and left as it is.
+func (c *StateRmCommand) upgradeInstances(state *states.State, instances []addrs.AbsResourceInstance, skipDecodeErrors bool) (*stateRmUpgrades, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	ret := &stateRmUpgrades{
+		stored: make(map[string]*states.ResourceInstanceObjectSrc),
+	}
+
+	byProvider := make(map[string][]addrs.AbsResourceInstance)
+	seen := make(map[string]bool)
+	for _, addr := range instances {
+		if seen[addr.String()] || addr.Resource.Resource.Mode != addrs.ManagedResourceMode {
+			continue
+		}
+		seen[addr.String()] = true
+		rs := state.Resource(addr.ContainingResource())
+		if rs == nil {
+			continue
+		}
+		if is := rs.Instance(addr.Resource.Key); is == nil || is.Current == nil {
+			continue
+		}
+		name := rs.ProviderConfig.ProviderConfig.Type
+		byProvider[name] = append(byProvider[name], addr)
+	}
+	if len(byProvider) == 0 {
+		return ret, diags
+	}
+
+	reqd := make(discovery.PluginRequirements, len(byProvider))
+	for name := range byProvider {
+		reqd[name] = &discovery.PluginConstraints{Versions: discovery.AllVersions}
+	}
+	var resolver providers.Resolver
+	if c.testingOverrides != nil {
+		resolver = c.testingOverrides.ProviderResolver
+	} else {
+		resolver = c.providerResolver()
+	}
+	factories, _ := resolver.ResolveProviders(reqd)
+
+	names := make([]string, 0, len(byProvider))
+	for name := range byProvider {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		factory, ok := factories[name]
+		if !ok {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Provider not installed",
+				fmt.Sprintf("The -schema-upgrade-first option needs provider.%s to upgrade %d of the selected resource instances, but it isn't installed, so they are used as stored. Run \"terraform init\" to install it.", name, len(byProvider[name])),
+			))
+			continue
+		}
+		provider, err := factory()
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Failed to start provider",
+				fmt.Sprintf("Could not start provider.%s to upgrade the selected resource instances: %s. They are used as stored.", name, err),
+			))
+			continue
+		}
+		moreDiags := ret.upgrade(state, provider, name, byProvider[name], skipDecodeErrors)
+		provider.Close()
+		diags = diags.Append(moreDiags)
+	}
+
+	sort.Slice(ret.Addrs, func(i, j int) bool {
+		return ret.Addrs[i].Less(ret.Addrs[j])
+	})
+	return ret, diags
+}
+
+// upgrade upgrades the current objects of the given instances in the given
+// state using the given provider, of the given type.
+func (u *stateRmUpgrades) upgrade(state *states.State, provider providers.Interface, name string, instances []addrs.AbsResourceInstance, skipDecodeErrors bool) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	resp := provider.GetSchema()
+	if resp.Diagnostics.HasErrors() {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Failed to load provider schema",
+			fmt.Sprintf("Could not load the schema of provider.%s: %s. The selected resource instances that belong to it are used as stored.", name, resp.Diagnostics.Err()),
+		))
+		return diags
+	}
+
+	for _, addr := range instances {
+		schema, ok := stateResourceTypeSchema(resp, addr.Resource.Resource)
+		if !ok || schema.Block == nil {
+			continue
+		}
+		is := state.ResourceInstance(addr)
+		obj := is.Current
+		if obj.SchemaVersion >= schema.Version {
+			continue
+		}
+
+		req := providers.UpgradeResourceStateRequest{
+			TypeName: addr.Resource.Resource.Type,
+			Version:  int(obj.SchemaVersion),
+		}
+		if obj.AttrsJSON != nil {
+			req.RawStateJSON = obj.AttrsJSON
+		} else {
+			req.RawStateFlatmap = obj.AttrsFlat
+		}
+		upgraded := provider.UpgradeResourceState(req)
+		if upgraded.Diagnostics.HasErrors() {
+			diags = diags.Append(stateRmDecodeError(addr, fmt.Sprintf("upgrade it from schema version %d to %d", obj.SchemaVersion, schema.Version), upgraded.Diagnostics.Err(), skipDecodeErrors))
+			continue
+		}
+		src, err := ctyjson.Marshal(upgraded.UpgradedState, schema.Block.ImpliedType())
+		if err != nil {
+			diags = diags.Append(stateRmDecodeError(addr, fmt.Sprintf("upgrade it from schema version %d to %d", obj.SchemaVersion, schema.Version), err, skipDecodeErrors))
+			continue
+		}
+
+		newObj := obj.DeepCopy()
+		newObj.AttrsJSON = src
+		newObj.AttrsFlat = nil
+		newObj.SchemaVersion = schema.Version
+		u.stored[addr.String()] = obj
+		is.Current = newObj
+		u.Addrs = append(u.Addrs, addr)
+	}
+	return diags
+}
+
+// Restore puts the objects as stored back in place of the upgraded objects of
+// those instances still in the given state. Nil upgrades restore nothing,
+// for when -schema-upgrade-first wasn't used.
+func (u *stateRmUpgrades) Restore(state *states.State) {
+	if u == nil {
+		return
+	}
+	for _, addr := range u.Addrs {
+		if is := state.ResourceInstance(addr); is != nil && is.Current != nil {
+			is.Current = u.stored[addr.String()]
+		}
+	}
+}
+
+// Report returns a warning listing the upgraded instances, if there are any.
+func (u *stateRmUpgrades) Report() tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+	if len(u.Addrs) == 0 {
+		return diags
+	}
+	lines := make([]string, len(u.Addrs))
+	for i, addr := range u.Addrs {
+		lines[i] = "  " + addr.String()
+	}
+	diags = diags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Resource instances upgraded in memory",
+		fmt.Sprintf("The following resource instances were stored with an older schema version, and were upgraded by their providers before being selected and checked:\n\n%s\n\nThe upgraded objects are only used while the command runs. The state is written with the objects as they were stored.", strings.Join(lines, "\n")),
+	))
+	return diags
+}
@@ -0,0 +1,559 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// validate checks the given options of "terraform state rm", returning an
+// error for the first that is invalid or that can't be used with the others.
+//
+// The options given as strings are only checked here, and are parsed again
+// where they are used, so runStateRm expects options that have been
+// validated. The exception is the output template, which with -template-file
+// is only read once the other options are known to be valid, and so is
+// parsed and checked by runStateRm.
+func (o *stateRmOpts) validate() tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	if o.RemovedBetween != "" {
+		if _, _, ok := parseRevisionRange(o.RemovedBetween); !ok {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -removed-between option",
+				fmt.Sprintf("The -removed-between option must be two git revisions separated by \"..\", such as \"main..HEAD\", not %q.", o.RemovedBetween),
+			))
+			return diags
+		}
+	}
+
+	selected := o.selected()
+	if !selected && o.ForeignLineage == "" && !o.NormalizeOnly && !o.CoalesceInstances && !o.DedupeDeposed && !o.DedupeAcrossModules && o.ProviderRename == "" && o.ProviderMigrationReport == "" && !o.UndoLast && !o.LineageReport {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No resource addresses given",
+			"At least one resource address is required.",
+		))
+		return diags
+	}
+
+	if o.LineageReport && (selected || o.ForeignLineage != "" || o.NormalizeOnly || o.CoalesceInstances || o.DedupeDeposed || o.ProviderRename != "" || o.UndoLast || o.MatchCount || o.PartitionByWorkspace) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -lineage-report option only reports on the state without changing it, so it cannot be used with resource addresses, -foreign-lineage, or the other options that select what to change. Use -assert-lineage to check the lineage in the report.",
+		))
+		return diags
+	}
+
+	if o.PartitionByWorkspace {
+		// This only reads the state of each workspace, so it takes only
+		// the addresses to look for and none of the options that affect
+		// a removal from the current workspace.
+		otherSelected := len(o.OrphanKeys) > 0 || len(o.ResourceTypes) > 0 || o.GCOrphanData || o.FromPlanJSON != "" || o.RemovedBetween != "" || o.ApplyRemovedBlocks
+		if len(o.Selectors) == 0 || otherSelected || o.StatePath != "" || o.NormalizeOnly || o.CoalesceInstances || o.DedupeDeposed || o.ProviderRename != "" || o.UndoLast || o.MatchCount {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid combination of options",
+				"The -partition-by-workspace option reports which workspaces in the configured backend contain the given resource addresses, so it requires resource addresses, and cannot be used with -state or with the other options that select what to change.",
+			))
+			return diags
+		}
+		// The rest of the options don't apply, so aren't checked.
+		return diags
+	}
+
+	if o.WorkspacePattern != "" {
+		// Each matching workspace gets a plain removal of the given
+		// addresses, so none of the options that select what to remove in
+		// other ways, or that act on the current workspace, apply.
+		otherSelected := len(o.OrphanKeys) > 0 || len(o.ResourceTypes) > 0 || o.GCOrphanData || o.FromPlanJSON != "" || o.RemovedBetween != "" || o.ApplyRemovedBlocks
+		if len(o.Selectors) == 0 || otherSelected || o.StatePath != "" || o.backupGiven() || o.NormalizeOnly || o.CoalesceInstances || o.DedupeDeposed || o.ProviderRename != "" || o.UndoLast || o.MatchCount || o.LineageReport {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid combination of options",
+				"The -workspace-pattern option removes the given resource addresses from each matching workspace in the configured backend, so it requires resource addresses, and cannot be used with -state, -backup, or with the other options that select what to change.",
+			))
+			return diags
+		}
+		return diags
+	}
+
+	if o.NormalizeOnly && selected {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -normalize-only option rewrites the state without removing anything, so it cannot be used with resource addresses or with the other options that select resource instances to remove.",
+		))
+		return diags
+	}
+
+	if o.ProviderRename != "" {
+		if o.NormalizeOnly || o.CoalesceInstances {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid combination of options",
+				"The -provider-rename option can't be used with -normalize-only or -coalesce-instances.",
+			))
+			return diags
+		}
+		_, _, moreDiags := parseProviderRename(o.ProviderRename)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return diags
+		}
+	}
+
+	if o.ProviderMigrationReport != "" {
+		if o.ProviderRename != "" || o.NormalizeOnly || o.CoalesceInstances || o.DedupeDeposed || o.DedupeAcrossModules || o.UndoLast || o.LineageReport || o.MatchCount || o.ScrubPrivate || o.CSV || o.Output != "" {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid combination of options",
+				"The -provider-migration-report option only reports on where resources would move without changing the state, so it can't be used with the options that select what to change, or with -csv or -output.",
+			))
+			return diags
+		}
+	}
+
+	if o.ScrubPrivate && (!selected || o.NormalizeOnly || o.CoalesceInstances || o.DedupeDeposed || o.ProviderRename != "" || o.UndoLast || o.MatchCount) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -scrub-private option clears the private data of the selected resource instances instead of removing them, so it requires resource addresses or other options that select resource instances, and cannot be used with the other options that select what to change.",
+		))
+		return diags
+	}
+
+	if o.CoalesceInstances && (selected || o.NormalizeOnly) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -coalesce-instances option only removes duplicate deposed objects, which it finds itself, so it cannot be used with resource addresses or with the other options that select what to remove.",
+		))
+		return diags
+	}
+	if o.DedupeDeposed && (selected || o.NormalizeOnly || o.CoalesceInstances || o.ProviderRename != "") {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -dedupe-deposed option only removes duplicate deposed objects, which it finds itself, so it cannot be used with resource addresses, with -coalesce-instances, or with the other options that select what to change.",
+		))
+		return diags
+	}
+	if o.DedupeAcrossModules && (selected || o.NormalizeOnly || o.CoalesceInstances || o.DedupeDeposed || o.ProviderRename != "" || o.UndoLast || o.LineageReport) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -dedupe-across-modules option only removes resource instances that duplicate another in a different module, which it finds itself, so it cannot be used with resource addresses, with -coalesce-instances or -dedupe-deposed, or with the other options that select what to change.",
+		))
+		return diags
+	}
+	if o.KeepModule != "" {
+		switch _, ok := parseModuleInstanceArg(o.KeepModule); {
+		case !o.DedupeAcrossModules:
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid combination of options",
+				"The -keep-module option applies only to -dedupe-across-modules.",
+			))
+			return diags
+		case !ok:
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -keep-module option",
+				fmt.Sprintf("The -keep-module option must be the address of a module instance, such as module.foo or module.foo[\"a\"].module.bar, not %q.", o.KeepModule),
+			))
+			return diags
+		}
+	}
+	if o.ScopeModule != "" {
+		if _, ok := parseModuleInstanceArg(o.ScopeModule); !ok {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -scope-module option",
+				fmt.Sprintf("The -scope-module option must be the address of a module instance, such as module.foo or module.foo[\"a\"].module.bar, not %q.", o.ScopeModule),
+			))
+			return diags
+		}
+	}
+
+	if o.AddressFileFormat != "" && o.FromFile == "" {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -address-file-format option applies only to -from-file.",
+		))
+		return diags
+	}
+	if o.UndoLast && (selected || o.NormalizeOnly || o.CoalesceInstances || o.DedupeDeposed || o.ProviderRename != "") {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -undo-last option restores a backup instead of removing anything, so it cannot be used with resource addresses or with the other options that select what to change.",
+		))
+		return diags
+	}
+	if o.FailIfEmpty && !selected {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -fail-if-empty option checks that the resource addresses or the other options that select resource instances to remove matched something, so it requires at least one of them.",
+		))
+		return diags
+	}
+	if o.AssertCount != nil && (*o.AssertCount < 0 || !selected) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -assert-count option",
+			"The -assert-count option must be the number of resource instances that the resource addresses or the other options that select resource instances to remove are expected to match, so it must be zero or more and requires at least one of them.",
+		))
+		return diags
+	}
+	if o.MaxRemovalPct < 0 || o.MaxRemovalPct > 100 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -max-removal-pct option",
+			"The -max-removal-pct option must be a whole percentage from 1 to 100 of the resource instances in the state.",
+		))
+		return diags
+	}
+	if o.RollbackOnVerifyFailure && !o.VerifyAfter {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -rollback-on-verify-failure option restores the state when the check made by -verify-after fails, so it requires -verify-after.",
+		))
+		return diags
+	}
+	if o.ReinsertOnAbort && (!o.VerifyAfter || o.RollbackOnVerifyFailure) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -reinsert-on-abort option puts the removed objects back when the check made by -verify-after fails, so it requires -verify-after, and can't be used with -rollback-on-verify-failure, which restores the whole state instead.",
+		))
+		return diags
+	}
+	if o.ConfirmShowDiff && (o.UndoLast || o.StdinConfirmToken) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -confirm-show-diff option asks for confirmation of the removal, so it can't be used with -undo-last, which asks for confirmation of its own, or with -stdin-confirm-token, which reads the approval from stdin instead.",
+		))
+		return diags
+	}
+	if o.Fix && !o.CoalesceInstances && !o.DedupeAcrossModules {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -fix option applies only to -coalesce-instances and -dedupe-across-modules.",
+		))
+		return diags
+	}
+
+	if o.Output != "" && !o.DryRun {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -output option writes the result of -dry-run to a file, so it can only be used with -dry-run.",
+		))
+		return diags
+	}
+
+	if o.PlanFileOut != "" && (!selected || o.NormalizeOnly || o.CoalesceInstances || o.DedupeDeposed || o.ProviderRename != "" || o.ScrubPrivate || o.UndoLast || o.MatchCount || o.EmitRemovedBlock != "") {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -plan-file-out option saves a plan against the state as the removal leaves it, so it requires resource addresses or other options that select resource instances to remove, and cannot be used with the options that change the state in other ways or with -emit-removed-block.",
+		))
+		return diags
+	}
+	if o.PlanFileOut != "" && o.DryRun && (o.JSON || o.CSV || o.Template != "" || o.TemplateFile != "" || o.ApprovalToken) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"With -dry-run, the -plan-file-out option reports where the plan was saved in the human-readable output, so it cannot be used with -json, -csv, -template, -template-file, or -approval-token.",
+		))
+		return diags
+	}
+	if o.PostRemovePlanGate != nil && (*o.PostRemovePlanGate < 0 || o.DryRun) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -post-remove-plan-gate option",
+			"The -post-remove-plan-gate option must be the number of resource instances, zero or more, that a plan against the saved state may destroy or replace after the removal. It can't be used with -dry-run, since it plans against the state once it has been saved; use -simulate-plan to preview the plan instead.",
+		))
+		return diags
+	}
+	if o.PostRemovePlanGateRollback && o.PostRemovePlanGate == nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -post-remove-plan-gate-rollback option restores the state when the check made by -post-remove-plan-gate fails, so it requires -post-remove-plan-gate.",
+		))
+		return diags
+	}
+	if o.SimulatePlan && (!o.DryRun || o.JSON || o.CSV || o.ApprovalToken) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -simulate-plan option summarizes a plan in the human-readable output of -dry-run, so it can only be used with -dry-run, and not with -json, -csv, or -approval-token.",
+		))
+		return diags
+	}
+
+	if o.PreviewDestroyCount && (!o.DryRun || o.JSON || o.CSV || o.ApprovalToken) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -preview-apply-destroy-count option adds a line to the human-readable output of -dry-run, so it can only be used with -dry-run, and not with -json, -csv, or -approval-token.",
+		))
+		return diags
+	}
+
+	if o.EmitRemovedBlock != "" && (!selected || o.NormalizeOnly || o.CoalesceInstances || o.ProviderRename != "" || o.UndoLast || o.MatchCount) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -emit-removed-block option writes a removed block for each of the selected resource instances, so it requires resource addresses or the other options that select resource instances, and cannot be used with -normalize-only, -coalesce-instances, -provider-rename, -undo-last, or -match-count.",
+		))
+		return diags
+	}
+
+	if o.BackupRetention < 0 || (o.BackupRetention > 0 && o.backupGiven()) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -backup-retention option",
+			"The -backup-retention option must be a positive number of backups to keep. It applies only to the timestamped backups that are written by default, and so can't be used with -backup.",
+		))
+		return diags
+	}
+
+	if o.MaxStateSize != "" {
+		if _, err := parseStateRmSize(o.MaxStateSize); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -max-state-size option",
+				fmt.Sprintf("The -max-state-size option %s, not %q.", err, o.MaxStateSize),
+			))
+			return diags
+		}
+	}
+	if o.BatchSizeReport && (o.JSON || o.CSV || o.Template != "" || o.TemplateFile != "" || o.ApprovalToken) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -batch-size-report option adds a line to the usual output, so it cannot be used with -json, -csv, -template, -template-file, or -approval-token. The -max-state-size option can still be used with them.",
+		))
+		return diags
+	}
+
+	if strings.ContainsAny(o.Label, "\r\n") {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -label option",
+			"The -label option must be a single line, so that it can be recorded in line-based reports such as -object-hash-manifest.",
+		))
+		return diags
+	}
+
+	if o.ChunkPersist < 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -chunk-persist option",
+			"The -chunk-persist option must be a positive number of resource instances to remove in each chunk.",
+		))
+		return diags
+	}
+
+	if o.RespectOrder && o.ChunkPersist > 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -respect-depends-on-order option chooses its own chunks, one for each step of the dependency order, so it can't be used with -chunk-persist.",
+		))
+		return diags
+	}
+
+	if o.PersistInterval < 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -persist-interval option",
+			"The -persist-interval option must be a positive duration such as \"30s\".",
+		))
+		return diags
+	}
+	if o.PersistInterval > 0 && (o.ChunkPersist > 0 || o.RespectOrder) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -persist-interval option decides when to persist the state by time, so it can't be used with -chunk-persist or -respect-depends-on-order, which decide by the resource instances removed.",
+		))
+		return diags
+	}
+
+	if o.Retry < 0 || o.RetryInterval < 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -retry option",
+			"The -retry option must be a positive number of times to retry persisting the state, and -retry-interval must be a positive duration such as \"5s\".",
+		))
+		return diags
+	}
+
+	outputTemplate := o.Template != "" || o.TemplateFile != ""
+	if outputTemplate && (o.Template != "" && o.TemplateFile != "" || o.JSON || o.ApprovalToken) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -template and -template-file options replace the human-readable output, so only one of them can be used, and not with -json or -approval-token.",
+		))
+		return diags
+	}
+
+	if o.PrintBackupPath && (o.DryRun || o.EmitRemovedBlock != "" || o.BackupToBackend) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -print-backup-path option prints the path of the local backup written along with the modified state, so it cannot be used with -dry-run, -emit-removed-block, or -backup-to-backend.",
+		))
+		return diags
+	}
+
+	if o.SnapshotDiffURL != "" {
+		if !strings.HasPrefix(o.SnapshotDiffURL, "http://") && !strings.HasPrefix(o.SnapshotDiffURL, "https://") {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -snapshot-diff-url option",
+				fmt.Sprintf("The -snapshot-diff-url option must be an http or https URL, not %q.", o.SnapshotDiffURL),
+			))
+			return diags
+		}
+		if o.DryRun || o.EmitRemovedBlock != "" {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid combination of options",
+				"The -snapshot-diff-url option sends a notification once the modified state has been saved, so it cannot be used with -dry-run or -emit-removed-block.",
+			))
+			return diags
+		}
+	}
+
+	if o.AddressTransform != "" {
+		if !o.DryRun || o.JSON || o.CSV || outputTemplate || o.SummaryOnly || o.ApprovalToken {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid combination of options",
+				"The -address-transform option suggests new addresses in the list of resource instances that a dry run would remove, so it requires -dry-run and can't be used with -json, -csv, -template, -template-file, -summary-only, or -approval-token.",
+			))
+			return diags
+		}
+		if _, err := parseStateRmAddressTransform(o.AddressTransform); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -address-transform option",
+				fmt.Sprintf("The -address-transform option %s.", err),
+			))
+			return diags
+		}
+	}
+
+	if o.CSV && (o.JSON || outputTemplate || o.GroupByModule || o.SummaryOnly || o.ApprovalToken || o.PrintBackupPath) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -csv option prints a row for each removed resource instance, so it cannot be used with -json, -template, -template-file, -group-by-module, -summary-only, -approval-token, or -print-backup-path.",
+		))
+		return diags
+	}
+
+	if o.JSON && !o.SummaryOnly && o.PrintBackupPath {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -json option prints an array of what was removed, which has no room for the backup path, so -print-backup-path requires -summary-only with -json, which gives the path as the backup_path property.",
+		))
+		return diags
+	}
+
+	if o.StdinConfirmToken && (o.ConfirmFile != "" || o.DryRun && (o.JSON || o.CSV || outputTemplate || o.ApprovalToken)) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -stdin-confirm-token option reads the approval token from stdin instead of from a file, so it cannot be used with -confirm-file. With -dry-run it prints the token after the human-readable output, so it cannot be used with -json, -csv, -template, -template-file, or -approval-token; use -approval-token alone to print only the token.",
+		))
+		return diags
+	}
+
+	if o.SummaryOnly && (outputTemplate || o.GroupByModule || o.ApprovalToken) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -summary-only option replaces the list of removed resource instances with a summary, so it cannot be used with -template, -template-file, -group-by-module, or -approval-token.",
+		))
+		return diags
+	}
+
+	if o.DiffProviders && (!o.DryRun || o.JSON || o.CSV || o.ApprovalToken) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -diff-providers option lists providers in the human-readable output of -dry-run, so it can only be used with -dry-run, and not with -json, -csv, or -approval-token. Use -max-providers to limit the providers of a real removal.",
+		))
+		return diags
+	}
+
+	if o.RetainSchemaVersion && o.SaveRemoved == "" {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -retain-schema-version option applies only to the file written by -save-removed, so it cannot be used without -save-removed.",
+		))
+		return diags
+	}
+
+	if _, ok := parseStateRmMode(o.Mode); !ok {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid resource mode",
+			fmt.Sprintf("The -mode option must be \"managed\", \"data\", or \"all\", not %q.", o.Mode),
+		))
+		return diags
+	}
+
+	if o.KeepLatest < 0 || (o.KeepLatest > 0 && (len(o.ResourceTypes) == 0 || o.KeepLatestBy == "")) || (o.KeepLatest == 0 && o.KeepLatestBy != "") {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -keep-latest option",
+			"The -keep-latest option must be a positive number of the newest instances of each resource type to keep. It must be used with -resource-type, to give the types, and with -keep-latest-by, to give the path of the timestamp attribute that orders them.",
+		))
+		return diags
+	}
+
+	switch o.DecodeErrors {
+	case "", "skip", "fail":
+	default:
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -decode-errors option",
+			fmt.Sprintf("The -decode-errors option must be \"skip\" or \"fail\", not %q.", o.DecodeErrors),
+		))
+		return diags
+	}
+
+	_, moreDiags := parseStateRmConditions(o.Where, "-where")
+	diags = diags.Append(moreDiags)
+	_, moreDiags = parseStateRmConditions(o.WhereNot, "-where-not")
+	diags = diags.Append(moreDiags)
+	for _, raw := range o.Exclude {
+		if _, err := newStateRmExclusion(states.NewState(), raw); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid -exclude option",
+				fmt.Sprintf("The -exclude option must be the address of a resource instance, resource or module instance, not %q: %s.", raw, err),
+			))
+		}
+	}
+	return diags
+}
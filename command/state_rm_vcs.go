@@ -0,0 +1,172 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/configs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// parseRevisionRange parses the OLD..NEW argument of -removed-between.
+func parseRevisionRange(raw string) (oldRev, newRev string, ok bool) {
+	parts := strings.SplitN(raw, "..", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// gitRootModuleAtRevision loads the root module in the current working
+// directory as it was at the given git revision, without checking it out.
+// Only the configuration files of the root module itself are read, so the
+// result has no child modules.
+func gitRootModuleAtRevision(rev string) (*configs.Module, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	out, err := gitOutput("ls-tree", "--name-only", rev, "--", ".")
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to read configuration from git",
+			fmt.Sprintf("Could not list the files in the current directory at revision %q: %s.", rev, err),
+		))
+		return nil, diags
+	}
+
+	// We'll copy the configuration files into an in-memory filesystem, so
+	// that the usual parser can load them as a directory.
+	const dir = "/config"
+	fs := afero.NewMemMapFs()
+	for _, name := range strings.Split(strings.TrimSpace(out), "\n") {
+		if !strings.HasSuffix(name, ".tf") && !strings.HasSuffix(name, ".tf.json") {
+			continue
+		}
+		src, err := gitOutput("show", rev+":./"+name)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to read configuration from git",
+				fmt.Sprintf("Could not read %s at revision %q: %s.", name, rev, err),
+			))
+			return nil, diags
+		}
+		if err := afero.WriteFile(fs, path.Join(dir, name), []byte(src), 0644); err != nil {
+			diags = diags.Append(err)
+			return nil, diags
+		}
+	}
+
+	mod, hclDiags := configs.NewParser(fs).LoadConfigDir(dir)
+	diags = diags.Append(hclDiags)
+	return mod, diags
+}
+
+// gitOutput runs git with the given arguments in the current working
+// directory and returns what it printed, or an error including anything it
+// printed on stderr.
+func gitOutput(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s", err, msg)
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+// removedBetweenRevisions returns the resources and module calls that are
+// declared in the root module at oldRev but not at newRev, each as an
+// address string, along with the instances of them in the given state, for
+// the -removed-between option.
+func removedBetweenRevisions(state *states.State, oldRev, newRev string) ([]string, []addrs.AbsResourceInstance, []addrs.ModuleInstance, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	oldMod, moreDiags := gitRootModuleAtRevision(oldRev)
+	diags = diags.Append(moreDiags)
+	newMod, moreDiags := gitRootModuleAtRevision(newRev)
+	diags = diags.Append(moreDiags)
+	if diags.HasErrors() {
+		return nil, nil, nil, diags
+	}
+
+	var removed []string
+	var instances []addrs.AbsResourceInstance
+	var modules []addrs.ModuleInstance
+
+	var gone []addrs.Resource
+	for _, rcs := range []map[string]*configs.Resource{oldMod.ManagedResources, oldMod.DataResources} {
+		for _, rc := range rcs {
+			if newMod.ResourceByAddr(rc.Addr()) == nil {
+				gone = append(gone, rc.Addr())
+			}
+		}
+	}
+	sort.Slice(gone, func(i, j int) bool {
+		return gone[i].String() < gone[j].String()
+	})
+	root := state.RootModule()
+	for _, addr := range gone {
+		removed = append(removed, addr.String())
+		if rs := root.Resource(addr); rs != nil {
+			instances = append(instances, stateResourceInstances(rs, addrs.RootModuleInstance)...)
+		}
+	}
+
+	var goneCalls []string
+	for name := range oldMod.ModuleCalls {
+		if _, exists := newMod.ModuleCalls[name]; !exists {
+			goneCalls = append(goneCalls, name)
+		}
+	}
+	sort.Strings(goneCalls)
+	for _, name := range goneCalls {
+		removed = append(removed, "module."+name)
+
+		// Each instance of the module in the state is removed, whatever
+		// its key, along with everything nested inside it.
+		seen := make(map[string]bool)
+		for _, ms := range state.Modules {
+			if len(ms.Addr) == 0 || ms.Addr[0].Name != name {
+				continue
+			}
+			modAddr := ms.Addr[:1]
+			if seen[modAddr.String()] {
+				continue
+			}
+			seen[modAddr.String()] = true
+			modules = append(modules, modAddr)
+			instances = append(instances, stateModuleResourceInstances(state, modAddr)...)
+		}
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Less(modules[j])
+	})
+
+	return removed, instances, modules, diags
+}
+
+// stateResourceInstances returns the addresses of all of the instances of the
+// given resource in the module instance with the given address, sorted by key.
+func stateResourceInstances(rs *states.Resource, modAddr addrs.ModuleInstance) []addrs.AbsResourceInstance {
+	ret := make([]addrs.AbsResourceInstance, 0, len(rs.Instances))
+	for key := range rs.Instances {
+		ret = append(ret, rs.Addr.Instance(key).Absolute(modAddr))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Less(ret[j])
+	})
+	return ret
+}
@@ -0,0 +1,100 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateRmCondition is a condition on the attributes of a resource instance,
+// given as PATH=VALUE to the -where and -where-not options.
+type stateRmCondition struct {
+	// Path is the flatmap key of the attribute, such as "tags.keep" or
+	// "network_interface.0.subnet_id", as shown by "terraform state show".
+	Path string
+
+	// Value is the value that the attribute must have, as shown by
+	// "terraform state show".
+	Value string
+}
+
+func (c stateRmCondition) String() string {
+	return c.Path + "=" + c.Value
+}
+
+// parseStateRmConditions parses the raw PATH=VALUE arguments given to the
+// option with the given name.
+func parseStateRmConditions(raws []string, option string) ([]stateRmCondition, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	ret := make([]stateRmCondition, 0, len(raws))
+	for _, raw := range raws {
+		eq := strings.Index(raw, "=")
+		if eq < 1 {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				fmt.Sprintf("Invalid %s option", option),
+				fmt.Sprintf("The %s option must be given as PATH=VALUE, where PATH is an attribute path such as tags.keep, not %q.", option, raw),
+			))
+			continue
+		}
+		ret = append(ret, stateRmCondition{Path: raw[:eq], Value: raw[eq+1:]})
+	}
+	return ret, diags
+}
+
+// matches returns true if the given flatmap attributes have the condition's
+// value at its path. An attribute that doesn't exist never matches.
+func (c stateRmCondition) matches(attrs map[string]string) bool {
+	v, ok := attrs[c.Path]
+	return ok && v == c.Value
+}
+
+// filterInstancesByConditions returns those of the given instances whose
+// current objects match all of the conditions in where and none of those in
+// whereNot, for the -where and -where-not options, along with those that
+// were left out.
+//
+// Instances that aren't in the state are kept, so that they are reported as
+// missing as usual, while an instance with only deposed objects has no
+// attributes to match and so is treated as if none of its attributes exist.
+// With skipDecodeErrors, an instance whose attributes can't be decoded is
+// kept with a warning, and otherwise it is an error.
+func filterInstancesByConditions(state *states.State, instances []addrs.AbsResourceInstance, where, whereNot []stateRmCondition, skipDecodeErrors bool) (kept, excluded []addrs.AbsResourceInstance, diags tfdiags.Diagnostics) {
+	for _, addr := range instances {
+		is := state.ResourceInstance(addr)
+		if is == nil {
+			kept = append(kept, addr)
+			continue
+		}
+
+		var attrs map[string]string
+		if is.Current != nil {
+			var err error
+			attrs, err = stateShowFlatAttrs(is.Current)
+			if err != nil {
+				diags = diags.Append(stateRmDecodeError(addr, "check the -where and -where-not conditions", err, skipDecodeErrors))
+				if skipDecodeErrors {
+					kept = append(kept, addr)
+				}
+				continue
+			}
+		}
+
+		keep := true
+		for _, cond := range where {
+			keep = keep && cond.matches(attrs)
+		}
+		for _, cond := range whereNot {
+			keep = keep && !cond.matches(attrs)
+		}
+		if !keep {
+			excluded = append(excluded, addr)
+			continue
+		}
+		kept = append(kept, addr)
+	}
+	return kept, excluded, diags
+}
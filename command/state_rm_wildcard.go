@@ -0,0 +1,76 @@
+package command
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+)
+
+// stateRmKeyWildcards replaces the wildcard tokens that states.Filter accepts
+// in place of instance keys with placeholder keys, to tell a pattern of
+// module instances from one of resource instances.
+var stateRmKeyWildcards = strings.NewReplacer(`["*"]`, `["key"]`, `[*]`, `[0]`)
+
+// isStateRmKeyPattern returns true if the given address contains any of the
+// wildcard tokens [*] or ["*"]. Any other "*", such as in the literal key of
+// aws_instance.node["a*b"], is left to the usual address parser.
+func isStateRmKeyPattern(raw string) bool {
+	return stateRmKeyWildcards.Replace(raw) != raw
+}
+
+// stateRmKeyPatternMatches returns what the given address pattern matches in
+// the given state, found with states.Filter: the module instances it matches
+// if it is a pattern of module instances, without their descendants, which
+// are removed along with them, or else the resource instances, in order.
+func stateRmKeyPatternMatches(state *states.State, raw string) (modules []addrs.ModuleInstance, instances []addrs.AbsResourceInstance, isModule bool, err error) {
+	results, err := (&states.Filter{State: state}).Filter(raw)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	_, isModule = parseModuleInstanceArg(stateRmKeyWildcards.Replace(raw))
+
+	if isModule {
+		for _, result := range results {
+			if ms, ok := result.Value.(*states.Module); ok && !ms.Addr.IsRoot() {
+				modules = append(modules, ms.Addr)
+			}
+		}
+		sort.Slice(modules, func(i, j int) bool {
+			return modules[i].Less(modules[j])
+		})
+		var outermost []addrs.ModuleInstance
+	Modules:
+		for _, modAddr := range modules {
+			for _, outer := range outermost {
+				if outer.IsAncestor(modAddr) {
+					continue Modules
+				}
+			}
+			outermost = append(outermost, modAddr)
+		}
+		return outermost, nil, true, nil
+	}
+	return nil, stateFilterResultInstances(results), false, nil
+}
+
+// stateFilterResultInstances returns the addresses of the resource instances
+// among the given results of states.Filter, in order.
+func stateFilterResultInstances(results []*states.FilterResult) []addrs.AbsResourceInstance {
+	var ret []addrs.AbsResourceInstance
+	for _, result := range results {
+		if _, ok := result.Value.(*states.ResourceInstance); !ok {
+			continue
+		}
+		addr, diags := addrs.ParseAbsResourceInstanceStr(result.Address)
+		if diags.HasErrors() {
+			continue
+		}
+		ret = append(ret, addr)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Less(ret[j])
+	})
+	return ret
+}
@@ -0,0 +1,421 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/backend"
+	backendlocal "github.com/hashicorp/terraform/backend/local"
+	"github.com/hashicorp/terraform/command/clistate"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// partitionByWorkspace reports, for each of the given addresses, which of the
+// workspaces in the configured backend have resource instances matching it
+// and how many, for the -partition-by-workspace option. Nothing is changed
+// in any of the workspaces.
+func (c *StateRmCommand) partitionByWorkspace(rawAddrs []string) int {
+	var diags tfdiags.Diagnostics
+
+	diags = diags.Append(c.checkSelectorArgs(rawAddrs))
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	b, backendDiags := c.Backend(nil)
+	diags = diags.Append(backendDiags)
+	if backendDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	workspaces, moreDiags := backendWorkspaces(b)
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	// counts[i][workspace] is the number of instances matching rawAddrs[i].
+	counts := make([]map[string]int, len(rawAddrs))
+	for i := range counts {
+		counts[i] = make(map[string]int)
+	}
+	for _, workspace := range workspaces {
+		stateMgr, err := b.StateMgr(workspace)
+		if err == nil {
+			err = stateMgr.RefreshState()
+		}
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to load state",
+				fmt.Sprintf("Could not read the state of the workspace %q: %s. Nothing has been changed.", workspace, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		state := stateMgr.State()
+		if state == nil {
+			continue
+		}
+		for i, rawAddr := range rawAddrs {
+			if n := len(stateRmSelectorInstances(state, rawAddr)); n > 0 {
+				counts[i][workspace] = n
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, rawAddr := range rawAddrs {
+		fmt.Fprintf(&buf, "%s:\n", rawAddr)
+		total := 0
+		for _, workspace := range workspaces {
+			if n, ok := counts[i][workspace]; ok {
+				fmt.Fprintf(&buf, "  %s: %d\n", workspace, n)
+				total += n
+			}
+		}
+		if len(counts[i]) == 0 {
+			buf.WriteString("  (not in any workspace)\n")
+			continue
+		}
+		fmt.Fprintf(&buf, "  %d resource instances in %d of %d workspaces\n", total, len(counts[i]), len(workspaces))
+	}
+	c.showDiagnostics(diags)
+	c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+	return 0
+}
+
+// removeFromWorkspaces removes the resource instances selected by the given
+// addresses from each of the workspaces in the configured backend whose name
+// matches the given glob pattern, as understood by path.Match, for the
+// -workspace-pattern option. With dryRun, it only reports what it would
+// remove. Otherwise, unless autoApprove is set, the user must confirm the
+// removal from all of the workspaces before any of them is changed.
+//
+// All of the matching workspaces are locked before any of them is changed,
+// so that a coordinated removal either starts with all of them or not at
+// all. Each workspace with a local state file gets its own timestamped
+// backup.
+func (c *StateRmCommand) removeFromWorkspaces(pattern string, rawAddrs []string, dryRun, autoApprove bool) int {
+	var diags tfdiags.Diagnostics
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid -workspace-pattern option",
+			fmt.Sprintf("The -workspace-pattern option must be a glob pattern such as \"dev-*\", but %q is not valid: %s.", pattern, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	diags = diags.Append(c.checkSelectorArgs(rawAddrs))
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	b, backendDiags := c.Backend(nil)
+	diags = diags.Append(backendDiags)
+	if backendDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	all, moreDiags := backendWorkspaces(b)
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	var workspaces []string
+	for _, workspace := range all {
+		if ok, _ := path.Match(pattern, workspace); ok {
+			workspaces = append(workspaces, workspace)
+		}
+	}
+	if len(workspaces) == 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No matching workspaces",
+			fmt.Sprintf("None of the %d workspaces in the backend match the pattern %q, so nothing has been removed.", len(all), pattern),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	// The backups are written alongside the local copy of each state, as
+	// they are for the current workspace.
+	localRaw, backendDiags := c.Backend(&BackendOpts{ForceLocal: true})
+	if backendDiags.HasErrors() {
+		diags = diags.Append(backendDiags)
+		c.showDiagnostics(diags)
+		return 1
+	}
+	localB, _ := localRaw.(*backendlocal.Local)
+
+	type target struct {
+		Workspace string
+		StateMgr  statemgr.Full
+		State     *states.State
+		Backup    string
+		Addrs     []addrs.AbsResourceInstance
+	}
+	targets := make([]*target, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		stateMgr, err := b.StateMgr(workspace)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to load state",
+				fmt.Sprintf("Could not load the state of the workspace %q: %s. Nothing has been changed.", workspace, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+		if !dryRun {
+			stateLocker := clistate.NewLocker(context.Background(), 0, c.Ui, c.Colorize())
+			if err := stateLocker.Lock(stateMgr, "state rm"); err != nil {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"Failed to lock state",
+					fmt.Sprintf("The -workspace-pattern option locks the state of every matching workspace before changing any of them, but the state of the workspace %q could not be locked: %s. Nothing has been changed.", workspace, err),
+				))
+				c.showDiagnostics(diags)
+				return 1
+			}
+			defer stateLocker.Unlock(nil)
+		}
+		if err := stateMgr.RefreshState(); err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to load state",
+				fmt.Sprintf("Could not read the state of the workspace %q: %s. Nothing has been changed.", workspace, err),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+
+		t := &target{Workspace: workspace, StateMgr: stateMgr, State: stateMgr.State()}
+		if t.State != nil {
+			seen := make(map[string]bool)
+			for _, rawAddr := range rawAddrs {
+				for _, addr := range stateRmSelectorInstances(t.State, rawAddr) {
+					if !seen[addr.String()] {
+						seen[addr.String()] = true
+						t.Addrs = append(t.Addrs, addr)
+					}
+				}
+			}
+		}
+		if fs, ok := stateMgr.(*statemgr.Filesystem); ok && localB != nil && !dryRun {
+			_, stateOutPath, _ := localB.StatePaths(workspace)
+			t.Backup = defaultStateBackupPath(stateOutPath)
+			fs.SetBackupPath(t.Backup)
+		}
+		targets = append(targets, t)
+	}
+
+	// As in a single workspace, addresses that match nothing in any of the
+	// workspaces are most likely mistyped or stale, so they fail with the
+	// same exit status before anything is asked or changed.
+	selected := 0
+	for _, t := range targets {
+		selected += len(t.Addrs)
+	}
+	if selected == 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Nothing selected for removal",
+			fmt.Sprintf("No matching resources found in the state of any of the %d workspaces matching the pattern %q, so nothing has been removed. Check whether the resources were renamed or removed, or whether the addresses are mistyped.", len(targets), pattern),
+		))
+		c.showDiagnostics(diags)
+		return stateRmEmptyExitStatus
+	}
+
+	if !dryRun && !autoApprove {
+		var list bytes.Buffer
+		for _, t := range targets {
+			if len(t.Addrs) == 0 {
+				continue
+			}
+			result, moreDiags := runStateRm(t.State, &stateRmOpts{Addrs: t.Addrs, DryRun: true})
+			diags = diags.Append(moreDiags)
+			if moreDiags.HasErrors() {
+				c.showDiagnostics(diags)
+				return 1
+			}
+			fmt.Fprintf(&list, "%s:\n", t.Workspace)
+			writeStateRmItems(&list, result.Items, "  ", "Would remove")
+		}
+		if list.Len() > 0 {
+			c.Ui.Output(fmt.Sprintf("The following items will be removed from the state of each of these workspaces. Terraform will forget these objects, but won't destroy them:\n\n%s", list.String()))
+			ok, moreDiags := c.confirmStateChange("Do you want to remove these items from all of these workspaces?")
+			diags = diags.Append(moreDiags)
+			if moreDiags.HasErrors() {
+				c.showDiagnostics(diags)
+				return 1
+			}
+			if !ok {
+				c.Ui.Output("Removal cancelled.")
+				return 0
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+	changed, total := 0, 0
+	for i, t := range targets {
+		if len(t.Addrs) == 0 {
+			fmt.Fprintf(&buf, "%s: no matching resource instances\n", t.Workspace)
+			continue
+		}
+		result, moreDiags := runStateRm(t.State, &stateRmOpts{Addrs: t.Addrs, DryRun: dryRun})
+		if !moreDiags.HasErrors() && !dryRun {
+			err := t.StateMgr.WriteState(t.State)
+			if err == nil {
+				err = t.StateMgr.PersistState()
+			}
+			if err != nil {
+				moreDiags = moreDiags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"Failed to persist state",
+					fmt.Sprintf(errStateRmPersist, err),
+				))
+			}
+		}
+		if moreDiags.HasErrors() {
+			c.Ui.Output(buf.String())
+			rest := make([]string, 0, len(targets)-i)
+			for _, t := range targets[i:] {
+				rest = append(rest, t.Workspace)
+			}
+			diags = diags.Append(moreDiags)
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Removal stopped",
+				fmt.Sprintf("Removing from the workspace %q failed, so the removal was stopped. The workspaces listed above as changed have been changed, and these have not:\n\n  %s", t.Workspace, strings.Join(rest, "\n  ")),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+
+		fmt.Fprintf(&buf, "%s:\n", t.Workspace)
+		writeStateRmItems(&buf, result.Items, "  ", verb)
+		if t.Backup != "" {
+			if _, err := os.Stat(t.Backup); err == nil {
+				fmt.Fprintf(&buf, "  Backup: %s\n", t.Backup)
+			}
+		}
+		changed++
+		total += len(result.Items)
+	}
+
+	if dryRun {
+		fmt.Fprintf(&buf, "\nWould've removed %d resource instances from %d of %d matching workspaces, without -dry-run.", total, changed, len(targets))
+	} else {
+		fmt.Fprintf(&buf, "\nRemoved %d resource instances from %d of %d matching workspaces.", total, changed, len(targets))
+	}
+	c.showDiagnostics(diags)
+	c.Ui.Output(buf.String())
+	return 0
+}
+
+// checkSelectorArgs checks that each of the given addresses can be used to
+// select resource instances with stateRmSelectorInstances, returning error
+// diagnostics for those that can't.
+func (c *StateRmCommand) checkSelectorArgs(rawAddrs []string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+	for i, rawAddr := range rawAddrs {
+		if strings.Contains(rawAddr, "*") {
+			continue
+		}
+		if _, ok := parseModuleInstanceArg(rawAddr); ok {
+			continue
+		}
+		_, moreDiags := c.parseResourceInstanceAddr(rawAddr, fmt.Sprintf("<address %d>", i+1))
+		diags = diags.Append(moreDiags)
+	}
+	return diags
+}
+
+// backendWorkspaces returns the names of the workspaces in the given backend,
+// in lexical order. A backend that doesn't support workspaces has only the
+// default one.
+func backendWorkspaces(b backend.Backend) ([]string, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	workspaces, err := b.Workspaces()
+	if err == backend.ErrWorkspacesNotSupported {
+		workspaces, err = []string{backend.DefaultStateName}, nil
+	}
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to list workspaces",
+			fmt.Sprintf("Could not list the workspaces in the backend: %s.", err),
+		))
+		return nil, diags
+	}
+	sort.Strings(workspaces)
+	return workspaces, diags
+}
+
+// stateRmSelectorInstances returns the addresses of the resource instances in
+// the given state selected by the given address, which can be a resource
+// instance, a resource, or a module instance, as for stateRmSelectorInState.
+// A resource without an instance key selects all of its instances, and an
+// address containing "*" is matched as a pattern against the address of
+// each resource instance in the state.
+func stateRmSelectorInstances(state *states.State, rawAddr string) []addrs.AbsResourceInstance {
+	if strings.Contains(rawAddr, "*") {
+		var ret []addrs.AbsResourceInstance
+		for _, addr := range stateAllResourceInstances(state) {
+			if stateRmWildcardMatch(rawAddr, addr.String()) {
+				ret = append(ret, addr)
+			}
+		}
+		return ret
+	}
+	if modAddr, ok := parseModuleInstanceArg(rawAddr); ok {
+		return stateModuleResourceInstances(state, modAddr)
+	}
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rawAddr)
+	if diags.HasErrors() {
+		return nil
+	}
+	if state.ResourceInstance(addr) != nil {
+		return []addrs.AbsResourceInstance{addr}
+	}
+	return stateResourceKeyedInstances(state, addr)
+}
+
+// stateRmWildcardMatch returns true if the given address matches the given
+// pattern, in which each "*" matches any sequence of characters, including
+// none. All other characters, including brackets, match only themselves.
+func stateRmWildcardMatch(pattern, addr string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(addr, parts[0]) {
+		return false
+	}
+	addr = addr[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(addr, part)
+		if i < 0 {
+			return false
+		}
+		addr = addr[i+len(part):]
+	}
+	return strings.HasSuffix(addr, parts[len(parts)-1])
+}
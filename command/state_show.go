@@ -1,13 +1,25 @@
 package command
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/mitchellh/cli"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/config/hcl2shim"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
 )
 
-// StateShowCommand is a Command implementation that shows a single resource.
+// StateShowCommand is a Command implementation that shows one or more
+// resource instances.
 type StateShowCommand struct {
 	Meta
 	StateMeta
@@ -20,12 +32,117 @@ func (c *StateShowCommand) Run(args []string) int {
 	}
 
 	cmdFlags := c.Meta.flagSet("state show")
+	var jsonOutput, rawOutput, all, showSchemaVersion, history, compareConfig, explainDeps, flatten, providersRequired, resourceOnly, neighbors bool
+	var historyLimit int
+	var onMissing, diffAgainst, attrPath, outputTo string
+	var redactPaths []string
+	var followDepth stateShowDepthFlag
+	cmdFlags.BoolVar(&jsonOutput, "json", false, "json")
+	cmdFlags.BoolVar(&all, "all", false, "show every resource instance in the state")
+	cmdFlags.BoolVar(&rawOutput, "raw", false, "print the stored attributes verbatim")
+	cmdFlags.Var((*FlagStringSlice)(&redactPaths), "redact", "attribute path whose value should be hidden")
+	cmdFlags.StringVar(&onMissing, "on-missing", "ignore", "what to do with addresses not in the state")
+	cmdFlags.StringVar(&diffAgainst, "diff-against", "", "path of a state file to compare with")
+	cmdFlags.StringVar(&attrPath, "attr", "", "path of a single attribute to print")
+	cmdFlags.Var(&followDepth, "follow-dependencies", "also show dependencies, to the given depth")
+	cmdFlags.BoolVar(&showSchemaVersion, "show-schema-version", false, "show the schema version of each instance")
+	cmdFlags.BoolVar(&history, "history", false, "show how the instance changed over the backups of the state")
+	cmdFlags.IntVar(&historyLimit, "history-limit", 0, "number of the most recent backups to use with -history")
+	cmdFlags.BoolVar(&compareConfig, "compare-config", false, "show how a plan would change the instance to match the configuration")
+	cmdFlags.BoolVar(&explainDeps, "explain-dependencies", false, "show the status of each dependency of the instance")
+	cmdFlags.BoolVar(&neighbors, "neighbors", false, "show the instances the instance depends on and those that depend on it")
+	cmdFlags.StringVar(&outputTo, "output-to", "", "path of a file to write the output to")
+	cmdFlags.BoolVar(&flatten, "flatten", false, "print each value on its own line under its full path, in lexical order")
+	cmdFlags.BoolVar(&providersRequired, "providers-required", false, "show the providers needed to manage the matching objects")
+	cmdFlags.BoolVar(&resourceOnly, "resource-only", false, "show whole resources, summarizing the instances of those with several")
 	cmdFlags.StringVar(&c.Meta.statePath, "state", DefaultStateFilename, "path")
 	if err := cmdFlags.Parse(args); err != nil {
 		return cli.RunResultHelp
 	}
 	args = cmdFlags.Args()
 
+	if all && (len(args) > 0 || diffAgainst != "" || attrPath != "") {
+		c.Ui.Error("The -all option shows every resource instance in the state, so it can't be used with any addresses, or with -diff-against or -attr.")
+		return 1
+	}
+	if len(args) < 1 && !all {
+		c.Ui.Error("At least one resource address is required.")
+		return 1
+	}
+	if rawOutput && (jsonOutput || len(redactPaths) > 0) {
+		c.Ui.Error("The -raw option can't be used with -json or -redact, because it shows the attributes exactly as stored.")
+		return 1
+	}
+	if diffAgainst != "" && (len(args) != 1 || jsonOutput || rawOutput) {
+		c.Ui.Error("The -diff-against option compares a single resource instance, so it requires exactly one address and can't be used with -json or -raw.")
+		return 1
+	}
+	if attrPath != "" && (len(args) != 1 || jsonOutput || rawOutput || diffAgainst != "" || len(redactPaths) > 0) {
+		c.Ui.Error("The -attr option prints a single value of a single resource instance, so it requires exactly one address and can't be used with -json, -raw, -diff-against, or -redact.")
+		return 1
+	}
+	if followDepth > 0 && (rawOutput || diffAgainst != "" || attrPath != "") {
+		c.Ui.Error("The -follow-dependencies option shows the dependencies of each instance along with its attributes, so it can't be used with -raw, -diff-against, or -attr.")
+		return 1
+	}
+	if showSchemaVersion && (rawOutput || diffAgainst != "" || attrPath != "") {
+		c.Ui.Error("The -show-schema-version option shows the schema version of each instance along with its attributes, so it can't be used with -raw, -diff-against, or -attr.")
+		return 1
+	}
+	if history && (len(args) != 1 || all || jsonOutput || rawOutput || diffAgainst != "" || attrPath != "" || followDepth > 0 || showSchemaVersion) {
+		c.Ui.Error("The -history option shows how a single resource instance changed over the backups of the state, so it requires exactly one address and can't be used with -all, -json, -raw, -diff-against, -attr, -follow-dependencies, or -show-schema-version.")
+		return 1
+	}
+	if compareConfig && (len(args) != 1 || all || jsonOutput || rawOutput || diffAgainst != "" || attrPath != "" || followDepth > 0 || showSchemaVersion || history) {
+		c.Ui.Error("The -compare-config option shows how a plan would change a single resource instance, so it requires exactly one address and can't be used with -all, -json, -raw, -diff-against, -attr, -follow-dependencies, -show-schema-version, or -history.")
+		return 1
+	}
+	if explainDeps && (len(args) != 1 || all || rawOutput || diffAgainst != "" || attrPath != "" || followDepth > 0 || showSchemaVersion || history || compareConfig || len(redactPaths) > 0) {
+		c.Ui.Error("The -explain-dependencies option shows the dependencies of a single resource instance instead of its attributes, so it requires exactly one address and can only be used with -json and -output-to.")
+		return 1
+	}
+	if flatten && (jsonOutput || rawOutput || diffAgainst != "" || attrPath != "" || followDepth > 0 || history || compareConfig || explainDeps) {
+		c.Ui.Error("The -flatten option changes how the attributes of each instance are printed as text, so it can't be used with -json, -raw, -diff-against, -attr, -follow-dependencies, -history, -compare-config, or -explain-dependencies.")
+		return 1
+	}
+	if providersRequired && (rawOutput || diffAgainst != "" || attrPath != "" || followDepth > 0 || showSchemaVersion || history || compareConfig || explainDeps || flatten || len(redactPaths) > 0) {
+		c.Ui.Error("The -providers-required option shows the providers needed to manage the matching objects instead of their attributes, so it can only be used with -all, -json, and -output-to.")
+		return 1
+	}
+	if resourceOnly && (all || jsonOutput || rawOutput || diffAgainst != "" || attrPath != "" || followDepth > 0 || showSchemaVersion || history || compareConfig || explainDeps || flatten || providersRequired) {
+		c.Ui.Error("The -resource-only option shows each of the given resources as a whole, so it can only be used with resource addresses, -redact, -on-missing, and -output-to.")
+		return 1
+	}
+	if neighbors && (len(args) != 1 || all || rawOutput || diffAgainst != "" || attrPath != "" || followDepth > 0 || showSchemaVersion || history || compareConfig || explainDeps || flatten || providersRequired || resourceOnly || len(redactPaths) > 0) {
+		c.Ui.Error("The -neighbors option shows the instances around a single resource instance instead of its attributes, so it requires exactly one address and can only be used with -json and -output-to.")
+		return 1
+	}
+	if historyLimit < 0 || (historyLimit > 0 && !history) {
+		c.Ui.Error("The -history-limit option must be a positive number of backups, and can only be used with -history.")
+		return 1
+	}
+	switch onMissing {
+	case "ignore", "warn", "error":
+	default:
+		c.Ui.Error(fmt.Sprintf("The -on-missing option must be \"ignore\", \"warn\", or \"error\", not %q.", onMissing))
+		return 1
+	}
+
+	// The output file is created before the state is read, so that a path
+	// that can't be written fails before anything is rendered. Diagnostics
+	// still go to the UI.
+	if outputTo != "" {
+		f, err := os.Create(outputTo)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to create %s for the -output-to option: %s", outputTo, err))
+			return 1
+		}
+		defer f.Close()
+		realUi := c.Meta.Ui
+		c.Meta.Ui = &stateRmOutputFileUi{Ui: realUi, w: f}
+		defer func() { c.Meta.Ui = realUi }()
+	}
+
 	// Load the backend
 	b, backendDiags := c.Backend(nil)
 	if backendDiags.HasErrors() {
@@ -51,69 +168,808 @@ func (c *StateShowCommand) Run(args []string) int {
 		return 1
 	}
 
-	c.Ui.Error("state show not yet updated for new state types")
-	return 1
+	if diffAgainst != "" {
+		return c.showDiff(stateReal, args[0], diffAgainst, redactPaths)
+	}
 
-	/*
-		filter := &terraform.StateFilter{State: stateReal}
-		results, err := filter.Filter(args...)
-		if err != nil {
-			c.Ui.Error(fmt.Sprintf(errStateFilter, err))
-			return 1
+	if attrPath != "" {
+		return c.showAttr(stateReal, args[0], attrPath)
+	}
+
+	if history {
+		return c.showHistory(stateReal, args[0], historyLimit, redactPaths)
+	}
+
+	if compareConfig {
+		return c.showCompareConfig(stateReal, args[0], redactPaths)
+	}
+
+	if explainDeps {
+		return c.showExplainDependencies(stateReal, args[0], jsonOutput)
+	}
+
+	if neighbors {
+		return c.showNeighbors(stateReal, args[0], jsonOutput)
+	}
+
+	if providersRequired {
+		return c.showProvidersRequired(stateReal, args, jsonOutput)
+	}
+
+	if resourceOnly {
+		return c.showResourceOnly(stateReal, args, redactPaths, onMissing)
+	}
+
+	var diags tfdiags.Diagnostics
+	var shown []stateShowInstance
+	if all {
+		// Instances with only deposed objects have nothing to show, as for
+		// an address given explicitly.
+		for _, addr := range stateAllResourceInstances(stateReal) {
+			if is := stateReal.ResourceInstance(addr); is.Current != nil {
+				shown = append(shown, stateShowInstance{
+					Addr:   addr,
+					Object: is.Current,
+				})
+			}
+		}
+	}
+	for i, rawAddr := range args {
+		addr, moreDiags := c.parseResourceInstanceAddr(rawAddr, fmt.Sprintf("<address %d>", i+1))
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			continue
+		}
+
+		is := stateReal.ResourceInstance(addr)
+		if is == nil || is.Current == nil {
+			switch onMissing {
+			case "error":
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"No such resource instance in state",
+					fmt.Sprintf("There is no resource instance in the current state with the address %s.", addr),
+				))
+			case "warn":
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Warning,
+					"No such resource instance in state",
+					fmt.Sprintf("There is no resource instance in the current state with the address %s, so it has been skipped.", addr),
+				))
+			}
+			continue
 		}
 
-		if len(results) == 0 {
-			return 0
+		shown = append(shown, stateShowInstance{
+			Addr:   addr,
+			Object: is.Current,
+		})
+	}
+
+	// Each instance is shown under a heading giving its address if there
+	// could be more than one of them.
+	several := len(args) > 1 || all
+
+	// dependencies[i] are the dependencies of shown[i] to show with
+	// -follow-dependencies, if any.
+	dependencies := make([][]*stateShowDependency, len(shown))
+	if followDepth > 0 {
+		for i, inst := range shown {
+			dependencies[i] = stateShowDependencyTree(stateReal, inst.Addr, int(followDepth))
+		}
+	}
+
+	// providerVersions are the current schema versions of the resource
+	// types of the shown instances, by address, for -show-schema-version.
+	var providerVersions map[string]uint64
+	if showSchemaVersion {
+		var moreDiags tfdiags.Diagnostics
+		providerVersions, moreDiags = c.providerSchemaVersions(stateReal, shown)
+		diags = diags.Append(moreDiags)
+	}
+
+	if rawOutput {
+		for i, inst := range shown {
+			var buf bytes.Buffer
+			if inst.Object.AttrsJSON != nil {
+				if err := json.Indent(&buf, inst.Object.AttrsJSON, "", "  "); err != nil {
+					diags = diags.Append(tfdiags.Sourceless(
+						tfdiags.Error,
+						"Invalid resource instance object",
+						fmt.Sprintf("The attributes of %s in the state are not valid JSON: %s.", inst.Addr, err),
+					))
+					continue
+				}
+			} else {
+				// There are no JSON attributes to show, so the nearest we
+				// can get is the flatmap attributes as they are stored.
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Warning,
+					"Legacy attributes format",
+					fmt.Sprintf("The attributes of %s are stored in the legacy flatmap format, so they are shown as a map of flatmap keys to values.", inst.Addr),
+				))
+				src, err := json.MarshalIndent(inst.Object.AttrsFlat, "", "  ")
+				if err != nil {
+					diags = diags.Append(err)
+					continue
+				}
+				buf.Write(src)
+			}
+
+			var header string
+			if several {
+				if i > 0 {
+					header = "\n"
+				}
+				header += fmt.Sprintf("# %s:\n", inst.Addr)
+			}
+			c.Ui.Output(header + buf.String())
 		}
+	} else if jsonOutput {
+		out := make([]stateShowJSON, 0, len(shown))
+		for i, inst := range shown {
+			attrs, moreDiags := stateShowJSONAttrs(inst, redactPaths)
+			diags = diags.Append(moreDiags)
+			if moreDiags.HasErrors() {
+				continue
+			}
+			deps, moreDiags := stateShowDependenciesJSON(dependencies[i], redactPaths)
+			diags = diags.Append(moreDiags)
+			item := stateShowJSON{
+				Address:      inst.Addr.String(),
+				Attributes:   attrs,
+				Dependencies: deps,
+			}
+			if showSchemaVersion {
+				stored := inst.Object.SchemaVersion
+				item.SchemaVersion = &stored
+				if current, ok := providerVersions[inst.Addr.String()]; ok {
+					item.ProviderSchemaVersion = &current
+				}
+			}
+			out = append(out, item)
+		}
+		if !diags.HasErrors() {
+			src, err := json.MarshalIndent(out, "", "  ")
+			if err != nil {
+				diags = diags.Append(err)
+			} else {
+				c.Ui.Output(string(src))
+			}
+		}
+	} else {
+		for i, inst := range shown {
+			var text string
+			var moreDiags tfdiags.Diagnostics
+			if flatten {
+				text, moreDiags = stateShowFlattenText(inst, redactPaths)
+			} else {
+				text, moreDiags = stateShowText(inst, redactPaths)
+			}
+			diags = diags.Append(moreDiags)
+			if moreDiags.HasErrors() {
+				continue
+			}
+
+			// Only when showing several instances, or their dependencies,
+			// do we need to say which is which.
+			var header string
+			if several || followDepth > 0 {
+				if i > 0 {
+					header = "\n"
+				}
+				header += fmt.Sprintf("# %s:\n", inst.Addr)
+			}
+			if showSchemaVersion {
+				current, ok := providerVersions[inst.Addr.String()]
+				header += stateShowSchemaVersionLine(inst.Object.SchemaVersion, current, ok) + "\n"
+			}
+			c.Ui.Output(header + text)
+			diags = diags.Append(c.showDependencies(inst.Addr, dependencies[i], redactPaths))
+		}
+	}
+
+	c.showDiagnostics(diags)
+	if diags.HasErrors() {
+		return 1
+	}
+	return 0
+}
+
+// showDiff prints the differences between the attributes of the resource
+// instance with the given address in the state file at the given path and
+// in the given current state, for the -diff-against option, returning the
+// exit status for the command.
+func (c *StateShowCommand) showDiff(state *states.State, rawAddr, path string, redactPaths []string) int {
+	var diags tfdiags.Diagnostics
 
-		instance, err := c.filterInstance(results)
+	addr, moreDiags := c.parseResourceInstanceAddr(rawAddr, "<address 1>")
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	other, err := readStateFile(path)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to read state to compare",
+			fmt.Sprintf("Could not read the state file %s: %s.", path, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	var attrs [2]map[string]string
+	for i, s := range []struct {
+		State *states.State
+		Desc  string
+	}{
+		{other.State, "in the state file " + path},
+		{state, "in the current state"},
+	} {
+		is := s.State.ResourceInstance(addr)
+		if is == nil || is.Current == nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"No such resource instance in state",
+				fmt.Sprintf("There is no resource instance %s with the address %s, so it can't be compared.", s.Desc, addr),
+			))
+			continue
+		}
+		flat, err := stateShowFlatAttrs(is.Current)
 		if err != nil {
-			c.Ui.Error(err.Error())
-			return 1
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid resource instance object",
+				fmt.Sprintf("The attributes of %s %s could not be decoded: %s.", addr, s.Desc, err),
+			))
+			continue
 		}
+		attrs[i] = redactFlatAttrs(flat, redactPaths)
+	}
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	diff := stateShowFormatDiff(attrs[0], attrs[1])
+	if diff == "" {
+		c.Ui.Output(fmt.Sprintf("%s has no differences between %s and the current state.", addr, path))
+	} else {
+		c.Ui.Output(fmt.Sprintf("# %s: changes from %s to the current state\n%s", addr, path, diff))
+	}
+	c.showDiagnostics(diags)
+	return 0
+}
+
+// showAttr prints the value of the attribute at the given path of the
+// resource instance with the given address in the given state, for the -attr
+// option, returning the exit status for the command.
+func (c *StateShowCommand) showAttr(state *states.State, rawAddr, path string) int {
+	var diags tfdiags.Diagnostics
+
+	addr, moreDiags := c.parseResourceInstanceAddr(rawAddr, "<address 1>")
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	is := state.ResourceInstance(addr)
+	if is == nil || is.Current == nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No such resource instance in state",
+			fmt.Sprintf("There is no resource instance in the current state with the address %s.", addr),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
 
-		if instance == nil {
-			return 0
+	val, err := stateShowAttrValue(is.Current, path)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid attribute path",
+			fmt.Sprintf("Can't show the attribute %q of %s: %s.", path, addr, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	c.Ui.Output(val)
+	return 0
+}
+
+// stateShowAttrValue returns the value at the given attribute path of the
+// given object, for the -attr option. Steps of the path are separated by
+// dots, as for -redact, and an index can also be given in brackets, as in
+// "network_interface[0].address" or `tags["Name"]`.
+//
+// A string is returned as it is, with no quotes, so that it can be used
+// directly by a script, while a number, bool, or null is returned as JSON and
+// a list, map, or object as indented JSON.
+func stateShowAttrValue(obj *states.ResourceInstanceObjectSrc, path string) (string, error) {
+	steps, err := parseStateShowAttrPath(path)
+	if err != nil {
+		return "", err
+	}
+	val, err := stateShowAttrLookup(obj, steps)
+	if err != nil {
+		return "", err
+	}
+
+	switch tv := val.(type) {
+	case string:
+		return tv, nil
+	case map[string]interface{}, []interface{}:
+		src, err := json.MarshalIndent(tv, "", "  ")
+		return string(src), err
+	default:
+		src, err := json.Marshal(tv)
+		return string(src), err
+	}
+}
+
+// stateShowAttrLookup returns the value at the given steps of an attribute
+// path in the given object, decoded from JSON with numbers kept as
+// json.Number. A value from the legacy flatmap attributes is always a string.
+func stateShowAttrLookup(obj *states.ResourceInstanceObjectSrc, steps []string) (interface{}, error) {
+	if obj.AttrsJSON == nil {
+		// Objects from older state formats have only flatmap attributes,
+		// whose structure can't be recovered without the provider schema,
+		// so only a single flatmap value can be looked up.
+		if v, ok := obj.AttrsFlat[strings.Join(steps, ".")]; ok {
+			return v, nil
 		}
+		return nil, fmt.Errorf("there is no attribute at this path, and the attributes are stored in the legacy flatmap format so only a path to a single value can be used")
+	}
+
+	// Numbers are kept as they are stored, rather than converted to float64.
+	var val interface{}
+	dec := json.NewDecoder(bytes.NewReader(obj.AttrsJSON))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err != nil {
+		return nil, err
+	}
+	for i, step := range steps {
+		switch tv := val.(type) {
+		case map[string]interface{}:
+			next, ok := tv[step]
+			if !ok {
+				return nil, fmt.Errorf("there is no attribute %q at %s", step, stateShowAttrPathString(steps[:i]))
+			}
+			val = next
+		case []interface{}:
+			idx, err := strconv.Atoi(step)
+			if err != nil || idx < 0 || idx >= len(tv) {
+				return nil, fmt.Errorf("there is no element %q at %s, which has %d elements", step, stateShowAttrPathString(steps[:i]), len(tv))
+			}
+			val = tv[idx]
+		default:
+			return nil, fmt.Errorf("the value at %s has no attributes or elements", stateShowAttrPathString(steps[:i]))
+		}
+	}
+	return val, nil
+}
+
+// parseStateShowAttrPath splits the given attribute path into its steps,
+// accepting both "a.0.b" and "a[0].b", and `a["key"]` for a map key that
+// contains dots.
+func parseStateShowAttrPath(path string) ([]string, error) {
+	var steps []string
+	rest := path
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if strings.HasPrefix(rest, `["`) {
+				end = strings.Index(rest, `"]`)
+				if end >= 0 {
+					key, err := strconv.Unquote(rest[1 : end+1])
+					if err != nil {
+						return nil, fmt.Errorf("invalid key %s in path", rest[1:end+1])
+					}
+					steps = append(steps, key)
+					rest = rest[end+2:]
+					break
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket in path")
+			}
+			steps = append(steps, rest[1:end])
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "."):
+			if len(steps) == 0 || rest == "." {
+				return nil, fmt.Errorf("empty step in path")
+			}
+			rest = rest[1:]
+			if strings.HasPrefix(rest, ".") {
+				return nil, fmt.Errorf("empty step in path")
+			}
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			steps = append(steps, rest[:end])
+			rest = rest[end:]
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return steps, nil
+}
+
+// stateShowAttrPathString returns the given steps of an attribute path for
+// use in error messages.
+func stateShowAttrPathString(steps []string) string {
+	if len(steps) == 0 {
+		return "the top level"
+	}
+	return strings.Join(steps, ".")
+}
 
-		is := instance.Value.(*terraform.InstanceState)
+// stateShowFormatDiff formats the differences between the given flatmap
+// attributes from before and after as aligned lines, with "~" for an
+// attribute whose value changed, "+" for one only in after, and "-" for one
+// only in before, ordered as in stateShowFormatAttrs. It returns an empty
+// string if there are no differences.
+func stateShowFormatDiff(before, after map[string]string) string {
+	changed := make(map[string]bool)
+	for k, v := range before {
+		if av, ok := after[k]; !ok || av != v {
+			changed[k] = true
+		}
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			changed[k] = true
+		}
+	}
 
-		// Sort the keys
-		var keys []string
-		for k, _ := range is.Attributes {
+	var keys []string
+	width := 0
+	for k := range changed {
+		if k != "id" {
 			keys = append(keys, k)
 		}
-		sort.Strings(keys)
+		if len(k) > width {
+			width = len(k)
+		}
+	}
+	sort.Strings(keys)
+	if changed["id"] {
+		keys = append([]string{"id"}, keys...)
+	}
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		bv, inBefore := before[k]
+		av, inAfter := after[k]
+		switch {
+		case !inBefore:
+			fmt.Fprintf(&buf, "+ %-*s = %s\n", width, k, av)
+		case !inAfter:
+			fmt.Fprintf(&buf, "- %-*s = %s\n", width, k, bv)
+		default:
+			fmt.Fprintf(&buf, "~ %-*s = %s -> %s\n", width, k, bv, av)
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// stateShowInstance is a resource instance object selected for display by
+// "terraform state show".
+type stateShowInstance struct {
+	Addr   addrs.AbsResourceInstance
+	Object *states.ResourceInstanceObjectSrc
+}
+
+// stateShowJSON is the JSON representation of a single resource instance
+// shown by "terraform state show -json".
+type stateShowJSON struct {
+	Address    string          `json:"address"`
+	Attributes json.RawMessage `json:"attributes"`
+
+	// Dependencies are the instances that this one depends on, shown with
+	// -follow-dependencies, each with its own dependencies nested in turn.
+	Dependencies []stateShowJSON `json:"dependencies,omitempty"`
+
+	// SchemaVersion is the schema version the object was saved with, and
+	// ProviderSchemaVersion the current schema version of its resource type
+	// in the provider, if it's installed, both shown with
+	// -show-schema-version.
+	SchemaVersion         *uint64 `json:"schema_version,omitempty"`
+	ProviderSchemaVersion *uint64 `json:"provider_schema_version,omitempty"`
+}
+
+// stateShowJSONAttrs returns the attributes of the given instance as JSON,
+// with the values at the given paths redacted.
+func stateShowJSONAttrs(inst stateShowInstance, redactPaths []string) (json.RawMessage, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	if inst.Object.AttrsJSON == nil {
+		// Objects from older state formats have only flatmap attributes,
+		// which we'll show as a flat JSON object.
+		src, err := json.Marshal(redactFlatAttrs(inst.Object.AttrsFlat, redactPaths))
+		if err != nil {
+			diags = diags.Append(err)
+			return nil, diags
+		}
+		return json.RawMessage(src), diags
+	}
+	if len(redactPaths) > 0 {
+		src, err := redactJSONAttrs(inst.Object.AttrsJSON, redactPaths)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid resource instance object",
+				fmt.Sprintf("The attributes of %s in the state could not be decoded: %s.", inst.Addr, err),
+			))
+			return nil, diags
+		}
+		return json.RawMessage(src), diags
+	}
+	return json.RawMessage(inst.Object.AttrsJSON), diags
+}
+
+// stateShowText returns the attributes of the given instance formatted as by
+// stateShowFormatAttrs, with the values at the given paths redacted.
+func stateShowText(inst stateShowInstance, redactPaths []string) (string, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
 
-		// Build the output
-		var output []string
-		output = append(output, fmt.Sprintf("id | %s", is.ID))
-		for _, k := range keys {
-			if k != "id" {
-				output = append(output, fmt.Sprintf("%s | %s", k, is.Attributes[k]))
+	attrs, err := stateShowFlatAttrs(inst.Object)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid resource instance object",
+			fmt.Sprintf("The attributes of %s in the state could not be decoded: %s.", inst.Addr, err),
+		))
+		return "", diags
+	}
+	return stateShowFormatAttrs(redactFlatAttrs(attrs, redactPaths)), diags
+}
+
+// stateShowFlatAttrs returns the attributes of the given object in the
+// legacy flatmap format, which is how "terraform state show" displays them.
+//
+// The provider schema isn't needed for this, since the structure of the
+// attributes can be inferred from their JSON representation.
+func stateShowFlatAttrs(obj *states.ResourceInstanceObjectSrc) (map[string]string, error) {
+	if obj.AttrsJSON == nil {
+		return obj.AttrsFlat, nil
+	}
+
+	ty, err := ctyjson.ImpliedType(obj.AttrsJSON)
+	if err != nil {
+		return nil, err
+	}
+	if !ty.IsObjectType() {
+		return nil, fmt.Errorf("attributes must be a JSON object")
+	}
+	val, err := ctyjson.Unmarshal(obj.AttrsJSON, ty)
+	if err != nil {
+		return nil, err
+	}
+	return hcl2shim.FlatmapValueFromHCL2(val), nil
+}
+
+// stateShowRedacted replaces the values of attributes given to -redact.
+const stateShowRedacted = "<redacted>"
+
+// redactFlatAttrs returns a copy of the given flatmap attributes with the
+// value at each of the given paths, such as "credentials.0.secret", replaced
+// by stateShowRedacted. A path to a list, set, or map redacts all of the
+// values within it, but keeps their count so the structure is still visible.
+func redactFlatAttrs(attrs map[string]string, paths []string) map[string]string {
+	if len(paths) == 0 {
+		return attrs
+	}
+
+	ret := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		ret[k] = v
+		for _, path := range paths {
+			if k == path || (strings.HasPrefix(k, path+".") && !strings.HasSuffix(k, ".#") && !strings.HasSuffix(k, ".%")) {
+				ret[k] = stateShowRedacted
+				break
 			}
 		}
+	}
+	return ret
+}
+
+// redactJSONAttrs returns the given JSON attributes with the value at each of
+// the given paths replaced by stateShowRedacted, using the same paths as
+// redactFlatAttrs. Each step of a path is either an object attribute name or
+// a list index. Paths that don't exist in the attributes are ignored.
+func redactJSONAttrs(src []byte, paths []string) ([]byte, error) {
+	var val interface{}
+	if err := json.Unmarshal(src, &val); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		val = redactJSONPath(val, strings.Split(path, "."))
+	}
+	return json.Marshal(val)
+}
 
-		// Output
-		config := columnize.DefaultConfig()
-		config.Glue = " = "
-		c.Ui.Output(columnize.Format(output, config))
-		return 0
-	*/
+func redactJSONPath(val interface{}, steps []string) interface{} {
+	if len(steps) == 0 {
+		return stateShowRedacted
+	}
+
+	switch tv := val.(type) {
+	case map[string]interface{}:
+		if next, ok := tv[steps[0]]; ok {
+			tv[steps[0]] = redactJSONPath(next, steps[1:])
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(steps[0]); err == nil && i >= 0 && i < len(tv) {
+			tv[i] = redactJSONPath(tv[i], steps[1:])
+		}
+	}
+	return val
+}
+
+// stateShowFormatAttrs formats the given flatmap attributes as aligned
+// "key = value" lines, with the id attribute first and then the others in
+// lexical order.
+func stateShowFormatAttrs(attrs map[string]string) string {
+	var keys []string
+	for k := range attrs {
+		if k != "id" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	if _, ok := attrs["id"]; ok {
+		keys = append([]string{"id"}, keys...)
+	}
+
+	width := 0
+	for _, k := range keys {
+		if len(k) > width {
+			width = len(k)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%-*s = %s\n", width, k, attrs[k])
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
 }
 
 func (c *StateShowCommand) Help() string {
 	helpText := `
-Usage: terraform state show [options] ADDRESS
+Usage: terraform state show [options] ADDRESS...
+       terraform state show [options] -all
 
-  Shows the attributes of a resource in the Terraform state.
+  Shows the attributes of resources in the Terraform state.
 
-  This command shows the attributes of a single resource in the Terraform
-  state. The address argument must be used to specify a single resource.
-  You can view the list of available resources with "terraform state list".
+  This command shows the attributes of one or more resource instances in the
+  Terraform state. Each address argument must be the address of a single
+  resource instance. When more than one address is given, each instance is
+  shown in turn under a heading giving its address. You can view the list of
+  available resources with "terraform state list".
 
 Options:
 
+  -all                Show every resource instance in the state instead of
+                      the instances at the given addresses, each under a
+                      heading giving its address, in the same order as
+                      "terraform state list". This can be used with -json,
+                      -raw, and -redact.
+
+  -attr=PATH          Print only the value of the attribute at PATH, such as
+                      "arn", "tags.Name", or "network_interface[0].address",
+                      with no other formatting, so that it can be used by a
+                      script. A string is printed without quotes, and a
+                      list, map, or object as JSON. Only one address can be
+                      given, and the command fails if the path doesn't
+                      exist.
+
+  -compare-config     Instead of showing the attributes of the instance, plan
+                      the configuration in the current directory for this
+                      instance alone and show how the plan would change its
+                      attributes to match the configuration, without
+                      refreshing it first. Only one address can be given.
+                      This can be used with -redact.
+
+  -diff-against=PATH  Instead of showing the attributes of the instance, show
+                      how they differ between the state file at PATH and the
+                      current state. Only one address can be given.
+
+  -explain-dependencies
+                      Instead of showing the attributes of the instance, list
+                      each dependency recorded for it in the state with the
+                      status of each instance it refers to, or "not in the
+                      state" if there are none. Only one address can be
+                      given. This can be used with -json.
+
+  -follow-dependencies[=DEPTH]
+                      After each instance, also show the instances it
+                      depends on according to the state, and the instances
+                      those depend on, up to DEPTH levels away. The default
+                      depth is 1. Each dependency is shown under a heading
+                      naming the instance that depends on it. With -json,
+                      the dependencies of each instance are nested in a
+                      "dependencies" array.
+
+  -flatten            Print each value of the attributes on its own line as
+                      "path = value", such as
+                      "root_block_device.0.volume_size = 20", in lexical
+                      order of the paths and without aligning the values,
+                      which is easier to grep and diff. This can be used
+                      with -all and -redact.
+
+  -history            Instead of showing the attributes of the instance, show
+                      how they changed over the timestamped backups of the
+                      state written by state commands, oldest first, and
+                      then in the current state. Only one address can be
+                      given. This can be used with -redact.
+
+  -history-limit=N    With -history, use only the N most recent backups.
+
+  -json               If specified, the instances are shown as a JSON array
+                      of objects, each with the address and attributes of
+                      one instance.
+
+  -neighbors          Instead of showing the attributes of the instance, list
+                      the instances in the state that it depends on, and
+                      those that depend on it and so would be left with a
+                      dependency on nothing if it were removed. Only one
+                      address can be given. With -json, these are the
+                      "depends_on" and "depended_on_by" arrays.
+
+  -on-missing=POLICY  What to do when an address doesn't match any resource
+                      instance in the state: "ignore" to skip it silently,
+                      "warn" to skip it with a warning, or "error" to show
+                      the other instances and then exit with an error.
+                      Defaults to "ignore".
+
+  -output-to=PATH     Write the output to the file at PATH instead of
+                      printing it, in the format chosen by the other
+                      options. Errors and warnings are still printed.
+
+  -providers-required Instead of showing the attributes, list each provider
+                      needed to manage the resources matching the addresses,
+                      which may be module or resource addresses, or with
+                      -all every resource, along with the provider
+                      configurations of it that they use. This can be used
+                      with -json.
+
+  -raw                Print the attributes of each instance exactly as they
+                      are stored in the state, as indented JSON and without
+                      using the provider schema. This can't be used with
+                      -json or -redact.
+
+  -redact=PATH        Show "<redacted>" instead of the value of the attribute
+                      at PATH, such as "credentials.0.secret". A path to a
+                      nested block or collection hides all of its values.
+                      Can be given more than once.
+
+  -resource-only      Take addresses of whole resources, without instance
+                      keys. A resource with a single instance is shown as
+                      that instance would be, and a resource with several
+                      is summarized with the id and status of each instance
+                      key instead of all of their attributes.
+
+  -show-schema-version
+                      Also show the schema version each instance was saved
+                      with and, if its provider is installed, the current
+                      schema version of its resource type, flagging any
+                      mismatch. An object saved with an older version will
+                      be upgraded by the provider in the next plan. With
+                      -json, these are the "schema_version" and
+                      "provider_schema_version" properties.
+
   -state=statefile    Path to a Terraform state file to use to look
                       up Terraform-managed resources. By default it will
                       use the state "terraform.tfstate" if it exists.
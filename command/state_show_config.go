@@ -0,0 +1,128 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/config/hcl2shim"
+	"github.com/hashicorp/terraform/plans"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateShowUnknownValue is shown in place of an attribute value that a plan
+// can't know until it is applied.
+const stateShowUnknownValue = "(known after apply)"
+
+// showCompareConfig prints the differences between the attributes of the
+// resource instance with the given address in the given state and those
+// that the configuration in the current working directory would give it, for
+// the -compare-config option, returning the exit status for the command.
+//
+// The differences are found by a plan targeting only the one instance, so
+// they are exactly what "terraform plan -target" would propose for it,
+// without refreshing it first.
+func (c *StateShowCommand) showCompareConfig(state *states.State, rawAddr string, redactPaths []string) int {
+	var diags tfdiags.Diagnostics
+
+	addr, moreDiags := c.parseResourceInstanceAddr(rawAddr, "<address 1>")
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	rs := state.Resource(addr.ContainingResource())
+	if is := state.ResourceInstance(addr); is == nil || is.Current == nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No such resource instance in state",
+			fmt.Sprintf("There is no resource instance in the current state with the address %s.", addr),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	config, configDiags := c.loadConfig(".")
+	diags = diags.Append(configDiags)
+	if configDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	plan, planDiags := c.planAgainstState(config, state, addr)
+	diags = diags.Append(planDiags)
+	if planDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	var change *plans.ResourceInstanceChangeSrc
+	for _, rc := range plan.Changes.Resources {
+		if rc.Addr.Equal(addr) && rc.DeposedKey == states.NotDeposed {
+			change = rc
+			break
+		}
+	}
+	if change == nil || change.Action == plans.NoOp {
+		c.showDiagnostics(diags)
+		c.Ui.Output(fmt.Sprintf("# %s: the configuration matches the state", addr))
+		return 0
+	}
+	if change.Action == plans.Delete {
+		c.showDiagnostics(diags)
+		c.Ui.Output(fmt.Sprintf("# %s: not in the configuration, so a plan would destroy it", addr))
+		return 0
+	}
+
+	// The planned values can only be decoded with the schema of the
+	// resource type, which the plan doesn't keep.
+	providerType := rs.ProviderConfig.ProviderConfig.Type
+	schemas, _, moreDiags := c.stateProviderSchemas([]string{providerType})
+	diags = diags.Append(moreDiags)
+	schema, ok := stateResourceTypeSchema(schemas[providerType], addr.Resource.Resource)
+	if !ok || schema.Block == nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Missing resource type schema",
+			fmt.Sprintf("The plan for %s can't be shown because provider.%s has no schema for %s.", addr, providerType, addr.Resource.Resource.Type),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	rc, err := change.Decode(schema.Block.ImpliedType())
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid planned change",
+			fmt.Sprintf("The planned change for %s could not be decoded: %s.", addr, err),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	rawBefore := hcl2shim.FlatmapValueFromHCL2(rc.Before)
+	rawAfter := hcl2shim.FlatmapValueFromHCL2(rc.After)
+	for k, v := range rawAfter {
+		if v == hcl2shim.UnknownVariableValue {
+			rawAfter[k] = stateShowUnknownValue
+		}
+	}
+	before := redactFlatAttrs(rawBefore, redactPaths)
+	after := redactFlatAttrs(rawAfter, redactPaths)
+	for k, v := range after {
+		// A redacted value that would change must still be listed, even
+		// though both sides of it look the same.
+		if bv, ok := before[k]; ok && v == stateShowRedacted && bv == v && rawBefore[k] != rawAfter[k] {
+			after[k] = stateShowRedacted + " (changed)"
+		}
+	}
+
+	c.showDiagnostics(diags)
+	diff := stateShowFormatDiff(before, after)
+	if diff == "" {
+		// The plan changed something that isn't visible as an attribute,
+		// such as the private data.
+		c.Ui.Output(fmt.Sprintf("# %s: a plan would %s it, with no changes to its attributes", addr, stateRmActionVerb(change.Action)))
+		return 0
+	}
+	c.Ui.Output(fmt.Sprintf("# %s: a plan would %s it to match the configuration\n%s", addr, stateRmActionVerb(change.Action), diff))
+	return 0
+}
@@ -0,0 +1,147 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateShowDepthFlag is the flag.Value for the -follow-dependencies option,
+// which can be given alone to follow dependencies one level deep or with a
+// depth, as in -follow-dependencies=2.
+type stateShowDepthFlag int
+
+func (f *stateShowDepthFlag) String() string {
+	return strconv.Itoa(int(*f))
+}
+
+func (f *stateShowDepthFlag) Set(raw string) error {
+	if raw == "true" {
+		*f = 1
+		return nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return fmt.Errorf("the depth must be a whole number greater than zero, not %q", raw)
+	}
+	*f = stateShowDepthFlag(n)
+	return nil
+}
+
+// IsBoolFlag allows the option to be given without a value.
+func (f *stateShowDepthFlag) IsBoolFlag() bool {
+	return true
+}
+
+// stateShowDependency is a resource instance shown by -follow-dependencies
+// because another shown instance depends on it.
+type stateShowDependency struct {
+	stateShowInstance
+
+	// Dependencies are the instances that this one depends on and that
+	// weren't already shown closer to the instance the tree starts from.
+	Dependencies []*stateShowDependency
+}
+
+// stateShowDependencyTree returns the resource instances in the given state
+// that the instance with the given address depends on, according to the
+// dependencies recorded in the state, each with the instances it depends on
+// in turn, up to the given depth. Each instance appears only once in the
+// tree, at the shallowest depth it is found, and instances without a current
+// object are left out.
+func stateShowDependencyTree(state *states.State, addr addrs.AbsResourceInstance, depth int) []*stateShowDependency {
+	root := &stateShowDependency{
+		stateShowInstance: stateShowInstance{
+			Addr:   addr,
+			Object: state.ResourceInstance(addr).Current,
+		},
+	}
+	seen := map[string]bool{addr.String(): true}
+	level := []*stateShowDependency{root}
+	for d := 0; d < depth && len(level) > 0; d++ {
+		var next []*stateShowDependency
+		for _, node := range level {
+			for _, depAddr := range stateInstanceDependencies(state, node.Addr.Module, node.Object) {
+				if seen[depAddr.String()] {
+					continue
+				}
+				seen[depAddr.String()] = true
+				dep := &stateShowDependency{
+					stateShowInstance: stateShowInstance{
+						Addr:   depAddr,
+						Object: state.ResourceInstance(depAddr).Current,
+					},
+				}
+				node.Dependencies = append(node.Dependencies, dep)
+				next = append(next, dep)
+			}
+		}
+		level = next
+	}
+	return root.Dependencies
+}
+
+// stateInstanceDependencies returns the addresses of the resource instances
+// with current objects in the given state that the given object, belonging
+// to the given module, depends on, in order.
+func stateInstanceDependencies(state *states.State, modAddr addrs.ModuleInstance, obj *states.ResourceInstanceObjectSrc) []addrs.AbsResourceInstance {
+	var ret []addrs.AbsResourceInstance
+	for _, addr := range stateAllResourceInstances(state) {
+		if state.ResourceInstance(addr).Current == nil {
+			continue
+		}
+		for _, dep := range obj.Dependencies {
+			if dependencyMatches(modAddr, dep, addr) {
+				ret = append(ret, addr)
+				break
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Less(ret[j])
+	})
+	return ret
+}
+
+// showDependencies prints each of the given dependencies of the instance with
+// the given address, and then their own dependencies, under headings naming
+// the instance that depends on each one.
+func (c *StateShowCommand) showDependencies(of addrs.AbsResourceInstance, deps []*stateShowDependency, redactPaths []string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+	for _, dep := range deps {
+		text, moreDiags := stateShowText(dep.stateShowInstance, redactPaths)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			continue
+		}
+		c.Ui.Output(fmt.Sprintf("\n# %s (dependency of %s):\n%s", dep.Addr, of, text))
+		diags = diags.Append(c.showDependencies(dep.Addr, dep.Dependencies, redactPaths))
+	}
+	return diags
+}
+
+// stateShowDependenciesJSON returns the JSON representation of the given
+// dependencies, with their own dependencies nested within each of them.
+func stateShowDependenciesJSON(deps []*stateShowDependency, redactPaths []string) ([]stateShowJSON, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	var ret []stateShowJSON
+	for _, dep := range deps {
+		attrs, moreDiags := stateShowJSONAttrs(dep.stateShowInstance, redactPaths)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			continue
+		}
+		nested, moreDiags := stateShowDependenciesJSON(dep.Dependencies, redactPaths)
+		diags = diags.Append(moreDiags)
+		ret = append(ret, stateShowJSON{
+			Address:      dep.Addr.String(),
+			Attributes:   attrs,
+			Dependencies: nested,
+		})
+	}
+	return ret, diags
+}
@@ -0,0 +1,166 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateShowExplainedDependency is a dependency recorded for a resource
+// instance, along with the instances in the state that it refers to, for the
+// -explain-dependencies option.
+type stateShowExplainedDependency struct {
+	// Ref is the dependency as recorded, relative to the module of the
+	// instance that depends on it.
+	Ref string
+
+	// Instances are the addresses of the instances in the state that the
+	// dependency refers to, in order, with the status of each. A dependency
+	// that refers to nothing is broken.
+	Instances []stateShowDependencyStatus
+}
+
+// stateShowDependencyStatus is the status of an instance that a dependency
+// refers to.
+type stateShowDependencyStatus struct {
+	Addr addrs.AbsResourceInstance
+
+	// Status is "ready" or "tainted" for the status of the current object,
+	// or "deposed only" if the instance has no current object.
+	Status  string
+	Deposed int
+}
+
+func (s stateShowDependencyStatus) String() string {
+	switch s.Deposed {
+	case 0:
+		return s.Status
+	case 1:
+		return s.Status + ", with 1 deposed object"
+	default:
+		return fmt.Sprintf("%s, with %d deposed objects", s.Status, s.Deposed)
+	}
+}
+
+// stateShowExplainDependencies returns each of the dependencies recorded for
+// the given current object of the instance with the given address, in the
+// order they are recorded, with the instances in the given state that each
+// refers to.
+func stateShowExplainDependencies(state *states.State, addr addrs.AbsResourceInstance, obj *states.ResourceInstanceObjectSrc) []*stateShowExplainedDependency {
+	all := stateAllResourceInstances(state)
+	ret := make([]*stateShowExplainedDependency, len(obj.Dependencies))
+	for i, ref := range obj.Dependencies {
+		dep := &stateShowExplainedDependency{Ref: ref.String()}
+		for _, other := range all {
+			if other.Equal(addr) || !dependencyMatches(addr.Module, ref, other) {
+				continue
+			}
+			is := state.ResourceInstance(other)
+			status := stateShowDependencyStatus{
+				Addr:    other,
+				Status:  "deposed only",
+				Deposed: len(is.Deposed),
+			}
+			if is.Current != nil {
+				status.Status = "ready"
+				if is.Current.Status == states.ObjectTainted {
+					status.Status = "tainted"
+				}
+			}
+			dep.Instances = append(dep.Instances, status)
+		}
+		ret[i] = dep
+	}
+	return ret
+}
+
+// stateShowExplainedDependencyJSON is the JSON representation of
+// stateShowExplainedDependency.
+type stateShowExplainedDependencyJSON struct {
+	Dependency string                          `json:"dependency"`
+	Instances  []stateShowDependencyStatusJSON `json:"instances"`
+}
+
+type stateShowDependencyStatusJSON struct {
+	Address      string `json:"address"`
+	Status       string `json:"status"`
+	DeposedCount int    `json:"deposed_count"`
+}
+
+// showExplainDependencies prints each of the dependencies recorded in the
+// given state for the resource instance with the given address, with the
+// status of each of the instances it refers to, for the -explain-dependencies
+// option, returning the exit status for the command. A dependency that refers
+// to nothing in the state is noted as such, since it may be why the instance
+// is planned differently than expected.
+func (c *StateShowCommand) showExplainDependencies(state *states.State, rawAddr string, jsonOutput bool) int {
+	var diags tfdiags.Diagnostics
+
+	addr, moreDiags := c.parseResourceInstanceAddr(rawAddr, "<address 1>")
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	is := state.ResourceInstance(addr)
+	if is == nil || is.Current == nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No such resource instance in state",
+			fmt.Sprintf("There is no resource instance in the current state with the address %s.", addr),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	deps := stateShowExplainDependencies(state, addr, is.Current)
+
+	if jsonOutput {
+		out := make([]stateShowExplainedDependencyJSON, len(deps))
+		for i, dep := range deps {
+			out[i] = stateShowExplainedDependencyJSON{
+				Dependency: dep.Ref,
+				Instances:  make([]stateShowDependencyStatusJSON, len(dep.Instances)),
+			}
+			for j, inst := range dep.Instances {
+				out[i].Instances[j] = stateShowDependencyStatusJSON{
+					Address:      inst.Addr.String(),
+					Status:       inst.Status,
+					DeposedCount: inst.Deposed,
+				}
+			}
+		}
+		src, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to marshal dependencies to JSON: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(src))
+		return 0
+	}
+
+	if len(deps) == 0 {
+		c.Ui.Output(fmt.Sprintf("# %s: no dependencies are recorded in the state", addr))
+		return 0
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s depends on:\n", addr)
+	for _, dep := range deps {
+		if len(dep.Instances) == 0 {
+			fmt.Fprintf(&buf, "%s: not in the state\n", dep.Ref)
+			continue
+		}
+		fmt.Fprintf(&buf, "%s:\n", dep.Ref)
+		for _, inst := range dep.Instances {
+			fmt.Fprintf(&buf, "  %s: %s\n", inst.Addr, inst)
+		}
+	}
+	c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+	return 0
+}
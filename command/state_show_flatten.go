@@ -0,0 +1,102 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateShowFlattenAttrs returns the attributes of the given instance for the
+// -flatten option, as a map from the dotted path of each value, such as
+// "root_block_device.0.volume_size", to the value.
+//
+// Unlike the flatmap attributes shown by default, there are no "#" or "%"
+// entries for the number of elements of each collection, since they change
+// along with every element and so clutter diffs. An empty collection is
+// instead shown as "[]" or "{}", so that it isn't lost, and null values are
+// left out as they are elsewhere. Attributes stored in the legacy flatmap
+// format have no structure to walk, so they are used as they are.
+func stateShowFlattenAttrs(inst stateShowInstance) (map[string]string, error) {
+	if inst.Object.AttrsJSON == nil {
+		return inst.Object.AttrsFlat, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(inst.Object.AttrsJSON))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return nil, err
+	}
+	obj, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("attributes must be a JSON object")
+	}
+
+	ret := make(map[string]string)
+	for k, v := range obj {
+		stateShowFlattenValue(ret, k, v)
+	}
+	return ret, nil
+}
+
+func stateShowFlattenValue(ret map[string]string, path string, val interface{}) {
+	switch tv := val.(type) {
+	case nil:
+	case map[string]interface{}:
+		if len(tv) == 0 {
+			ret[path] = "{}"
+		}
+		for k, v := range tv {
+			stateShowFlattenValue(ret, path+"."+k, v)
+		}
+	case []interface{}:
+		if len(tv) == 0 {
+			ret[path] = "[]"
+		}
+		for i, v := range tv {
+			stateShowFlattenValue(ret, path+"."+strconv.Itoa(i), v)
+		}
+	case bool:
+		ret[path] = strconv.FormatBool(tv)
+	case json.Number:
+		ret[path] = tv.String()
+	case string:
+		ret[path] = tv
+	}
+}
+
+// stateShowFlattenText returns the attributes of the given instance for the
+// -flatten option, with the values at the given paths redacted, as a
+// "path = value" line for each, in lexical order of the paths. The lines
+// aren't aligned, so that a change to one doesn't change the others.
+func stateShowFlattenText(inst stateShowInstance, redactPaths []string) (string, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	attrs, err := stateShowFlattenAttrs(inst)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid resource instance object",
+			fmt.Sprintf("The attributes of %s in the state could not be decoded: %s.", inst.Addr, err),
+		))
+		return "", diags
+	}
+	attrs = redactFlatAttrs(attrs, redactPaths)
+
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = k + " = " + attrs[k]
+	}
+	return strings.Join(lines, "\n"), diags
+}
@@ -0,0 +1,132 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	backendlocal "github.com/hashicorp/terraform/backend/local"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// showHistory prints how the attributes of the resource instance with the
+// given address changed over the timestamped backups of the state, oldest
+// first, and then its attributes in the given current state, for the -history
+// option, returning the exit status for the command. With a positive limit,
+// only that many of the most recent backups are used.
+//
+// The first snapshot the instance is in is shown in full and each one after
+// that as the changes from the one before, so that the output reads as a
+// history of the instance rather than a series of near-identical listings.
+func (c *StateShowCommand) showHistory(state *states.State, rawAddr string, limit int, redactPaths []string) int {
+	var diags tfdiags.Diagnostics
+
+	addr, moreDiags := c.parseResourceInstanceAddr(rawAddr, "<address 1>")
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	// The backups are written alongside the local copy of the state, which
+	// for a remote backend is its cache.
+	localRaw, backendDiags := c.Backend(&BackendOpts{ForceLocal: true})
+	if backendDiags.HasErrors() {
+		c.showDiagnostics(backendDiags)
+		return 1
+	}
+	localB, ok := localRaw.(*backendlocal.Local)
+	if !ok {
+		c.Ui.Error("The current backend doesn't keep a local copy of the state, so there are no backups to show the history from.")
+		return 1
+	}
+	_, stateOutPath, _ := localB.StatePaths(c.Workspace())
+
+	backups, err := timestampedStateBackups(stateOutPath)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to find backups of %s: %s", stateOutPath, err))
+		return 1
+	}
+	if limit > 0 && len(backups) > limit {
+		backups = backups[:limit]
+	}
+
+	// The snapshots are the backups, oldest first, followed by the current
+	// state.
+	type snapshot struct {
+		Desc  string
+		State *states.State
+	}
+	snapshots := make([]snapshot, 0, len(backups)+1)
+	for i := len(backups) - 1; i >= 0; i-- {
+		backup := backups[i]
+		f, err := readStateFile(backup.Path)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Failed to read backup",
+				fmt.Sprintf("Could not read the backup %s, so it has been skipped: %s.", backup.Path, err),
+			))
+			continue
+		}
+		snapshots = append(snapshots, snapshot{
+			Desc:  fmt.Sprintf("%s (%s)", backup.Path, time.Unix(backup.Time, 0).UTC().Format(time.RFC3339)),
+			State: f.State,
+		})
+	}
+	snapshots = append(snapshots, snapshot{Desc: "the current state", State: state})
+
+	var buf bytes.Buffer
+	var prev map[string]string
+	for i, s := range snapshots {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		is := s.State.ResourceInstance(addr)
+		if is == nil || is.Current == nil {
+			if prev != nil {
+				fmt.Fprintf(&buf, "# %s: removed in %s\n", addr, s.Desc)
+			} else {
+				fmt.Fprintf(&buf, "# %s: not in %s\n", addr, s.Desc)
+			}
+			prev = nil
+			continue
+		}
+		attrs, err := stateShowFlatAttrs(is.Current)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid resource instance object",
+				fmt.Sprintf("The attributes of %s in %s could not be decoded: %s.", addr, s.Desc, err),
+			))
+			continue
+		}
+		attrs = redactFlatAttrs(attrs, redactPaths)
+
+		switch diff := stateShowFormatDiff(prev, attrs); {
+		case prev == nil:
+			fmt.Fprintf(&buf, "# %s: in %s\n%s\n", addr, s.Desc, stateShowFormatAttrs(attrs))
+		case diff == "":
+			fmt.Fprintf(&buf, "# %s: no changes in %s\n", addr, s.Desc)
+		default:
+			fmt.Fprintf(&buf, "# %s: changes in %s\n%s\n", addr, s.Desc, diff)
+		}
+		prev = attrs
+	}
+	if len(backups) == 0 {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"No backups found",
+			fmt.Sprintf("There are no timestamped backups of %s, so only the current state is shown. Only the backups that state commands write by default, named like %s, are used by -history.", stateOutPath, defaultStateBackupPath(stateOutPath)),
+		))
+	}
+
+	c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+	c.showDiagnostics(diags)
+	if diags.HasErrors() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,127 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateShowNeighborsJSON is the JSON representation of the neighbors of a
+// resource instance, for the -neighbors option.
+type stateShowNeighborsJSON struct {
+	Address      string   `json:"address"`
+	DependsOn    []string `json:"depends_on"`
+	DependedOnBy []string `json:"depended_on_by"`
+}
+
+// stateShowNeighbors returns the resource instances in the given state that
+// the instance with the given address depends on, and those that depend on
+// it, each in order. The dependencies recorded for the deposed objects of an
+// instance count as well as those of its current object, since a deposed
+// object is still destroyed in the order they give.
+func stateShowNeighbors(state *states.State, addr addrs.AbsResourceInstance) (dependsOn, dependedOnBy []addrs.AbsResourceInstance) {
+	all := stateAllResourceInstances(state)
+	refs := stateShowObjectDependencies(state.ResourceInstance(addr))
+	for _, other := range all {
+		if other.Equal(addr) {
+			continue
+		}
+		for _, ref := range refs {
+			if dependencyMatches(addr.Module, ref, other) {
+				dependsOn = append(dependsOn, other)
+				break
+			}
+		}
+		for _, ref := range stateShowObjectDependencies(state.ResourceInstance(other)) {
+			if dependencyMatches(other.Module, ref, addr) {
+				dependedOnBy = append(dependedOnBy, other)
+				break
+			}
+		}
+	}
+	return dependsOn, dependedOnBy
+}
+
+// stateShowObjectDependencies returns the dependencies recorded for all of
+// the objects of the given resource instance.
+func stateShowObjectDependencies(is *states.ResourceInstance) []addrs.Referenceable {
+	var ret []addrs.Referenceable
+	if is.Current != nil {
+		ret = append(ret, is.Current.Dependencies...)
+	}
+	for _, obj := range is.Deposed {
+		ret = append(ret, obj.Dependencies...)
+	}
+	return ret
+}
+
+// showNeighbors prints the instances in the given state that the resource
+// instance with the given address depends on, and those that depend on it,
+// for the -neighbors option, returning the exit status for the command. The
+// instances that depend on it are those whose recorded dependencies would
+// refer to nothing once it is removed from the state.
+func (c *StateShowCommand) showNeighbors(state *states.State, rawAddr string, jsonOutput bool) int {
+	var diags tfdiags.Diagnostics
+
+	addr, moreDiags := c.parseResourceInstanceAddr(rawAddr, "<address 1>")
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	if state.ResourceInstance(addr) == nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No such resource instance in state",
+			fmt.Sprintf("There is no resource instance in the current state with the address %s.", addr),
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+	dependsOn, dependedOnBy := stateShowNeighbors(state, addr)
+
+	if jsonOutput {
+		out := stateShowNeighborsJSON{
+			Address:      addr.String(),
+			DependsOn:    make([]string, len(dependsOn)),
+			DependedOnBy: make([]string, len(dependedOnBy)),
+		}
+		for i, dep := range dependsOn {
+			out.DependsOn[i] = dep.String()
+		}
+		for i, dep := range dependedOnBy {
+			out.DependedOnBy[i] = dep.String()
+		}
+		src, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to marshal neighbors to JSON: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(src))
+		return 0
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s depends on:\n", addr)
+	writeStateShowNeighbors(&buf, dependsOn)
+	fmt.Fprintf(&buf, "\n# %s is depended on by:\n", addr)
+	writeStateShowNeighbors(&buf, dependedOnBy)
+	c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+	return 0
+}
+
+func writeStateShowNeighbors(buf *bytes.Buffer, instances []addrs.AbsResourceInstance) {
+	if len(instances) == 0 {
+		buf.WriteString("  (nothing in the state)\n")
+		return
+	}
+	for _, addr := range instances {
+		fmt.Fprintf(buf, "  %s\n", addr)
+	}
+}
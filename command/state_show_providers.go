@@ -0,0 +1,152 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// stateShowRequiredProvider is a provider needed to manage some of the
+// objects selected for the -providers-required option, with each of its
+// configurations that they use.
+type stateShowRequiredProvider struct {
+	// Type is the provider type, such as "aws". The state doesn't record
+	// where a provider is installed from, so this is all that identifies
+	// the provider itself.
+	Type string
+
+	// Configs are the addresses of the provider configurations, in lexical
+	// order.
+	Configs []string
+}
+
+// stateShowRequiredProviders returns the providers of the resources in the
+// given state that contain the resource instances matching any of the given
+// addresses, which may be addresses of modules, resources, or resource
+// instances, or of all of them if no addresses are given. The result is
+// ordered by provider type.
+func stateShowRequiredProviders(state *states.State, rawAddrs []string) ([]*stateShowRequiredProvider, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	byType := make(map[string]map[string]bool)
+	add := func(addr addrs.AbsResourceInstance) {
+		rs := state.Resource(addr.ContainingResource())
+		if rs == nil {
+			return
+		}
+		ty := rs.ProviderConfig.ProviderConfig.Type
+		if byType[ty] == nil {
+			byType[ty] = make(map[string]bool)
+		}
+		byType[ty][rs.ProviderConfig.String()] = true
+	}
+
+	if len(rawAddrs) == 0 {
+		for _, addr := range stateAllResourceInstances(state) {
+			add(addr)
+		}
+	}
+	for _, rawAddr := range rawAddrs {
+		filter := &states.Filter{State: state}
+		results, err := filter.Filter(rawAddr)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid address",
+				fmt.Sprintf(errStateFilter, err),
+			))
+			continue
+		}
+		matched := false
+		for _, result := range results {
+			if _, ok := result.Value.(*states.ResourceInstance); !ok {
+				continue
+			}
+			addr, moreDiags := addrs.ParseAbsResourceInstanceStr(result.Address)
+			if moreDiags.HasErrors() {
+				continue
+			}
+			add(addr)
+			matched = true
+		}
+		if !matched {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"No matching resource instances in state",
+				fmt.Sprintf("There are no resource instances in the current state matching %s.", rawAddr),
+			))
+		}
+	}
+
+	ret := make([]*stateShowRequiredProvider, 0, len(byType))
+	for ty, configs := range byType {
+		provider := &stateShowRequiredProvider{Type: ty}
+		for config := range configs {
+			provider.Configs = append(provider.Configs, config)
+		}
+		sort.Strings(provider.Configs)
+		ret = append(ret, provider)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Type < ret[j].Type
+	})
+	return ret, diags
+}
+
+// stateShowRequiredProviderJSON is the JSON representation of
+// stateShowRequiredProvider.
+type stateShowRequiredProviderJSON struct {
+	Provider       string   `json:"provider"`
+	Configurations []string `json:"configurations"`
+}
+
+// showProvidersRequired prints the providers needed to manage the objects
+// matching the given addresses in the given state, for the
+// -providers-required option, returning the exit status for the command.
+// Each provider type is printed once, followed by the configurations of it
+// that are used, indented.
+func (c *StateShowCommand) showProvidersRequired(state *states.State, rawAddrs []string, jsonOutput bool) int {
+	providers, diags := stateShowRequiredProviders(state, rawAddrs)
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	if jsonOutput {
+		out := make([]stateShowRequiredProviderJSON, len(providers))
+		for i, provider := range providers {
+			out[i] = stateShowRequiredProviderJSON{
+				Provider:       provider.Type,
+				Configurations: provider.Configs,
+			}
+		}
+		src, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to marshal providers to JSON: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(src))
+		return 0
+	}
+
+	if len(providers) == 0 {
+		c.Ui.Output("No providers are required.")
+		return 0
+	}
+
+	var buf bytes.Buffer
+	for _, provider := range providers {
+		fmt.Fprintf(&buf, "%s\n", provider.Type)
+		for _, config := range provider.Configs {
+			fmt.Fprintf(&buf, "  %s\n", config)
+		}
+	}
+	c.Ui.Output(strings.TrimSuffix(buf.String(), "\n"))
+	return 0
+}
@@ -0,0 +1,141 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// showResourceOnly prints each of the resources with the given addresses in
+// the given state, for the -resource-only option, returning the exit status
+// for the command. A resource with a single instance is shown as that
+// instance would be, but under the address of the resource, and a resource
+// with several instances is summarized with a line for each instance key
+// instead of all of their attributes.
+func (c *StateShowCommand) showResourceOnly(state *states.State, rawAddrs []string, redactPaths []string, onMissing string) int {
+	var diags tfdiags.Diagnostics
+
+	var texts []string
+	for _, rawAddr := range rawAddrs {
+		addr, moreDiags := addrs.ParseAbsResourceStr(rawAddr)
+		if moreDiags.HasErrors() {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid resource address",
+				fmt.Sprintf("The -resource-only option takes the addresses of whole resources, without instance keys, such as aws_instance.web, so %q can't be used: %s", rawAddr, moreDiags.Err()),
+			))
+			continue
+		}
+
+		rs := state.Resource(addr)
+		if rs == nil || len(rs.Instances) == 0 {
+			switch onMissing {
+			case "error":
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"No such resource in state",
+					fmt.Sprintf("There is no resource in the current state with the address %s.", addr),
+				))
+			case "warn":
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Warning,
+					"No such resource in state",
+					fmt.Sprintf("There is no resource in the current state with the address %s, so it has been skipped.", addr),
+				))
+			}
+			continue
+		}
+
+		text, moreDiags := stateShowResourceText(rs, addr, redactPaths, len(rawAddrs) > 1)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			continue
+		}
+		texts = append(texts, text)
+	}
+
+	if len(texts) > 0 {
+		c.Ui.Output(strings.Join(texts, "\n\n"))
+	}
+	c.showDiagnostics(diags)
+	if diags.HasErrors() {
+		return 1
+	}
+	return 0
+}
+
+// stateShowResourceText returns the text shown for the given resource by the
+// -resource-only option. The address of a resource with a single instance is
+// only given as a heading when several resources are shown, as for instances
+// shown without the option.
+func stateShowResourceText(rs *states.Resource, addr addrs.AbsResource, redactPaths []string, several bool) (string, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	keys := make([]addrs.InstanceKey, 0, len(rs.Instances))
+	for key := range rs.Instances {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return addr.Instance(keys[i]).Less(addr.Instance(keys[j]))
+	})
+
+	if len(keys) == 1 {
+		var header string
+		if several {
+			header = fmt.Sprintf("# %s:\n", addr)
+		}
+		is := rs.Instances[keys[0]]
+		if is.Current == nil {
+			return header + "(no current object)", diags
+		}
+		text, moreDiags := stateShowText(stateShowInstance{
+			Addr:   addr.Instance(keys[0]),
+			Object: is.Current,
+		}, redactPaths)
+		diags = diags.Append(moreDiags)
+		return header + text, diags
+	}
+
+	width := 0
+	for _, key := range keys {
+		if n := len(key.String()); n > width {
+			width = n
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s: %d instances", addr, len(keys))
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "\n  %-*s  %s", width, key.String(), stateShowInstanceSummary(rs.Instances[key]))
+	}
+	return buf.String(), diags
+}
+
+// stateShowInstanceSummary returns a brief description of the given resource
+// instance for the per-key summary of the -resource-only option: the id of
+// its current object, and its status if it isn't ready.
+func stateShowInstanceSummary(is *states.ResourceInstance) string {
+	var parts []string
+	if is.Current == nil {
+		parts = append(parts, "(no current object)")
+	} else {
+		if id := states.LegacyInstanceObjectID(is.Current); id != "<none>" && id != "" {
+			parts = append(parts, "id = "+id)
+		}
+		if is.Current.Status == states.ObjectTainted {
+			parts = append(parts, "(tainted)")
+		}
+	}
+	if n := len(is.Deposed); n > 0 {
+		parts = append(parts, fmt.Sprintf("(%d deposed)", n))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, " ")
+}
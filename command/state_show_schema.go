@@ -0,0 +1,94 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/providers"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// providerSchemaVersions returns the current schema version of the resource
+// type of each of the given instances in the given state, as reported by its
+// provider, for the -show-schema-version option. The versions are keyed by
+// instance address, and an instance whose provider isn't installed, or
+// doesn't have its resource type, has none, which is only a warning since
+// the stored version can still be shown.
+func (c *StateShowCommand) providerSchemaVersions(state *states.State, shown []stateShowInstance) (map[string]uint64, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	seen := make(map[string]bool)
+	var types []string
+	for _, inst := range shown {
+		rs := state.Resource(inst.Addr.ContainingResource())
+		if rs == nil {
+			continue
+		}
+		if name := rs.ProviderConfig.ProviderConfig.Type; !seen[name] {
+			seen[name] = true
+			types = append(types, name)
+		}
+	}
+
+	schemas, missing, moreDiags := c.stateProviderSchemas(types)
+	diags = diags.Append(moreDiags)
+	if len(missing) > 0 {
+		lines := make([]string, len(missing))
+		for i, name := range missing {
+			lines[i] = "  provider." + name
+		}
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Providers not installed",
+			fmt.Sprintf("The following providers aren't installed, so only the stored schema versions of their resource instances are shown:\n\n%s\n\nRun \"terraform init\" to install them.", strings.Join(lines, "\n")),
+		))
+	}
+
+	ret := make(map[string]uint64, len(shown))
+	for _, inst := range shown {
+		rs := state.Resource(inst.Addr.ContainingResource())
+		if rs == nil {
+			continue
+		}
+		resp, ok := schemas[rs.ProviderConfig.ProviderConfig.Type]
+		if !ok {
+			continue
+		}
+		if schema, ok := stateResourceTypeSchema(resp, inst.Addr.Resource.Resource); ok {
+			ret[inst.Addr.String()] = schema.Version
+		}
+	}
+	return ret, diags
+}
+
+// stateResourceTypeSchema returns the schema of the type of the given
+// resource from the given provider schema, if the provider has it.
+func stateResourceTypeSchema(resp providers.GetSchemaResponse, res addrs.Resource) (providers.Schema, bool) {
+	var schema providers.Schema
+	var ok bool
+	switch res.Mode {
+	case addrs.ManagedResourceMode:
+		schema, ok = resp.ResourceTypes[res.Type]
+	case addrs.DataResourceMode:
+		schema, ok = resp.DataSources[res.Type]
+	}
+	return schema, ok
+}
+
+// stateShowSchemaVersionLine returns a comment line describing the schema
+// version stored for an object, and how it compares with the current schema
+// version of its resource type in the provider, if known.
+func stateShowSchemaVersionLine(stored uint64, current uint64, known bool) string {
+	switch {
+	case !known:
+		return fmt.Sprintf("# schema_version = %d (the provider's current version is unknown)", stored)
+	case stored < current:
+		return fmt.Sprintf("# schema_version = %d (MISMATCH: the provider is at version %d, so the next plan will upgrade this object)", stored, current)
+	case stored > current:
+		return fmt.Sprintf("# schema_version = %d (MISMATCH: the provider is at version %d, which is older than the version that saved this object)", stored, current)
+	default:
+		return fmt.Sprintf("# schema_version = %d (matches the provider)", stored)
+	}
+}
@@ -1,13 +1,21 @@
 package command
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/mitchellh/cli"
 
 	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/helper/copy"
 	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
 )
 
 func TestStateShow(t *testing.T) {
@@ -93,10 +101,1107 @@ func TestStateShow_multi(t *testing.T) {
 	args := []string{
 		"-state", statePath,
 		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	expected := strings.TrimSpace(testStateShowMultiOutput) + "\n"
+	actual := ui.OutputWriter.String()
+	if actual != expected {
+		t.Fatalf("Expected:\n%q\n\nTo equal: %q", actual, expected)
+	}
+}
+
+func TestStateShow_all(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	ui := cli.NewMockUi()
+	c := &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args := []string{
+		"-state", statePath,
+		"-all",
+		"-redact", "foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := strings.TrimSpace(testStateShowAllOutput) + "\n"
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	ui = cli.NewMockUi()
+	c = &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args = []string{
+		"-state", statePath,
+		"-all",
+		"-json",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var got []struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if len(got) != 2 || got[0].Address != "test_instance.bar" || got[1].Address != "test_instance.foo" {
+		t.Errorf("wrong instances\n%s", ui.OutputWriter.String())
+	}
+
+	ui = cli.NewMockUi()
+	c = &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args = []string{
+		"-state", statePath,
+		"-all",
+		"test_instance.foo",
 	}
 	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+}
+
+func TestStateShow_onMissing(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	cases := map[string]struct {
+		policy   string
+		wantCode int
+		wantErr  string
+	}{
+		"ignore": {"ignore", 0, ""},
+		"warn":   {"warn", 0, "No such resource instance in state"},
+		"error":  {"error", 1, "No such resource instance in state"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateShowCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+
+			args := []string{
+				"-state", statePath,
+				"-on-missing", tc.policy,
+				"test_instance.baz",
+				"test_instance.foo",
+			}
+			if code := c.Run(args); code != tc.wantCode {
+				t.Fatalf("wrong exit status %d; want %d\n\n%s", code, tc.wantCode, ui.ErrorWriter.String())
+			}
+
+			// The instance that is present is shown regardless.
+			if got, want := ui.OutputWriter.String(), "# test_instance.foo:\n"; !strings.HasPrefix(got, want) {
+				t.Errorf("wrong output\ngot:  %s\nwant prefix: %s", got, want)
+			}
+			got := ui.ErrorWriter.String()
+			if tc.wantErr == "" && got != "" {
+				t.Errorf("unexpected diagnostics\n%s", got)
+			}
+			if !strings.Contains(got, tc.wantErr) {
+				t.Errorf("wrong diagnostics\ngot:  %s\nwant: %s", got, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestStateShow_json(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+
+	ui := cli.NewMockUi()
+	c := &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+
+	args := []string{
+		"-state", statePath,
+		"-json",
+		"test_instance.foo",
+		"test_instance.bar",
+	}
+	if code := c.Run(args); code != 0 {
 		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
 	}
+
+	var got []struct {
+		Address    string            `json:"address"`
+		Attributes map[string]string `json:"attributes"`
+	}
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if len(got) != 2 {
+		t.Fatalf("wrong number of instances %d; want 2", len(got))
+	}
+	if got[0].Address != "test_instance.foo" || got[0].Attributes["id"] != "bar" {
+		t.Errorf("wrong first instance %#v", got[0])
+	}
+	if got[1].Address != "test_instance.bar" || got[1].Attributes["id"] != "foo" {
+		t.Errorf("wrong second instance %#v", got[1])
+	}
+}
+
+func TestStateShow_redact(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "test_instance",
+				Name: "foo",
+			}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"bar","password":"hunter2","credentials":{"user":"admin","secret":"s3cr3t"},"keys":["k0","k1"]}`),
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	ui := cli.NewMockUi()
+	c := &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args := []string{
+		"-state", statePath,
+		"-redact", "password",
+		"-redact", "credentials.secret",
+		"-redact", "keys.1",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `id                 = bar
+credentials.secret = <redacted>
+credentials.user   = admin
+keys.#             = 2
+keys.0             = k0
+keys.1             = <redacted>
+password           = <redacted>
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	ui = cli.NewMockUi()
+	c = &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args = []string{
+		"-state", statePath,
+		"-json",
+		"-redact", "credentials",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var got []struct {
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if len(got) != 1 {
+		t.Fatalf("wrong number of instances %d; want 1", len(got))
+	}
+	if got, want := got[0].Attributes["credentials"], "<redacted>"; got != want {
+		t.Errorf("credentials not redacted: %#v", got)
+	}
+	if got, want := got[0].Attributes["password"], "hunter2"; got != want {
+		t.Errorf("wrong password %#v; want %#v", got, want)
+	}
+}
+
+func TestStateShow_flatten(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "test_instance",
+				Name: "foo",
+			}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"bar","password":"hunter2","root_block_device":[{"volume_size":20,"encrypted":true}],"tags":{},"ami":null}`),
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	ui := cli.NewMockUi()
+	c := &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args := []string{
+		"-state", statePath,
+		"-flatten",
+		"-redact", "password",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `id = bar
+password = <redacted>
+root_block_device.0.encrypted = true
+root_block_device.0.volume_size = 20
+tags = {}
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	ui = cli.NewMockUi()
+	c = &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-flatten", "-json", "test_instance.foo"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "-flatten option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateShow_raw(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.foo"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"bar","size":1.50,"a":null}`),
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.legacy"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsFlat: map[string]string{"id": "old", "tags.%": "0"},
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	ui := cli.NewMockUi()
+	c := &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args := []string{
+		"-state", statePath,
+		"-raw",
+		"test_instance.foo",
+		"test_instance.legacy",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	// The attributes keep their stored order and number formatting.
+	want := `# test_instance.foo:
+{
+  "id": "bar",
+  "size": 1.50,
+  "a": null
+}
+
+# test_instance.legacy:
+{
+  "id": "old",
+  "tags.%": "0"
+}
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := ui.ErrorWriter.String(), "Legacy attributes format"; !strings.Contains(got, want) {
+		t.Errorf("no warning for flatmap attributes\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateShow_diffAgainst(t *testing.T) {
+	build := func(attrs string) *states.State {
+		return states.BuildState(func(s *states.SyncState) {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr("test_instance.foo"),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(attrs),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		})
+	}
+	statePath := testStateFile(t, build(`{"id":"bar","ami":"ami-123","size":"small","secret":"new"}`))
+	otherPath := testStateFile(t, build(`{"id":"bar","ami":"ami-456","secret":"old"}`))
+
+	ui := cli.NewMockUi()
+	c := &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args := []string{
+		"-state", statePath,
+		"-diff-against", otherPath,
+		"-redact", "secret",
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := fmt.Sprintf(`# test_instance.foo: changes from %s to the current state
+~ ami  = ami-456 -> ami-123
++ size = small
+`, otherPath)
+	if got := ui.OutputWriter.String(); got != want {
+		t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// An instance missing from either state is an error.
+	ui = cli.NewMockUi()
+	c = &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args[len(args)-1] = "test_instance.bar"
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	for _, want := range []string{
+		"in the state file",
+		"in the current state",
+	} {
+		if got := ui.ErrorWriter.String(); !strings.Contains(got, want) {
+			t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+		}
+	}
+}
+
+func TestStateShow_attr(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.foo"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"bar","count":3,"tags":{"Name":"web","a.b":"dotted"},"network":[{"address":"10.0.0.1"},{"address":"10.0.0.2"}]}`),
+				Status:    states.ObjectReady,
+			},
+			addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	tests := map[string]struct {
+		path string
+		want string
+		err  string
+	}{
+		"string":           {path: "id", want: "bar\n"},
+		"number":           {path: "count", want: "3\n"},
+		"nested":           {path: "tags.Name", want: "web\n"},
+		"quoted key":       {path: `tags["a.b"]`, want: "dotted\n"},
+		"dotted index":     {path: "network.1.address", want: "10.0.0.2\n"},
+		"bracket index":    {path: "network[0].address", want: "10.0.0.1\n"},
+		"collection":       {path: "network[0]", want: "{\n  \"address\": \"10.0.0.1\"\n}\n"},
+		"missing":          {path: "tags.Env", err: `no attribute "Env" at tags`},
+		"out of range":     {path: "network[2]", err: "which has 2 elements"},
+		"not a collection": {path: "id.x", err: "the value at id has no attributes"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateShowCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+			code := c.Run([]string{"-state", statePath, "-attr", test.path, "test_instance.foo"})
+			if test.err != "" {
+				if code != 1 {
+					t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+				}
+				if got, want := ui.ErrorWriter.String(), "Invalid attribute path"; !strings.Contains(got, want) {
+					t.Fatalf("wrong error\ngot:  %s\nwant: %s", got, want)
+				}
+				_, err := stateShowAttrValue(state.ResourceInstance(mustResourceInstanceAddr("test_instance.foo")).Current, test.path)
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("wrong error\ngot:  %v\nwant: %s", err, test.err)
+				}
+				return
+			}
+			if code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != test.want {
+				t.Fatalf("wrong output\ngot:  %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStateShow_followDependencies(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		for addr, deps := range map[string][]addrs.Referenceable{
+			"test_instance.web": {
+				addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_instance", Name: "net"},
+				addrs.ModuleCall{Name: "db"},
+			},
+			"test_instance.net":               {addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_instance", Name: "vpc"}},
+			"test_instance.vpc":               nil,
+			"module.db.test_instance.primary": nil,
+		} {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON:    []byte(fmt.Sprintf(`{"id":%q}`, addr)),
+					Status:       states.ObjectReady,
+					Dependencies: deps,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	run := func(args ...string) *cli.MockUi {
+		t.Helper()
+		ui := cli.NewMockUi()
+		c := &StateShowCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(testProvider()),
+				Ui:               ui,
+			},
+		}
+		if code := c.Run(append([]string{"-state", statePath}, args...)); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		return ui
+	}
+
+	ui := run("-follow-dependencies", "test_instance.web")
+	want := `# test_instance.web:
+id = test_instance.web
+
+# test_instance.net (dependency of test_instance.web):
+id = test_instance.net
+
+# module.db.test_instance.primary (dependency of test_instance.web):
+id = module.db.test_instance.primary
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	ui = run("-json", "-follow-dependencies=2", "test_instance.web")
+	var got []stateShowJSON
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if len(got) != 1 || len(got[0].Dependencies) != 2 {
+		t.Fatalf("wrong result\n%s", ui.OutputWriter.String())
+	}
+	net := got[0].Dependencies[0]
+	if net.Address != "test_instance.net" || len(net.Dependencies) != 1 || net.Dependencies[0].Address != "test_instance.vpc" {
+		t.Errorf("wrong nested dependencies\n%s", ui.OutputWriter.String())
+	}
+}
+
+func TestStateShow_explainDependencies(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	ready := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.web"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"web"}`),
+				Status:    states.ObjectReady,
+				Dependencies: []addrs.Referenceable{
+					addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_instance", Name: "net"},
+					addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_instance", Name: "gone"},
+					addrs.ModuleCall{Name: "db"},
+				},
+			},
+			provider,
+		)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.net[0]"), ready, provider)
+		s.SetResourceInstanceDeposed(mustResourceInstanceAddr("test_instance.net[0]"), states.DeposedKey("00000001"), ready, provider)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.net[1]"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"foo"}`),
+				Status:    states.ObjectTainted,
+			},
+			provider,
+		)
+		s.SetResourceInstanceDeposed(mustResourceInstanceAddr("module.db.test_instance.primary"), states.DeposedKey("00000002"), ready, provider)
+	})
+	statePath := testStateFile(t, state)
+
+	run := func(args ...string) (int, *cli.MockUi) {
+		ui := cli.NewMockUi()
+		c := &StateShowCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(testProvider()),
+				Ui:               ui,
+			},
+		}
+		return c.Run(append([]string{"-state", statePath}, args...)), ui
+	}
+
+	code, ui := run("-explain-dependencies", "test_instance.web")
+	if code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `# test_instance.web depends on:
+test_instance.net:
+  test_instance.net[0]: ready, with 1 deposed object
+  test_instance.net[1]: tainted
+test_instance.gone: not in the state
+module.db:
+  module.db.test_instance.primary: deposed only, with 1 deposed object
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	code, ui = run("-explain-dependencies", "-json", "test_instance.web")
+	if code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var got []stateShowExplainedDependencyJSON
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if len(got) != 3 || got[1].Dependency != "test_instance.gone" || len(got[1].Instances) != 0 || got[0].Instances[1].Status != "tainted" {
+		t.Errorf("wrong result\n%s", ui.OutputWriter.String())
+	}
+
+	code, ui = run("-explain-dependencies", "test_instance.web", "test_instance.net[0]")
+	if code != 1 {
+		t.Fatalf("wrong exit status %d; want 1", code)
+	}
+}
+
+func TestStateShow_neighbors(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	dependsOn := func(names ...string) *states.ResourceInstanceObjectSrc {
+		obj := &states.ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(`{"id":"foo"}`),
+			Status:    states.ObjectReady,
+		}
+		for _, name := range names {
+			obj.Dependencies = append(obj.Dependencies, addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_instance", Name: name})
+		}
+		return obj
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.net"), dependsOn(), provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.web"), dependsOn("net"), provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.lb"), dependsOn("web"), provider)
+		s.SetResourceInstanceDeposed(mustResourceInstanceAddr("test_instance.dns"), states.DeposedKey("00000001"), dependsOn("web"), provider)
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.web"), dependsOn(), provider)
+	})
+	statePath := testStateFile(t, state)
+
+	run := func(args ...string) (int, *cli.MockUi) {
+		ui := cli.NewMockUi()
+		c := &StateShowCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(testProvider()),
+				Ui:               ui,
+			},
+		}
+		return c.Run(append([]string{"-state", statePath}, args...)), ui
+	}
+
+	code, ui := run("-neighbors", "test_instance.web")
+	if code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	want := `# test_instance.web depends on:
+  test_instance.net
+
+# test_instance.web is depended on by:
+  test_instance.dns
+  test_instance.lb
+`
+	if got := ui.OutputWriter.String(); got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	code, ui = run("-neighbors", "-json", "test_instance.net")
+	if code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	var got stateShowNeighborsJSON
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	wantJSON := stateShowNeighborsJSON{
+		Address:      "test_instance.net",
+		DependsOn:    []string{},
+		DependedOnBy: []string{"test_instance.web"},
+	}
+	if !reflect.DeepEqual(got, wantJSON) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, wantJSON)
+	}
+
+	if code, _ := run("-neighbors", "test_instance.missing"); code != 1 {
+		t.Fatalf("wrong exit status %d for a missing instance; want 1", code)
+	}
+	if code, _ := run("-neighbors", "-redact", "id", "test_instance.web"); code != 1 {
+		t.Fatalf("wrong exit status %d with -redact; want 1", code)
+	}
+}
+
+func TestStateShow_providersRequired(t *testing.T) {
+	obj := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("test_instance.root"), obj, addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance))
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.a"), obj, addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance))
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.test_instance.b[0]"), obj, addrs.ProviderConfig{Type: "test", Alias: "west"}.Absolute(addrs.RootModuleInstance))
+		s.SetResourceInstanceCurrent(mustResourceInstanceAddr("module.child.null_resource.c"), obj, addrs.ProviderConfig{Type: "null"}.Absolute(addrs.RootModuleInstance))
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"module":   {[]string{"module.child"}, "null\n  provider.null\ntest\n  provider.test\n  provider.test.west\n"},
+		"resource": {[]string{"module.child.test_instance.b"}, "test\n  provider.test.west\n"},
+		"several":  {[]string{"test_instance.root", "module.child.test_instance.a"}, "test\n  provider.test\n"},
+		"json": {[]string{"-json", "module.child.null_resource.c"}, `[
+  {
+    "provider": "null",
+    "configurations": [
+      "provider.null"
+    ]
+  }
+]
+`},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateShowCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+			args := append([]string{"-state", statePath, "-providers-required"}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+
+	ui := cli.NewMockUi()
+	c := &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-providers-required", "module.missing"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "No matching resource instances in state"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateShow_resourceOnly(t *testing.T) {
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+	state := states.BuildState(func(s *states.SyncState) {
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr("test_instance.single[0]"),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"one","ami":"ami-1"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr(`test_instance.multi["a"]`),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"i-a","ami":"ami-1"}`),
+				Status:    states.ObjectReady,
+			},
+			provider,
+		)
+		s.SetResourceInstanceCurrent(
+			mustResourceInstanceAddr(`test_instance.multi["bb"]`),
+			&states.ResourceInstanceObjectSrc{
+				AttrsJSON: []byte(`{"id":"i-bb","ami":"ami-1"}`),
+				Status:    states.ObjectTainted,
+			},
+			provider,
+		)
+	})
+	statePath := testStateFile(t, state)
+
+	cases := map[string]struct {
+		args []string
+		want string
+	}{
+		"single": {
+			[]string{"test_instance.single"},
+			"id  = one\nami = ami-1\n",
+		},
+		"several instances": {
+			[]string{"test_instance.multi"},
+			"# test_instance.multi: 2 instances\n  [\"a\"]   id = i-a\n  [\"bb\"]  id = i-bb (tainted)\n",
+		},
+		"several resources": {
+			[]string{"-redact", "ami", "test_instance.single", "test_instance.multi"},
+			"# test_instance.single:\nid  = one\nami = <redacted>\n\n# test_instance.multi: 2 instances\n  [\"a\"]   id = i-a\n  [\"bb\"]  id = i-bb (tainted)\n",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			c := &StateShowCommand{
+				Meta: Meta{
+					testingOverrides: metaOverridesForProvider(testProvider()),
+					Ui:               ui,
+				},
+			}
+			args := append([]string{"-state", statePath, "-resource-only"}, tc.args...)
+			if code := c.Run(args); code != 0 {
+				t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+			}
+			if got := ui.OutputWriter.String(); got != tc.want {
+				t.Fatalf("wrong output\ngot:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+
+	// An instance address isn't a resource address.
+	ui := cli.NewMockUi()
+	c := &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	if code := c.Run([]string{"-state", statePath, "-resource-only", "test_instance.single[0]"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Invalid resource address"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateShow_schemaVersion(t *testing.T) {
+	state := states.BuildState(func(s *states.SyncState) {
+		for addr, version := range map[string]uint64{
+			"test_instance.foo": 0,
+			"test_instance.bar": 1,
+			"other_thing.baz":   2,
+		} {
+			provider := "test"
+			if strings.HasPrefix(addr, "other_") {
+				provider = "other"
+			}
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr(addr),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON:     []byte(`{"id":"bar"}`),
+					Status:        states.ObjectReady,
+					SchemaVersion: version,
+				},
+				addrs.ProviderConfig{Type: provider}.Absolute(addrs.RootModuleInstance),
+			)
+		}
+	})
+	statePath := testStateFile(t, state)
+
+	run := func(args ...string) *cli.MockUi {
+		t.Helper()
+		ui := cli.NewMockUi()
+		c := &StateShowCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(planFixtureProvider()),
+				Ui:               ui,
+			},
+		}
+		if code := c.Run(append([]string{"-state", statePath, "-show-schema-version"}, args...)); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		return ui
+	}
+
+	// The mock provider's schema is always version 0.
+	ui := run("test_instance.foo", "test_instance.bar", "other_thing.baz")
+	got := ui.OutputWriter.String()
+	for _, want := range []string{
+		"# test_instance.foo:\n# schema_version = 0 (matches the provider)\nid = bar\n",
+		"# test_instance.bar:\n# schema_version = 1 (MISMATCH: the provider is at version 0,",
+		"# other_thing.baz:\n# schema_version = 2 (the provider's current version is unknown)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output is missing %q\n\n%s", want, got)
+		}
+	}
+	if got, want := ui.ErrorWriter.String(), "provider.other"; !strings.Contains(got, want) {
+		t.Errorf("missing warning about %s\n\n%s", want, got)
+	}
+
+	ui = run("-json", "test_instance.bar")
+	var shown []stateShowJSON
+	if err := json.Unmarshal(ui.OutputWriter.Bytes(), &shown); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, ui.OutputWriter.String())
+	}
+	if len(shown) != 1 || shown[0].SchemaVersion == nil || *shown[0].SchemaVersion != 1 || shown[0].ProviderSchemaVersion == nil || *shown[0].ProviderSchemaVersion != 0 {
+		t.Errorf("wrong result\n%s", ui.OutputWriter.String())
+	}
+}
+
+func TestStateShow_history(t *testing.T) {
+	snapshot := func(attrs string) *states.State {
+		return states.BuildState(func(s *states.SyncState) {
+			if attrs == "" {
+				return
+			}
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr("test_instance.foo"),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(attrs),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		})
+	}
+	statePath := testStateFile(t, snapshot(`{"id":"bar","size":"large"}`))
+	for i, attrs := range []string{
+		``,
+		`{"id":"bar","size":"small"}`,
+		`{"id":"bar","size":"small"}`,
+	} {
+		f := statefile.New(snapshot(attrs), "", uint64(i))
+		if err := writeStateFile(fmt.Sprintf("%s.%d%s", statePath, 1500000000+i, DefaultBackupExtension), f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(args ...string) string {
+		t.Helper()
+		ui := cli.NewMockUi()
+		c := &StateShowCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(testProvider()),
+				Ui:               ui,
+			},
+		}
+		if code := c.Run(append([]string{"-state", statePath, "-history"}, args...)); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		return ui.OutputWriter.String()
+	}
+
+	got := run("test_instance.foo")
+	want := fmt.Sprintf(`# test_instance.foo: not in %[1]s.1500000000.backup (2017-07-14T02:40:00Z)
+
+# test_instance.foo: in %[1]s.1500000001.backup (2017-07-14T02:40:01Z)
+id   = bar
+size = small
+
+# test_instance.foo: no changes in %[1]s.1500000002.backup (2017-07-14T02:40:02Z)
+
+# test_instance.foo: changes in the current state
+~ size = small -> large
+`, statePath)
+	if got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = run("-history-limit=1", "-redact=size", "test_instance.foo")
+	want = fmt.Sprintf(`# test_instance.foo: in %s.1500000002.backup (2017-07-14T02:40:02Z)
+id   = bar
+size = <redacted>
+
+# test_instance.foo: no changes in the current state
+`, statePath)
+	if got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestStateShow_compareConfig(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("plan"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	run := func(ami string, args ...string) string {
+		t.Helper()
+		state := states.BuildState(func(s *states.SyncState) {
+			s.SetResourceInstanceCurrent(
+				mustResourceInstanceAddr("test_instance.foo"),
+				&states.ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(fmt.Sprintf(`{"id":"bar","ami":%q,"network_interface":[{"device_index":"0","description":"Main network interface"}]}`, ami)),
+					Status:    states.ObjectReady,
+				},
+				addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+			)
+		})
+		statePath := testStateFile(t, state)
+
+		ui := cli.NewMockUi()
+		c := &StateShowCommand{
+			Meta: Meta{
+				testingOverrides: metaOverridesForProvider(planFixtureProvider()),
+				Ui:               ui,
+			},
+		}
+		if code := c.Run(append([]string{"-state", statePath, "-compare-config"}, args...)); code != 0 {
+			t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+		}
+		return ui.OutputWriter.String()
+	}
+
+	got := run("foo", "test_instance.foo")
+	want := `# test_instance.foo: a plan would update it to match the configuration
+~ ami = foo -> bar
+`
+	if got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = run("foo", "-redact=ami", "test_instance.foo")
+	want = `# test_instance.foo: a plan would update it to match the configuration
+~ ami = <redacted> -> <redacted> (changed)
+`
+	if got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = run("bar", "test_instance.foo")
+	want = "# test_instance.foo: the configuration matches the state\n"
+	if got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestStateShow_outputTo(t *testing.T) {
+	statePath := testStateFile(t, testStateRmState())
+	outPath := filepath.Join(filepath.Dir(statePath), "foo.json")
+
+	ui := cli.NewMockUi()
+	c := &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args := []string{
+		"-state", statePath,
+		"-json",
+		"-output-to", outPath,
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got := ui.OutputWriter.String(); got != "" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	src, err := ioutil.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		Address    string            `json:"address"`
+		Attributes map[string]string `json:"attributes"`
+	}
+	if err := json.Unmarshal(src, &got); err != nil {
+		t.Fatalf("output file is not valid JSON: %s\n%s", err, src)
+	}
+	if len(got) != 1 || got[0].Address != "test_instance.foo" || got[0].Attributes["id"] != "bar" {
+		t.Errorf("wrong instances %#v", got)
+	}
+
+	ui = cli.NewMockUi()
+	c = &StateShowCommand{
+		Meta: Meta{
+			testingOverrides: metaOverridesForProvider(testProvider()),
+			Ui:               ui,
+		},
+	}
+	args = []string{
+		"-state", statePath,
+		"-output-to", filepath.Join(filepath.Dir(statePath), "missing", "foo.txt"),
+		"test_instance.foo",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "for the -output-to option"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+	if got := ui.OutputWriter.String(); got != "" {
+		t.Errorf("unexpected output %q", got)
+	}
 }
 
 func TestStateShow_noState(t *testing.T) {
@@ -145,3 +1250,27 @@ id  = bar
 bar = value
 foo = value
 `
+
+const testStateShowMultiOutput = `
+# test_instance.foo:
+id  = bar
+bar = value
+foo = value
+
+# test_instance.bar:
+id  = foo
+bar = value
+foo = value
+`
+
+const testStateShowAllOutput = `
+# test_instance.bar:
+id  = foo
+bar = value
+foo = <redacted>
+
+# test_instance.foo:
+id  = bar
+bar = value
+foo = <redacted>
+`
@@ -0,0 +1,663 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/state/remote"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/hashicorp/terraform/tfdiags"
+)
+
+// StateSnapshotCommand is a Command implementation that just shows help for
+// the subcommands nested below it.
+type StateSnapshotCommand struct {
+	StateMeta
+}
+
+func (c *StateSnapshotCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *StateSnapshotCommand) Help() string {
+	helpText := `
+Usage: terraform state snapshot <subcommand> [options] [args]
+
+  This command has subcommands for managing named snapshots of the state.
+
+  A named snapshot is a restore point that can be created before a risky
+  session of state surgery and restored afterwards if something goes wrong,
+  instead of searching for the right timestamped backup file.
+
+  Snapshots are stored in the .terraform directory of the current working
+  directory, or with -backend as extra workspaces in the configured backend.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *StateSnapshotCommand) Synopsis() string {
+	return "Manage named state snapshots"
+}
+
+// StateSnapshotCreateCommand is a Command implementation that saves the
+// current state as a named snapshot.
+type StateSnapshotCreateCommand struct {
+	StateMeta
+}
+
+func (c *StateSnapshotCreateCommand) Run(args []string) int {
+	args, err := c.Meta.process(args, true)
+	if err != nil {
+		return 1
+	}
+
+	var inBackend, force bool
+	cmdFlags := c.Meta.flagSet("state snapshot create")
+	cmdFlags.BoolVar(&inBackend, "backend", false, "store the snapshot in the backend")
+	cmdFlags.BoolVar(&force, "force", false, "replace an existing snapshot")
+	cmdFlags.StringVar(&c.statePath, "state", "", "path")
+	if err := cmdFlags.Parse(args); err != nil {
+		return cli.RunResultHelp
+	}
+	args = cmdFlags.Args()
+
+	var diags tfdiags.Diagnostics
+
+	if len(args) != 1 {
+		c.Ui.Error("Exactly one argument expected: the name of the snapshot to create")
+		return 1
+	}
+	name := args[0]
+	if diags = diags.Append(validateStateSnapshotName(name)); diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if inBackend && c.statePath != "" {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -backend option can't be used with -state, because a state file given by -state is not stored in the backend.",
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	stateMgr, err := c.State()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(errStateLoadingState, err))
+		return 1
+	}
+	if err := stateMgr.RefreshState(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to refresh state: %s", err))
+		return 1
+	}
+	state := stateMgr.State()
+	if state == nil {
+		c.Ui.Error(fmt.Sprintf(errStateNotFound))
+		return 1
+	}
+
+	if !force {
+		snapshots, moreDiags := c.listStateSnapshots(inBackend)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			c.showDiagnostics(diags)
+			return 1
+		}
+		for _, meta := range snapshots {
+			if meta.Name == name {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"Snapshot already exists",
+					fmt.Sprintf("There is already a snapshot named %q for the workspace %q. Use -force to replace it.", name, c.Workspace()),
+				))
+				c.showDiagnostics(diags)
+				return 1
+			}
+		}
+	}
+
+	f := statemgr.StateFile(stateMgr, state)
+	meta := &stateSnapshotMeta{
+		Name:              name,
+		Workspace:         c.Workspace(),
+		CreatedAt:         time.Now().UTC(),
+		Serial:            f.Serial,
+		Lineage:           f.Lineage,
+		ResourceInstances: stateSnapshotInstanceCount(state),
+		InBackend:         inBackend,
+	}
+	diags = diags.Append(c.saveStateSnapshot(meta, f))
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	c.showDiagnostics(diags)
+	c.Ui.Output(fmt.Sprintf("Created snapshot %q with %d resource instances.", name, meta.ResourceInstances))
+	return 0
+}
+
+func (c *StateSnapshotCreateCommand) Help() string {
+	helpText := `
+Usage: terraform state snapshot create [options] NAME
+
+  Save the current state as a snapshot with the given name.
+
+  The snapshot records when it was created, along with the serial, lineage,
+  and number of resource instances of the state, so that it can be found
+  again with "terraform state snapshot list" and restored with
+  "terraform state snapshot restore".
+
+Options:
+
+  -backend            Store the snapshot in the configured backend, as a
+                      new workspace named after the current workspace and
+                      the snapshot, rather than in the .terraform directory.
+
+  -force              Replace any existing snapshot with the same name.
+
+  -state=statefile    Path to a Terraform state file to use to look
+                      up Terraform-managed resources. By default it will
+                      use the state "terraform.tfstate" if it exists.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *StateSnapshotCreateCommand) Synopsis() string {
+	return "Save the state as a named snapshot"
+}
+
+// StateSnapshotRestoreCommand is a Command implementation that replaces the
+// current state with a named snapshot.
+type StateSnapshotRestoreCommand struct {
+	StateMeta
+}
+
+func (c *StateSnapshotRestoreCommand) Run(args []string) int {
+	args, err := c.Meta.process(args, true)
+	if err != nil {
+		return 1
+	}
+
+	var inBackend, force bool
+	cmdFlags := c.Meta.flagSet("state snapshot restore")
+	cmdFlags.BoolVar(&inBackend, "backend", false, "read the snapshot from the backend")
+	cmdFlags.BoolVar(&force, "force", false, "restore a snapshot with a different lineage")
+	cmdFlags.StringVar(&c.backupPath, "backup", "-", "backup")
+	cmdFlags.StringVar(&c.statePath, "state", "", "path")
+	if err := cmdFlags.Parse(args); err != nil {
+		return cli.RunResultHelp
+	}
+	args = cmdFlags.Args()
+
+	var diags tfdiags.Diagnostics
+
+	if len(args) != 1 {
+		c.Ui.Error("Exactly one argument expected: the name of the snapshot to restore")
+		return 1
+	}
+	name := args[0]
+	if diags = diags.Append(validateStateSnapshotName(name)); diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	if inBackend && c.statePath != "" {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid combination of options",
+			"The -backend option can't be used with -state, because a state file given by -state is not stored in the backend.",
+		))
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	snapshot, moreDiags := c.loadStateSnapshot(name, inBackend)
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+
+	stateMgr, err := c.State()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(errStateLoadingState, err))
+		return 1
+	}
+	if err := stateMgr.RefreshState(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to refresh state: %s", err))
+		return 1
+	}
+
+	// As for "terraform state push", we refuse to replace a state with an
+	// apparently unrelated one unless forced.
+	if current := stateMgr.State(); !force && !current.Empty() {
+		currentFile := statemgr.StateFile(stateMgr, current)
+		if currentFile.Lineage != "" && snapshot.Lineage != "" && currentFile.Lineage != snapshot.Lineage {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Snapshot lineage does not match",
+				fmt.Sprintf("The snapshot %q has the lineage %q, but the current state has the lineage %q, so it may belong to a different configuration. The state has not been changed. Use -force to restore it anyway.", name, snapshot.Lineage, currentFile.Lineage),
+			))
+			c.showDiagnostics(diags)
+			return 1
+		}
+	}
+
+	if err := stateMgr.WriteState(snapshot.State); err != nil {
+		c.Ui.Error(fmt.Sprintf(errStateRmPersist, err))
+		return 1
+	}
+	if err := stateMgr.PersistState(); err != nil {
+		c.Ui.Error(fmt.Sprintf(errStateRmPersist, err))
+		return 1
+	}
+
+	c.showDiagnostics(diags)
+	c.Ui.Output(fmt.Sprintf("Restored snapshot %q. Updated state written successfully.", name))
+	return 0
+}
+
+func (c *StateSnapshotRestoreCommand) Help() string {
+	helpText := `
+Usage: terraform state snapshot restore [options] NAME
+
+  Replace the current state with the snapshot of the given name, as created
+  by "terraform state snapshot create".
+
+  A backup of the current state is written first, as for the other state
+  commands that modify the state. The snapshot itself is kept, so it can be
+  restored again later.
+
+Options:
+
+  -backend            Read the snapshot from the configured backend rather
+                      than from the .terraform directory.
+
+  -backup=PATH        Path where Terraform should write the backup
+                      state. This can't be disabled. If not set, Terraform
+                      will write it to the same path as the statefile with
+                      a backup extension.
+
+  -force              Restore the snapshot even if its lineage doesn't match
+                      the lineage of the current state.
+
+  -state=statefile    Path to a Terraform state file to use to look
+                      up Terraform-managed resources. By default it will
+                      use the state "terraform.tfstate" if it exists.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *StateSnapshotRestoreCommand) Synopsis() string {
+	return "Replace the state with a named snapshot"
+}
+
+// StateSnapshotListCommand is a Command implementation that lists the named
+// snapshots of the current workspace.
+type StateSnapshotListCommand struct {
+	StateMeta
+}
+
+func (c *StateSnapshotListCommand) Run(args []string) int {
+	args, err := c.Meta.process(args, true)
+	if err != nil {
+		return 1
+	}
+
+	var inBackend bool
+	cmdFlags := c.Meta.flagSet("state snapshot list")
+	cmdFlags.BoolVar(&inBackend, "backend", false, "list the snapshots in the backend")
+	if err := cmdFlags.Parse(args); err != nil {
+		return cli.RunResultHelp
+	}
+	if len(cmdFlags.Args()) != 0 {
+		c.Ui.Error("The state snapshot list command expects no arguments.")
+		return 1
+	}
+
+	snapshots, diags := c.listStateSnapshots(inBackend)
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 1
+	}
+	c.showDiagnostics(diags)
+
+	for _, meta := range snapshots {
+		created := "-"
+		if !meta.CreatedAt.IsZero() {
+			created = meta.CreatedAt.Format(time.RFC3339)
+		}
+		c.Ui.Output(fmt.Sprintf("%s\t%s\tserial %d\t%d resource instances", meta.Name, created, meta.Serial, meta.ResourceInstances))
+	}
+	return 0
+}
+
+func (c *StateSnapshotListCommand) Help() string {
+	helpText := `
+Usage: terraform state snapshot list [options]
+
+  List the named snapshots of the current workspace, one per line, with the
+  time each was created, the serial of the state it was created from, and
+  the number of resource instances it contains.
+
+Options:
+
+  -backend            List the snapshots stored in the configured backend
+                      rather than those in the .terraform directory. The
+                      backend doesn't record when each snapshot was
+                      created, so no creation times are shown.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *StateSnapshotListCommand) Synopsis() string {
+	return "List named state snapshots"
+}
+
+// stateSnapshotMeta describes a named snapshot created by
+// "terraform state snapshot create".
+type stateSnapshotMeta struct {
+	Name      string    `json:"name"`
+	Workspace string    `json:"workspace"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Serial and Lineage are those of the state the snapshot was created
+	// from, where the state manager records them.
+	Serial  uint64 `json:"serial"`
+	Lineage string `json:"lineage"`
+
+	ResourceInstances int `json:"resource_instances"`
+
+	// InBackend is set for a snapshot stored in the backend rather than
+	// in the local data directory. It is not saved.
+	InBackend bool `json:"-"`
+}
+
+var stateSnapshotNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+func validateStateSnapshotName(name string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+	if !stateSnapshotNameRe.MatchString(name) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid snapshot name",
+			fmt.Sprintf("The snapshot name %q is not valid. A snapshot name must start with a letter or digit and contain only letters, digits, underscores, and dashes.", name),
+		))
+	}
+	return diags
+}
+
+// stateSnapshotInstanceCount returns the number of resource instances in the
+// given state.
+func stateSnapshotInstanceCount(state *states.State) int {
+	count := 0
+	for _, ms := range state.Modules {
+		for _, rs := range ms.Resources {
+			count += len(rs.Instances)
+		}
+	}
+	return count
+}
+
+// stateSnapshotDir returns the local directory where the named snapshots of
+// the current workspace are stored.
+func (c *StateMeta) stateSnapshotDir() string {
+	return filepath.Join(c.DataDir(), "state-snapshots", c.Workspace())
+}
+
+// stateSnapshotWorkspace returns the name of the backend workspace where the
+// snapshot with the given name of the current workspace is stored.
+func (c *StateMeta) stateSnapshotWorkspace(name string) string {
+	return c.stateSnapshotWorkspacePrefix() + name
+}
+
+func (c *StateMeta) stateSnapshotWorkspacePrefix() string {
+	return c.Workspace() + ".snapshot-"
+}
+
+// saveStateSnapshot stores the given state file as the snapshot described by
+// the given metadata, either in the local data directory or in the backend.
+func (c *StateMeta) saveStateSnapshot(meta *stateSnapshotMeta, f *statefile.File) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	if meta.InBackend {
+		b, backendDiags := c.Backend(nil)
+		diags = diags.Append(backendDiags)
+		if backendDiags.HasErrors() {
+			return diags
+		}
+		name := c.stateSnapshotWorkspace(meta.Name)
+		stateMgr, err := b.StateMgr(name)
+		if err == backend.ErrWorkspacesNotSupported {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Backend can't store snapshots",
+				"The backend doesn't support workspaces, so it can't store named snapshots. Create the snapshot without -backend to store it locally instead.",
+			))
+			return diags
+		}
+		if rs, ok := stateMgr.(*remote.State); ok && err == nil {
+			// We write the snapshot directly so that it keeps the lineage
+			// and serial of the state it was created from, rather than
+			// starting a new lineage of its own, and so can be checked
+			// against the current state when it is restored.
+			var buf bytes.Buffer
+			err = statefile.Write(f, &buf)
+			if err == nil {
+				err = rs.Client.Put(buf.Bytes())
+			}
+		} else if err == nil {
+			// As in backupToBackend, the new workspace must be read before
+			// its initial empty snapshot can be replaced.
+			err = stateMgr.RefreshState()
+			if err == nil {
+				err = statemgr.WriteAndPersist(stateMgr, f.State)
+			}
+		}
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to save snapshot",
+				fmt.Sprintf("Could not write the snapshot to the workspace %q: %s.", name, err),
+			))
+		}
+		return diags
+	}
+
+	dir := c.stateSnapshotDir()
+	metaSrc, err := json.MarshalIndent(meta, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err == nil {
+		err = writeStateFile(filepath.Join(dir, meta.Name+".tfstate"), f)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, meta.Name+".json"), metaSrc, 0644)
+	}
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to save snapshot",
+			fmt.Sprintf("Could not write the snapshot to %s: %s.", dir, err),
+		))
+	}
+	return diags
+}
+
+// loadStateSnapshot reads the snapshot with the given name of the current
+// workspace, either from the local data directory or from the backend.
+func (c *StateMeta) loadStateSnapshot(name string, inBackend bool) (*statefile.File, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	if !inBackend {
+		path := filepath.Join(c.stateSnapshotDir(), name+".tfstate")
+		f, err := readStateFile(path)
+		if os.IsNotExist(err) {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"No such snapshot",
+				fmt.Sprintf("There is no snapshot named %q for the workspace %q. Use \"terraform state snapshot list\" to see the available snapshots.", name, c.Workspace()),
+			))
+			return nil, diags
+		}
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to read snapshot",
+				fmt.Sprintf("Could not read the snapshot from %s: %s.", path, err),
+			))
+			return nil, diags
+		}
+		return f, diags
+	}
+
+	snapshots, moreDiags := c.listStateSnapshots(true)
+	diags = diags.Append(moreDiags)
+	if moreDiags.HasErrors() {
+		return nil, diags
+	}
+	found := false
+	for _, meta := range snapshots {
+		if meta.Name == name {
+			found = true
+		}
+	}
+	if !found {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No such snapshot",
+			fmt.Sprintf("There is no snapshot named %q for the workspace %q in the backend. Use \"terraform state snapshot list -backend\" to see the available snapshots.", name, c.Workspace()),
+		))
+		return nil, diags
+	}
+
+	b, backendDiags := c.Backend(nil)
+	diags = diags.Append(backendDiags)
+	if backendDiags.HasErrors() {
+		return nil, diags
+	}
+	workspace := c.stateSnapshotWorkspace(name)
+	stateMgr, err := b.StateMgr(workspace)
+	if err == nil {
+		err = stateMgr.RefreshState()
+	}
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to read snapshot",
+			fmt.Sprintf("Could not read the snapshot from the workspace %q: %s.", workspace, err),
+		))
+		return nil, diags
+	}
+	return statemgr.StateFile(stateMgr, stateMgr.State()), diags
+}
+
+// listStateSnapshots returns the metadata of each of the snapshots of the
+// current workspace, ordered by name, either from the local data directory
+// or from the backend.
+//
+// The backend doesn't store the metadata that is saved alongside a local
+// snapshot, so for snapshots in the backend it is worked out from the
+// snapshot itself and the creation time is left unset.
+func (c *StateMeta) listStateSnapshots(inBackend bool) ([]*stateSnapshotMeta, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	var ret []*stateSnapshotMeta
+
+	if !inBackend {
+		dir := c.stateSnapshotDir()
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			diags = diags.Append(err)
+			return nil, diags
+		}
+		for _, path := range paths {
+			meta := &stateSnapshotMeta{}
+			src, err := ioutil.ReadFile(path)
+			if err == nil {
+				err = json.Unmarshal(src, meta)
+			}
+			if err != nil {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Warning,
+					"Invalid snapshot metadata",
+					fmt.Sprintf("Could not read the snapshot metadata in %s, so it has been skipped: %s.", path, err),
+				))
+				continue
+			}
+			ret = append(ret, meta)
+		}
+	} else {
+		b, backendDiags := c.Backend(nil)
+		diags = diags.Append(backendDiags)
+		if backendDiags.HasErrors() {
+			return nil, diags
+		}
+		workspaces, err := b.Workspaces()
+		if err == backend.ErrWorkspacesNotSupported {
+			return nil, diags
+		}
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Failed to list snapshots",
+				fmt.Sprintf("Could not list the workspaces in the backend: %s.", err),
+			))
+			return nil, diags
+		}
+		prefix := c.stateSnapshotWorkspacePrefix()
+		for _, workspace := range workspaces {
+			if !strings.HasPrefix(workspace, prefix) {
+				continue
+			}
+			stateMgr, err := b.StateMgr(workspace)
+			if err == nil {
+				err = stateMgr.RefreshState()
+			}
+			if err != nil {
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Warning,
+					"Failed to read snapshot",
+					fmt.Sprintf("Could not read the snapshot in the workspace %q, so it has been skipped: %s.", workspace, err),
+				))
+				continue
+			}
+			state := stateMgr.State()
+			if state == nil {
+				state = states.NewState()
+			}
+			f := statemgr.StateFile(stateMgr, state)
+			ret = append(ret, &stateSnapshotMeta{
+				Name:              strings.TrimPrefix(workspace, prefix),
+				Workspace:         c.Workspace(),
+				Serial:            f.Serial,
+				Lineage:           f.Lineage,
+				ResourceInstances: stateSnapshotInstanceCount(state),
+				InBackend:         true,
+			})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, diags
+}
@@ -0,0 +1,167 @@
+package command
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/backend/remote-state/inmem"
+	"github.com/hashicorp/terraform/helper/copy"
+	"github.com/hashicorp/terraform/states/statemgr"
+	"github.com/mitchellh/cli"
+)
+
+func TestStateSnapshot(t *testing.T) {
+	tmp, cwd := testCwd(t)
+	defer testFixCwd(t, tmp, cwd)
+
+	statePath := testStateFile(t, testStateRmState())
+
+	create, ui := testStateSnapshotCreateCommand()
+	if code := create.Run([]string{"-state", statePath, "before"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), `Created snapshot "before" with 2 resource instances.`; !strings.Contains(got, want) {
+		t.Errorf("wrong output\ngot:  %s\nwant: %s", got, want)
+	}
+
+	// A second snapshot with the same name is refused without -force.
+	create, ui = testStateSnapshotCreateCommand()
+	if code := create.Run([]string{"-state", statePath, "before"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "Snapshot already exists"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+
+	rm, ui := testStateRmCommand(testProvider())
+	if code := rm.Run([]string{"-state", statePath, "-auto-approve", "test_instance.foo"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutput)
+
+	list, ui := testStateSnapshotListCommand()
+	if code := list.Run(nil); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	fields := strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\t")
+	if len(fields) != 4 || fields[0] != "before" || fields[3] != "2 resource instances" {
+		t.Errorf("wrong list output %q", ui.OutputWriter.String())
+	}
+
+	restore, ui := testStateSnapshotRestoreCommand()
+	if code := restore.Run([]string{"-state", statePath, "before"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	testStateOutput(t, statePath, testStateRmOutputOriginal)
+
+	restore, ui = testStateSnapshotRestoreCommand()
+	if code := restore.Run([]string{"-state", statePath, "after"}); code != 1 {
+		t.Fatalf("wrong exit status %d; want 1\n\n%s", code, ui.OutputWriter.String())
+	}
+	if got, want := ui.ErrorWriter.String(), "No such snapshot"; !strings.Contains(got, want) {
+		t.Errorf("wrong error\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestStateSnapshot_backend(t *testing.T) {
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("inmem-backend"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+	defer inmem.Reset()
+
+	ui := new(cli.MockUi)
+	initCmd := &InitCommand{
+		Meta: Meta{Ui: ui},
+	}
+	if code := initCmd.Run([]string{}); code != 0 {
+		t.Fatalf("bad: \n%s", ui.ErrorWriter.String())
+	}
+
+	// The inmem backend resets the default workspace each time it's
+	// configured, so we work in a separate one.
+	ui = new(cli.MockUi)
+	newCmd := &WorkspaceNewCommand{
+		Meta: Meta{Ui: ui},
+	}
+	if code := newCmd.Run([]string{"test"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter)
+	}
+
+	b := backend.TestBackendConfig(t, inmem.New(), nil)
+	sMgr, err := b.StateMgr("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sMgr.RefreshState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := statemgr.WriteAndPersist(sMgr, testStateRmState()); err != nil {
+		t.Fatal(err)
+	}
+
+	create, ui := testStateSnapshotCreateCommand()
+	if code := create.Run([]string{"-backend", "before"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	workspaces, err := b.Workspaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, name := range workspaces {
+		if name == "test.snapshot-before" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("no snapshot workspace in %#v", workspaces)
+	}
+
+	rm, ui := testStateRmCommand(testProvider())
+	if code := rm.Run([]string{"-auto-approve", "test_instance.foo"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	list, ui := testStateSnapshotListCommand()
+	if code := list.Run([]string{"-backend"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if got, want := ui.OutputWriter.String(), "before\t-\tserial 1\t2 resource instances\n"; got != want {
+		t.Errorf("wrong list output\ngot:  %q\nwant: %q", got, want)
+	}
+
+	restore, ui := testStateSnapshotRestoreCommand()
+	if code := restore.Run([]string{"-backend", "before"}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if err := sMgr.RefreshState(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(sMgr.State().String()), strings.TrimSpace(testStateRmOutputOriginal); got != want {
+		t.Errorf("wrong state after restore\ngot:\n%s\n\nwant:\n%s", got, want)
+	}
+}
+
+func testStateSnapshotCreateCommand() (*StateSnapshotCreateCommand, *cli.MockUi) {
+	ui := cli.NewMockUi()
+	return &StateSnapshotCreateCommand{
+		StateMeta{Meta: Meta{Ui: ui}},
+	}, ui
+}
+
+func testStateSnapshotRestoreCommand() (*StateSnapshotRestoreCommand, *cli.MockUi) {
+	ui := cli.NewMockUi()
+	return &StateSnapshotRestoreCommand{
+		StateMeta{Meta: Meta{Ui: ui}},
+	}, ui
+}
+
+func testStateSnapshotListCommand() (*StateSnapshotListCommand, *cli.MockUi) {
+	ui := cli.NewMockUi()
+	return &StateSnapshotListCommand{
+		StateMeta{Meta: Meta{Ui: ui}},
+	}, ui
+}
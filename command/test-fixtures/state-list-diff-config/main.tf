@@ -0,0 +1,9 @@
+resource "test_instance" "foo" {
+  count = 2
+}
+
+resource "test_instance" "new" {
+}
+
+data "test_data_source" "kept" {
+}
@@ -0,0 +1,2 @@
+data "test_data_source" "kept" {
+}
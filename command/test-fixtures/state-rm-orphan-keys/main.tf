@@ -0,0 +1,3 @@
+resource "test_instance" "web" {
+  count = 2
+}
@@ -0,0 +1,8 @@
+resource "test_instance" "foo" {
+  lifecycle {
+    prevent_destroy = true
+  }
+}
+
+resource "test_instance" "bar" {
+}
@@ -300,6 +300,14 @@ func initCommands(config *Config, services *disco.Disco) {
 			return &command.StateCommand{}, nil
 		},
 
+		"state compact": func() (cli.Command, error) {
+			return &command.StateCompactCommand{
+				StateMeta: command.StateMeta{
+					Meta: meta,
+				},
+			}, nil
+		},
+
 		"state list": func() (cli.Command, error) {
 			return &command.StateListCommand{
 				Meta: meta,
@@ -334,6 +342,34 @@ func initCommands(config *Config, services *disco.Disco) {
 			}, nil
 		},
 
+		"state snapshot": func() (cli.Command, error) {
+			return &command.StateSnapshotCommand{}, nil
+		},
+
+		"state snapshot create": func() (cli.Command, error) {
+			return &command.StateSnapshotCreateCommand{
+				StateMeta: command.StateMeta{
+					Meta: meta,
+				},
+			}, nil
+		},
+
+		"state snapshot list": func() (cli.Command, error) {
+			return &command.StateSnapshotListCommand{
+				StateMeta: command.StateMeta{
+					Meta: meta,
+				},
+			}, nil
+		},
+
+		"state snapshot restore": func() (cli.Command, error) {
+			return &command.StateSnapshotRestoreCommand{
+				StateMeta: command.StateMeta{
+					Meta: meta,
+				},
+			}, nil
+		},
+
 		"state show": func() (cli.Command, error) {
 			return &command.StateShowCommand{
 				Meta: meta,
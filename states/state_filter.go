@@ -24,20 +24,36 @@ type Filter struct {
 // Filter takes the addresses specified by fs and finds all the matches.
 // The values of fs are resource addressing syntax that can be parsed by
 // ParseResourceAddress.
+//
+// Any instance key in an address can instead be a wildcard: "[*]" matches
+// any key and ["*"] any string key, as used with for_each. Wildcards aren't
+// allowed anywhere else, so that an address can't match resources of other
+// types or names. An address with wildcards is matched against the whole
+// address of each module and resource, so unlike aws_instance.foo, which
+// matches in any module, aws_instance.foo[*] only matches in the root module.
 func (f *Filter) Filter(fs ...string) ([]*FilterResult, error) {
 	// Parse all the addresses
-	as := make([]addrs.Targetable, len(fs))
-	for i, v := range fs {
+	as := make([]addrs.Targetable, 0, len(fs))
+	var patterns []*filterPattern
+	for _, v := range fs {
+		if isFilterPattern(v) {
+			pattern, err := parseFilterPattern(v)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing address '%s': %s", v, err)
+			}
+			patterns = append(patterns, pattern)
+			continue
+		}
 		if addr, diags := addrs.ParseModuleInstanceStr(v); !diags.HasErrors() {
-			as[i] = addr
+			as = append(as, addr)
 			continue
 		}
 		if addr, diags := addrs.ParseAbsResourceStr(v); !diags.HasErrors() {
-			as[i] = addr
+			as = append(as, addr)
 			continue
 		}
 		if addr, diags := addrs.ParseAbsResourceInstanceStr(v); !diags.HasErrors() {
-			as[i] = addr
+			as = append(as, addr)
 			continue
 		}
 		return nil, fmt.Errorf("Error parsing address '%s'", v)
@@ -56,6 +72,11 @@ func (f *Filter) Filter(fs ...string) ([]*FilterResult, error) {
 			resultSet[v.String()] = v
 		}
 	}
+	for _, pattern := range patterns {
+		for _, v := range f.filterPattern(pattern) {
+			resultSet[v.String()] = v
+		}
+	}
 
 	// Make the result list
 	results := make([]*FilterResult, 0, len(resultSet))
@@ -140,6 +161,48 @@ func (f *Filter) relevant(filter addrs.Targetable, rs addrs.AbsResource, key add
 	}
 }
 
+func (f *Filter) filterPattern(p *filterPattern) []*FilterResult {
+	var results []*FilterResult
+	for _, m := range f.State.Modules {
+		switch {
+		case p.resource == nil:
+			// A module pattern matches everything in each matching module
+			// and its descendents, as a module address does.
+			if len(m.Addr) < len(p.module) || !p.matchModule(m.Addr[:len(p.module)]) {
+				continue
+			}
+			results = append(results, &FilterResult{
+				Address: m.Addr.String(),
+				Value:   m,
+			})
+		case len(m.Addr) != len(p.module) || !p.matchModule(m.Addr):
+			continue
+		}
+
+		for _, rs := range m.Resources {
+			if p.resource != nil && !p.resource.Equal(rs.Addr) {
+				continue
+			}
+			if p.resource == nil || !p.keyed {
+				results = append(results, &FilterResult{
+					Address: rs.Addr.Absolute(m.Addr).String(),
+					Value:   rs,
+				})
+			}
+			for key, is := range rs.Instances {
+				if p.resource != nil && p.keyed && !p.key.match(key) {
+					continue
+				}
+				results = append(results, &FilterResult{
+					Address: rs.Addr.Absolute(m.Addr).Instance(key).String(),
+					Value:   is,
+				})
+			}
+		}
+	}
+	return results
+}
+
 // FilterResult is a single result from a filter operation. Filter can
 // match multiple things within a state (curently modules and resources).
 type FilterResult struct {
@@ -0,0 +1,186 @@
+package states
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform/addrs"
+)
+
+// filterWildcards are the instance keys that can be given as wildcards in
+// the addresses given to Filter.Filter, with the key each is replaced with
+// to parse the rest of the address. They are checked in order, so that
+// ["*"] isn't taken for a literal key.
+var filterWildcards = []struct {
+	Token       string
+	Placeholder string
+	Kind        filterKeyKind
+}{
+	{`["*"]`, `["key"]`, filterKeyString},
+	{`[*]`, `[0]`, filterKeyAny},
+}
+
+// filterQuotedKey matches a quoted instance key, whose contents are taken
+// literally even if they include "*".
+var filterQuotedKey = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+
+type filterKeyKind int
+
+const (
+	filterKeyExact filterKeyKind = iota
+	filterKeyAny
+	filterKeyString
+)
+
+// filterKey is an instance key in a filterPattern, which is either a key
+// to match exactly or a wildcard.
+type filterKey struct {
+	Kind filterKeyKind
+	Key  addrs.InstanceKey
+}
+
+func (k filterKey) match(key addrs.InstanceKey) bool {
+	switch k.Kind {
+	case filterKeyAny:
+		return key != addrs.NoKey
+	case filterKeyString:
+		_, ok := key.(addrs.StringKey)
+		return ok
+	default:
+		return k.Key == key
+	}
+}
+
+// filterPattern is an address given to Filter.Filter with wildcards in place
+// of some of its instance keys, such as module.workers["*"].aws_instance.node
+// or aws_instance.node[*].
+type filterPattern struct {
+	// module has an entry for each step of the module path of the pattern,
+	// matched by name and key.
+	module []filterModuleStep
+
+	// resource is nil if the pattern is of module instances. Otherwise key
+	// is the key of the resource instances to match, if keyed is set, or
+	// else the pattern matches the resource and all of its instances.
+	resource *addrs.Resource
+	keyed    bool
+	key      filterKey
+}
+
+type filterModuleStep struct {
+	Name string
+	Key  filterKey
+}
+
+// isFilterPattern returns true if the given address contains any of the
+// wildcard tokens [*] or ["*"], and so must be parsed with
+// parseFilterPattern. Any other "*", such as in the literal key of
+// aws_instance.node["a*b"], is left to the usual address parser.
+func isFilterPattern(raw string) bool {
+	for _, w := range filterWildcards {
+		if strings.Contains(raw, w.Token) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFilterPattern parses the given address pattern. Each wildcard is
+// replaced with a placeholder key so that the rest of the address can be
+// parsed as usual, and the keys of the parsed address are then matched up,
+// in order, with the wildcards and literal keys of the pattern.
+func parseFilterPattern(raw string) (*filterPattern, error) {
+	var placeholder strings.Builder
+	var kinds []filterKeyKind
+	rest := raw
+	for len(rest) > 0 {
+		matched := false
+		for _, w := range filterWildcards {
+			if strings.HasPrefix(rest, w.Token) {
+				placeholder.WriteString(w.Placeholder)
+				kinds = append(kinds, w.Kind)
+				rest = rest[len(w.Token):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		if rest[0] == '[' {
+			// A literal key, which may be quoted and so contain "]".
+			end := strings.Index(rest, "]")
+			if loc := filterQuotedKey.FindStringIndex(rest); loc != nil && loc[0] == 1 {
+				end = loc[1]
+			}
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			placeholder.WriteString(rest[:end+1])
+			kinds = append(kinds, filterKeyExact)
+			rest = rest[end+1:]
+			continue
+		}
+		i := strings.IndexByte(rest, '[')
+		if i < 0 {
+			i = len(rest)
+		}
+		placeholder.WriteString(rest[:i])
+		rest = rest[i:]
+	}
+
+	check := placeholder.String()
+	if strings.Contains(filterQuotedKey.ReplaceAllString(check, `""`), "*") {
+		return nil, fmt.Errorf("\"*\" can only be used in place of a whole instance key, as in [*] or [\"*\"]")
+	}
+
+	ret := &filterPattern{}
+	var module addrs.ModuleInstance
+	var key addrs.InstanceKey = addrs.NoKey
+	if addr, diags := addrs.ParseModuleInstanceStr(check); !diags.HasErrors() {
+		module = addr
+	} else if addr, diags := addrs.ParseAbsResourceStr(check); !diags.HasErrors() {
+		module = addr.Module
+		ret.resource = &addr.Resource
+	} else if addr, diags := addrs.ParseAbsResourceInstanceStr(check); !diags.HasErrors() {
+		module = addr.Module
+		ret.resource = &addr.Resource.Resource
+		ret.keyed = true
+		key = addr.Resource.Key
+	} else {
+		return nil, diags.Err()
+	}
+
+	// Each key in the parsed address came from one pair of brackets in the
+	// pattern, in the same order.
+	next := func(key addrs.InstanceKey) filterKey {
+		if key == addrs.NoKey {
+			return filterKey{Kind: filterKeyExact, Key: addrs.NoKey}
+		}
+		kind := kinds[0]
+		kinds = kinds[1:]
+		if kind != filterKeyExact {
+			return filterKey{Kind: kind}
+		}
+		return filterKey{Kind: kind, Key: key}
+	}
+	for _, step := range module {
+		ret.module = append(ret.module, filterModuleStep{Name: step.Name, Key: next(step.InstanceKey)})
+	}
+	if ret.keyed {
+		ret.key = next(key)
+	}
+	return ret, nil
+}
+
+// matchModule returns true if the given module instance address matches the
+// module path of the pattern, which must have the same number of steps.
+func (p *filterPattern) matchModule(addr addrs.ModuleInstance) bool {
+	for i, step := range p.module {
+		if addr[i].Name != step.Name || !step.Key.match(addr[i].InstanceKey) {
+			return false
+		}
+	}
+	return true
+}
@@ -117,6 +117,77 @@ func TestFilterFilter(t *testing.T) {
 				"*states.ResourceInstance: module.outer.module.child2.aws_instance.foo",
 			},
 		},
+
+		"any count index": {
+			testStateComplete(),
+			[]string{"module.consul.aws_instance.consul-green[*]"},
+			[]string{
+				"*states.ResourceInstance: module.consul.aws_instance.consul-green[0]",
+				"*states.ResourceInstance: module.consul.aws_instance.consul-green[1]",
+				"*states.ResourceInstance: module.consul.aws_instance.consul-green[2]",
+			},
+		},
+
+		"any count index only in the given module": {
+			testStateComplete(),
+			[]string{"aws_instance.consul-green[*]"},
+			[]string{},
+		},
+
+		"any key in root module": {
+			testStateKeyedModules(),
+			[]string{"aws_instance.web[*]"},
+			[]string{
+				"*states.ResourceInstance: aws_instance.web[0]",
+				"*states.ResourceInstance: aws_instance.web[1]",
+			},
+		},
+
+		"any string module key": {
+			testStateKeyedModules(),
+			[]string{`module.workers["*"]`},
+			[]string{
+				`*states.Module: module.workers["a"]`,
+				`*states.Resource: module.workers["a"].aws_instance.node`,
+				`*states.ResourceInstance: module.workers["a"].aws_instance.node[0]`,
+				`*states.Module: module.workers["b"]`,
+				`*states.Resource: module.workers["b"].aws_instance.node`,
+				`*states.ResourceInstance: module.workers["b"].aws_instance.node[0]`,
+				`*states.ResourceInstance: module.workers["b"].aws_instance.node[1]`,
+			},
+		},
+
+		"any module key with resource": {
+			testStateKeyedModules(),
+			[]string{`module.workers[*].aws_instance.node`},
+			[]string{
+				`*states.Resource: module.workers["a"].aws_instance.node`,
+				`*states.ResourceInstance: module.workers["a"].aws_instance.node[0]`,
+				`*states.Resource: module.workers["b"].aws_instance.node`,
+				`*states.ResourceInstance: module.workers["b"].aws_instance.node[0]`,
+				`*states.ResourceInstance: module.workers["b"].aws_instance.node[1]`,
+				`*states.Resource: module.workers[0].aws_instance.node`,
+				`*states.ResourceInstance: module.workers[0].aws_instance.node`,
+			},
+		},
+
+		"wildcard and literal keys": {
+			testStateKeyedModules(),
+			[]string{`module.workers["*"].aws_instance.node[1]`},
+			[]string{
+				`*states.ResourceInstance: module.workers["b"].aws_instance.node[1]`,
+			},
+		},
+
+		"wildcard with plain address": {
+			testStateKeyedModules(),
+			[]string{`module.workers["a"].aws_instance.node[*]`, "aws_instance.db"},
+			[]string{
+				"*states.Resource: aws_instance.db",
+				"*states.ResourceInstance: aws_instance.db",
+				`*states.ResourceInstance: module.workers["a"].aws_instance.node[0]`,
+			},
+		},
 	}
 
 	for n, tc := range cases {
@@ -333,6 +404,61 @@ func testStateComplete() *State {
 	})
 }
 
+func TestFilterFilter_invalidPattern(t *testing.T) {
+	cases := []string{
+		"aws_instance.*",
+		"aws_*.foo[*]",
+		"module.*.aws_instance.foo",
+		`aws_instance.foo["a*"][*]`,
+		"aws_instance.foo[*",
+	}
+
+	for _, raw := range cases {
+		filter := &Filter{State: testStateKeyedModules()}
+		if _, err := filter.Filter(raw); err == nil {
+			t.Errorf("%q: expected error", raw)
+		}
+	}
+}
+
+// testStateKeyedModules returns a test State structure with instances of
+// a module and of resources that use count and for_each keys.
+func testStateKeyedModules() *State {
+	workers := func(key addrs.InstanceKey) addrs.ModuleInstance {
+		return addrs.RootModuleInstance.Child("workers", key)
+	}
+	instance := func(typeName, name string, key addrs.InstanceKey) addrs.ResourceInstance {
+		return addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: typeName,
+			Name: name,
+		}.Instance(key)
+	}
+
+	return BuildState(func(s *SyncState) {
+		for _, addr := range []addrs.AbsResourceInstance{
+			instance("aws_instance", "web", addrs.IntKey(0)).Absolute(addrs.RootModuleInstance),
+			instance("aws_instance", "web", addrs.IntKey(1)).Absolute(addrs.RootModuleInstance),
+			instance("aws_instance", "db", addrs.NoKey).Absolute(addrs.RootModuleInstance),
+			instance("aws_instance", "node", addrs.NoKey).Absolute(workers(addrs.IntKey(0))),
+			instance("aws_instance", "node", addrs.IntKey(0)).Absolute(workers(addrs.StringKey("a"))),
+			instance("aws_instance", "node", addrs.IntKey(0)).Absolute(workers(addrs.StringKey("b"))),
+			instance("aws_instance", "node", addrs.IntKey(1)).Absolute(workers(addrs.StringKey("b"))),
+		} {
+			s.SetResourceInstanceCurrent(
+				addr,
+				&ResourceInstanceObjectSrc{
+					AttrsJSON: []byte(`{"id": "1234567890"}`),
+					Status:    ObjectReady,
+				},
+				addrs.ProviderConfig{
+					Type: "aws",
+				}.Absolute(addr.Module),
+			)
+		}
+	})
+}
+
 // testStateNestedModules returns a test State structure.
 func testStateNestedModules() *State {
 	outer, _ := addrs.ParseModuleInstanceStr("module.outer")
@@ -115,3 +115,51 @@ func TestState(t *testing.T) {
 		t.Error(problem)
 	}
 }
+
+func TestStateDeepCopy_private(t *testing.T) {
+	addr := addrs.Resource{
+		Mode: addrs.ManagedResourceMode,
+		Type: "test_thing",
+		Name: "foo",
+	}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance)
+	provider := addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance)
+
+	state := BuildState(func(s *SyncState) {
+		s.SetResourceInstanceCurrent(addr, &ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(`{"id":"current"}`),
+			Private:   []byte("current private"),
+			Status:    ObjectReady,
+		}, provider)
+		s.SetResourceInstanceDeposed(addr, DeposedKey("00000001"), &ResourceInstanceObjectSrc{
+			AttrsJSON: []byte(`{"id":"deposed"}`),
+			Private:   []byte("deposed private"),
+			Status:    ObjectReady,
+		}, provider)
+	})
+
+	got := state.DeepCopy().ResourceInstance(addr)
+	if got, want := string(got.Current.Private), "current private"; got != want {
+		t.Errorf("wrong private data for current object %q; want %q", got, want)
+	}
+	if got, want := string(got.Deposed[DeposedKey("00000001")].Private), "deposed private"; got != want {
+		t.Errorf("wrong private data for deposed object %q; want %q", got, want)
+	}
+
+	// The copy must not share its private data with the original.
+	state.ResourceInstance(addr).Deposed[DeposedKey("00000001")].Private[0] = 'D'
+	if got, want := string(got.Deposed[DeposedKey("00000001")].Private), "deposed private"; got != want {
+		t.Errorf("copy's private data changed with the original: %q", got)
+	}
+}
+
+func TestResourceInstanceObjectDeepCopy_private(t *testing.T) {
+	obj := &ResourceInstanceObject{
+		Value:   cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal("foo")}),
+		Private: []byte("private"),
+		Status:  ObjectReady,
+	}
+	got := obj.DeepCopy()
+	if got, want := string(got.Private), "private"; got != want {
+		t.Errorf("wrong private data %q; want %q", got, want)
+	}
+}
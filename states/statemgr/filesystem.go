@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -107,6 +109,12 @@ func (s *Filesystem) BackupPath() string {
 	return s.backupPath
 }
 
+// ReadPath returns the path of the file that the manager reads its initial
+// state snapshot from.
+func (s *Filesystem) ReadPath() string {
+	return s.readPath
+}
+
 // State is an implementation of Reader.
 func (s *Filesystem) State() *states.State {
 	defer s.mutex()()
@@ -141,15 +149,13 @@ func (s *Filesystem) WriteState(state *states.State) error {
 	// We'll try to write our backup first, so we can be sure we've created
 	// it successfully before clobbering the original file it came from.
 	if !s.writtenBackup && s.backupFile != nil && s.backupPath != "" && !statefile.StatesMarshalEqual(state, s.backupFile.State) {
-		bfh, err := os.Create(s.backupPath)
-		if err != nil {
-			return fmt.Errorf("failed to create local state backup file: %s", err)
-		}
-		defer bfh.Close()
-
-		err = statefile.Write(s.backupFile, bfh)
+		// The backup is only useful if it survives a crash during the write
+		// that follows, so we make sure it's durably on disk first.
+		err := writeFileDurable(s.backupPath, func(w io.Writer) error {
+			return statefile.Write(s.backupFile, w)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to write to local state backup file: %s", err)
+			return fmt.Errorf("failed to write local state backup file: %s", err)
 		}
 
 		s.writtenBackup = true
@@ -410,6 +416,100 @@ func (s *Filesystem) writeLockInfo(info *LockInfo) error {
 	return nil
 }
 
+// writeFileDurable creates or replaces the file at the given path with the
+// content written by the given function, such that the file at path is never
+// observed in a partially-written state and its new content has been flushed
+// to stable storage once writeFileDurable returns successfully.
+//
+// The content is first written to a temporary file in the same directory,
+// which is synced and then renamed into place before syncing the directory
+// itself, so that the rename is durable too.
+//
+// The result is the same file as creating it with os.Create would give: if
+// the path is a symlink, the file it links to is replaced rather than the
+// link, and the file keeps the permissions of the file it replaces, or else
+// gets the default permissions for a new file, 0666 less the umask.
+func writeFileDurable(path string, write func(io.Writer) error) error {
+	path, err := resolveFileSymlinks(path)
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := createTempFile(dir, "."+name+".tmp", 0666)
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	if info, statErr := os.Stat(path); statErr == nil {
+		err = f.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		err = write(f)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// resolveFileSymlinks returns the path of the file that the given path refers
+// to once any symlinks are followed, including a final symlink whose target
+// doesn't exist yet, or the path itself if it isn't a symlink.
+func resolveFileSymlinks(path string) (string, error) {
+	for i := 0; i < 255; i++ {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", fmt.Errorf("too many levels of symbolic links at %s", path)
+}
+
+// createTempFile creates a new file in the given directory with a name
+// starting with the given prefix, as ioutil.TempFile does, but with the
+// given permissions before the umask instead of 0600.
+func createTempFile(dir, prefix string, perm os.FileMode) (*os.File, error) {
+	for i := 0; i < 10000; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("failed to create a temporary file in %s", dir)
+}
+
 func (s *Filesystem) mutex() func() {
 	s.mu.Lock()
 	return s.mu.Unlock
@@ -0,0 +1,20 @@
+// +build !windows
+
+package statemgr
+
+import (
+	"os"
+)
+
+// syncDir flushes the directory entries of the given directory to stable
+// storage, so that a file just created or renamed within it will still be
+// there after a crash.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
@@ -0,0 +1,10 @@
+// +build windows
+
+package statemgr
+
+// syncDir is a no-op on Windows, which has no means to sync a directory.
+// NTFS journals its metadata, and so a completed rename there survives a
+// crash anyway.
+func syncDir(dir string) error {
+	return nil
+}
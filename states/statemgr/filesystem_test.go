@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"testing"
 
@@ -11,6 +13,7 @@ import (
 
 	version "github.com/hashicorp/go-version"
 
+	"github.com/hashicorp/terraform/states"
 	"github.com/hashicorp/terraform/states/statefile"
 )
 
@@ -165,6 +168,191 @@ func TestFilesystem_backup(t *testing.T) {
 	}
 }
 
+func TestFilesystem_backupFailure(t *testing.T) {
+	ls := testFilesystem(t)
+	defer os.Remove(ls.readPath)
+
+	// A non-empty directory at the backup path means the backup can be
+	// written to its temporary file but never moved into place.
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backupPath := filepath.Join(dir, "terraform.tfstate.backup")
+	if err := os.MkdirAll(filepath.Join(backupPath, "occupied"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ls.SetBackupPath(backupPath)
+
+	if ls.State() == nil {
+		t.Fatal("initial state is nil")
+	}
+	if err := ls.WriteState(states.NewState()); err == nil {
+		t.Fatal("WriteState succeeded; want error")
+	}
+
+	// The original state must be left untouched, since it's the only copy.
+	fh, err := os.Open(ls.readPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	f, err := statefile.Read(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origState := TestFullInitialState(); !f.State.Equal(origState) {
+		for _, problem := range deep.Equal(origState, f.State) {
+			t.Error(problem)
+		}
+	}
+
+	// The temporary file must not be left behind.
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		for _, entry := range entries {
+			t.Logf("found %s", entry.Name())
+		}
+		t.Errorf("backup directory has %d entries; want 1", len(entries))
+	}
+}
+
+func TestFilesystem_backupMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions aren't meaningful on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A new backup gets the same permissions as os.Create would give it.
+	ref, err := os.Create(filepath.Join(dir, "reference"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref.Close()
+	refInfo, err := os.Stat(ref.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls := testFilesystem(t)
+	defer os.Remove(ls.readPath)
+	backupPath := filepath.Join(dir, "new.backup")
+	ls.SetBackupPath(backupPath)
+	if ls.State() == nil {
+		t.Fatal("initial state is nil")
+	}
+	if err := ls.WriteState(states.NewState()); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Mode().Perm(), refInfo.Mode().Perm(); got != want {
+		t.Errorf("new backup has mode %s; want %s", got, want)
+	}
+
+	// An existing backup keeps its permissions.
+	ls = testFilesystem(t)
+	defer os.Remove(ls.readPath)
+	backupPath = filepath.Join(dir, "existing.backup")
+	if err := ioutil.WriteFile(backupPath, nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(backupPath, 0640); err != nil {
+		t.Fatal(err)
+	}
+	ls.SetBackupPath(backupPath)
+	if ls.State() == nil {
+		t.Fatal("initial state is nil")
+	}
+	if err := ls.WriteState(states.NewState()); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Mode().Perm(), os.FileMode(0640); got != want {
+		t.Errorf("existing backup has mode %s; want %s", got, want)
+	}
+}
+
+func TestFilesystem_backupSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need special privileges on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "backups"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, target := range map[string]string{
+		"existing target": "backups/existing.backup",
+		"missing target":  "backups/missing.backup",
+	} {
+		t.Run(name, func(t *testing.T) {
+			targetPath := filepath.Join(dir, target)
+			if name == "existing target" {
+				if err := ioutil.WriteFile(targetPath, []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			linkPath := filepath.Join(dir, filepath.Base(target)+".link")
+			if err := os.Symlink(target, linkPath); err != nil {
+				t.Fatal(err)
+			}
+
+			ls := testFilesystem(t)
+			defer os.Remove(ls.readPath)
+			ls.SetBackupPath(linkPath)
+			if ls.State() == nil {
+				t.Fatal("initial state is nil")
+			}
+			if err := ls.WriteState(states.NewState()); err != nil {
+				t.Fatal(err)
+			}
+
+			// The backup is written through the link, which is kept.
+			info, err := os.Lstat(linkPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("symlink was replaced with a regular file")
+			}
+			fh, err := os.Open(targetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fh.Close()
+			f, err := statefile.Read(fh)
+			if err != nil {
+				t.Fatalf("backup not written to the link's target: %s", err)
+			}
+			if origState := TestFullInitialState(); !f.State.Equal(origState) {
+				for _, problem := range deep.Equal(origState, f.State) {
+					t.Error(problem)
+				}
+			}
+		})
+	}
+}
+
 func TestFilesystem_nonExist(t *testing.T) {
 	ls := NewFilesystem("ishouldntexist")
 	if err := ls.RefreshState(); err != nil {